@@ -1,27 +1,52 @@
 package main
 
 import (
-	"bytes"         // 导入 bytes 包，用于处理字节缓冲区，例如构建 HTTP 请求体
-	"encoding/json" // 导入 encoding/json 包，用于 JSON 数据的编解码
-	"fmt"           // 导入 fmt 包，用于格式化字符串和错误信息
-	"io"            // 导入 io 包，用于 IO 操作，例如读取响应体
-	"log"           // 导入 log 包，用于日志输出
-	"net/http"      // 导入 net/http 包，用于构建 HTTP 服务器和客户端
-	"os"            // 导入 os 包，用于文件操作，例如设置日志输出到标准输出
-	"time"          // 导入 time 包，用于处理时间相关操作，例如设置 HTTP 客户端超时时间
-
-	"dify2wxbot/internal/config"  // 导入 internal/config 包，用于加载应用程序配置
-	"dify2wxbot/internal/handler" // 导入 internal/handler 包，包含 WebhookHandler
-	"dify2wxbot/internal/service" // 导入 internal/service 包，包含 DifyService 和 MessageConverter
-	"dify2wxbot/internal/store"   // 导入 internal/store 包，包含 ConversationStore
-
-	"github.com/robfig/cron/v3"        // 导入 cron 包，用于定时任务调度
-	"gopkg.in/natefinch/lumberjack.v2" // 导入 lumberjack 包，用于日志文件轮转和管理
+	"context"   // 导入 context 包，用于限制优雅退出的等待时间
+	"errors"    // 导入 errors 包，用于识别服务器正常关闭
+	"fmt"       // 导入 fmt 包，用于格式化字符串和错误信息
+	"log/slog"  // 导入 log/slog 包，用于结构化日志输出
+	"net/http"  // 导入 net/http 包，用于构建 HTTP 服务器
+	"os"        // 导入 os 包，用于在启动失败时退出程序
+	"os/signal" // 导入 os/signal 包，用于接收 SIGINT 和 SIGTERM 信号
+	"syscall"   // 导入 syscall 包，用于引用 SIGTERM 信号
+	"time"      // 导入 time 包，用于设置服务器超时时间
+
+	"dify2wxbot/internal/config"    // 导入 internal/config 包，用于加载应用程序配置
+	"dify2wxbot/internal/handler"   // 导入 internal/handler 包，包含 WebhookHandler 和 JobsHandler
+	"dify2wxbot/internal/jobs"      // 导入 internal/jobs 包，用于异步处理 Webhook 请求
+	"dify2wxbot/internal/logging"   // 导入 internal/logging 包，用于初始化结构化日志
+	"dify2wxbot/internal/metrics"   // 导入 internal/metrics 包，用于暴露 Prometheus 指标
+	"dify2wxbot/internal/scheduler" // 导入 internal/scheduler 包，用于定时任务调度
+	"dify2wxbot/internal/service"   // 导入 internal/service 包，包含 DifyService 和 MessageConverter
+	"dify2wxbot/internal/store"     // 导入 internal/store 包，包含 ConversationStore
+	"dify2wxbot/pkg/wecom"          // 导入 pkg/wecom 包，用于在退出前清空企业微信发送队列
 )
 
 // Version 应用程序版本号
 var Version = "v1.0.0" // 当前版本号
 
+const (
+	defaultServerAddr      = ":7860"           // 默认的 HTTP 服务器监听地址，与 Dockerfile 暴露的端口一致
+	defaultReadTimeout     = 60 * time.Second  // 默认的请求读取超时时间
+	defaultWriteTimeout    = 360 * time.Second // 默认的响应写入超时时间，需大于 Dify 调用的截止时间
+	defaultIdleTimeout     = 120 * time.Second // 默认的 keep-alive 连接空闲超时时间
+	defaultShutdownTimeout = 60 * time.Second  // 默认的优雅退出等待时间
+)
+
+// seconds 将以秒为单位的配置值转换为 time.Duration，未配置 (<= 0) 时返回默认值
+func seconds(value int, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return time.Duration(value) * time.Second
+}
+
+// fatal 记录错误日志并以非 0 状态码退出程序
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 // main 函数是程序的入口点，负责初始化和启动各项服务
 func main() {
 	// 打印应用程序版本信息
@@ -31,166 +56,138 @@ func main() {
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		// 如果配置加载失败，则记录致命错误并退出程序
-		log.Fatalf("配置加载失败: %v", err)
+		fatal("配置加载失败", err)
 	}
 
-	// 根据配置设置日志输出目标
-	if cfg.LogToFile {
-		// 如果配置中启用了日志文件输出，则配置 lumberjack 日志轮转器
-		logOutput := &lumberjack.Logger{
-			Filename:   cfg.LogFilePath,     // 日志文件路径，例如 "logs/app.log"
-			MaxSize:    cfg.LogMaxSizeBytes, // 单个日志文件的最大大小（MB），达到此大小后会进行切割
-			MaxBackups: cfg.LogMaxBackups,   // 保留旧日志文件的最大个数，超出此数量的旧文件会被删除
-			MaxAge:     cfg.LogMaxAgeDays,   // 保留旧日志文件的最大天数，超出此天数的旧文件会被删除
-			Compress:   cfg.LogCompress,     // 是否压缩旧的日志文件（gzip 格式）
-		}
-		log.SetOutput(logOutput)                             // 将标准日志库的输出重定向到 lumberjack
-		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile) // 设置日志格式，包含日期、时间、文件名和行号
-		log.Printf("日志已重定向到文件: %s (最大大小: %dMB, 最大备份: %d, 最大天数: %d, 压缩: %t)",
-			cfg.LogFilePath, cfg.LogMaxSizeBytes, cfg.LogMaxBackups, cfg.LogMaxAgeDays, cfg.LogCompress)
-	} else {
-		// 如果未启用日志文件输出，则默认将日志输出到标准输出 (控制台)
-		log.SetOutput(os.Stdout)                             // 将日志输出设置为标准输出
-		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile) // 设置日志格式，包含日期、时间、文件名和行号
+	// 根据配置初始化结构化日志：输出到标准输出或 lumberjack 管理的轮转文件，并对凭据进行脱敏
+	if logFile := logging.Setup(cfg); logFile != nil {
+		defer logFile.Close()
 	}
 
-	// 创建 DifyService 实例，用于与 Dify AI 服务的 API 进行交互
-	difyService := service.NewDifyService(cfg)
+	// 根据配置创建 ConversationStore 实例，用于管理用户与 Dify 之间的对话 ID，以维持上下文
+	conversationStore, err := store.NewConversationStore(cfg.Store)
+	if err != nil {
+		fatal("对话存储初始化失败", err)
+	}
+	defer conversationStore.Close() // 程序退出时关闭存储，确保数据落盘
+	storeType := cfg.Store.Type
+	if storeType == "" {
+		storeType = "memory" // 未配置存储类型时使用内存实现
+	}
+	metrics.RegisterConversationCount(storeType, conversationStore.Count)
+
+	// 检查每个 Dify 应用的工作流输出模板，模板有误时在启动阶段退出，而不是在工作流运行后才发现
+	for _, app := range cfg.DifyApps() {
+		if err := service.ValidateWorkflowOutput(app.WorkflowOutput); err != nil {
+			fatal("Dify 应用 "+app.Name+" 的工作流输出配置无效", err)
+		}
+	}
 
-	// 创建 MessageConverter 实例，负责将 Dify 的回复消息格式化并发送到企业微信群机器人
-	messageConverter := service.NewMessageConverter(cfg, difyService)
+	// 创建 MessageConverter 实例，负责将消息路由到对应的 Dify 应用、管理对话上下文，并将 Dify 的回复消息格式化后发送到企业微信群机器人
+	messageConverter := service.NewMessageConverter(cfg, conversationStore)
 
-	// 创建 ConversationStore 实例，用于管理用户与 Dify 之间的对话 ID，以维持上下文
-	conversationStore := store.NewInMemoryConversationStore()
+	// 创建异步任务管理器，启动固定数量的 worker 处理异步 Webhook 请求
+	jobManager := jobs.NewManager(cfg.Async, messageConverter)
 
 	// 创建 WebhookHandler 实例，用于处理所有传入的 HTTP Webhook 请求
-	webhookHandler := handler.NewWebhookHandler(messageConverter, conversationStore, cfg)
+	webhookHandler := handler.NewWebhookHandler(messageConverter, jobManager, cfg)
 
-	// 注册 Webhook 路由，将所有 "/webhook" 路径的请求路由到 webhookHandler 的 HandleWebhook 方法
-	http.HandleFunc("/webhook", webhookHandler.HandleWebhook)
+	// 创建健康检查处理器，提供 /healthz (存活检查) 和 /readyz (就绪检查)
+	healthHandler := handler.NewHealthHandler(messageConverter, cfg)
 
-	// 创建一个可重用的 HTTP 客户端实例，用于发送定时任务请求
-	httpClient := &http.Client{
-		Timeout: 10 * time.Second, // 设置 HTTP 请求的超时时间为 10 秒，防止长时间阻塞
+	// 使用独立的路由器注册所有路由，而不是全局的 http.DefaultServeMux
+	mux := http.NewServeMux()
+	// 注册 Webhook 路由，将所有 "/webhook" 路径的请求路由到 webhookHandler 的 HandleWebhook 方法
+	mux.HandleFunc("/webhook", webhookHandler.HandleWebhook)
+	// 注册异步任务查询路由，GET /jobs/{id} 返回任务的状态和结果
+	mux.HandleFunc("/jobs/", handler.NewJobsHandler(jobManager, cfg).HandleJob)
+	// 注册会话管理路由，代理 Dify 的会话列表、消息历史、重命名和删除接口，与 Webhook 使用相同的认证
+	conversationsHandler := handler.NewConversationsHandler(messageConverter, cfg)
+	mux.HandleFunc("/admin/conversations", conversationsHandler.HandleConversations)
+	mux.HandleFunc("/admin/conversations/", conversationsHandler.HandleConversations)
+	// 注册健康检查路由
+	mux.HandleFunc("/healthz", healthHandler.HandleHealthz)
+	mux.HandleFunc("/readyz", healthHandler.HandleReadyz)
+	// 注册 Prometheus 指标路由，与健康检查一样不需要认证
+	mux.Handle("/metrics", metrics.Handler())
+	// 启用消息回调时注册企业微信回调路由，请求通过签名校验，不使用 auth_token 认证
+	if cfg.Callback.Enable {
+		callbackHandler, err := handler.NewWeComCallbackHandler(jobManager, cfg.Callback)
+		if err != nil {
+			fatal("企业微信消息回调初始化失败", err)
+		}
+		mux.HandleFunc("/wecom/callback", callbackHandler.HandleCallback)
+		slog.Info("已启用企业微信消息回调", "path", "/wecom/callback", "app", cfg.Callback.App, "targets", cfg.Callback.Targets)
 	}
 
-	// 初始化一个全局的 Cron 调度器实例，用于管理和执行定时任务
-	c := cron.New()
+	// 根据配置创建定时任务调度器，定时任务在进程内直接调用消息转换器
+	taskScheduler, err := scheduler.New(cfg, messageConverter)
+	if err != nil {
+		// 定时任务配置有误 (例如 Cron 表达式或模板无效)，记录致命错误并退出程序
+		fatal("定时任务初始化失败", err)
+	}
+	// 启动调度器 (在所有定时任务添加完毕后统一启动，使其开始执行)
+	taskScheduler.Start()
 
-	// 启动定时任务（如果配置中启用了定时器）
-	// 遍历配置文件中定义的所有定时器配置
-	for i, schedulerCfg := range cfg.Schedulers {
-		// 检查当前定时器是否被启用
-		if !schedulerCfg.Enable {
-			log.Printf("定时器 %d: 未启用，跳过配置和启动。", i)
-			continue // 跳过当前定时器的处理
-		}
+	// 根据配置创建 HTTP 服务器
+	addr := cfg.Server.Addr
+	if addr == "" {
+		addr = defaultServerAddr
+	}
+	server := &http.Server{
+		Addr:         addr,                                                         // 监听地址
+		Handler:      handler.WithRequestID(mux),                                   // 路由器，每个请求都会分配请求 ID
+		ReadTimeout:  seconds(cfg.Server.ReadTimeoutSeconds, defaultReadTimeout),   // 读取整个请求的超时时间
+		WriteTimeout: seconds(cfg.Server.WriteTimeoutSeconds, defaultWriteTimeout), // 写入响应的超时时间
+		IdleTimeout:  seconds(cfg.Server.IdleTimeoutSeconds, defaultIdleTimeout),   // keep-alive 连接的空闲超时时间
+		ErrorLog:     slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),  // 服务器内部错误 (例如 TLS 握手失败) 也使用结构化日志
+	}
 
-		// 为每个定时器定义一个独立的执行函数（闭包）
-		// 捕获当前 schedulerCfg 的值，避免在循环中因闭包引用外部变量导致的问题
-		currentSchedulerCfg := schedulerCfg
-		taskName := fmt.Sprintf("定时器 %d", i) // 为当前定时任务生成一个唯一的名称，用于日志记录
-		scheduleTask := func() {
-			log.Printf("%s 触发，正在调用目标 URL: %s", taskName, currentSchedulerCfg.TargetURL)
-			// 构建发送到目标 URL 的请求体，包含默认消息和用户标识
-			requestBody := map[string]string{
-				"message": currentSchedulerCfg.DefaultMessage,
-				"user":    fmt.Sprintf("scheduler_bot_%d", i), // 定时任务的默认用户标识，带序号区分，便于追踪
-			}
-			// 将请求体编码为 JSON 格式
-			jsonBody, err := json.Marshal(requestBody)
-			if err != nil {
-				log.Printf("%s：JSON 编码请求体失败: %v", taskName, err)
-				return // 如果编码失败，则终止当前任务的执行
-			}
-
-			// 创建一个新的 HTTP POST 请求
-			req, err := http.NewRequest(http.MethodPost, currentSchedulerCfg.TargetURL, bytes.NewBuffer(jsonBody))
-			if err != nil {
-				log.Printf("%s：创建 HTTP 请求失败: %v", taskName, err)
-				return // 如果请求创建失败，则终止当前任务的执行
-			}
-			req.Header.Set("Content-Type", "application/json") // 设置请求头为 JSON 格式
-
-			// 如果应用程序配置中启用了认证功能，则添加 Authorization 头
-			if cfg.EnableAuth { // 注意：这里的认证 Token 是全局的，所有定时任务共享
-				req.Header.Set("Authorization", "Bearer "+cfg.AuthToken)
-			}
-
-			// 使用预先创建的可重用 HTTP 客户端发送请求
-			resp, err := httpClient.Do(req)
-			if err != nil {
-				log.Printf("%s：发送 HTTP 请求失败: %v", taskName, err)
-				return // 如果请求发送失败，则终止当前任务的执行
-			}
-			defer resp.Body.Close() // 确保在函数返回前关闭响应体，释放资源
-
-			// 检查 HTTP 响应状态码是否为 200 OK
-			if resp.StatusCode != http.StatusOK {
-				// 如果状态码不是 200，则读取响应体并记录详细错误日志
-				bodyBytes, _ := io.ReadAll(resp.Body) // 尝试读取响应体内容
-				log.Printf("%s：HTTP 请求返回非 200 状态码: %d, 响应体: %s", taskName, resp.StatusCode, string(bodyBytes))
-			} else {
-				// 如果状态码是 200 OK，则记录请求成功日志
-				log.Printf("%s：HTTP 请求成功。", taskName)
-			}
+	// 在后台启动 HTTP 服务器，如果启动失败（例如端口被占用），则记录致命错误并退出
+	go func() {
+		slog.Info("服务器正在启动并监听传入请求", "addr", addr, "version", Version)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("服务器启动失败", err)
 		}
-
-		// 优先使用 Cron 表达式进行调度（如果配置了 CronSpec）
-		if currentSchedulerCfg.CronSpec != "" {
-			// 将定时任务添加到 Cron 调度器
-			_, err := c.AddFunc(currentSchedulerCfg.CronSpec, scheduleTask)
-			if err != nil {
-				// 如果添加 Cron 表达式失败，则记录致命错误并退出程序，因为这通常是配置问题
-				log.Fatalf("%s：添加 Cron 表达式失败: %v", taskName, err)
-			}
-			log.Printf("%s 已启动，将使用 Cron 表达式: '%s' 定期调用 %s", taskName, currentSchedulerCfg.CronSpec, currentSchedulerCfg.TargetURL)
-		} else {
-			// 如果没有配置 Cron 表达式，则回退到旧的周期性调度方式
-			// 将周期性调度（间隔时间和单位）转换为 Cron 表达式，例如 "@every 1m"
-			var cronSpec string
-			switch currentSchedulerCfg.Unit {
-			case "second": // 单位为秒
-				cronSpec = fmt.Sprintf("@every %ds", currentSchedulerCfg.Interval)
-			case "minute": // 单位为分钟
-				cronSpec = fmt.Sprintf("@every %dm", currentSchedulerCfg.Interval)
-			case "hour": // 单位为小时
-				cronSpec = fmt.Sprintf("@every %dh", currentSchedulerCfg.Interval)
-			default: // 未知的单位
-				log.Printf("%s：检测到未知的定时任务单位: '%s'，此定时任务将不会启动。", taskName, currentSchedulerCfg.Unit)
-				continue // 跳过当前定时任务的配置
-			}
-
-			// 检查定时任务间隔时间是否有效
-			if currentSchedulerCfg.Interval <= 0 {
-				log.Printf("%s：定时任务间隔时间必须大于 0，此定时任务将不会启动。", taskName)
-				continue // 跳过当前定时任务的配置
-			}
-
-			// 将转换后的周期性 Cron 表达式添加到调度器
-			_, err := c.AddFunc(cronSpec, scheduleTask)
-			if err != nil {
-				// 如果添加周期性 Cron 表达式失败，则记录致命错误并退出程序
-				log.Fatalf("%s：添加周期性 Cron 表达式失败: %v", taskName, err)
-			}
-			log.Printf("%s 已启动，将每隔 %d %s 调用一次 %s (转换为 Cron 表达式: '%s')",
-				taskName, currentSchedulerCfg.Interval, currentSchedulerCfg.Unit, currentSchedulerCfg.TargetURL, cronSpec)
+	}()
+
+	// 等待 SIGINT 或 SIGTERM 信号，收到后优雅退出
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	shutdownTimeout := seconds(cfg.Server.ShutdownTimeoutSeconds, defaultShutdownTimeout)
+	slog.Info("收到信号，开始优雅退出", "signal", sig.String(), "timeout", shutdownTimeout.String())
+
+	// 1. 就绪检查开始失败，在 drain_delay_seconds 内继续接收请求，等待负载均衡器摘除实例；再次收到信号时立即继续
+	healthHandler.SetDraining()
+	if drainDelay := seconds(cfg.Server.DrainDelaySeconds, 0); drainDelay > 0 {
+		slog.Info("等待负载均衡器摘除实例", "delay", drainDelay.String())
+		select {
+		case <-time.After(drainDelay):
+		case sig := <-signals:
+			slog.Info("再次收到信号，跳过剩余的等待", "signal", sig.String())
 		}
 	}
 
-	// 启动 Cron 调度器 (在所有定时任务添加完毕后统一启动，使其开始执行)
-	c.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	// 启动 HTTP 服务器，监听指定端口
-	port := ":8080" // 服务器监听的端口号
-	log.Printf("服务器正在端口 %s 上启动并监听传入请求...", port)
-	// 启动 HTTP 服务器，如果启动失败（例如端口被占用），则记录致命错误并退出
-	err = http.ListenAndServe(port, nil)
-	if err != nil {
-		log.Fatalf("服务器启动失败: %v", err)
+	// 2. 停止接收新连接，等待进行中的请求完成；超时后强制关闭连接，进行中的同步请求随之取消
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("等待进行中的请求完成超时，强制关闭服务器", "error", err)
+		server.Close()
+	}
+	// 3. 停止定时任务调度，等待进行中的定时任务完成
+	if err := taskScheduler.Stop(ctx); err != nil {
+		slog.Warn("定时任务未能在退出前完成", "error", err)
+	}
+	// 4. 停止接受异步任务，等待排队中和进行中的任务完成
+	if err := jobManager.Shutdown(ctx); err != nil {
+		slog.Warn("异步任务未能在退出前完成", "error", err)
+	}
+	// 5. 等待企业微信发送队列中剩余的消息发送完毕
+	if err := wecom.DrainQueues(ctx); err != nil {
+		slog.Warn("企业微信发送队列未能在退出前清空", "error", err)
 	}
-	// 为了确保程序持续运行，如果 HTTP 服务器没有阻塞，可以添加一个阻塞语句
-	// 例如：select {}
-	// 但 http.ListenAndServe 理论上是阻塞的，如果它立即返回，说明有错误发生并被 log.Fatalf 捕获。
-	// 如果程序仍然立即退出且没有日志，可能是日志配置问题或环境问题。
+	slog.Info("服务已退出")
 }
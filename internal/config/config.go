@@ -5,63 +5,415 @@ import (
 	"os"            // 导入 os 包，用于文件操作和环境变量读取
 	"path/filepath" // 导入 filepath 包，用于处理文件路径
 	"strconv"       // 导入 strconv 包，用于字符串和基本数据类型之间的转换
+	"strings"       // 导入 strings 包，用于规范化命令名称
 
 	"gopkg.in/yaml.v2" // 导入 yaml.v2 包，用于 YAML 文件的编解码
 )
 
 // DifyConfig 结构体定义了 Dify API 的配置
 type DifyConfig struct {
-	APIKey        string `yaml:"api_key"`        // Dify API 密钥，用于认证 Dify API 请求
-	BaseURL       string `yaml:"base_url"`       // Dify API 基础 URL，例如 "https://api.dify.ai"
-	BotType       string `yaml:"bot_type"`       // Dify 应用类型，可以是 "chat", "completion", "workflow"
-	WorkflowID    string `yaml:"workflow_id"`    // Dify Workflow 应用的 ID，仅当 BotType 为 "workflow" 时需要
-	DefaultPrompt string `yaml:"default_prompt"` // 默认提示词，当用户消息为空时使用，或用于定时任务的默认输入
+	Name           string               `yaml:"name"`                // 应用名称，用于消息路由和 /app 命令，仅在 apps 列表中需要配置
+	APIKey         string               `yaml:"api_key"`             // Dify API 密钥，用于认证 Dify API 请求
+	BaseURL        string               `yaml:"base_url"`            // Dify API 基础 URL，例如 "https://api.dify.ai"
+	BotType        string               `yaml:"bot_type"`            // Dify 应用类型，可以是 "chat", "completion", "workflow"
+	WorkflowID     string               `yaml:"workflow_id"`         // Dify Workflow 应用的 ID，仅当 BotType 为 "workflow" 时需要
+	DefaultPrompt  string               `yaml:"default_prompt"`      // 默认提示词，当用户消息为空时使用，或用于定时任务的默认输入
+	ResponseMode   string               `yaml:"response_mode"`       // 响应模式，可以是 "blocking" (默认) 或 "streaming"，仅对 chat 类型应用生效
+	Citations      CitationsConfig      `yaml:"citations"`           // 引用来源配置，用于在回答后附上知识库的检索结果
+	Suggestions    SuggestionsConfig    `yaml:"suggested_questions"` // 推荐问题配置，用于在回答后发送 Dify 生成的下一步问题，仅对 chat 类型应用生效
+	Feedback       FeedbackConfig       `yaml:"feedback"`            // 回答评价配置，仅对 chat 和 completion 类型应用生效
+	WorkflowOutput WorkflowOutputConfig `yaml:"workflow_output"`     // 工作流运行结果的展示配置，仅对 workflow 类型应用生效
+}
+
+// WorkflowOutputConfig 结构体定义了如何展示工作流的运行结果 (data.outputs)
+// Fields 选择要展示的输出字段；Template 是 Go text/template 模板，可以使用 table、list、number、date 等辅助函数，
+// 为空时按字段逐行列出。Format 决定发送的消息类型，不支持图文消息或模板卡片的投递目标收到 Markdown。
+// Title、URL 和 PicURL 同样是模板，可以引用输出字段，例如 "{{.Outputs.report_url}}"。
+type WorkflowOutputConfig struct {
+	Fields   []string `yaml:"fields"`   // 要展示的 outputs 字段，按列出的顺序展示，为空时展示全部字段 (按字段名排序)
+	Format   string   `yaml:"format"`   // 消息类型，可以是 "markdown" (默认)、"text"、"news" (图文消息) 或 "template_card" (text_notice 模板卡片)
+	Template string   `yaml:"template"` // 正文模板，为空时按字段逐行列出，只有一个文本字段时直接发送该字段
+	Title    string   `yaml:"title"`    // 标题模板，format 为 news 或 template_card 时使用，默认 "工作流运行结果"
+	URL      string   `yaml:"url"`      // 点击消息后跳转的地址模板，format 为 news 或 template_card 时必须配置
+	PicURL   string   `yaml:"pic_url"`  // 图文消息的图片地址模板，仅 format 为 news 时使用
+}
+
+// FeedbackConfig 结构体定义了用户如何评价回答
+// 用户始终可以通过 /good 和 /bad 命令评价最近的一条回答；开启 Buttons 后，每条回答之后还会发送带有
+// "有帮助" 和 "没帮助" 按钮的 button_interaction 模板卡片，只发送给支持模板卡片的投递目标。
+type FeedbackConfig struct {
+	Buttons bool `yaml:"buttons"` // 是否在回答后发送评价按钮卡片，默认关闭
+}
+
+// SuggestionsConfig 结构体定义了如何发送 Dify 的推荐问题 (GET /v1/messages/{message_id}/suggested)
+// Dify 应用需要开启 "下一步问题建议"。Style 为 "button" (默认) 时以 button_interaction 模板卡片发送，
+// 点击按钮后问题经消息回调作为用户的下一条消息提交；为 "text" 时以 text_notice 模板卡片列出问题，
+// 智能机器人中点击问题会直接向机器人提问。不支持模板卡片的投递目标收到带编号的 Markdown 列表。
+type SuggestionsConfig struct {
+	Enable       bool   `yaml:"enable"`        // 是否在回答后发送推荐问题，默认关闭
+	Style        string `yaml:"style"`         // 展示方式，可以是 "button" (默认) 或 "text"
+	MaxQuestions int    `yaml:"max_questions"` // 最多展示的问题数，默认 3；button 方式最多 6 个，text 方式最多 3 个
+}
+
+// CitationsConfig 结构体定义了如何展示 Dify 知识库检索结果 (metadata.retriever_resources)
+// Style 为 "markdown" (默认) 时在回答后发送一条带编号的 "参考来源" Markdown 消息；
+// 为 "card" 时以 news_notice 模板卡片发送，不支持模板卡片的投递目标仍收到 Markdown。
+type CitationsConfig struct {
+	Enable        bool    `yaml:"enable"`         // 是否在回答后附上引用来源，默认关闭
+	Style         string  `yaml:"style"`          // 展示方式，可以是 "markdown" (默认) 或 "card"
+	MinScore      float64 `yaml:"min_score"`      // 最低相关度 (0 到 1)，低于该值的检索结果不展示，默认 0 表示全部展示
+	MaxSources    int     `yaml:"max_sources"`    // 最多展示的来源数，默认 3；card 方式最多 4 条
+	SnippetLength int     `yaml:"snippet_length"` // 每条来源展示的片段长度 (字符数)，默认 60，负数表示不展示片段
+	CardImageURL  string  `yaml:"card_image_url"` // 模板卡片的封面图片地址，仅 style 为 "card" 时需要
+	CardURL       string  `yaml:"card_url"`       // 点击模板卡片后跳转的地址，例如知识库页面，仅 style 为 "card" 时需要
 }
 
 // WeComConfig 结构体定义了企业微信机器人的配置
+// Type 为 "robot" (默认) 时通过群机器人 Webhook 发送；为 "app" 时通过自建应用的应用消息接口发给指定的成员、部门或标签。
 type WeComConfig struct {
-	WebhookURL string `yaml:"webhook_url"` // 企业微信机器人 Webhook URL，用于发送消息到企业微信群
+	Name               string   `yaml:"name"`                  // 机器人名称，用于在 Webhook 请求和定时任务中指定投递目标，仅在 robots 列表中需要配置
+	Type               string   `yaml:"type"`                  // 发送方类型，可以是 "robot" (默认，群机器人)、"app" (自建应用)、"feishu" (飞书/Lark)、"dingtalk" (钉钉) 或 "slack" (Slack 兼容的 Incoming Webhook)
+	WebhookURL         string   `yaml:"webhook_url"`           // 群机器人 Webhook URL，用于发送消息到群聊，type 为 "app" 以外的类型都需要
+	Secret             string   `yaml:"secret"`                // 飞书或钉钉自定义机器人的签名密钥，开启 "签名校验"/"加签" 安全设置时需要
+	CorpID             string   `yaml:"corp_id"`               // 企业 ID，仅 type 为 "app" 时需要
+	CorpSecret         string   `yaml:"corp_secret"`           // 自建应用的 Secret，用于获取 access_token，仅 type 为 "app" 时需要
+	AgentID            int      `yaml:"agent_id"`              // 自建应用的 AgentId，仅 type 为 "app" 时需要
+	ToUser             []string `yaml:"to_user"`               // 接收消息的成员 userid 列表，"@all" 表示应用可见范围内的全部成员
+	ToParty            []string `yaml:"to_party"`              // 接收消息的部门 ID 列表
+	ToTag              []string `yaml:"to_tag"`                // 接收消息的标签 ID 列表
+	RateLimitPerMinute int      `yaml:"rate_limit_per_minute"` // 每个机器人每分钟允许发送的消息数，默认为各渠道的上限 (企业微信和钉钉 20、飞书 100、Slack 60)
+	QueueSize          int      `yaml:"queue_size"`            // 每个机器人发送队列的最大长度，队列满时新消息会被丢弃，默认 100
+}
+
+// StoreConfig 结构体定义了对话存储的配置
+type StoreConfig struct {
+	Type            string `yaml:"type"`             // 存储类型，可以是 "memory" (默认), "bolt" (本地文件), "redis"
+	Path            string `yaml:"path"`             // BoltDB 数据库文件路径，仅当 Type 为 "bolt" 时生效，默认 "data/conversations.db"
+	RedisAddr       string `yaml:"redis_addr"`       // Redis 地址，例如 "localhost:6379"，仅当 Type 为 "redis" 时生效
+	RedisPassword   string `yaml:"redis_password"`   // Redis 密码，可以为空
+	RedisDB         int    `yaml:"redis_db"`         // Redis 数据库编号，默认 0
+	KeyPrefix       string `yaml:"key_prefix"`       // Redis 键前缀，默认 "dify2wxbot:conv:"
+	IdleTTLMinutes  int    `yaml:"idle_ttl_minutes"` // 对话最长空闲时间 (分钟)，超过后自动开启新对话，0 表示不限制
+	MaxTurns        int    `yaml:"max_turns"`        // 单个对话的最大问答轮数，达到后自动开启新对话，0 表示不限制
+	JanitorInterval int    `yaml:"janitor_interval"` // 后台清理空闲对话的间隔 (秒)，默认 60，仅对 memory 和 bolt 生效
+}
+
+// AsyncConfig 结构体定义了 Webhook 异步处理的配置
+type AsyncConfig struct {
+	Workers          int `yaml:"workers"`           // 并发处理异步任务的 worker 数量，默认 4
+	QueueSize        int `yaml:"queue_size"`        // 等待处理的异步任务队列长度，队列满时拒绝新任务，默认 100
+	RetentionMinutes int `yaml:"retention_minutes"` // 已完成的任务保留多长时间 (分钟) 以供查询，默认 60
+}
+
+// ServerConfig 结构体定义了 HTTP 服务器的配置
+type ServerConfig struct {
+	Addr                   string `yaml:"addr"`                     // 监听地址，例如 ":7860" 或 "127.0.0.1:8080"，默认 ":7860"
+	ReadTimeoutSeconds     int    `yaml:"read_timeout_seconds"`     // 读取整个请求 (包括上传的文件) 的超时时间 (秒)，默认 60
+	WriteTimeoutSeconds    int    `yaml:"write_timeout_seconds"`    // 写入响应的超时时间 (秒)，同步请求需要等待 Dify 回复，应大于 timeouts 中的截止时间，默认 360
+	IdleTimeoutSeconds     int    `yaml:"idle_timeout_seconds"`     // keep-alive 连接的空闲超时时间 (秒)，默认 120
+	ShutdownTimeoutSeconds int    `yaml:"shutdown_timeout_seconds"` // 收到 SIGTERM 后等待进行中的请求、异步任务和定时任务完成的最长时间 (秒)，超过后强制取消，默认 60
+	DrainDelaySeconds      int    `yaml:"drain_delay_seconds"`      // 收到 SIGTERM 后 /readyz 返回 503 但仍继续接收新请求的时间 (秒)，留给负载均衡器摘除实例，默认 0 (不等待)
+}
+
+// CallbackConfig 结构体定义了企业微信消息回调 (智能机器人或群机器人接收消息) 的配置
+// Token 和 EncodingAESKey 与企业微信管理后台中 "接收消息" 的配置保持一致，用于校验签名和加解密消息。
+type CallbackConfig struct {
+	Enable         bool     `yaml:"enable"`           // 是否启用 /wecom/callback，默认关闭
+	Token          string   `yaml:"token"`            // 回调配置中的 Token，用于计算和校验 msg_signature
+	EncodingAESKey string   `yaml:"encoding_aes_key"` // 回调配置中的 EncodingAESKey (43 个字符)，用于 AES-256-CBC 加解密消息
+	ReceiveID      string   `yaml:"receive_id"`       // 加密消息中的接收方 ID，自建应用为企业 CorpID，智能机器人和群机器人留空
+	App            string   `yaml:"app"`              // 处理回调消息的 Dify 应用名称，为空时由路由规则决定
+	Targets        []string `yaml:"targets"`          // 消息中没有 response_url 或 WebhookUrl 时回复的投递目标 (机器人名称) 列表，为空时使用 default_targets
+	WelcomeMessage string   `yaml:"welcome_message"`  // 用户进入与机器人的单聊会话时被动回复的欢迎语，为空时不回复
+}
+
+// TimeoutConfig 结构体定义了按 Dify 应用类型区分的请求截止时间
+// 截止时间覆盖一条消息调用 Dify 的全过程，包括文件上传、重试等待和流式响应的读取，超过后请求被取消。
+type TimeoutConfig struct {
+	ChatSeconds       int `yaml:"chat_seconds"`       // chat 类型应用的截止时间 (秒)，默认 120
+	CompletionSeconds int `yaml:"completion_seconds"` // completion 类型应用的截止时间 (秒)，默认 120
+	WorkflowSeconds   int `yaml:"workflow_seconds"`   // workflow 类型应用的截止时间 (秒)，默认 300
+}
+
+// RetryConfig 结构体定义了调用 Dify API 失败时的重试策略
+// 网络错误、HTTP 429/5xx 以及 Dify 返回的 rate_limit、server_error 错误会按指数退避加随机抖动重试，
+// 响应中带有 Retry-After 头时以其为准；参数错误 (invalid_param) 等其他错误不会重试。
+type RetryConfig struct {
+	MaxAttempts      int `yaml:"max_attempts"`       // 每次调用的最大尝试次数 (包含首次请求)，默认 3，1 表示不重试
+	InitialBackoffMs int `yaml:"initial_backoff_ms"` // 首次重试前的退避时间 (毫秒)，之后每次翻倍，默认 1000
+	MaxBackoffMs     int `yaml:"max_backoff_ms"`     // 单次退避时间的上限 (毫秒)，默认 10000
+	DeadlineSeconds  int `yaml:"deadline_seconds"`   // 一次调用中允许重试的总时限 (秒)，剩余时间不足以等待下一次重试时直接返回错误，默认 120；与 timeouts 中的截止时间以较早者为准，不会中断进行中的请求
+}
+
+// CommandConfig 结构体定义了一个通过配置注册的斜杠命令
+// 设置了 Reply 时直接回复固定内容；否则将 Prompt 与命令参数拼接后转发给 App 指定的 Dify 应用。
+type CommandConfig struct {
+	Name         string   `yaml:"name"`          // 命令名称，不含前导 "/"，例如 "translate"
+	Description  string   `yaml:"description"`   // 命令说明，显示在 /help 中
+	Reply        string   `yaml:"reply"`         // 固定回复内容，设置后直接回复而不调用 Dify
+	App          string   `yaml:"app"`           // 转发到的 Dify 应用名称，为空时使用当前应用
+	Prompt       string   `yaml:"prompt"`        // 转发到 Dify 时添加在命令参数前面的提示词，例如 "请将以下内容翻译成英文："
+	AllowedUsers []string `yaml:"allowed_users"` // 允许使用该命令的用户列表，为空时所有用户都可以使用
+}
+
+// RouteConfig 结构体定义了一条消息路由规则
+// 规则中设置的所有条件都满足时命中；Prefix 或 Keywords 按消息内容匹配，优先于用户通过 /app 选择的应用；
+// 只设置了 Users 或 Groups 的规则按发送方匹配，用户通过 /app 选择的应用优先于这类规则。
+type RouteConfig struct {
+	App      string   `yaml:"app"`      // 命中规则时使用的 Dify 应用名称
+	Prefix   string   `yaml:"prefix"`   // 消息前缀，例如 "#翻译"，命中后会从消息中去掉前缀再发送给 Dify
+	Keywords []string `yaml:"keywords"` // 关键词列表，消息包含任意一个关键词时满足条件
+	Users    []string `yaml:"users"`    // 用户列表，消息来自其中任意一个用户时满足条件
+	Groups   []string `yaml:"groups"`   // 群组列表，消息来自其中任意一个群组时满足条件 (对应 Webhook 请求中的 group 字段)
 }
 
 // SchedulerConfig 结构体定义了定时任务的配置
+// 定时任务在进程内直接调用消息处理流程；DefaultMessage、User 和 Inputs 中的字符串值支持 Go 模板，例如 "今天是 {{.Date}}"。
 type SchedulerConfig struct {
-	Enable         bool   `yaml:"enable"`          // 是否启用当前定时任务 (true: 启用, false: 禁用)
-	CronSpec       string `yaml:"cron_spec"`       // Cron 表达式，用于更灵活的定时调度，例如 "0 0 * * *" 表示每天午夜执行
-	Interval       int    `yaml:"interval"`        // 定时任务间隔时间，当 CronSpec 为空时生效，表示每隔多少单位时间执行一次
-	Unit           string `yaml:"unit"`            // 时间单位，当 CronSpec 为空时生效，可以是 "second", "minute", "hour"
-	TargetURL      string `yaml:"target_url"`      // 定时调用的目标 URL，通常是本服务的 Webhook 地址
-	DefaultMessage string `yaml:"default_message"` // 定时调用时发送的默认消息内容
+	Enable           bool                   `yaml:"enable"`            // 是否启用当前定时任务 (true: 启用, false: 禁用)
+	Name             string                 `yaml:"name"`              // 定时任务名称，用于日志和模板，为空时使用 "定时器 N"
+	CronSpec         string                 `yaml:"cron_spec"`         // Cron 表达式，用于更灵活的定时调度，例如 "0 0 * * *" 表示每天午夜执行
+	Interval         int                    `yaml:"interval"`          // 定时任务间隔时间，当 CronSpec 为空时生效，表示每隔多少单位时间执行一次
+	Unit             string                 `yaml:"unit"`              // 时间单位，当 CronSpec 为空时生效，可以是 "second", "minute", "hour"
+	TargetURL        string                 `yaml:"target_url"`        // 已废弃：设置后改为通过 HTTP 调用该 URL (例如其他服务的 Webhook 地址)，而不是在进程内处理
+	DefaultMessage   string                 `yaml:"default_message"`   // 定时调用时发送的消息内容，支持模板
+	Targets          []string               `yaml:"targets"`           // 回复的投递目标 (机器人名称) 列表，为空时使用 default_targets
+	App              string                 `yaml:"app"`               // 处理消息的 Dify 应用名称，为空时由路由规则决定
+	Inputs           map[string]interface{} `yaml:"inputs"`            // 传给 Dify 应用的变量，例如工作流的输入参数，字符串值支持模板
+	User             string                 `yaml:"user"`              // 调用 Dify 时使用的用户标识，支持模板，为空时使用 "scheduler_bot_N"
+	KeepConversation bool                   `yaml:"keep_conversation"` // 是否在多次运行之间沿用同一个对话，默认每次运行都开启新的对话
 }
 
 // AppConfig 结构体定义了整个应用程序的配置
 type AppConfig struct {
-	Dify            DifyConfig        `yaml:"dify"`             // Dify 配置部分，包含 Dify API 相关的设置
-	WeCom           WeComConfig       `yaml:"wecom"`            // WeCom (企业微信) 配置部分，包含企业微信机器人相关的设置
+	Dify            DifyConfig        `yaml:"dify"`             // Dify 配置部分，包含 Dify API 相关的设置；配置了 apps 时被忽略
+	Apps            []DifyConfig      `yaml:"apps"`             // 多个具名 Dify 应用的配置列表，配合 routes 按消息选择应用
+	DefaultApp      string            `yaml:"default_app"`      // 默认 Dify 应用名称，没有路由规则命中时使用，为空时使用 apps 中的第一个
+	Routes          []RouteConfig     `yaml:"routes"`           // 消息路由规则列表，决定每条消息交给哪个 Dify 应用处理
+	WeCom           WeComConfig       `yaml:"wecom"`            // WeCom (企业微信) 配置部分，包含企业微信机器人相关的设置；配置了 robots 时被忽略
+	Robots          []WeComConfig     `yaml:"robots"`           // 多个具名企业微信机器人的配置列表，每条回复可以扇出到其中的多个机器人
+	DefaultTargets  []string          `yaml:"default_targets"`  // 默认投递目标 (机器人名称) 列表，请求未指定目标时使用，为空时使用 robots 中的第一个
+	Store           StoreConfig       `yaml:"store"`            // 对话存储配置部分，决定用户对话 ID 保存在内存、本地文件还是 Redis 中
+	Async           AsyncConfig       `yaml:"async"`            // Webhook 异步处理配置部分，决定异步任务的并发数、队列长度和保留时间
+	Retry           RetryConfig       `yaml:"retry"`            // Dify API 重试策略配置部分，决定失败时的重试次数、退避时间和总时限
+	Timeouts        TimeoutConfig     `yaml:"timeouts"`         // 请求截止时间配置部分，按 Dify 应用类型设置调用 Dify 的最长时间
+	Server          ServerConfig      `yaml:"server"`           // HTTP 服务器配置部分，包含监听地址、读写超时和优雅退出的等待时间
+	Callback        CallbackConfig    `yaml:"callback"`         // 企业微信消息回调配置部分，启用后群成员 @机器人 的消息通过 /wecom/callback 进入服务
 	AuthToken       string            `yaml:"auth_token"`       // 用于 Webhook 认证的 Token，客户端请求时需在 Authorization 头中携带
 	EnableAuth      bool              `yaml:"enable_auth"`      // 是否开启认证 Token 功能，如果为 true，则所有 Webhook 请求都需要认证
 	Schedulers      []SchedulerConfig `yaml:"schedulers"`       // 定时任务配置列表部分，支持配置多个独立的定时器
+	Commands        []CommandConfig   `yaml:"commands"`         // 自定义斜杠命令列表，例如 "/translate"，内置命令无需配置
 	LogToFile       bool              `yaml:"log_to_file"`      // 是否将日志输出到文件，如果为 true，日志将写入到指定文件
 	LogFilePath     string            `yaml:"log_file_path"`    // 日志文件路径，当 log_to_file 为 true 时生效，例如 "logs/app.log"
 	LogMaxSizeBytes int               `yaml:"log_max_size_mb"`  // 日志文件最大大小 (MB)，达到此大小后会进行切割，防止单个日志文件过大
 	LogMaxBackups   int               `yaml:"log_max_backups"`  // 日志文件最大备份数量，超出此数量的旧文件会被删除
 	LogMaxAgeDays   int               `yaml:"log_max_age_days"` // 日志文件最大保留天数，超出此天数的旧文件会被删除
 	LogCompress     bool              `yaml:"log_compress"`     // 是否压缩旧的日志文件（gzip 格式），以节省存储空间
+	LogLevel        string            `yaml:"log_level"`        // 日志级别，可以是 "debug", "info" (默认), "warn", "error"；消息内容和 Dify 响应体只在 debug 级别输出
+	LogFormat       string            `yaml:"log_format"`       // 日志格式，可以是 "json" (默认) 或 "text"
 }
 
 // Validate 方法用于验证 AppConfig 结构体中的必要配置项是否已设置
 // 如果有任何必要配置项缺失，将返回一个错误
 func (c *AppConfig) Validate() error {
-	// 检查 Dify API Key 是否为空，这是与 Dify 交互的必备条件
-	if c.Dify.APIKey == "" {
-		return fmt.Errorf("dify API Key 未配置")
+	// 检查每个 Dify 应用的必要配置，未配置 apps 时检查 dify 部分
+	apps := c.DifyApps()
+	appNames := make(map[string]bool)
+	for _, app := range apps {
+		if app.Name == "" {
+			return fmt.Errorf("apps 中的 Dify 应用必须配置 name")
+		}
+		if appNames[app.Name] {
+			return fmt.Errorf("dify 应用名称重复: %s", app.Name)
+		}
+		appNames[app.Name] = true
+		// 检查 Dify API Key 是否为空，这是与 Dify 交互的必备条件
+		if app.APIKey == "" {
+			return fmt.Errorf("dify 应用 %s 的 API Key 未配置", app.Name)
+		}
+		// 检查 Dify Base URL 是否为空，这是 Dify API 的访问地址
+		if app.BaseURL == "" {
+			return fmt.Errorf("dify 应用 %s 的 Base URL 未配置", app.Name)
+		}
+		// 检查 Dify 响应模式是否合法，留空时使用阻塞模式
+		switch app.ResponseMode {
+		case "", "blocking", "streaming":
+		default:
+			return fmt.Errorf("dify 应用 %s 的 response_mode 配置无效: %s，仅支持 blocking 或 streaming", app.Name, app.ResponseMode)
+		}
+		// 检查推荐问题配置
+		if suggestions := app.Suggestions; suggestions.Enable {
+			switch suggestions.Style {
+			case "", "button", "text":
+			default:
+				return fmt.Errorf("dify 应用 %s 的 suggested_questions.style 配置无效: %s，仅支持 button 或 text", app.Name, suggestions.Style)
+			}
+			if suggestions.MaxQuestions < 0 {
+				return fmt.Errorf("dify 应用 %s 的 suggested_questions.max_questions 不能为负数", app.Name)
+			}
+		}
+		// 检查工作流输出配置，图文消息和模板卡片必须有跳转地址；模板语法在创建消息转换器前检查
+		if output := app.WorkflowOutput; app.BotType == "workflow" {
+			switch output.Format {
+			case "", "markdown", "text":
+			case "news", "template_card":
+				if output.URL == "" {
+					return fmt.Errorf("dify 应用 %s 的 workflow_output.format 为 %s 时必须配置 url", app.Name, output.Format)
+				}
+			default:
+				return fmt.Errorf("dify 应用 %s 的 workflow_output.format 配置无效: %s，仅支持 markdown、text、news 或 template_card", app.Name, output.Format)
+			}
+		}
+		// 检查引用来源配置，模板卡片必须有封面图片和跳转地址
+		if citations := app.Citations; citations.Enable {
+			switch citations.Style {
+			case "", "markdown":
+			case "card":
+				if citations.CardImageURL == "" || citations.CardURL == "" {
+					return fmt.Errorf("dify 应用 %s 的 citations.style 为 card 时必须配置 card_image_url 和 card_url", app.Name)
+				}
+			default:
+				return fmt.Errorf("dify 应用 %s 的 citations.style 配置无效: %s，仅支持 markdown 或 card", app.Name, citations.Style)
+			}
+			if citations.MinScore < 0 || citations.MinScore > 1 {
+				return fmt.Errorf("dify 应用 %s 的 citations.min_score 必须在 0 到 1 之间", app.Name)
+			}
+			if citations.MaxSources < 0 {
+				return fmt.Errorf("dify 应用 %s 的 citations.max_sources 不能为负数", app.Name)
+			}
+		}
+	}
+	// 检查默认应用和路由规则引用的应用是否存在
+	if c.DefaultApp != "" && !appNames[c.DefaultApp] {
+		return fmt.Errorf("default_app 引用了不存在的 Dify 应用: %s", c.DefaultApp)
+	}
+	for i, route := range c.Routes {
+		if !appNames[route.App] {
+			return fmt.Errorf("第 %d 条路由规则引用了不存在的 Dify 应用: '%s'", i+1, route.App)
+		}
+		if route.Prefix == "" && len(route.Keywords) == 0 && len(route.Users) == 0 && len(route.Groups) == 0 {
+			return fmt.Errorf("第 %d 条路由规则没有设置任何匹配条件", i+1)
+		}
+	}
+	// 检查每个企业微信机器人的必要配置，未配置 robots 时检查 wecom 部分
+	robotNames := make(map[string]bool)
+	for _, robot := range c.WeComRobots() {
+		if robot.Name == "" {
+			return fmt.Errorf("robots 中的企业微信机器人必须配置 name")
+		}
+		if robotNames[robot.Name] {
+			return fmt.Errorf("企业微信机器人名称重复: %s", robot.Name)
+		}
+		robotNames[robot.Name] = true
+		switch robot.Type {
+		case "", "robot", "feishu", "dingtalk", "slack":
+			// 检查 Webhook URL 是否为空，这是发送消息到群聊的必要条件
+			if robot.WebhookURL == "" {
+				return fmt.Errorf("企业微信机器人 %s 的 Webhook URL 未配置", robot.Name)
+			}
+		case "app":
+			// 自建应用需要 corp_id、corp_secret 和 agent_id 获取 access_token，并至少指定一类接收人
+			if robot.CorpID == "" || robot.CorpSecret == "" || robot.AgentID == 0 {
+				return fmt.Errorf("企业微信自建应用 %s 必须配置 corp_id、corp_secret 和 agent_id", robot.Name)
+			}
+			if len(robot.ToUser) == 0 && len(robot.ToParty) == 0 && len(robot.ToTag) == 0 {
+				return fmt.Errorf("企业微信自建应用 %s 必须配置 to_user、to_party 或 to_tag 中的至少一项", robot.Name)
+			}
+		default:
+			return fmt.Errorf("企业微信机器人 %s 的 type 无效: %s (可选值: robot, app, feishu, dingtalk, slack)", robot.Name, robot.Type)
+		}
+	}
+	// 检查默认投递目标和定时任务的投递目标是否存在
+	for _, target := range c.DefaultTargets {
+		if !robotNames[target] {
+			return fmt.Errorf("default_targets 引用了不存在的企业微信机器人: %s", target)
+		}
+	}
+	for i, scheduler := range c.Schedulers {
+		for _, target := range scheduler.Targets {
+			if !robotNames[target] {
+				return fmt.Errorf("第 %d 个定时任务的 targets 引用了不存在的企业微信机器人: %s", i+1, target)
+			}
+		}
+		if scheduler.App != "" && !appNames[scheduler.App] {
+			return fmt.Errorf("第 %d 个定时任务引用了不存在的 Dify 应用: %s", i+1, scheduler.App)
+		}
+	}
+	// 检查对话存储类型是否合法，以及所选类型的必要参数是否已配置
+	switch c.Store.Type {
+	case "", "memory", "bolt":
+	case "redis":
+		if c.Store.RedisAddr == "" {
+			return fmt.Errorf("对话存储类型为 redis 但未配置 redis_addr")
+		}
+	default:
+		return fmt.Errorf("对话存储类型配置无效: %s，仅支持 memory、bolt 或 redis", c.Store.Type)
+	}
+	// 检查对话过期策略是否合法
+	if c.Store.IdleTTLMinutes < 0 || c.Store.MaxTurns < 0 || c.Store.JanitorInterval < 0 {
+		return fmt.Errorf("对话存储的 idle_ttl_minutes、max_turns 和 janitor_interval 不能为负数")
 	}
-	// 检查 Dify Base URL 是否为空，这是 Dify API 的访问地址
-	if c.Dify.BaseURL == "" {
-		return fmt.Errorf("dify Base URL 未配置")
+	// 检查异步处理配置是否合法
+	if c.Async.Workers < 0 || c.Async.QueueSize < 0 || c.Async.RetentionMinutes < 0 {
+		return fmt.Errorf("async 的 workers、queue_size 和 retention_minutes 不能为负数")
 	}
-	// 检查企业微信 Webhook URL 是否为空，这是发送消息到企业微信的必要条件
-	if c.WeCom.WebhookURL == "" {
-		return fmt.Errorf("企业微信 Webhook URL 未配置")
+	// 检查重试策略是否合法
+	if c.Retry.MaxAttempts < 0 || c.Retry.InitialBackoffMs < 0 || c.Retry.MaxBackoffMs < 0 || c.Retry.DeadlineSeconds < 0 {
+		return fmt.Errorf("retry 的 max_attempts、initial_backoff_ms、max_backoff_ms 和 deadline_seconds 不能为负数")
+	}
+	if c.Retry.InitialBackoffMs > 0 && c.Retry.MaxBackoffMs > 0 && c.Retry.InitialBackoffMs > c.Retry.MaxBackoffMs {
+		return fmt.Errorf("retry 的 initial_backoff_ms 不能大于 max_backoff_ms")
+	}
+	// 检查 HTTP 服务器的超时配置是否合法
+	if c.Server.ReadTimeoutSeconds < 0 || c.Server.WriteTimeoutSeconds < 0 || c.Server.IdleTimeoutSeconds < 0 || c.Server.ShutdownTimeoutSeconds < 0 || c.Server.DrainDelaySeconds < 0 {
+		return fmt.Errorf("server 的 read_timeout_seconds、write_timeout_seconds、idle_timeout_seconds、shutdown_timeout_seconds 和 drain_delay_seconds 不能为负数")
+	}
+	// 检查消息回调的加解密配置是否完整，以及引用的应用和投递目标是否存在
+	if c.Callback.Enable {
+		if c.Callback.Token == "" {
+			return fmt.Errorf("消息回调已启用但未配置 callback.token")
+		}
+		if len(c.Callback.EncodingAESKey) != 43 {
+			return fmt.Errorf("callback.encoding_aes_key 必须是 43 个字符")
+		}
+		if c.Callback.App != "" && !appNames[c.Callback.App] {
+			return fmt.Errorf("callback.app 引用了不存在的 Dify 应用: %s", c.Callback.App)
+		}
+		for _, target := range c.Callback.Targets {
+			if !robotNames[target] {
+				return fmt.Errorf("callback.targets 引用了不存在的企业微信机器人: %s", target)
+			}
+		}
+	}
+	// 检查请求截止时间是否合法
+	if c.Timeouts.ChatSeconds < 0 || c.Timeouts.CompletionSeconds < 0 || c.Timeouts.WorkflowSeconds < 0 {
+		return fmt.Errorf("timeouts 的 chat_seconds、completion_seconds 和 workflow_seconds 不能为负数")
+	}
+	// 检查日志级别和格式是否合法
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log_level 配置无效: %s，仅支持 debug、info、warn 或 error", c.LogLevel)
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "", "json", "text":
+	default:
+		return fmt.Errorf("log_format 配置无效: %s，仅支持 json 或 text", c.LogFormat)
+	}
+	// 检查自定义命令是否有名称，且名称不重复
+	commandNames := make(map[string]bool)
+	for i, command := range c.Commands {
+		name := strings.ToLower(strings.TrimPrefix(command.Name, "/"))
+		if name == "" || strings.ContainsAny(name, " \t\n") {
+			return fmt.Errorf("第 %d 个自定义命令的名称无效: '%s'", i+1, command.Name)
+		}
+		if commandNames[name] {
+			return fmt.Errorf("自定义命令重复: /%s", name)
+		}
+		commandNames[name] = true
+		if command.App != "" && !appNames[command.App] {
+			return fmt.Errorf("自定义命令 /%s 引用了不存在的 Dify 应用: %s", name, command.App)
+		}
 	}
 	// 如果配置中开启了认证功能，则检查 Auth Token 是否已配置
 	if c.EnableAuth && c.AuthToken == "" {
@@ -70,6 +422,37 @@ func (c *AppConfig) Validate() error {
 	return nil // 所有必要配置都已设置，返回 nil 表示验证成功
 }
 
+// DifyApps 返回所有 Dify 应用的配置
+// 配置了 apps 时返回 apps；否则将 dify 部分作为唯一的应用返回，名称默认为 "default"。
+func (c *AppConfig) DifyApps() []DifyConfig {
+	if len(c.Apps) > 0 {
+		return c.Apps
+	}
+	app := c.Dify
+	if app.Name == "" {
+		app.Name = DefaultAppName
+	}
+	return []DifyConfig{app}
+}
+
+// WeComRobots 返回所有企业微信机器人的配置
+// 配置了 robots 时返回 robots；否则将 wecom 部分作为唯一的机器人返回，名称默认为 "default"。
+func (c *AppConfig) WeComRobots() []WeComConfig {
+	if len(c.Robots) > 0 {
+		return c.Robots
+	}
+	robot := c.WeCom
+	if robot.Name == "" {
+		robot.Name = DefaultRobotName
+	}
+	return []WeComConfig{robot}
+}
+
+const (
+	DefaultAppName   = "default" // DefaultAppName 是未配置 apps 时 dify 部分对应的应用名称
+	DefaultRobotName = "default" // DefaultRobotName 是未配置 robots 时 wecom 部分对应的机器人名称
+)
+
 // LoadConfig 函数用于加载应用程序配置
 // 它首先尝试从名为 "config/config.yaml" 的 YAML 文件加载配置。
 // 如果 YAML 文件不存在或加载失败，它将回退到从环境变量加载配置。
@@ -104,26 +487,113 @@ func LoadConfig() (*AppConfig, error) {
 				BotType:       os.Getenv("DIFY_BOT_TYPE"),       // 从环境变量 DIFY_BOT_TYPE 获取 Dify 应用类型
 				WorkflowID:    os.Getenv("DIFY_WORKFLOW_ID"),    // 从环境变量 DIFY_WORKFLOW_ID 获取 Dify Workflow ID
 				DefaultPrompt: os.Getenv("DIFY_DEFAULT_PROMPT"), // 从环境变量 DIFY_DEFAULT_PROMPT 获取默认提示词
+				ResponseMode:  os.Getenv("DIFY_RESPONSE_MODE"),  // 从环境变量 DIFY_RESPONSE_MODE 获取响应模式
+				Citations: CitationsConfig{ // 引用来源配置部分
+					Enable:        os.Getenv("DIFY_CITATIONS_ENABLE") == "true",            // 从环境变量 DIFY_CITATIONS_ENABLE 获取是否附上引用来源
+					Style:         os.Getenv("DIFY_CITATIONS_STYLE"),                       // 从环境变量 DIFY_CITATIONS_STYLE 获取展示方式
+					MinScore:      parseFloat(os.Getenv("DIFY_CITATIONS_MIN_SCORE"), 0),    // 从环境变量 DIFY_CITATIONS_MIN_SCORE 获取最低相关度
+					MaxSources:    parseInt(os.Getenv("DIFY_CITATIONS_MAX_SOURCES"), 0),    // 从环境变量 DIFY_CITATIONS_MAX_SOURCES 获取最多展示的来源数
+					SnippetLength: parseInt(os.Getenv("DIFY_CITATIONS_SNIPPET_LENGTH"), 0), // 从环境变量 DIFY_CITATIONS_SNIPPET_LENGTH 获取片段长度
+					CardImageURL:  os.Getenv("DIFY_CITATIONS_CARD_IMAGE_URL"),              // 从环境变量 DIFY_CITATIONS_CARD_IMAGE_URL 获取模板卡片封面图片
+					CardURL:       os.Getenv("DIFY_CITATIONS_CARD_URL"),                    // 从环境变量 DIFY_CITATIONS_CARD_URL 获取模板卡片跳转地址
+				},
+				Suggestions: SuggestionsConfig{ // 推荐问题配置部分
+					Enable:       os.Getenv("DIFY_SUGGESTED_QUESTIONS_ENABLE") == "true",           // 从环境变量 DIFY_SUGGESTED_QUESTIONS_ENABLE 获取是否发送推荐问题
+					Style:        os.Getenv("DIFY_SUGGESTED_QUESTIONS_STYLE"),                      // 从环境变量 DIFY_SUGGESTED_QUESTIONS_STYLE 获取展示方式
+					MaxQuestions: parseInt(os.Getenv("DIFY_SUGGESTED_QUESTIONS_MAX_QUESTIONS"), 0), // 从环境变量 DIFY_SUGGESTED_QUESTIONS_MAX_QUESTIONS 获取最多展示的问题数
+				},
+				Feedback: FeedbackConfig{ // 回答评价配置部分
+					Buttons: os.Getenv("DIFY_FEEDBACK_BUTTONS") == "true", // 从环境变量 DIFY_FEEDBACK_BUTTONS 获取是否发送评价按钮卡片
+				},
+				WorkflowOutput: WorkflowOutputConfig{ // 工作流输出配置部分
+					Fields:   splitList(os.Getenv("DIFY_WORKFLOW_OUTPUT_FIELDS")), // 从环境变量 DIFY_WORKFLOW_OUTPUT_FIELDS 获取要展示的输出字段，多个字段以逗号分隔
+					Format:   os.Getenv("DIFY_WORKFLOW_OUTPUT_FORMAT"),            // 从环境变量 DIFY_WORKFLOW_OUTPUT_FORMAT 获取消息类型
+					Template: os.Getenv("DIFY_WORKFLOW_OUTPUT_TEMPLATE"),          // 从环境变量 DIFY_WORKFLOW_OUTPUT_TEMPLATE 获取正文模板
+					Title:    os.Getenv("DIFY_WORKFLOW_OUTPUT_TITLE"),             // 从环境变量 DIFY_WORKFLOW_OUTPUT_TITLE 获取标题模板
+					URL:      os.Getenv("DIFY_WORKFLOW_OUTPUT_URL"),               // 从环境变量 DIFY_WORKFLOW_OUTPUT_URL 获取跳转地址模板
+					PicURL:   os.Getenv("DIFY_WORKFLOW_OUTPUT_PIC_URL"),           // 从环境变量 DIFY_WORKFLOW_OUTPUT_PIC_URL 获取图文消息的图片地址模板
+				},
 			},
 			WeCom: WeComConfig{ // 企业微信机器人配置部分
-				WebhookURL: os.Getenv("WECHAT_WEBHOOK_URL"), // 从环境变量 WECHAT_WEBHOOK_URL 获取企业微信 Webhook URL
+				Type:               os.Getenv("WECHAT_TYPE"),                               // 从环境变量 WECHAT_TYPE 获取发送方类型
+				WebhookURL:         os.Getenv("WECHAT_WEBHOOK_URL"),                        // 从环境变量 WECHAT_WEBHOOK_URL 获取企业微信 Webhook URL
+				Secret:             os.Getenv("WECHAT_SECRET"),                             // 从环境变量 WECHAT_SECRET 获取飞书或钉钉机器人的签名密钥
+				CorpID:             os.Getenv("WECHAT_CORP_ID"),                            // 从环境变量 WECHAT_CORP_ID 获取企业 ID
+				CorpSecret:         os.Getenv("WECHAT_CORP_SECRET"),                        // 从环境变量 WECHAT_CORP_SECRET 获取自建应用的 Secret
+				AgentID:            parseInt(os.Getenv("WECHAT_AGENT_ID"), 0),              // 从环境变量 WECHAT_AGENT_ID 获取自建应用的 AgentId
+				ToUser:             splitList(os.Getenv("WECHAT_TO_USER")),                 // 从环境变量 WECHAT_TO_USER 获取接收成员，多个以逗号分隔
+				ToParty:            splitList(os.Getenv("WECHAT_TO_PARTY")),                // 从环境变量 WECHAT_TO_PARTY 获取接收部门，多个以逗号分隔
+				ToTag:              splitList(os.Getenv("WECHAT_TO_TAG")),                  // 从环境变量 WECHAT_TO_TAG 获取接收标签，多个以逗号分隔
+				RateLimitPerMinute: parseInt(os.Getenv("WECHAT_RATE_LIMIT_PER_MINUTE"), 0), // 从环境变量 WECHAT_RATE_LIMIT_PER_MINUTE 获取发送频率上限，0 表示使用默认值
+				QueueSize:          parseInt(os.Getenv("WECHAT_QUEUE_SIZE"), 0),            // 从环境变量 WECHAT_QUEUE_SIZE 获取发送队列长度，0 表示使用默认值
+			},
+			Store: StoreConfig{ // 对话存储配置部分
+				Type:            os.Getenv("STORE_TYPE"),                          // 从环境变量 STORE_TYPE 获取对话存储类型
+				Path:            os.Getenv("STORE_PATH"),                          // 从环境变量 STORE_PATH 获取 BoltDB 文件路径
+				RedisAddr:       os.Getenv("REDIS_ADDR"),                          // 从环境变量 REDIS_ADDR 获取 Redis 地址
+				RedisPassword:   os.Getenv("REDIS_PASSWORD"),                      // 从环境变量 REDIS_PASSWORD 获取 Redis 密码
+				RedisDB:         parseInt(os.Getenv("REDIS_DB"), 0),               // 从环境变量 REDIS_DB 获取 Redis 数据库编号，默认 0
+				KeyPrefix:       os.Getenv("STORE_KEY_PREFIX"),                    // 从环境变量 STORE_KEY_PREFIX 获取 Redis 键前缀
+				IdleTTLMinutes:  parseInt(os.Getenv("STORE_IDLE_TTL_MINUTES"), 0), // 从环境变量 STORE_IDLE_TTL_MINUTES 获取对话最长空闲时间，0 表示不限制
+				MaxTurns:        parseInt(os.Getenv("STORE_MAX_TURNS"), 0),        // 从环境变量 STORE_MAX_TURNS 获取单个对话的最大问答轮数，0 表示不限制
+				JanitorInterval: parseInt(os.Getenv("STORE_JANITOR_INTERVAL"), 0), // 从环境变量 STORE_JANITOR_INTERVAL 获取清理间隔，0 表示使用默认值
+			},
+			Async: AsyncConfig{ // Webhook 异步处理配置部分
+				Workers:          parseInt(os.Getenv("ASYNC_WORKERS"), 0),           // 从环境变量 ASYNC_WORKERS 获取 worker 数量，0 表示使用默认值
+				QueueSize:        parseInt(os.Getenv("ASYNC_QUEUE_SIZE"), 0),        // 从环境变量 ASYNC_QUEUE_SIZE 获取任务队列长度，0 表示使用默认值
+				RetentionMinutes: parseInt(os.Getenv("ASYNC_RETENTION_MINUTES"), 0), // 从环境变量 ASYNC_RETENTION_MINUTES 获取任务保留时间，0 表示使用默认值
 			},
-			AuthToken:       os.Getenv("AUTH_TOKEN"),                     // 从环境变量 AUTH_TOKEN 获取认证 Token
-			EnableAuth:      os.Getenv("ENABLE_AUTH") == "true",          // 从环境变量 ENABLE_AUTH 获取是否开启认证功能
-			LogToFile:       os.Getenv("LOG_TO_FILE") == "true",          // 从环境变量 LOG_TO_FILE 获取是否将日志输出到文件
-			LogFilePath:     os.Getenv("LOG_FILE_PATH"),                  // 从环境变量 LOG_FILE_PATH 获取日志文件路径
-			LogMaxSizeBytes: parseInt(os.Getenv("LOG_MAX_SIZE_MB"), 100), // 从环境变量 LOG_MAX_SIZE_MB 获取日志文件最大大小，并提供默认值 100MB
-			LogMaxBackups:   parseInt(os.Getenv("LOG_MAX_BACKUPS"), 0),   // 从环境变量 LOG_MAX_BACKUPS 获取日志文件最大备份数量，并提供默认值 0
-			LogMaxAgeDays:   parseInt(os.Getenv("LOG_MAX_AGE_DAYS"), 0),  // 从环境变量 LOG_MAX_AGE_DAYS 获取日志文件最大保留天数，并提供默认值 0
-			LogCompress:     os.Getenv("LOG_COMPRESS") == "true",         // 从环境变量 LOG_COMPRESS 获取是否压缩旧日志文件
+			Retry: RetryConfig{ // Dify API 重试策略配置部分
+				MaxAttempts:      parseInt(os.Getenv("RETRY_MAX_ATTEMPTS"), 0),       // 从环境变量 RETRY_MAX_ATTEMPTS 获取最大尝试次数，0 表示使用默认值
+				InitialBackoffMs: parseInt(os.Getenv("RETRY_INITIAL_BACKOFF_MS"), 0), // 从环境变量 RETRY_INITIAL_BACKOFF_MS 获取首次退避时间，0 表示使用默认值
+				MaxBackoffMs:     parseInt(os.Getenv("RETRY_MAX_BACKOFF_MS"), 0),     // 从环境变量 RETRY_MAX_BACKOFF_MS 获取退避时间上限，0 表示使用默认值
+				DeadlineSeconds:  parseInt(os.Getenv("RETRY_DEADLINE_SECONDS"), 0),   // 从环境变量 RETRY_DEADLINE_SECONDS 获取调用总时限，0 表示使用默认值
+			},
+			Server: ServerConfig{ // HTTP 服务器配置部分
+				Addr:                   os.Getenv("SERVER_ADDR"),                                  // 从环境变量 SERVER_ADDR 获取监听地址，为空时使用默认值
+				ReadTimeoutSeconds:     parseInt(os.Getenv("SERVER_READ_TIMEOUT_SECONDS"), 0),     // 从环境变量 SERVER_READ_TIMEOUT_SECONDS 获取读取超时，0 表示使用默认值
+				WriteTimeoutSeconds:    parseInt(os.Getenv("SERVER_WRITE_TIMEOUT_SECONDS"), 0),    // 从环境变量 SERVER_WRITE_TIMEOUT_SECONDS 获取写入超时，0 表示使用默认值
+				IdleTimeoutSeconds:     parseInt(os.Getenv("SERVER_IDLE_TIMEOUT_SECONDS"), 0),     // 从环境变量 SERVER_IDLE_TIMEOUT_SECONDS 获取空闲超时，0 表示使用默认值
+				ShutdownTimeoutSeconds: parseInt(os.Getenv("SERVER_SHUTDOWN_TIMEOUT_SECONDS"), 0), // 从环境变量 SERVER_SHUTDOWN_TIMEOUT_SECONDS 获取优雅退出的等待时间，0 表示使用默认值
+				DrainDelaySeconds:      parseInt(os.Getenv("SERVER_DRAIN_DELAY_SECONDS"), 0),      // 从环境变量 SERVER_DRAIN_DELAY_SECONDS 获取摘除实例的等待时间，0 表示不等待
+			},
+			Timeouts: TimeoutConfig{ // 请求截止时间配置部分
+				ChatSeconds:       parseInt(os.Getenv("TIMEOUT_CHAT_SECONDS"), 0),       // 从环境变量 TIMEOUT_CHAT_SECONDS 获取 chat 应用的截止时间，0 表示使用默认值
+				CompletionSeconds: parseInt(os.Getenv("TIMEOUT_COMPLETION_SECONDS"), 0), // 从环境变量 TIMEOUT_COMPLETION_SECONDS 获取 completion 应用的截止时间，0 表示使用默认值
+				WorkflowSeconds:   parseInt(os.Getenv("TIMEOUT_WORKFLOW_SECONDS"), 0),   // 从环境变量 TIMEOUT_WORKFLOW_SECONDS 获取 workflow 应用的截止时间，0 表示使用默认值
+			},
+			Callback: CallbackConfig{ // 企业微信消息回调配置部分
+				Enable:         os.Getenv("WECOM_CALLBACK_ENABLE") == "true",   // 从环境变量 WECOM_CALLBACK_ENABLE 获取是否启用消息回调
+				Token:          os.Getenv("WECOM_CALLBACK_TOKEN"),              // 从环境变量 WECOM_CALLBACK_TOKEN 获取回调 Token
+				EncodingAESKey: os.Getenv("WECOM_CALLBACK_ENCODING_AES_KEY"),   // 从环境变量 WECOM_CALLBACK_ENCODING_AES_KEY 获取消息加解密密钥
+				ReceiveID:      os.Getenv("WECOM_CALLBACK_RECEIVE_ID"),         // 从环境变量 WECOM_CALLBACK_RECEIVE_ID 获取接收方 ID
+				App:            os.Getenv("WECOM_CALLBACK_APP"),                // 从环境变量 WECOM_CALLBACK_APP 获取处理回调消息的 Dify 应用名称
+				Targets:        splitList(os.Getenv("WECOM_CALLBACK_TARGETS")), // 从环境变量 WECOM_CALLBACK_TARGETS 获取回复的投递目标，多个目标以逗号分隔
+				WelcomeMessage: os.Getenv("WECOM_CALLBACK_WELCOME_MESSAGE"),    // 从环境变量 WECOM_CALLBACK_WELCOME_MESSAGE 获取欢迎语
+			},
+			DefaultTargets:  splitList(os.Getenv("WECHAT_DEFAULT_TARGETS")), // 从环境变量 WECHAT_DEFAULT_TARGETS 获取默认投递目标，多个目标以逗号分隔
+			AuthToken:       os.Getenv("AUTH_TOKEN"),                        // 从环境变量 AUTH_TOKEN 获取认证 Token
+			EnableAuth:      os.Getenv("ENABLE_AUTH") == "true",             // 从环境变量 ENABLE_AUTH 获取是否开启认证功能
+			LogToFile:       os.Getenv("LOG_TO_FILE") == "true",             // 从环境变量 LOG_TO_FILE 获取是否将日志输出到文件
+			LogFilePath:     os.Getenv("LOG_FILE_PATH"),                     // 从环境变量 LOG_FILE_PATH 获取日志文件路径
+			LogMaxSizeBytes: parseInt(os.Getenv("LOG_MAX_SIZE_MB"), 100),    // 从环境变量 LOG_MAX_SIZE_MB 获取日志文件最大大小，并提供默认值 100MB
+			LogMaxBackups:   parseInt(os.Getenv("LOG_MAX_BACKUPS"), 0),      // 从环境变量 LOG_MAX_BACKUPS 获取日志文件最大备份数量，并提供默认值 0
+			LogMaxAgeDays:   parseInt(os.Getenv("LOG_MAX_AGE_DAYS"), 0),     // 从环境变量 LOG_MAX_AGE_DAYS 获取日志文件最大保留天数，并提供默认值 0
+			LogCompress:     os.Getenv("LOG_COMPRESS") == "true",            // 从环境变量 LOG_COMPRESS 获取是否压缩旧日志文件
+			LogLevel:        os.Getenv("LOG_LEVEL"),                         // 从环境变量 LOG_LEVEL 获取日志级别
+			LogFormat:       os.Getenv("LOG_FORMAT"),                        // 从环境变量 LOG_FORMAT 获取日志格式
 			Schedulers: []SchedulerConfig{ // 定时任务配置列表，从环境变量加载时只支持一个定时器
 				{
-					Enable:         os.Getenv("SCHEDULER_ENABLE") == "true",      // 从环境变量 SCHEDULER_ENABLE 获取是否启用定时任务
-					CronSpec:       os.Getenv("SCHEDULER_CRON_SPEC"),             // 从环境变量 SCHEDULER_CRON_SPEC 获取 Cron 表达式
-					Interval:       parseInt(os.Getenv("SCHEDULER_INTERVAL"), 0), // 从环境变量 SCHEDULER_INTERVAL 获取间隔时间，并提供默认值 0
-					Unit:           os.Getenv("SCHEDULER_UNIT"),                  // 从环境变量 SCHEDULER_UNIT 获取时间单位
-					TargetURL:      os.Getenv("SCHEDULER_TARGET_URL"),            // 从环境变量 SCHEDULER_TARGET_URL 获取目标 URL
-					DefaultMessage: os.Getenv("SCHEDULER_DEFAULT_MESSAGE"),       // 从环境变量 SCHEDULER_DEFAULT_MESSAGE 获取默认消息
+					Enable:           os.Getenv("SCHEDULER_ENABLE") == "true",            // 从环境变量 SCHEDULER_ENABLE 获取是否启用定时任务
+					CronSpec:         os.Getenv("SCHEDULER_CRON_SPEC"),                   // 从环境变量 SCHEDULER_CRON_SPEC 获取 Cron 表达式
+					Interval:         parseInt(os.Getenv("SCHEDULER_INTERVAL"), 0),       // 从环境变量 SCHEDULER_INTERVAL 获取间隔时间，并提供默认值 0
+					Unit:             os.Getenv("SCHEDULER_UNIT"),                        // 从环境变量 SCHEDULER_UNIT 获取时间单位
+					TargetURL:        os.Getenv("SCHEDULER_TARGET_URL"),                  // 从环境变量 SCHEDULER_TARGET_URL 获取目标 URL
+					DefaultMessage:   os.Getenv("SCHEDULER_DEFAULT_MESSAGE"),             // 从环境变量 SCHEDULER_DEFAULT_MESSAGE 获取默认消息
+					Targets:          splitList(os.Getenv("SCHEDULER_TARGETS")),          // 从环境变量 SCHEDULER_TARGETS 获取投递目标，多个目标以逗号分隔
+					App:              os.Getenv("SCHEDULER_APP"),                         // 从环境变量 SCHEDULER_APP 获取处理消息的 Dify 应用名称
+					User:             os.Getenv("SCHEDULER_USER"),                        // 从环境变量 SCHEDULER_USER 获取用户标识 (支持模板)
+					KeepConversation: os.Getenv("SCHEDULER_KEEP_CONVERSATION") == "true", // 从环境变量 SCHEDULER_KEEP_CONVERSATION 获取是否沿用对话
 				},
 			},
 		}
@@ -155,3 +625,29 @@ func parseInt(s string, defaultValue int) int {
 	}
 	return i // 返回成功转换后的整数
 }
+
+// parseFloat 辅助函数，用于将字符串转换为浮点数
+// s: 待转换的字符串
+// defaultValue: 如果字符串为空或转换失败时返回的默认值
+func parseFloat(s string, defaultValue float64) float64 {
+	if s == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return f
+}
+
+// splitList 辅助函数，用于将逗号分隔的字符串拆分为列表，忽略空白项
+// s: 待拆分的字符串，例如 "team-a, team-b"
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"       // 导入 context 包，用于识别调用 Dify 超时
+	"encoding/json" // 导入 encoding/json 包，用于解析重命名请求体
+	"errors"        // 导入 errors 包，用于根据错误类型选择状态码
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/http"      // 导入 net/http 包，用于处理 HTTP 请求和响应
+	"strconv"       // 导入 strconv 包，用于解析分页大小
+	"strings"       // 导入 strings 包，用于从路径中提取对话 ID 和操作
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取认证配置
+	"dify2wxbot/internal/service" // 导入 internal/service 包，用于调用 Dify 的会话管理接口
+)
+
+const conversationsPathPrefix = "/admin/conversations" // 会话管理接口的路径前缀
+
+// ConversationsHandler 处理 /admin/conversations 下的会话管理接口，代理 Dify 的会话和消息 API
+//
+//	GET    /admin/conversations?user=&app=&last_id=&limit=                 会话列表
+//	GET    /admin/conversations/{id}/messages?user=&app=&first_id=&limit=  会话中的消息
+//	POST   /admin/conversations/{id}/name  {"user", "app", "name"}         重命名会话，name 为空时自动生成
+//	DELETE /admin/conversations/{id}?user=&app=                            删除会话并清除本地存储中的对话
+//
+// user 是消息处理时使用的用户标识 (群聊中为 "群聊 ID:userid")，app 为空时使用该用户当前的应用。
+type ConversationsHandler struct {
+	converter *service.MessageConverter // converter 是消息转换器，负责选择 Dify 应用并维护本地对话存储
+	cfg       *config.AppConfig         // cfg 是应用程序配置，用于认证
+}
+
+// NewConversationsHandler 创建并返回一个新的 ConversationsHandler 实例
+// converter: 消息转换器实例
+// cfg: 应用程序配置，提供认证 Token 等设置
+func NewConversationsHandler(converter *service.MessageConverter, cfg *config.AppConfig) *ConversationsHandler {
+	return &ConversationsHandler{
+		converter: converter, // 初始化 ConversationsHandler 的 converter 字段
+		cfg:       cfg,       // 初始化 ConversationsHandler 的 cfg 字段
+	}
+}
+
+// renameRequest 是重命名会话的请求体
+type renameRequest struct {
+	User string `json:"user"` // User 是会话所有者的用户标识
+	App  string `json:"app"`  // App 是 Dify 应用名称，可以为空
+	Name string `json:"name"` // Name 是新的会话名称，为空时由 Dify 自动生成
+}
+
+// HandleConversations 根据路径和方法分发会话管理请求
+func (h *ConversationsHandler) HandleConversations(w http.ResponseWriter, r *http.Request) {
+	if !authorize(h.cfg, w, r) {
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, conversationsPathPrefix), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.list(w, r)
+	case id != "" && action == "messages" && r.Method == http.MethodGet:
+		h.messages(w, r, id)
+	case id != "" && action == "name" && r.Method == http.MethodPost:
+		h.rename(w, r, id)
+	case id != "" && action == "" && r.Method == http.MethodDelete:
+		h.delete(w, r, id)
+	default:
+		http.Error(w, "不支持的会话管理请求", http.StatusNotFound)
+	}
+}
+
+// list 返回用户在 Dify 中的会话列表
+func (h *ConversationsHandler) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	user, limit, ok := userAndLimit(w, r)
+	if !ok {
+		return
+	}
+	resp, err := h.converter.ListConversations(r.Context(), query.Get("app"), user, query.Get("last_id"), limit)
+	if err != nil {
+		writeConversationError(w, r, "获取会话列表失败", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// messages 返回会话中的消息，按时间倒序分页
+func (h *ConversationsHandler) messages(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+	user, limit, ok := userAndLimit(w, r)
+	if !ok {
+		return
+	}
+	resp, err := h.converter.ConversationMessages(r.Context(), query.Get("app"), user, id, query.Get("first_id"), limit)
+	if err != nil {
+		writeConversationError(w, r, "获取会话消息失败", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// rename 重命名会话并返回更新后的会话
+func (h *ConversationsHandler) rename(w http.ResponseWriter, r *http.Request, id string) {
+	var req renameRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("解析请求体失败: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.User == "" {
+		http.Error(w, "缺少 user", http.StatusBadRequest)
+		return
+	}
+	conversation, err := h.converter.RenameConversation(r.Context(), req.App, req.User, id, req.Name)
+	if err != nil {
+		writeConversationError(w, r, "重命名会话失败", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "[Conversations] 会话已重命名", "conversation_id", id, "user", req.User, "name", conversation.Name)
+	writeJSON(w, http.StatusOK, conversation)
+}
+
+// delete 删除会话，本地存储中的当前对话正是该会话时一并清除
+func (h *ConversationsHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+	user := query.Get("user")
+	if user == "" {
+		http.Error(w, "缺少 user", http.StatusBadRequest)
+		return
+	}
+	if err := h.converter.DeleteConversation(r.Context(), query.Get("app"), user, id); err != nil {
+		writeConversationError(w, r, "删除会话失败", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "[Conversations] 会话已删除", "conversation_id", id, "user", user)
+	writeJSON(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// userAndLimit 读取查询参数中必填的 user 和可选的 limit，参数无效时写入 400 响应并返回 false
+func userAndLimit(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	query := r.URL.Query()
+	user := query.Get("user")
+	if user == "" {
+		http.Error(w, "缺少 user", http.StatusBadRequest)
+		return "", 0, false
+	}
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit 必须是正整数", http.StatusBadRequest)
+			return "", 0, false
+		}
+		limit = n
+	}
+	return user, limit, true
+}
+
+// writeConversationError 根据错误类型写入错误响应
+// 应用不存在或不是聊天型应用属于请求参数错误；Dify 返回的 4xx 原样透传，例如会话不存在时返回 404。
+// Dify 返回的 401 和 403 说明服务配置的 API Key 无效或无权访问，与调用方的认证无关，返回 502。
+func writeConversationError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	status := http.StatusBadGateway
+	var apiErr *service.DifyAPIError
+	switch {
+	case errors.Is(err, service.ErrUnknownApp) || errors.Is(err, service.ErrNotChatApp):
+		status = http.StatusBadRequest
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		status = http.StatusBadGateway
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		status = apiErr.StatusCode
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	slog.WarnContext(r.Context(), "[Conversations] "+message, "status", status, "error", err)
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), status)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/internal/service"
+	"dify2wxbot/internal/store"
+)
+
+// newTestConversationsHandler 创建使用 difyHandler 作为 Dify 服务端的会话管理接口
+func newTestConversationsHandler(t *testing.T, difyHandler http.HandlerFunc) *ConversationsHandler {
+	t.Helper()
+	dify := httptest.NewServer(difyHandler)
+	t.Cleanup(dify.Close)
+	cfg := &config.AppConfig{
+		Dify:  config.DifyConfig{APIKey: "app-test", BaseURL: dify.URL, BotType: "chat"},
+		WeCom: config.WeComConfig{WebhookURL: "http://127.0.0.1:0/send?key=test"},
+	}
+	return NewConversationsHandler(service.NewMessageConverter(cfg, store.NewInMemoryConversationStore()), cfg)
+}
+
+func TestConversationMessagesOmitsLimitByDefault(t *testing.T) {
+	var queries []url.Values
+	h := newTestConversationsHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"limit": 20, "has_more": false, "data": []}`))
+	})
+
+	for _, target := range []string{"/admin/conversations/c1/messages?user=tester", "/admin/conversations/c1/messages?user=tester&limit=5"} {
+		rec := httptest.NewRecorder()
+		h.HandleConversations(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %q, want 200", target, rec.Code, rec.Body.String())
+		}
+	}
+	if len(queries) != 2 {
+		t.Fatalf("dify got %d requests, want 2", len(queries))
+	}
+	if _, ok := queries[0]["limit"]; ok {
+		t.Errorf("dify query = %v, want no limit when the request omits it", queries[0])
+	}
+	if got := queries[1].Get("limit"); got != "5" {
+		t.Errorf("dify limit = %q, want 5", got)
+	}
+}
+
+func TestConversationErrorsMapDifyStatus(t *testing.T) {
+	tests := []struct {
+		difyStatus int
+		want       int
+	}{
+		{difyStatus: http.StatusNotFound, want: http.StatusNotFound},
+		{difyStatus: http.StatusBadRequest, want: http.StatusBadRequest},
+		{difyStatus: http.StatusUnauthorized, want: http.StatusBadGateway},
+		{difyStatus: http.StatusForbidden, want: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		h := newTestConversationsHandler(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(tt.difyStatus)
+			fmt.Fprintf(w, `{"code": "error", "message": "dify error", "status": %d}`, tt.difyStatus)
+		})
+		rec := httptest.NewRecorder()
+		h.HandleConversations(rec, httptest.NewRequest(http.MethodGet, "/admin/conversations?user=tester", nil))
+		if rec.Code != tt.want {
+			t.Errorf("dify %d: status = %d, want %d", tt.difyStatus, rec.Code, tt.want)
+		}
+	}
+}
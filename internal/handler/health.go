@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"     // 导入 context 包，用于限制就绪检查的耗时
+	"log/slog"    // 导入 log/slog 包，用于结构化日志输出
+	"net/http"    // 导入 net/http 包，用于处理 HTTP 请求和响应
+	"sync/atomic" // 导入 sync/atomic 包，用于并发安全地标记服务正在关闭
+	"time"        // 导入 time 包，用于设置就绪检查的超时时间
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于检查配置是否有效
+	"dify2wxbot/internal/service" // 导入 internal/service 包，用于检查 Dify 应用是否可以访问
+)
+
+const readyCheckTimeout = 5 * time.Second // 就绪检查中访问 Dify 的超时时间
+
+// HealthHandler 结构体定义了存活检查和就绪检查的处理器
+// 这两个接口供负载均衡器或 Kubernetes 探针使用，不需要认证。
+type HealthHandler struct {
+	converter *service.MessageConverter // converter 是消息转换器，用于检查各个 Dify 应用是否可以访问
+	cfg       *config.AppConfig         // cfg 是应用程序配置，就绪检查时会重新校验
+	draining  atomic.Bool               // draining 表示服务正在关闭，此时就绪检查失败，不再接收新的流量
+}
+
+// NewHealthHandler 创建并返回一个新的 HealthHandler 实例
+// converter: 消息转换器实例
+// cfg: 应用程序配置
+func NewHealthHandler(converter *service.MessageConverter, cfg *config.AppConfig) *HealthHandler {
+	return &HealthHandler{
+		converter: converter, // 初始化 HealthHandler 的 converter 字段
+		cfg:       cfg,       // 初始化 HealthHandler 的 cfg 字段
+	}
+}
+
+// SetDraining 标记服务正在关闭，之后 /readyz 返回 503，使负载均衡器停止转发新的请求
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+// HandleHealthz 处理 GET /healthz 请求 (存活检查)，进程能够响应即返回 200
+func (h *HealthHandler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReadyz 处理 GET /readyz 请求 (就绪检查)
+// 配置有效、所有 Dify 应用都可以访问且服务没有在关闭时返回 200，否则返回 503；响应中的 checks 列出每一项检查的结果。
+func (h *HealthHandler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]string)
+	ready := true
+
+	if h.draining.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	} else {
+		checks["server"] = "ok"
+	}
+
+	if err := h.cfg.Validate(); err != nil {
+		checks["config"] = err.Error()
+		ready = false
+	} else {
+		checks["config"] = "ok"
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+	for app, err := range h.converter.CheckApps(ctx) {
+		if err != nil {
+			checks["dify:"+app] = err.Error()
+			ready = false
+			continue
+		}
+		checks["dify:"+app] = "ok"
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+		slog.WarnContext(r.Context(), "[Health] 就绪检查未通过", "checks", checks)
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
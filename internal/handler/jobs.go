@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"net/http" // 导入 net/http 包，用于处理 HTTP 请求和响应
+	"strings"  // 导入 strings 包，用于从路径中提取任务 ID
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取认证配置
+	"dify2wxbot/internal/jobs"   // 导入 internal/jobs 包，用于查询异步任务
+)
+
+// JobsHandler 结构体定义了查询异步任务状态的处理器
+type JobsHandler struct {
+	jobs *jobs.Manager     // jobs 是异步任务管理器
+	cfg  *config.AppConfig // cfg 是应用程序配置，用于认证
+}
+
+// NewJobsHandler 创建并返回一个新的 JobsHandler 实例
+// jobManager: 异步任务管理器实例
+// cfg: 应用程序配置，提供认证 Token 等设置
+func NewJobsHandler(jobManager *jobs.Manager, cfg *config.AppConfig) *JobsHandler {
+	return &JobsHandler{
+		jobs: jobManager, // 初始化 JobsHandler 的 jobs 字段
+		cfg:  cfg,        // 初始化 JobsHandler 的 cfg 字段
+	}
+}
+
+// HandleJob 处理 GET /jobs/{id} 请求，返回异步任务的状态、Dify 的回答、耗时和错误信息
+// 任务不存在或已超过保留时间被清理时返回 404。
+func (h *JobsHandler) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持 GET 请求", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(h.cfg, w, r) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if id == "" {
+		http.Error(w, "缺少任务 ID", http.StatusBadRequest)
+		return
+	}
+	job, ok := h.jobs.Get(id)
+	if !ok {
+		slog.InfoContext(r.Context(), "[Jobs] 查询的任务不存在或已过期", "job_id", id)
+		http.Error(w, "任务不存在或已过期", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
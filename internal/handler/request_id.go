@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http" // 导入 net/http 包，用于包装 HTTP 处理器
+	"regexp"   // 导入 regexp 包，用于校验调用方传入的请求 ID
+
+	"dify2wxbot/internal/logging" // 导入 logging 包，用于生成请求 ID 并放入请求上下文
+)
+
+// requestIDHeader 是携带请求 ID 的 HTTP 头
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID 限制调用方传入的请求 ID 的字符和长度，避免日志注入
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// WithRequestID 为每个请求分配请求 ID，放入请求上下文并写入响应头
+// 调用方通过 X-Request-ID 头传入合法的 ID 时沿用该 ID，便于与上游系统的日志关联；否则生成新的 UUID。
+// 之后处理器、消息转换器、DifyService 和企业微信机器人使用该上下文记录的日志都会带上 request_id 字段。
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
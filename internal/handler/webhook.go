@@ -1,38 +1,44 @@
 package handler
 
 import (
+	"context"       // 导入 context 包，用于识别 Dify 调用超时
 	"encoding/json" // 导入 encoding/json 包，用于 JSON 数据的编解码
+	"errors"        // 导入 errors 包，用于识别请求参数错误
 	"fmt"           // 导入 fmt 包，用于格式化字符串和错误信息
 	"io"            // 导入 io 包，用于 IO 操作，例如读取文件内容
-	"log"           // 导入 log 包，用于日志输出
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"mime"          // 导入 mime 包，用于解析 Content-Type 作为指标标签
 	"net/http"      // 导入 net/http 包，用于处理 HTTP 请求和响应
 	"os"            // 导入 os 包，用于文件操作，例如创建临时文件
 	"path/filepath" // 导入 path/filepath 包，用于处理文件路径，例如获取文件名
+	"strconv"       // 导入 strconv 包，用于将状态码转换为指标标签
 	"strings"       // 导入 strings 包，用于字符串操作，例如检查 Content-Type 前缀
 
 	"dify2wxbot/internal/config"  // 导入 config 包，用于加载应用程序配置
+	"dify2wxbot/internal/jobs"    // 导入 internal/jobs 包，用于异步处理请求
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于统计 Webhook 请求
 	"dify2wxbot/internal/service" // 导入 internal/service 包，包含 MessageConverter 和 DifyService
-	"dify2wxbot/internal/store"   // 导入 internal/store 包，包含 ConversationStore 接口
+	"dify2wxbot/internal/store"   // 导入 internal/store 包，用于获取对话重置原因
 
 	"github.com/google/uuid" // 导入 uuid 包，用于生成唯一标识符 (UUID)
 )
 
 // WebhookHandler 结构体定义了处理 Webhook 请求的处理器
 type WebhookHandler struct {
-	converter         *service.MessageConverter // converter 是一个 MessageConverter 实例，用于消息转换和发送到 Dify 及企业微信
-	conversationStore store.ConversationStore   // conversationStore 用于管理用户与 Dify 之间的对话 ID，以维持上下文
-	cfg               *config.AppConfig         // cfg 是应用程序配置，用于访问认证 Token 等全局设置
+	converter *service.MessageConverter // converter 是一个 MessageConverter 实例，用于消息转换、对话管理和发送到 Dify 及企业微信
+	jobs      *jobs.Manager             // jobs 是异步任务管理器，用于异步处理请求
+	cfg       *config.AppConfig         // cfg 是应用程序配置，用于访问认证 Token 等全局设置
 }
 
 // NewWebhookHandler 创建并返回一个新的 WebhookHandler 实例
-// converter: 消息转换器实例，负责消息的格式化和转发
-// conversationStore: 对话存储实例，负责对话 ID 的管理
+// converter: 消息转换器实例，负责消息的格式化、转发和对话 ID 的管理
+// jobManager: 异步任务管理器实例，负责异步请求的排队和执行
 // cfg: 应用程序配置，提供必要的配置信息
-func NewWebhookHandler(converter *service.MessageConverter, conversationStore store.ConversationStore, cfg *config.AppConfig) *WebhookHandler {
+func NewWebhookHandler(converter *service.MessageConverter, jobManager *jobs.Manager, cfg *config.AppConfig) *WebhookHandler {
 	return &WebhookHandler{
-		converter:         converter,         // 初始化 WebhookHandler 的 converter 字段
-		conversationStore: conversationStore, // 初始化 WebhookHandler 的 conversationStore 字段
-		cfg:               cfg,               // 初始化 WebhookHandler 的 cfg 字段
+		converter: converter,  // 初始化 WebhookHandler 的 converter 字段
+		jobs:      jobManager, // 初始化 WebhookHandler 的 jobs 字段
+		cfg:       cfg,        // 初始化 WebhookHandler 的 cfg 字段
 	}
 }
 
@@ -43,66 +49,67 @@ func NewWebhookHandler(converter *service.MessageConverter, conversationStore st
 // w: http.ResponseWriter 用于写入 HTTP 响应，将处理结果返回给客户端
 // r: *http.Request 包含传入的 HTTP 请求的所有信息，如方法、路径、头和请求体
 func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
-	// 获取请求的远程地址 (IP:Port)，用于日志记录和追踪请求来源。
-	remoteAddr := r.RemoteAddr
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+	defer func() {
+		metrics.WebhookRequests.Inc(recorder.statusLabel(r), contentTypeLabel(r.Header.Get("Content-Type")))
+	}()
+
+	// 请求上下文中携带由 WithRequestID 分配的请求 ID，之后的日志都使用它关联同一个请求
+	ctx := r.Context()
 	// 记录接收到新 Webhook 请求的日志，包括请求方法、路径和调用方 IP 地址，便于追踪和调试。
-	log.Printf("[Webhook] 接收到新请求，调用方: %s, 方法: %s, 路径: %s", remoteAddr, r.Method, r.URL.Path)
+	slog.InfoContext(ctx, "[Webhook] 接收到新请求", "remote_addr", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
 
 	// 强制要求请求方法为 POST。Webhook 通常通过 POST 请求发送数据。
 	if r.Method != http.MethodPost {
 		// 如果不是 POST 请求，返回 405 Method Not Allowed 错误，并记录日志。
 		http.Error(w, "只支持 POST 请求", http.StatusMethodNotAllowed)
-		log.Printf("[Webhook] 请求方法不被允许: %s", r.Method) // 记录不被允许的请求方法
+		slog.WarnContext(ctx, "[Webhook] 请求方法不被允许", "method", r.Method) // 记录不被允许的请求方法
 		return
 	}
 
 	// --- 认证逻辑 ---
-	// 检查配置文件中是否开启了认证功能 (h.cfg.EnableAuth)。
-	if h.cfg.EnableAuth {
-		// 从请求头中获取 Authorization 字段。
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			// 如果 Authorization 头缺失，返回 401 Unauthorized 错误，并记录日志。
-			http.Error(w, "缺少 Authorization 头", http.StatusUnauthorized)
-			log.Println("[Webhook] 缺少 Authorization 头")
-			return
-		}
-
-		// 构造期望的 Token 格式，通常是 "Bearer YOUR_AUTH_TOKEN"。
-		expectedToken := "Bearer " + h.cfg.AuthToken
-		// 比较请求头中的 Token 是否与配置中预期的 Token 匹配。
-		if authHeader != expectedToken {
-			// 如果 Token 不匹配，返回 401 Unauthorized 错误，并记录日志。
-			http.Error(w, "无效的 Token", http.StatusUnauthorized)
-			log.Printf("[Webhook] Token 无效: %s", authHeader)
-			return
-		}
-		log.Println("[Webhook] Token 认证成功")
+	if !authorize(h.cfg, w, r) {
+		return
 	}
 
 	// 定义用于存储从请求体中解析出的消息、用户、对话 ID 和文件路径的变量。
 	var message string
 	var user string
 	var conversationID string
-	var filePath string // 用于存储上传文件的临时路径
+	var reset bool         // 是否在处理本条消息前重置用户的对话
+	var app string         // 请求中明确指定的 Dify 应用名称，为空时由路由规则决定
+	var group string       // 消息来源的群组标识，用于按群组路由
+	var targets []string   // 回复的投递目标 (机器人名称) 列表，为空时使用默认投递目标
+	var async bool         // 是否异步处理，为 true 时立即返回任务 ID
+	var callbackURL string // 异步任务完成后通知的地址，设置后自动启用异步处理
+	var filePath string    // 用于存储上传文件的临时路径
+	var fileName string    // 上传文件的原始文件名，上传到 Dify 时使用
+	var keepFile bool      // 为 true 时请求返回后不删除临时文件，由异步任务负责删除
 
 	// 获取请求的 Content-Type，用于判断请求体的格式（JSON 或 multipart/form-data）。
 	contentType := r.Header.Get("Content-Type")
-	log.Printf("[Webhook] 请求 Content-Type: %s", contentType)
+	slog.DebugContext(ctx, "[Webhook] 请求 Content-Type", "content_type", contentType)
 
 	// --- 请求体解析逻辑 ---
 	// 根据 Content-Type 处理不同类型的请求体。
 	if strings.HasPrefix(contentType, "application/json") {
 		// 如果 Content-Type 是 application/json，则解析 JSON 格式的请求体。
 		var request struct {
-			Message        string `json:"message"`         // 消息内容
-			User           string `json:"user"`            // 用户标识
-			ConversationID string `json:"conversation_id"` // 对话 ID
+			Message        string   `json:"message"`         // 消息内容
+			User           string   `json:"user"`            // 用户标识
+			ConversationID string   `json:"conversation_id"` // 对话 ID
+			Reset          bool     `json:"reset"`           // 是否重置对话，为 true 时丢弃当前对话并开启新的对话
+			App            string   `json:"app"`             // 指定处理本条消息的 Dify 应用名称
+			Group          string   `json:"group"`           // 消息来源的群组标识
+			Targets        []string `json:"targets"`         // 回复的投递目标 (机器人名称) 列表
+			Async          bool     `json:"async"`           // 是否异步处理
+			CallbackURL    string   `json:"callback_url"`    // 异步任务完成后通知的地址
 		}
 		// 使用 json.NewDecoder 解码请求体到 request 结构体。
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 			// 如果 JSON 解析失败，记录错误并返回 400 Bad Request。
-			log.Printf("[Webhook] 解析 JSON 请求体失败: %v", err)
+			slog.WarnContext(ctx, "[Webhook] 解析 JSON 请求体失败", "error", err)
 			http.Error(w, fmt.Sprintf("解析请求体失败: %v", err), http.StatusBadRequest)
 			return
 		}
@@ -110,7 +117,14 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		message = request.Message
 		user = request.User
 		conversationID = request.ConversationID
-		log.Printf("[Webhook] 成功解析 JSON 请求体，消息: '%s', 用户: '%s', 对话ID: '%s'", message, user, conversationID)
+		reset = request.Reset
+		app = request.App
+		group = request.Group
+		targets = request.Targets
+		async = request.Async
+		callbackURL = request.CallbackURL
+		slog.InfoContext(ctx, "[Webhook] 成功解析 JSON 请求体", "user", user, "conversation_id", conversationID, "message_length", len(message))
+		slog.DebugContext(ctx, "[Webhook] 请求消息内容", "message", message)
 
 	} else if strings.HasPrefix(contentType, "multipart/form-data") {
 		// 如果 Content-Type 是 multipart/form-data，通常用于文件上传。
@@ -118,7 +132,7 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		err := r.ParseMultipartForm(32 << 20) // 32MB
 		if err != nil {
 			// 如果解析失败，记录错误并返回 400 Bad Request。
-			log.Printf("[Webhook] 解析 multipart/form-data 失败: %v", err)
+			slog.WarnContext(ctx, "[Webhook] 解析 multipart/form-data 失败", "error", err)
 			http.Error(w, fmt.Sprintf("解析 multipart/form-data 失败: %v", err), http.StatusBadRequest)
 			return
 		}
@@ -127,41 +141,60 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		message = r.FormValue("message")
 		user = r.FormValue("user")
 		conversationID = r.FormValue("conversation_id")
+		reset = r.FormValue("reset") == "true"
+		app = r.FormValue("app")
+		group = r.FormValue("group")
+		async = r.FormValue("async") == "true"
+		callbackURL = r.FormValue("callback_url")
+		// 投递目标可以重复提交多个 targets 字段，也可以在一个字段中以逗号分隔
+		for _, value := range r.MultipartForm.Value["targets"] {
+			for _, target := range strings.Split(value, ",") {
+				if target = strings.TrimSpace(target); target != "" {
+					targets = append(targets, target)
+				}
+			}
+		}
 
 		// 尝试获取上传的文件。
 		file, handler, err := r.FormFile("file")
 		if err == nil { // 如果成功获取到文件（即有文件上传）
 			defer file.Close() // 确保文件在函数结束时关闭。
-			// 将文件保存到系统的临时目录。
-			tempDir := os.TempDir()
-			filePath = filepath.Join(tempDir, handler.Filename)
-			dst, createErr := os.Create(filePath) // 创建临时文件。
+			// 将文件保存到系统临时目录中随机命名的临时文件，避免同名上传互相覆盖；原始文件名只保留扩展名，上传到 Dify 时单独传递。
+			fileName = filepath.Base(handler.Filename)
+			dst, createErr := os.CreateTemp("", "upload-*"+filepath.Ext(fileName)) // 创建临时文件。
 			if createErr != nil {
-				log.Printf("[Webhook] 创建临时文件失败: %v", createErr) // 记录创建文件错误
+				slog.ErrorContext(ctx, "[Webhook] 创建临时文件失败", "error", createErr) // 记录创建文件错误
 				http.Error(w, fmt.Sprintf("创建临时文件失败: %v", createErr), http.StatusInternalServerError)
 				return
 			}
-			defer dst.Close()         // 确保目标文件在函数结束时关闭。
-			defer os.Remove(filePath) // 在处理完成后删除临时文件，避免文件残留。
+			filePath = dst.Name()
+			defer dst.Close() // 确保目标文件在函数结束时关闭。
+			// 在处理完成后删除临时文件，避免文件残留；异步处理时由任务完成后删除。
+			defer func() {
+				if !keepFile {
+					os.Remove(filePath)
+				}
+			}()
 
 			// 将上传的文件内容复制到临时文件。
 			if _, copyErr := io.Copy(dst, file); copyErr != nil { // 捕获复制文件错误
-				log.Printf("[Webhook] 保存临时文件失败: %v", copyErr) // 记录保存文件错误
+				slog.ErrorContext(ctx, "[Webhook] 保存临时文件失败", "error", copyErr) // 记录保存文件错误
 				http.Error(w, fmt.Sprintf("保存临时文件失败: %v", copyErr), http.StatusInternalServerError)
 				return
 			}
-			log.Printf("[Webhook] 成功接收文件: %s，保存到: %s", handler.Filename, filePath)
+			slog.InfoContext(ctx, "[Webhook] 成功接收文件", "filename", handler.Filename, "path", filePath)
 		} else if err != http.ErrMissingFile {
 			// 如果文件获取失败，但不是因为文件缺失（即其他错误），则记录错误并返回 400 Bad Request。
-			log.Printf("[Webhook] 获取文件失败: %v", err)
+			slog.WarnContext(ctx, "[Webhook] 获取文件失败", "error", err)
 			http.Error(w, fmt.Sprintf("获取文件失败: %v", err), http.StatusBadRequest)
 			return
 		}
-		log.Printf("[Webhook] 成功解析 multipart/form-data，消息: '%s', 用户: '%s', 对话ID: '%s', 文件路径: '%s'", message, user, conversationID, filePath)
+		slog.InfoContext(ctx, "[Webhook] 成功解析 multipart/form-data", "user", user, "conversation_id", conversationID, "message_length", len(message), "file", filePath)
+		slog.DebugContext(ctx, "[Webhook] 请求消息内容", "message", message)
 
 	} else {
 		// 如果 Content-Type 既不是 JSON 也不是 multipart/form-data，则返回 415 Unsupported Media Type 错误。
-		log.Printf("[Webhook] 不支持的 Content-Type: %s", contentType)
+		slog.WarnContext(ctx, "[Webhook] 不支持的 Content-Type", "content_type", contentType)
 		http.Error(w, "不支持的 Content-Type", http.StatusUnsupportedMediaType)
 		return
 	}
@@ -170,51 +203,206 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 	// 如果请求中没有提供用户标识，则生成一个唯一的 UUID 作为用户标识。
 	if user == "" {
 		user = uuid.New().String() // 生成一个新的 UUID，确保每个请求都有一个用户标识
-		log.Printf("[Webhook] 用户标识为空，生成新的用户ID: %s", user)
+		slog.InfoContext(ctx, "[Webhook] 用户标识为空，已生成新的用户ID", "user", user)
 	}
 
-	var currentConversationID string // 默认为空字符串
-
-	// 如果请求中明确提供了 conversation_id，则优先使用请求中的 ID。
-	if conversationID != "" {
-		currentConversationID = conversationID
-		// 并将此 ID 保存或更新到存储中，确保后续请求使用相同的对话上下文。
-		h.conversationStore.SaveConversationID(user, currentConversationID)
-		log.Printf("[Webhook] 请求中提供了对话ID '%s'，使用并更新存储。", currentConversationID)
-	} else {
-		// 如果请求中没有提供 conversation_id，则尝试从本地存储获取。
-		storedConversationID, ok := h.conversationStore.GetConversationID(user)
-		if ok {
-			currentConversationID = storedConversationID
-			log.Printf("[Webhook] 从存储中获取到用户 '%s' 的对话ID: %s", user, currentConversationID)
-		} else {
-			// 如果请求和存储中都没有提供 conversation_id，则保持 currentConversationID 为空，让 Dify 自动创建。
-			log.Printf("[Webhook] 未找到用户 '%s' 的对话ID，将发送空对话ID给Dify，让Dify自动创建新会话。", user)
-			// 不需要调用 h.conversationStore.NewConversationID(user)
+	// 如果请求要求重置对话，则丢弃用户当前的对话，本条消息将在新的对话中处理。
+	if reset {
+		h.converter.ResetConversation(user)
+		// 只要求重置而没有消息内容时，不调用 Dify，直接返回
+		if message == "" && filePath == "" {
+			writeResult(w, "对话已重置", &service.ConvertResult{NewConversation: true, ResetReason: store.ExpireReasonManual})
+			return
 		}
 	}
 
 	// --- 消息处理和响应 ---
-	// 调用消息转换器 (h.converter) 处理并发送消息到 Dify AI 服务。
-	// 传入用户标识、对话 ID 和文件路径（如果存在）。
-	if err := h.converter.ConvertAndSend(message, user, currentConversationID, filePath); err != nil {
+	// 传入用户标识、请求中指定的对话 ID、文件路径（如果存在）、用于路由的应用和群组以及投递目标，
+	// Dify 应用的选择、对话 ID 的查找和过期判断由转换器负责。
+	req := service.ConvertRequest{
+		Message:        message,
+		User:           user,
+		ConversationID: conversationID,
+		FilePath:       filePath,
+		FileName:       fileName,
+		App:            app,
+		Group:          group,
+		Targets:        targets,
+	}
+
+	// 异步处理：校验请求参数后加入任务队列，立即返回 202 和任务 ID
+	if async || callbackURL != "" {
+		if err := h.converter.Validate(req); err != nil {
+			slog.WarnContext(ctx, "[Webhook] 异步请求参数无效", "error", err)
+			http.Error(w, fmt.Sprintf("请求参数无效: %v", err), http.StatusBadRequest)
+			return
+		}
+		if callbackURL != "" && !strings.HasPrefix(callbackURL, "http://") && !strings.HasPrefix(callbackURL, "https://") {
+			http.Error(w, "callback_url 必须是 http 或 https 地址", http.StatusBadRequest)
+			return
+		}
+		var done func()
+		if filePath != "" {
+			done = func() { os.Remove(filePath) }
+		}
+		job, err := h.jobs.SubmitContext(ctx, req, callbackURL, done)
+		if err != nil {
+			slog.WarnContext(ctx, "[Webhook] 异步任务入队失败", "error", err)
+			http.Error(w, fmt.Sprintf("异步任务入队失败: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		keepFile = true
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"status":     "accepted",
+			"job_id":     job.ID,
+			"status_url": "/jobs/" + job.ID,
+		})
+		slog.InfoContext(ctx, "[Webhook] 请求已转为异步任务", "job_id", job.ID)
+		return
+	}
+
+	// 同步处理：调用消息转换器 (h.converter) 处理并发送消息到 Dify AI 服务，完成后返回结果
+	// 使用请求的上下文，客户端断开连接时进行中的 Dify 调用和企业微信发送会被取消
+	result, err := h.converter.ConvertAndSendContext(ctx, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			slog.WarnContext(ctx, "[Webhook] 客户端已断开连接，消息处理已取消", "error", err)
+			return
+		}
 		// 如果消息处理失败（例如，与 Dify 服务通信失败），记录错误日志并返回 500 Internal Server Error。
-		log.Printf("[Webhook] 处理消息失败: %v", err)
-		http.Error(w, fmt.Sprintf("处理消息失败: %v", err), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "[Webhook] 处理消息失败", "error", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrUnknownApp) || errors.Is(err, service.ErrUnknownTarget) {
+			status = http.StatusBadRequest // 请求中指定的 Dify 应用或投递目标不存在，属于请求参数错误
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout // 调用 Dify 超过了按应用类型配置的截止时间
+		} else if errors.Is(err, service.ErrWorkflowFailed) {
+			status = http.StatusBadGateway // 工作流在 Dify 中运行失败，失败信息已发送到投递目标
+		}
+		http.Error(w, fmt.Sprintf("处理消息失败: %v", err), status)
 		return
 	}
+	if reset && result.ResetReason == store.ExpireReasonNone {
+		result.ResetReason = store.ExpireReasonManual
+	}
+
+	writeResult(w, "消息已成功处理", result)
+	// 记录 Webhook 请求处理成功并返回响应的日志，表示整个处理流程完成。
+	slog.InfoContext(ctx, "[Webhook] 请求处理成功并返回响应")
+}
+
+// writeResult 将处理结果以 JSON 格式写入成功响应
+// 响应中包含本次使用的对话 ID，以及是否开启了新的对话和原因，便于调用方感知上下文是否被重置；
+// 回复扇出到多个投递目标时还包含每个目标的发送结果，部分目标发送失败时 status 为 "partial_success"。
+func writeResult(w http.ResponseWriter, message string, result *service.ConvertResult) {
+	// 构建一个表示成功响应的 JSON 结构。
+	status := "success"
+	if result.PartialFailure() {
+		status = "partial_success"
+	}
+	response := map[string]interface{}{
+		"status":           status,
+		"message":          message,
+		"app":              result.App,
+		"conversation_id":  result.ConversationID,
+		"new_conversation": result.NewConversation,
+	}
+	if result.ResetReason != store.ExpireReasonNone {
+		response["reset_reason"] = result.ResetReason // 旧对话被丢弃的原因: idle、max_turns 或 manual
+	}
+	if len(result.Deliveries) > 0 {
+		response["deliveries"] = result.Deliveries // 每个投递目标的发送结果
+	}
+	// 以 200 OK 状态码写入成功响应。
+	writeJSON(w, http.StatusOK, response)
+}
 
+// writeJSON 以指定的状态码将 value 编码为 JSON 写入响应
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
 	// 设置 HTTP 响应头，声明响应内容为 JSON 格式。
 	w.Header().Set("Content-Type", "application/json")
-	// 设置 HTTP 状态码为 200 OK，表示请求已成功处理。
-	w.WriteHeader(http.StatusOK)
-	// 构建一个表示成功响应的 JSON 结构。
-	response := map[string]string{"status": "success", "message": "消息已成功处理"}
-	// 将成功响应编码为 JSON 并写入 HTTP 响应体。
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
 		// 如果写入响应失败，记录错误日志。
-		log.Printf("[Webhook] 写入成功响应失败: %v", err)
+		slog.Warn("[Webhook] 写入响应失败", "error", err)
 	}
-	// 记录 Webhook 请求处理成功并返回响应的日志，表示整个处理流程完成。
-	log.Println("[Webhook] 请求处理成功并返回响应")
+}
+
+// statusRecorder 包装 http.ResponseWriter，记录写入的状态码，用于统计 Webhook 请求
+type statusRecorder struct {
+	http.ResponseWriter     // ResponseWriter 是被包装的原始响应
+	status              int // status 是写入的状态码，尚未写入时为 0
+}
+
+// WriteHeader 记录状态码并写入响应头
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write 写入响应体，未显式写入状态码时视为 200
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+// statusLabel 返回用于指标的状态码标签
+// 处理过程中客户端断开连接且没有写入任何响应时沿用 nginx 的约定记为 499。
+func (rec *statusRecorder) statusLabel(r *http.Request) string {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+		if r.Context().Err() != nil {
+			status = 499
+		}
+	}
+	return strconv.Itoa(status)
+}
+
+// contentTypeLabel 将请求的 Content-Type 归一为有限的几个取值，避免参数 (例如 boundary) 导致指标标签无限增长
+func contentTypeLabel(contentType string) string {
+	if contentType == "" {
+		return "none"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "invalid"
+	}
+	switch mediaType {
+	case "application/json", "multipart/form-data":
+		return mediaType
+	}
+	return "other"
+}
+
+// authorize 在开启认证时检查请求的 Authorization 头，认证失败时写入 401 响应并返回 false
+// cfg: 应用程序配置，提供是否开启认证和期望的 Token
+func authorize(cfg *config.AppConfig, w http.ResponseWriter, r *http.Request) bool {
+	// 检查配置文件中是否开启了认证功能 (cfg.EnableAuth)。
+	if cfg.EnableAuth {
+		// 从请求头中获取 Authorization 字段。
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			// 如果 Authorization 头缺失，返回 401 Unauthorized 错误，并记录日志。
+			http.Error(w, "缺少 Authorization 头", http.StatusUnauthorized)
+			slog.WarnContext(r.Context(), "[Webhook] 缺少 Authorization 头", "remote_addr", r.RemoteAddr)
+			return false
+		}
+
+		// 构造期望的 Token 格式，通常是 "Bearer YOUR_AUTH_TOKEN"。
+		expectedToken := "Bearer " + cfg.AuthToken
+		// 比较请求头中的 Token 是否与配置中预期的 Token 匹配。
+		if authHeader != expectedToken {
+			// 如果 Token 不匹配，返回 401 Unauthorized 错误，并记录日志。
+			http.Error(w, "无效的 Token", http.StatusUnauthorized)
+			slog.WarnContext(r.Context(), "[Webhook] Token 无效", "remote_addr", r.RemoteAddr) // 不记录请求中的 Token，避免凭据泄露到日志
+			return false
+		}
+		slog.DebugContext(r.Context(), "[Webhook] Token 认证成功")
+	}
+	return true
 }
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/internal/jobs"
+	"dify2wxbot/internal/service"
+	"dify2wxbot/internal/store"
+	"dify2wxbot/pkg/sender"
+)
+
+// multipartUpload 返回上传 content 的 multipart 请求，文件名为 fileName
+func multipartUpload(t *testing.T, fileName, content string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+	r := httptest.NewRequest(http.MethodPost, "/webhook", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestWebhookUploadsWithTheSameNameDoNotCollide(t *testing.T) {
+	var mu sync.Mutex
+	var uploads []string
+	dify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/files/upload") {
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("FormFile: %v", err)
+				return
+			}
+			content, _ := io.ReadAll(file)
+			mu.Lock()
+			uploads = append(uploads, header.Filename+": "+string(content))
+			mu.Unlock()
+			w.Write([]byte(`{"id": "f1"}`))
+			return
+		}
+		w.Write([]byte(`{"answer": "已收到文件。", "conversation_id": "c1", "message_id": "m1"}`))
+	}))
+	defer dify.Close()
+
+	cfg := &config.AppConfig{
+		Dify:  config.DifyConfig{APIKey: "app-test", BaseURL: dify.URL, BotType: "chat"},
+		WeCom: config.WeComConfig{WebhookURL: "http://127.0.0.1:0/send?key=test"},
+	}
+	converter := service.NewMessageConverter(cfg, store.NewInMemoryConversationStore())
+	converter.RegisterSender(sender.NewRecorder(config.DefaultRobotName, sender.Capabilities{}))
+	manager := jobs.NewManager(config.AsyncConfig{}, converter)
+	h := NewWebhookHandler(converter, manager, cfg)
+
+	// 两个异步请求上传同名文件，请求返回后文件仍由各自的任务使用
+	for _, content := range []string{"第一份报告", "第二份报告"} {
+		rec := httptest.NewRecorder()
+		h.HandleWebhook(rec, multipartUpload(t, "../report.txt", content, map[string]string{"message": "总结文件", "user": "tester", "async": "true"}))
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d %q, want 202", rec.Code, rec.Body.String())
+		}
+	}
+	manager.Shutdown(context.Background())
+
+	sort.Strings(uploads)
+	want := []string{"report.txt: 第一份报告", "report.txt: 第二份报告"}
+	if len(uploads) != 2 || uploads[0] != want[0] || uploads[1] != want[1] {
+		t.Fatalf("dify got uploads %q, want each job's own file under its original name %q", uploads, want)
+	}
+}
@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"context"  // 导入 context 包，用于限制图片下载时间
+	"fmt"      // 导入 fmt 包，用于格式化错误信息
+	"io"       // 导入 io 包，用于读取回调请求体和图片内容
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"net/http" // 导入 net/http 包，用于处理回调请求和下载图片
+	"os"       // 导入 os 包，用于保存下载的图片
+	"strings"  // 导入 strings 包，用于还原 echostr 中被解码为空格的加号
+	"sync"     // 导入 sync 包，用于保护已处理消息 ID 的记录
+	"time"     // 导入 time 包，用于消息去重的过期时间
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取消息回调配置
+	"dify2wxbot/internal/jobs"    // 导入 internal/jobs 包，用于在后台处理回调消息
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于统计回调消息
+	"dify2wxbot/internal/service" // 导入 internal/service 包，用于构建消息处理请求
+	"dify2wxbot/pkg/sender"       // 导入 pkg/sender 包，用于构建回复消息来源会话的发送方
+	"dify2wxbot/pkg/wecom"        // 导入 pkg/wecom 包，用于校验签名、解密和解析回调消息
+)
+
+const (
+	maxCallbackBodyBytes  = 1 << 20          // 回调请求体的最大长度
+	maxCallbackImageBytes = 10 << 20         // 回调消息中图片的最大下载长度
+	callbackImageTimeout  = 3 * time.Second  // 下载回调消息中图片的超时时间，企业微信要求 5 秒内响应回调
+	callbackDedupWindow   = 10 * time.Minute // 消息 ID 去重的时间窗口，覆盖企业微信的超时重试
+	callbackReplyName     = "wecom_callback" // 回复消息来源会话的发送方名称，用于日志和投递结果
+)
+
+// WeComCallbackHandler 处理企业微信的消息回调 (/wecom/callback)
+// GET 请求用于保存回调配置时的 URL 验证；POST 请求携带加密的消息，校验签名并解密后交给异步任务处理，
+// 立即返回以满足企业微信 5 秒内响应的要求。回复发送到消息来源的会话：智能机器人使用消息中的 response_url，
+// 群机器人使用消息中的 WebhookUrl；两者都没有时才发送到配置的投递目标。
+type WeComCallbackHandler struct {
+	crypt      *wecom.MsgCrypt       // crypt 用于校验签名和加解密消息
+	jobs       *jobs.Manager         // jobs 是异步任务管理器，回调消息在后台处理
+	cfg        config.CallbackConfig // cfg 是消息回调配置
+	httpClient *http.Client          // httpClient 用于下载消息中的图片
+
+	mu   sync.Mutex           // mu 保护 seen
+	seen map[string]time.Time // seen 记录最近处理过的消息 ID，避免企业微信重试时重复回答
+}
+
+// NewWeComCallbackHandler 创建并返回一个新的 WeComCallbackHandler 实例
+// jobManager: 异步任务管理器实例，负责回调消息的排队和处理
+// cfg: 消息回调配置，EncodingAESKey 无效时返回错误
+func NewWeComCallbackHandler(jobManager *jobs.Manager, cfg config.CallbackConfig) (*WeComCallbackHandler, error) {
+	crypt, err := wecom.NewMsgCrypt(cfg.Token, cfg.EncodingAESKey, cfg.ReceiveID)
+	if err != nil {
+		return nil, err
+	}
+	return &WeComCallbackHandler{
+		crypt:      crypt,
+		jobs:       jobManager,
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: callbackImageTimeout},
+		seen:       make(map[string]time.Time),
+	}, nil
+}
+
+// HandleCallback 处理企业微信的回调请求
+func (h *WeComCallbackHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.verifyURL(w, r)
+	case http.MethodPost:
+		h.receive(w, r)
+	default:
+		http.Error(w, "只支持 GET 和 POST 请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// verifyURL 响应 URL 验证请求：校验签名并解密 echostr，将明文原样返回
+func (h *WeComCallbackHandler) verifyURL(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	// echostr 是 base64 字符串，未经 URL 编码的 "+" 会被解码为空格
+	echostr := strings.ReplaceAll(query.Get("echostr"), " ", "+")
+	plain, err := h.crypt.VerifyURL(query.Get("msg_signature"), query.Get("timestamp"), query.Get("nonce"), echostr)
+	if err != nil {
+		slog.WarnContext(ctx, "[Callback] URL 验证失败", "remote_addr", r.RemoteAddr, "error", err)
+		http.Error(w, "URL 验证失败", http.StatusForbidden)
+		return
+	}
+	slog.InfoContext(ctx, "[Callback] URL 验证成功")
+	w.Write(plain)
+}
+
+// receive 处理加密的回调消息
+func (h *WeComCallbackHandler) receive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	timestamp, nonce := query.Get("timestamp"), query.Get("nonce")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodyBytes))
+	if err != nil {
+		slog.WarnContext(ctx, "[Callback] 读取回调请求体失败", "error", err)
+		http.Error(w, "读取请求体失败", http.StatusBadRequest)
+		return
+	}
+	encrypted, isXML, err := wecom.ParseEnvelope(body)
+	if err != nil {
+		slog.WarnContext(ctx, "[Callback] 解析回调请求体失败", "error", err)
+		http.Error(w, "解析请求体失败", http.StatusBadRequest)
+		return
+	}
+	if err := h.crypt.VerifySignature(query.Get("msg_signature"), timestamp, nonce, encrypted); err != nil {
+		slog.WarnContext(ctx, "[Callback] 回调消息签名校验失败", "remote_addr", r.RemoteAddr, "error", err)
+		metrics.WeComCallbacks.Inc("unknown", "invalid_signature")
+		http.Error(w, "签名校验失败", http.StatusForbidden)
+		return
+	}
+	plain, err := h.crypt.Decrypt(encrypted)
+	if err != nil {
+		slog.WarnContext(ctx, "[Callback] 解密回调消息失败", "error", err)
+		metrics.WeComCallbacks.Inc("unknown", "decrypt_error")
+		http.Error(w, "解密消息失败", http.StatusBadRequest)
+		return
+	}
+	msg, err := wecom.ParseCallbackMessage(plain)
+	if err != nil {
+		slog.WarnContext(ctx, "[Callback] 解析回调消息失败", "error", err)
+		metrics.WeComCallbacks.Inc("unknown", "parse_error")
+		http.Error(w, "解析消息失败", http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(ctx, "[Callback] 收到企业微信回调消息", "msg_id", msg.MsgID, "msgtype", msg.MsgType,
+		"chat_type", msg.ChatType, "chat_id", msg.ChatID, "user", msg.UserID, "images", len(msg.ImageURLs))
+	slog.DebugContext(ctx, "[Callback] 回调消息内容", "text", msg.Text)
+
+	var answerID string
+	if msg.MsgType == wecom.CallbackMsgEvent {
+		text, id, ok := cardButtonMessage(ctx, msg)
+		if !ok {
+			metrics.WeComCallbacks.Inc(msg.MsgType, "event")
+			h.handleEvent(w, r, msg, isXML)
+			return
+		}
+		// 点击推荐问题或评价按钮等同于用户发送了对应的消息，之后按文本消息处理
+		msg.Text, answerID = text, id
+	}
+	if h.duplicate(msg.MsgID) {
+		slog.InfoContext(ctx, "[Callback] 消息已处理过，忽略企业微信的重试", "msg_id", msg.MsgID)
+		metrics.WeComCallbacks.Inc(msg.MsgType, "duplicate")
+		return
+	}
+	if msg.UserID == "" || (msg.Text == "" && len(msg.ImageURLs) == 0) {
+		slog.InfoContext(ctx, "[Callback] 消息没有可处理的内容，已忽略", "msgtype", msg.MsgType)
+		metrics.WeComCallbacks.Inc(msg.MsgType, "ignored")
+		return
+	}
+
+	req := service.ConvertRequest{
+		Message:  msg.Text,
+		User:     msg.ConversationKey(),
+		App:      h.cfg.App,
+		Group:    msg.ChatID,
+		Targets:  h.cfg.Targets,
+		AnswerID: answerID,
+		ReplyTo:  replySender(msg),
+	}
+	if msg.ChatType == wecom.ChatTypeGroup {
+		req.Mentions = []string{msg.UserID} // 群聊中的回复 @提问的成员
+	}
+	var done func()
+	if len(msg.ImageURLs) > 0 {
+		// Dify 的一条消息只处理一个本地文件，图文混排消息中只使用第一张图片
+		filePath, err := h.downloadImage(ctx, msg.ImageURLs[0], !isXML)
+		if err != nil {
+			slog.WarnContext(ctx, "[Callback] 下载回调消息中的图片失败，只处理文本", "error", err)
+		} else {
+			req.FilePath = filePath
+			done = func() { os.Remove(filePath) }
+		}
+	}
+	if req.Message == "" && req.FilePath == "" {
+		metrics.WeComCallbacks.Inc(msg.MsgType, "ignored")
+		return
+	}
+
+	job, err := h.jobs.SubmitContext(ctx, req, "", done)
+	if err != nil {
+		if done != nil {
+			done()
+		}
+		// 返回错误状态码，企业微信稍后会重试
+		slog.WarnContext(ctx, "[Callback] 回调消息入队失败", "error", err)
+		metrics.WeComCallbacks.Inc(msg.MsgType, "rejected")
+		h.forget(msg.MsgID)
+		http.Error(w, fmt.Sprintf("消息入队失败: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	metrics.WeComCallbacks.Inc(msg.MsgType, "accepted")
+	slog.InfoContext(ctx, "[Callback] 回调消息已交给异步任务处理", "job_id", job.ID)
+	// 返回空响应体，回复由任务完成后发送到消息来源的会话
+}
+
+// replySender 返回回复消息来源会话的发送方
+// 智能机器人的消息携带 response_url，群机器人的消息携带可向该群发送消息的 WebhookUrl；两者都没有时返回 nil，回复发送到配置的投递目标。
+func replySender(msg *wecom.CallbackMessage) sender.Sender {
+	switch {
+	case msg.ResponseURL != "":
+		return wecom.NewResponseSender(callbackReplyName, msg.ResponseURL)
+	case msg.WebhookURL != "":
+		// 与配置中相同 Webhook 的机器人共享发送队列和频率配额
+		return wecom.NewRobot(config.WeComConfig{Name: callbackReplyName, WebhookURL: msg.WebhookURL})
+	}
+	return nil
+}
+
+// cardButtonMessage 将模板卡片按钮的点击事件转换为用户消息
+// 推荐问题按钮对应该问题；评价按钮对应 /good 或 /bad 命令，同时返回被评价回答的消息 ID。其他事件返回 false。
+func cardButtonMessage(ctx context.Context, msg *wecom.CallbackMessage) (text, answerID string, ok bool) {
+	if msg.Event != wecom.EventTemplateCard {
+		return "", "", false
+	}
+	if question, ok := service.SuggestedQuestionFromKey(msg.EventKey); ok {
+		slog.InfoContext(ctx, "[Callback] 用户点击了推荐问题", "task_id", msg.TaskID, "user", msg.UserID)
+		return question, "", true
+	}
+	if command, answerID, ok := service.FeedbackFromKey(msg.EventKey); ok {
+		slog.InfoContext(ctx, "[Callback] 用户点击了评价按钮", "task_id", msg.TaskID, "user", msg.UserID, "command", command)
+		return command, answerID, true
+	}
+	return "", "", false
+}
+
+// handleEvent 处理事件消息：用户进入单聊会话时被动回复欢迎语，其他事件只记录日志
+func (h *WeComCallbackHandler) handleEvent(w http.ResponseWriter, r *http.Request, msg *wecom.CallbackMessage, isXML bool) {
+	ctx := r.Context()
+	slog.InfoContext(ctx, "[Callback] 收到企业微信事件", "event", msg.Event, "user", msg.UserID, "chat_id", msg.ChatID)
+	if msg.Event != wecom.EventEnterChat || h.cfg.WelcomeMessage == "" {
+		return
+	}
+	plain, err := wecom.TextReply(isXML, h.cfg.WelcomeMessage)
+	if err == nil {
+		err = h.writeReply(w, r, plain, isXML)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "[Callback] 回复欢迎语失败", "error", err)
+		http.Error(w, "回复失败", http.StatusInternalServerError)
+	}
+}
+
+// writeReply 加密被动回复并写入响应，格式与回调请求体一致
+func (h *WeComCallbackHandler) writeReply(w http.ResponseWriter, r *http.Request, plain []byte, isXML bool) error {
+	query := r.URL.Query()
+	timestamp, nonce := query.Get("timestamp"), query.Get("nonce")
+	encrypted, signature, err := h.crypt.EncryptReply(plain, timestamp, nonce)
+	if err != nil {
+		return err
+	}
+	reply, err := wecom.MarshalReply(isXML, encrypted, signature, timestamp, nonce)
+	if err != nil {
+		return err
+	}
+	if isXML {
+		w.Header().Set("Content-Type", "application/xml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	_, err = w.Write(reply)
+	return err
+}
+
+// downloadImage 下载回调消息中的图片并保存为临时文件，返回文件路径
+// encrypted: 图片内容是否经过加密，智能机器人的图片需要使用 EncodingAESKey 解密，群机器人的图片为明文
+func (h *WeComCallbackHandler) downloadImage(ctx context.Context, url string, encrypted bool) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, callbackImageTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create image request: %w", err)
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download image: status code %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCallbackImageBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	if encrypted {
+		if data, err = h.crypt.DecryptFile(data); err != nil {
+			return "", fmt.Errorf("failed to decrypt image: %w", err)
+		}
+	}
+
+	file, err := os.CreateTemp("", "wecom_image_*"+imageExtension(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to save image: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// imageExtension 根据图片内容返回文件扩展名，转换器根据扩展名判断上传给 Dify 的文件类型
+func imageExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	return ".jpg"
+}
+
+// duplicate 判断消息是否已经处理过，并记录本次处理的消息 ID
+// 企业微信在 5 秒内没有收到响应时会重试相同的消息，没有消息 ID 时不做去重。
+func (h *WeComCallbackHandler) duplicate(msgID string) bool {
+	if msgID == "" {
+		return false
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, seenAt := range h.seen {
+		if now.Sub(seenAt) > callbackDedupWindow {
+			delete(h.seen, id)
+		}
+	}
+	if _, ok := h.seen[msgID]; ok {
+		return true
+	}
+	h.seen[msgID] = now
+	return false
+}
+
+// forget 删除消息 ID 的记录，使企业微信的重试可以再次处理该消息
+func (h *WeComCallbackHandler) forget(msgID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.seen, msgID)
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/internal/jobs"
+	"dify2wxbot/internal/service"
+	"dify2wxbot/internal/store"
+	"dify2wxbot/pkg/sender"
+	"dify2wxbot/pkg/wecom"
+)
+
+const (
+	testCallbackToken  = "QDG6eK"
+	testCallbackAESKey = "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+)
+
+// callbackFixture 是回调处理器及其依赖，没有回复地址的消息发送到 fallback
+type callbackFixture struct {
+	handler  *WeComCallbackHandler
+	jobs     *jobs.Manager
+	crypt    *wecom.MsgCrypt
+	fallback *sender.Recorder
+}
+
+func newCallbackFixture(t *testing.T) *callbackFixture {
+	t.Helper()
+	cfg := &config.AppConfig{
+		Dify:  config.DifyConfig{APIKey: "app-test", BaseURL: "http://127.0.0.1:0", BotType: "chat"},
+		WeCom: config.WeComConfig{WebhookURL: "http://127.0.0.1:0/send?key=test"},
+	}
+	converter := service.NewMessageConverter(cfg, store.NewInMemoryConversationStore())
+	fallback := sender.NewRecorder(config.DefaultRobotName, sender.Capabilities{})
+	converter.RegisterSender(fallback)
+	manager := jobs.NewManager(config.AsyncConfig{}, converter)
+	t.Cleanup(func() { manager.Shutdown(context.Background()) })
+
+	handler, err := NewWeComCallbackHandler(manager, config.CallbackConfig{Enable: true, Token: testCallbackToken, EncodingAESKey: testCallbackAESKey})
+	if err != nil {
+		t.Fatalf("NewWeComCallbackHandler: %v", err)
+	}
+	crypt, err := wecom.NewMsgCrypt(testCallbackToken, testCallbackAESKey, "")
+	if err != nil {
+		t.Fatalf("NewMsgCrypt: %v", err)
+	}
+	return &callbackFixture{handler: handler, jobs: manager, crypt: crypt, fallback: fallback}
+}
+
+// post 加密并签名 plain，以企业微信的格式 POST 到回调处理器
+func (f *callbackFixture) post(t *testing.T, plain string, isXML bool) *httptest.ResponseRecorder {
+	t.Helper()
+	encrypted, signature, err := f.crypt.EncryptReply([]byte(plain), "1700000000", "n1")
+	if err != nil {
+		t.Fatalf("EncryptReply: %v", err)
+	}
+	body := `{"encrypt": "` + encrypted + `"}`
+	if isXML {
+		body = "<xml><ToUserName><![CDATA[bot]]></ToUserName><Encrypt><![CDATA[" + encrypted + "]]></Encrypt></xml>"
+	}
+	query := url.Values{"msg_signature": {signature}, "timestamp": {"1700000000"}, "nonce": {"n1"}}
+	req := httptest.NewRequest(http.MethodPost, "/wecom/callback?"+query.Encode(), strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	f.handler.HandleCallback(rec, req)
+	return rec
+}
+
+// wait 等待已提交的回调消息处理完成
+func (f *callbackFixture) wait(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := f.jobs.Shutdown(ctx); err != nil {
+		t.Fatalf("jobs.Shutdown: %v", err)
+	}
+}
+
+// newReplyServer 创建记录回复请求的企业微信服务端，返回其地址和读取已收到请求的函数
+func newReplyServer(t *testing.T) (string, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.URL.RequestURI()+" "+string(body))
+		mu.Unlock()
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestCallbackVerifiesURL(t *testing.T) {
+	f := newCallbackFixture(t)
+	// 选择密文中带 "+" 的 echostr，企业微信不会对其进行 URL 编码
+	var echostr string
+	for i := 0; !strings.Contains(echostr, "+"); i++ {
+		var err error
+		if echostr, err = f.crypt.Encrypt([]byte("echo-1616140317")); err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		if i > 1000 {
+			t.Fatal("could not produce an echostr containing '+'")
+		}
+	}
+	signature := f.crypt.Signature("1700000000", "n1", echostr)
+
+	rec := httptest.NewRecorder()
+	f.handler.HandleCallback(rec, httptest.NewRequest(http.MethodGet,
+		"/wecom/callback?msg_signature="+signature+"&timestamp=1700000000&nonce=n1&echostr="+echostr, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "echo-1616140317" {
+		t.Fatalf("verify url = %d %q, want 200 with the decrypted echostr", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	f.handler.HandleCallback(rec, httptest.NewRequest(http.MethodGet,
+		"/wecom/callback?msg_signature=0000&timestamp=1700000000&nonce=n1&echostr="+url.QueryEscape(echostr), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("verify url with a bad signature = %d, want 403", rec.Code)
+	}
+}
+
+func TestCallbackRejectsBadSignature(t *testing.T) {
+	f := newCallbackFixture(t)
+	encrypted, err := f.crypt.Encrypt([]byte(`{"msgid": "m1", "msgtype": "text", "from": {"userid": "zhangsan"}, "text": {"content": "/help"}}`))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/wecom/callback?msg_signature=0000&timestamp=1700000000&nonce=n1",
+		strings.NewReader(`{"encrypt": "`+encrypted+`"}`))
+	rec := httptest.NewRecorder()
+	f.handler.HandleCallback(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestCallbackIgnoresDuplicateMessages(t *testing.T) {
+	f := newCallbackFixture(t)
+	replyURL, requests := newReplyServer(t)
+	msg, _ := json.Marshal(map[string]interface{}{
+		"msgid": "m-dup", "chattype": "single", "from": map[string]string{"userid": "zhangsan"},
+		"response_url": replyURL + "/cgi-bin/aibot/response?response_code=rc",
+		"msgtype":      "text", "text": map[string]string{"content": "/help"},
+	})
+	for i := 0; i < 2; i++ {
+		if rec := f.post(t, string(msg), false); rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+			t.Fatalf("post #%d = %d %q, want 200 with an empty body", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	f.wait(t)
+	if got := requests(); len(got) != 1 || !strings.Contains(got[0], "response_code=rc") {
+		t.Fatalf("response_url got %q, want a single reply for the retried message", got)
+	}
+	if got := f.fallback.Records(); len(got) != 0 {
+		t.Fatalf("configured target got %+v, want nothing when the message carries a response_url", got)
+	}
+}
+
+func TestCallbackRepliesThroughGroupWebhook(t *testing.T) {
+	f := newCallbackFixture(t)
+	replyURL, requests := newReplyServer(t)
+	msg := `<xml><From><UserId>zhangsan</UserId></From><WebhookUrl><![CDATA[` + replyURL + `/cgi-bin/webhook/send?key=group-test]]></WebhookUrl>
+		<ChatId>g1</ChatId><ChatType>group</ChatType><MsgId>x1</MsgId><MsgType>text</MsgType><Text><Content><![CDATA[@小助手 /help]]></Content></Text></xml>`
+	if rec := f.post(t, msg, true); rec.Code != http.StatusOK {
+		t.Fatalf("post = %d %q, want 200", rec.Code, rec.Body.String())
+	}
+	f.wait(t)
+	got := requests()
+	if len(got) == 0 {
+		t.Fatal("group webhook got no reply")
+	}
+	for _, r := range got {
+		if !strings.Contains(r, "key=group-test") {
+			t.Fatalf("reply %q was not sent to the group webhook", r)
+		}
+	}
+	if len(f.fallback.Records()) != 0 {
+		t.Fatalf("configured target got %+v, want nothing when the message carries a WebhookUrl", f.fallback.Records())
+	}
+}
+
+func TestCallbackFallsBackToConfiguredTargets(t *testing.T) {
+	f := newCallbackFixture(t)
+	msg := `{"msgid": "m1", "chattype": "single", "from": {"userid": "zhangsan"}, "msgtype": "text", "text": {"content": "/help"}}`
+	if rec := f.post(t, msg, false); rec.Code != http.StatusOK {
+		t.Fatalf("post = %d %q, want 200", rec.Code, rec.Body.String())
+	}
+	f.wait(t)
+	if len(f.fallback.Records()) == 0 {
+		t.Fatal("configured target got no reply for a message without a reply address")
+	}
+}
@@ -0,0 +1,300 @@
+package jobs
+
+import (
+	"bytes"         // 导入 bytes 包，用于构建回调请求体
+	"context"       // 导入 context 包，用于在关闭时取消进行中的任务
+	"encoding/json" // 导入 encoding/json 包，用于编码回调请求体
+	"errors"        // 导入 errors 包，用于定义任务队列错误
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/http"      // 导入 net/http 包，用于发送完成回调
+	"sync"          // 导入 sync 包，用于保护任务表的并发访问
+	"time"          // 导入 time 包，用于记录任务耗时和清理过期任务
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取异步处理配置
+	"dify2wxbot/internal/logging" // 导入 logging 包，用于在任务处理中沿用提交请求的请求 ID
+	"dify2wxbot/internal/service" // 导入 internal/service 包，用于处理任务中的消息
+
+	"github.com/google/uuid" // 导入 uuid 包，用于生成任务 ID
+)
+
+const (
+	defaultWorkers          = 4                // 默认的 worker 数量
+	defaultQueueSize        = 100              // 默认的任务队列长度
+	defaultRetentionMinutes = 60               // 已完成任务的默认保留时间 (分钟)
+	callbackTimeout         = 10 * time.Second // 完成回调的 HTTP 超时时间
+)
+
+var (
+	// ErrQueueFull 表示异步任务队列已满，新任务被拒绝
+	ErrQueueFull = errors.New("async job queue is full")
+	// ErrShuttingDown 表示服务正在关闭，不再接受新任务
+	ErrShuttingDown = errors.New("async job manager is shutting down")
+)
+
+// Status 表示异步任务的状态
+type Status string
+
+const (
+	StatusQueued    Status = "queued"    // StatusQueued 表示任务正在排队等待处理
+	StatusRunning   Status = "running"   // StatusRunning 表示任务正在处理中
+	StatusSucceeded Status = "succeeded" // StatusSucceeded 表示任务处理成功
+	StatusFailed    Status = "failed"    // StatusFailed 表示任务处理失败
+)
+
+// Job 描述一个异步处理的 Webhook 请求，也是 GET /jobs/{id} 和完成回调返回的内容
+type Job struct {
+	ID              string             `json:"id"`                     // ID 是任务 ID
+	RequestID       string             `json:"request_id,omitempty"`   // RequestID 是提交任务的 Webhook 请求的请求 ID，任务处理的日志沿用该 ID
+	Status          Status             `json:"status"`                 // Status 是任务状态
+	User            string             `json:"user"`                   // User 是请求中的用户标识
+	App             string             `json:"app,omitempty"`          // App 是处理消息的 Dify 应用名称
+	ConversationID  string             `json:"conversation_id"`        // ConversationID 是本次问答所在的 Dify 对话 ID
+	NewConversation bool               `json:"new_conversation"`       // NewConversation 表示本次问答是否开启了新的对话上下文
+	ResetReason     string             `json:"reset_reason,omitempty"` // ResetReason 是旧对话被丢弃的原因
+	Answer          string             `json:"answer,omitempty"`       // Answer 是 Dify 的回答或命令的回复
+	Deliveries      []service.Delivery `json:"deliveries,omitempty"`   // Deliveries 是每个投递目标的发送结果
+	Error           string             `json:"error,omitempty"`        // Error 是任务失败的原因
+	CallbackURL     string             `json:"callback_url,omitempty"` // CallbackURL 是任务完成后通知的地址
+	CreatedAt       time.Time          `json:"created_at"`             // CreatedAt 是任务入队时间
+	StartedAt       *time.Time         `json:"started_at,omitempty"`   // StartedAt 是任务开始处理的时间
+	FinishedAt      *time.Time         `json:"finished_at,omitempty"`  // FinishedAt 是任务处理完成的时间
+	WaitMillis      int64              `json:"wait_ms"`                // WaitMillis 是任务在队列中等待的时间 (毫秒)
+	RunMillis       int64              `json:"run_ms"`                 // RunMillis 是任务处理耗时 (毫秒)
+
+	request service.ConvertRequest // request 是待处理的消息
+	done    func()                 // done 在任务处理完成后调用，用于清理临时文件等资源
+}
+
+// Manager 管理异步任务的排队、执行和查询
+// 任务进入有界队列，由固定数量的 worker 按先进先出顺序处理；已完成的任务保留一段时间以供查询，之后自动清理。
+type Manager struct {
+	converter  *service.MessageConverter // converter 是消息转换器，用于处理任务中的消息
+	queue      chan *Job                 // queue 是等待处理的任务队列
+	retention  time.Duration             // retention 是已完成任务的保留时间
+	httpClient *http.Client              // httpClient 用于发送完成回调
+	ctx        context.Context           // ctx 是任务处理使用的上下文，关闭超时后被取消
+	cancel     context.CancelFunc        // cancel 取消 ctx，中止进行中的任务并停止过期任务清理
+	workers    sync.WaitGroup            // workers 用于等待所有 worker 退出
+
+	mu     sync.Mutex      // mu 保护 jobs、closed 以及其中每个任务的状态
+	jobs   map[string]*Job // jobs 是任务 ID 到任务的映射
+	closed bool            // closed 表示已开始关闭，队列已关闭，不再接受新任务
+}
+
+// NewManager 创建 Manager 并启动 worker 和过期任务清理
+// cfg: 异步处理配置，未配置的项使用默认值
+// converter: 消息转换器实例
+func NewManager(cfg config.AsyncConfig, converter *service.MessageConverter) *Manager {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	retentionMinutes := cfg.RetentionMinutes
+	if retentionMinutes <= 0 {
+		retentionMinutes = defaultRetentionMinutes
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		ctx:        ctx,
+		cancel:     cancel,
+		converter:  converter,
+		queue:      make(chan *Job, queueSize),
+		retention:  time.Duration(retentionMinutes) * time.Minute,
+		httpClient: &http.Client{Timeout: callbackTimeout},
+		jobs:       make(map[string]*Job),
+	}
+	m.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+	go m.janitor()
+	slog.Info("[Jobs] 异步任务处理已启动", "workers", workers, "queue_size", queueSize, "retention", m.retention.String())
+	return m
+}
+
+// Submit 将消息加入异步任务队列，立即返回任务的快照
+// callbackURL: 任务完成后 POST 任务结果的地址，为空表示不回调
+// done: 任务处理完成后调用的清理函数，可以为 nil；任务未能入队时不会调用
+// 队列已满时返回 ErrQueueFull，正在关闭时返回 ErrShuttingDown。
+func (m *Manager) Submit(req service.ConvertRequest, callbackURL string, done func()) (Job, error) {
+	return m.SubmitContext(context.Background(), req, callbackURL, done)
+}
+
+// SubmitContext 与 Submit 相同，但任务记录 ctx 中的请求 ID，处理任务时的日志沿用该 ID
+// ctx 只用于读取请求 ID，不控制任务的取消：任务在提交请求返回之后才会执行。
+func (m *Manager) SubmitContext(ctx context.Context, req service.ConvertRequest, callbackURL string, done func()) (Job, error) {
+	job := &Job{
+		ID:          uuid.New().String(),
+		RequestID:   logging.RequestID(ctx),
+		Status:      StatusQueued,
+		User:        req.User,
+		CallbackURL: callbackURL,
+		CreatedAt:   time.Now(),
+		request:     req,
+		done:        done,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return Job{}, ErrShuttingDown
+	}
+	select {
+	case m.queue <- job:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[job.ID] = job
+	slog.InfoContext(ctx, "[Jobs] 任务已入队", "job_id", job.ID, "user", req.User, "queued", len(m.queue))
+	return *job, nil
+}
+
+// Get 返回指定任务的快照，任务不存在或已被清理时 ok 为 false
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Shutdown 停止接受新任务，并等待已入队和进行中的任务处理完成
+// ctx 到期时取消剩余的任务 (中止 Dify 调用和企业微信发送，尚未开始的任务直接失败)，等待 worker 退出后返回 ctx.Err()。
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	pending := len(m.queue)
+	m.mu.Unlock()
+	slog.Info("[Jobs] 正在关闭异步任务处理，等待排队中和进行中的任务完成", "pending", pending)
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		m.cancel()
+		slog.Info("[Jobs] 异步任务处理已关闭，所有任务已完成")
+		return nil
+	case <-ctx.Done():
+		slog.Warn("[Jobs] 等待异步任务完成超时，正在取消剩余的任务")
+		m.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// worker 循环处理队列中的任务，队列关闭且取空后退出
+func (m *Manager) worker() {
+	defer m.workers.Done()
+	for job := range m.queue {
+		m.run(job)
+	}
+}
+
+// run 处理一个任务，并在完成后发送回调
+func (m *Manager) run(job *Job) {
+	started := time.Now()
+	m.mu.Lock()
+	job.Status = StatusRunning
+	job.StartedAt = &started
+	job.WaitMillis = started.Sub(job.CreatedAt).Milliseconds()
+	req := job.request
+	m.mu.Unlock()
+
+	requestID := job.RequestID
+	if requestID == "" {
+		requestID = job.ID // 通过 Submit 提交的任务没有请求 ID，使用任务 ID 关联日志
+	}
+	ctx := logging.WithRequestID(m.ctx, requestID)
+	slog.InfoContext(ctx, "[Jobs] 开始处理任务", "job_id", job.ID, "waited", started.Sub(job.CreatedAt).String())
+	result, err := m.converter.ConvertAndSendContext(ctx, req)
+	if job.done != nil {
+		job.done()
+	}
+
+	finished := time.Now()
+	m.mu.Lock()
+	job.FinishedAt = &finished
+	job.RunMillis = finished.Sub(started).Milliseconds()
+	if result != nil {
+		job.App = result.App
+		job.ConversationID = result.ConversationID
+		job.NewConversation = result.NewConversation
+		job.ResetReason = result.ResetReason
+		job.Answer = result.Answer
+		job.Deliveries = result.Deliveries
+	}
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = StatusSucceeded
+	}
+	snapshot := *job
+	m.mu.Unlock()
+
+	if err != nil {
+		slog.ErrorContext(ctx, "[Jobs] 任务处理失败", "job_id", job.ID, "duration", finished.Sub(started).String(), "error", err)
+	} else {
+		slog.InfoContext(ctx, "[Jobs] 任务处理成功", "job_id", job.ID, "duration", finished.Sub(started).String())
+	}
+	if snapshot.CallbackURL != "" {
+		m.callback(ctx, snapshot)
+	}
+}
+
+// callback 将任务结果 POST 到任务的回调地址，失败时只记录日志
+// ctx: 只用于日志中的请求 ID，回调使用独立的超时时间
+func (m *Manager) callback(ctx context.Context, job Job) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		slog.ErrorContext(ctx, "[Jobs] 编码回调请求体失败", "job_id", job.ID, "error", err)
+		return
+	}
+	resp, err := m.httpClient.Post(job.CallbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.WarnContext(ctx, "[Jobs] 完成回调发送失败", "job_id", job.ID, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.WarnContext(ctx, "[Jobs] 完成回调返回非 2xx 状态码", "job_id", job.ID, "status", resp.StatusCode)
+		return
+	}
+	slog.InfoContext(ctx, "[Jobs] 完成回调已发送", "job_id", job.ID, "callback_url", job.CallbackURL)
+}
+
+// janitor 定期清理超过保留时间的已完成任务，直到 Manager 关闭
+func (m *Manager) janitor() {
+	ticker := time.NewTicker(m.retention / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.prune(now)
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// prune 删除完成时间早于 now - retention 的任务
+func (m *Manager) prune(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > m.retention {
+			delete(m.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package logging 负责初始化基于 log/slog 的结构化日志，并在 context 中传递请求 ID
+// 所有日志都会经过脱敏处理，Token、API Key 和 webhook key 不会以明文写入日志。
+package logging
+
+import (
+	"context"       // 导入 context 包，用于在调用链中传递请求 ID
+	"io"            // 导入 io 包，用于抽象日志输出目标
+	"log"           // 导入 log 包，用于将标准库日志转发到 slog
+	"log/slog"      // 导入 log/slog 包，提供结构化和分级日志
+	"net/url"       // 导入 net/url 包，用于从 webhook URL 中提取 key 和 access_token
+	"os"            // 导入 os 包，用于将日志输出到标准输出
+	"path"          // 导入 path 包，用于提取飞书和 Slack webhook 路径中的凭据
+	"path/filepath" // 导入 path/filepath 包，用于缩短日志中的源文件路径
+	"strconv"       // 导入 strconv 包，用于格式化源文件行号
+	"strings"       // 导入 strings 包，用于解析日志级别和格式
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取日志级别、格式和文件轮转配置
+
+	"github.com/google/uuid"           // 导入 uuid 包，用于生成请求 ID
+	"gopkg.in/natefinch/lumberjack.v2" // 导入 lumberjack 包，用于日志文件轮转和管理
+)
+
+// requestIDContextKey 是请求 ID 在 context 中的键
+type requestIDContextKey struct{}
+
+// Setup 根据配置创建结构化日志记录器并设置为默认记录器
+// 启用 log_to_file 时日志写入 lumberjack 管理的轮转文件，否则写入标准输出；
+// 标准库 log 包的输出也会转发到该记录器，返回值用于在程序退出时关闭日志文件，可能为 nil。
+func Setup(cfg *config.AppConfig) io.Closer {
+	var out io.Writer = os.Stdout
+	var closer io.Closer
+	if cfg.LogToFile {
+		rotator := &lumberjack.Logger{
+			Filename:   cfg.LogFilePath,     // 日志文件路径，例如 "logs/app.log"
+			MaxSize:    cfg.LogMaxSizeBytes, // 单个日志文件的最大大小（MB），达到此大小后会进行切割
+			MaxBackups: cfg.LogMaxBackups,   // 保留旧日志文件的最大个数，超出此数量的旧文件会被删除
+			MaxAge:     cfg.LogMaxAgeDays,   // 保留旧日志文件的最大天数，超出此天数的旧文件会被删除
+			Compress:   cfg.LogCompress,     // 是否压缩旧的日志文件（gzip 格式）
+		}
+		out, closer = rotator, rotator
+	}
+
+	registerConfigSecrets(cfg)
+	logger := slog.New(NewHandler(out, cfg.LogLevel, cfg.LogFormat))
+	slog.SetDefault(logger) // 同时将标准库 log 包的输出重定向到该记录器
+	log.SetFlags(0)         // 时间和源文件位置由 slog 记录
+
+	if cfg.LogToFile {
+		slog.Info("日志已重定向到文件",
+			"path", cfg.LogFilePath, "max_size_mb", cfg.LogMaxSizeBytes, "max_backups", cfg.LogMaxBackups,
+			"max_age_days", cfg.LogMaxAgeDays, "compress", cfg.LogCompress)
+	}
+	return closer
+}
+
+// registerConfigSecrets 将配置中的认证 Token、Dify API Key、Redis 密码、各渠道机器人的 webhook 凭据和签名密钥、自建应用 Secret 和消息回调密钥注册为需要脱敏的凭据
+func registerConfigSecrets(cfg *config.AppConfig) {
+	values := []string{cfg.AuthToken, cfg.Store.RedisPassword, cfg.Callback.Token, cfg.Callback.EncodingAESKey}
+	for _, app := range cfg.DifyApps() {
+		values = append(values, app.APIKey)
+	}
+	for _, robot := range cfg.WeComRobots() {
+		values = append(values, robot.CorpSecret, robot.Secret)
+		if u, err := url.Parse(robot.WebhookURL); err == nil {
+			values = append(values, u.Query().Get("key"), u.Query().Get("access_token"))
+			if robot.Type == "feishu" || robot.Type == "slack" {
+				values = append(values, path.Base(u.Path)) // 飞书和 Slack 的凭据是 webhook 路径的最后一段
+			}
+		}
+	}
+	RegisterSecrets(values...)
+}
+
+// NewHandler 创建写入 out 的日志处理器，并包装脱敏和请求 ID 处理
+// level: 日志级别，为空时使用 info
+// format: 日志格式，"text" 输出 key=value 格式，其他值输出 JSON
+func NewHandler(out io.Writer, level, format string) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       ParseLevel(level),
+		ReplaceAttr: shortSource,
+	}
+	var h slog.Handler
+	if strings.EqualFold(format, "text") {
+		h = slog.NewTextHandler(out, opts)
+	} else {
+		h = slog.NewJSONHandler(out, opts)
+	}
+	return &redactHandler{next: h}
+}
+
+// ParseLevel 将配置中的日志级别转换为 slog.Level，无法识别时使用 info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// shortSource 将源文件位置缩短为 "文件名:行号"，与之前 log.Lshortfile 的输出保持一致
+func shortSource(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.SourceKey || len(groups) > 0 {
+		return a
+	}
+	if src, ok := a.Value.Any().(*slog.Source); ok {
+		return slog.String(slog.SourceKey, filepath.Base(src.File)+":"+strconv.Itoa(src.Line))
+	}
+	return a
+}
+
+// NewRequestID 生成新的请求 ID
+func NewRequestID() string {
+	return uuid.NewString()
+}
+
+// WithRequestID 返回携带请求 ID 的 context，之后使用该 context 记录的日志都会带上 request_id 字段
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestID 返回 context 中的请求 ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"context"       // 导入 context 包，用于从 context 中读取请求 ID
+	"crypto/sha256" // 导入 crypto/sha256 包，用于将用户标识转换为不可逆的摘要
+	"encoding/hex"  // 导入 encoding/hex 包，用于格式化摘要
+	"log/slog"      // 导入 log/slog 包，用于实现日志处理器
+	"regexp"        // 导入 regexp 包，用于识别日志文本中的凭据
+	"strings"       // 导入 strings 包，用于替换已知的凭据
+	"sync"          // 导入 sync 包，用于保护已注册的凭据列表
+)
+
+const (
+	redacted     = "[REDACTED]" // 凭据被替换后的文本
+	requestIDKey = "request_id" // 请求 ID 在日志中的字段名
+	minSecretLen = 6            // 注册凭据的最小长度，过短的值容易误伤正常文本，不做替换
+)
+
+// secretKeys 是值总是被完全隐藏的字段名 (小写)
+var secretKeys = map[string]bool{
+	"authorization": true,
+	"token":         true,
+	"auth_token":    true,
+	"api_key":       true,
+	"apikey":        true,
+	"password":      true,
+	"secret":        true,
+	"webhook_key":   true,
+}
+
+// userKeys 是值为用户标识的字段名 (小写)，输出时替换为摘要，既能关联同一用户的日志又不暴露原始 ID
+var userKeys = map[string]bool{
+	"user":    true,
+	"user_id": true,
+}
+
+// secretPatterns 用于识别日志文本中出现的凭据
+var secretPatterns = []struct {
+	re   *regexp.Regexp // re 匹配凭据
+	repl string         // repl 是替换后的文本
+}{
+	{regexp.MustCompile(`(?i)\bbearer\s+[^\s"',;]+`), "Bearer " + redacted},                                  // Authorization 头
+	{regexp.MustCompile(`(?i)([?&](?:key|token|access_token|api_key|secret)=)[^&\s"']+`), "${1}" + redacted}, // URL 中的 webhook key 等查询参数
+	{regexp.MustCompile(`\b(app|dataset)-[A-Za-z0-9]{16,}\b`), "${1}-" + redacted},                           // Dify API Key
+}
+
+var (
+	secretsMu  sync.RWMutex      // secretsMu 保护 registered 和 secrets
+	registered []string          // registered 保存所有已注册的凭据替换对
+	secrets    *strings.Replacer // secrets 将已注册的凭据替换为 [REDACTED]，未注册时为 nil
+)
+
+// RegisterSecrets 注册需要从所有日志中移除的凭据，例如配置中的认证 Token 和 API Key
+// 空值和过短的值会被忽略；重复调用会追加到已注册的凭据中。
+func RegisterSecrets(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	var pairs []string
+	for _, v := range values {
+		if len(v) >= minSecretLen {
+			pairs = append(pairs, v, redacted)
+		}
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	registered = append(registered, pairs...)
+	secrets = strings.NewReplacer(registered...)
+}
+
+// Redact 移除文本中的凭据，供需要在日志以外输出错误信息的调用方使用
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	secretsMu.RLock()
+	r := secrets
+	secretsMu.RUnlock()
+	if r != nil {
+		s = r.Replace(s)
+	}
+	return s
+}
+
+// HashUser 返回用户标识的摘要，用于在日志中关联同一用户而不记录原始 ID
+func HashUser(user string) string {
+	if user == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(user))
+	return "u_" + hex.EncodeToString(sum[:6])
+}
+
+// redactHandler 包装另一个 slog.Handler，在输出前移除凭据、隐藏用户标识，并附加 context 中的请求 ID
+type redactHandler struct {
+	next slog.Handler // next 是实际负责格式化和输出的处理器
+}
+
+// Enabled 实现 slog.Handler 接口
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle 实现 slog.Handler 接口
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	record := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String(requestIDKey, id))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		record.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, record)
+}
+
+// WithAttrs 实现 slog.Handler 接口
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	cleaned := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		cleaned[i] = redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(cleaned)}
+}
+
+// WithGroup 实现 slog.Handler 接口
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name)}
+}
+
+// redactAttr 根据字段名和字段值移除凭据、隐藏用户标识
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	key := strings.ToLower(a.Key)
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, redacted)
+	case a.Value.Kind() == slog.KindGroup:
+		group := a.Value.Group()
+		cleaned := make([]slog.Attr, len(group))
+		for i, g := range group {
+			cleaned[i] = redactAttr(g)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(cleaned...)}
+	case userKeys[key] && a.Value.Kind() == slog.KindString:
+		return slog.String(a.Key, HashUser(a.Value.String()))
+	case a.Value.Kind() == slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case a.Value.Kind() == slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+	}
+	return a
+}
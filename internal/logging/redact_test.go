@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	RegisterSecrets("s3cr3t-auth-token", "short", "")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Authorization: Bearer app-abc.def", "Authorization: Bearer [REDACTED]"},
+		{`{"header": "bearer xyz123", "ok": 1}`, `{"header": "Bearer [REDACTED]", "ok": 1}`},
+		{"POST https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=693a91f6-7xxx&debug=1 failed",
+			"POST https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=[REDACTED]&debug=1 failed"},
+		{"https://oapi.dingtalk.com/robot/send?access_token=abc123&sign=x", "https://oapi.dingtalk.com/robot/send?access_token=[REDACTED]&sign=x"},
+		{"keyword=plain text?monkey=1", "keyword=plain text?monkey=1"},
+		{"api key app-0123456789abcdefXYZ is invalid", "api key app-[REDACTED] is invalid"},
+		{"dataset-0123456789abcdef0123", "dataset-[REDACTED]"},
+		{"my-app-short", "my-app-short"},
+		{"token s3cr3t-auth-token leaked", "token [REDACTED] leaked"},
+		{"short values are kept", "short values are kept"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// logJSON 使用 NewHandler 记录一条日志并解析输出的 JSON
+func logJSON(t *testing.T, log func(logger *slog.Logger)) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	log(slog.New(NewHandler(&buf, "debug", "json")))
+	var out map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("decode log line %q: %v", buf.String(), err)
+	}
+	return out
+}
+
+func TestHandlerRedactsAttrs(t *testing.T) {
+	out := logJSON(t, func(logger *slog.Logger) {
+		ctx := WithRequestID(context.Background(), "req-1")
+		logger.InfoContext(ctx, "调用 Bearer app-0123456789abcdefXYZ 失败",
+			"Authorization", "Bearer abc", "api_key", 12345, "user", "zhangsan", "user_id", "lisi", "users", 2,
+			"url", "https://example.com/hook?token=abc", "error", errors.New("bad key app-0123456789abcdefXYZ"))
+	})
+	want := map[string]interface{}{
+		"msg":           "调用 Bearer [REDACTED] 失败",
+		"request_id":    "req-1",
+		"Authorization": "[REDACTED]",
+		"api_key":       "[REDACTED]",
+		"user":          HashUser("zhangsan"),
+		"user_id":       HashUser("lisi"),
+		"users":         float64(2),
+		"url":           "https://example.com/hook?token=[REDACTED]",
+		"error":         "bad key app-[REDACTED]",
+	}
+	for key, value := range want {
+		if out[key] != value {
+			t.Errorf("%s = %v, want %v", key, out[key], value)
+		}
+	}
+	if h := HashUser("zhangsan"); !strings.HasPrefix(h, "u_") || h == HashUser("lisi") || HashUser("") != "" {
+		t.Errorf("HashUser(zhangsan) = %q, want a stable u_ digest distinct per user", h)
+	}
+}
+
+func TestHandlerRedactsWithAttrsAndGroups(t *testing.T) {
+	out := logJSON(t, func(logger *slog.Logger) {
+		logger.With("user", "zhangsan", "token", "abc").
+			WithGroup("dify").With("user_id", "lisi").
+			Info("请求", slog.Group("req", "user", "wangwu", "secret", "x"), "key", "https://x/?key=abc")
+	})
+	if out["user"] != HashUser("zhangsan") || out["token"] != redacted {
+		t.Fatalf("attrs from With = %v, %v; want the user hashed and the token hidden", out["user"], out["token"])
+	}
+	dify, _ := out["dify"].(map[string]interface{})
+	req, _ := dify["req"].(map[string]interface{})
+	if dify["user_id"] != HashUser("lisi") || dify["key"] != "https://x/?key=[REDACTED]" {
+		t.Fatalf("grouped attrs = %v, want user_id hashed and the URL redacted", dify)
+	}
+	if req["user"] != HashUser("wangwu") || req["secret"] != redacted {
+		t.Fatalf("nested group = %v, want user hashed and secret hidden", req)
+	}
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"log/slog" // 导入 log/slog 包，用于记录采集失败
+	"net/http" // 导入 net/http 包，用于提供 /metrics 处理器
+)
+
+// Default 是服务使用的全局指标注册表，/metrics 输出其中的所有指标
+var Default = NewRegistry()
+
+// latencyBuckets 是 Dify 调用耗时直方图的桶上界 (秒)，覆盖从快速的历史查询到长时间运行的工作流
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+var (
+	// WebhookRequests 统计 /webhook 请求数，按响应状态码和请求的 Content-Type 区分
+	WebhookRequests = Default.NewCounterVec("dify2wxbot_webhook_requests_total",
+		"Webhook requests by HTTP status and request content type.", "status", "content_type")
+
+	// DifyRequestDuration 统计 Dify API 调用耗时 (包含重试和退避等待)，按应用类型和接口区分
+	DifyRequestDuration = Default.NewHistogramVec("dify2wxbot_dify_request_duration_seconds",
+		"Latency of Dify API calls including retries.", latencyBuckets, "bot_type", "endpoint")
+
+	// DifyRequests 统计 Dify API 调用次数，result 为 success、error 或 canceled
+	DifyRequests = Default.NewCounterVec("dify2wxbot_dify_requests_total",
+		"Dify API calls by result.", "bot_type", "endpoint", "result")
+
+	// DifyRetries 统计 Dify API 的重试次数，reason 为 Dify 错误码、HTTP 状态码或 transport
+	DifyRetries = Default.NewCounterVec("dify2wxbot_dify_retries_total",
+		"Retried Dify API attempts by reason.", "bot_type", "endpoint", "reason")
+
+	// DifyTokens 统计 Dify 响应 metadata.usage 中报告的 token 用量，type 为 prompt、completion 或 total
+	DifyTokens = Default.NewCounterVec("dify2wxbot_dify_tokens_total",
+		"Tokens reported in Dify metadata.usage.", "app", "bot_type", "type")
+
+	// WeComMessages 统计企业微信消息发送次数，按消息类型和错误码区分
+	// errcode 为 0 表示成功，45009 表示触发频率限制，"http" 表示请求未得到企业微信的业务响应。
+	WeComMessages = Default.NewCounterVec("dify2wxbot_wecom_messages_total",
+		"WeCom robot sends by msgtype and errcode.", "msgtype", "errcode")
+
+	// WeComSendDuration 统计单次企业微信发送请求的耗时 (不含排队等待)
+	WeComSendDuration = Default.NewHistogramVec("dify2wxbot_wecom_send_duration_seconds",
+		"Latency of WeCom robot webhook calls, excluding queueing.", []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "msgtype")
+
+	// ChannelMessages 统计飞书、钉钉和 Slack 等其他渠道的消息发送次数，按渠道、消息类型和结果区分
+	// result 为 0 表示成功，其他值是渠道返回的错误码，"http" 表示请求未得到渠道的业务响应。
+	ChannelMessages = Default.NewCounterVec("dify2wxbot_channel_messages_total",
+		"Feishu, DingTalk and Slack webhook sends by channel, msgtype and result.", "channel", "msgtype", "result")
+
+	// WeComCallbacks 统计企业微信消息回调，result 为 accepted、event、duplicate、ignored、rejected 或校验解密失败的原因
+	WeComCallbacks = Default.NewCounterVec("dify2wxbot_wecom_callbacks_total",
+		"WeCom callback messages by msgtype and result.", "msgtype", "result")
+
+	// DifyFeedback 统计用户对回答的评价，rating 为 like 或 dislike，result 为 success、error 或 not_found (没有可评价的回答)
+	DifyFeedback = Default.NewCounterVec("dify2wxbot_dify_feedback_total",
+		"Answer ratings submitted to Dify by app, rating and result.", "app", "rating", "result")
+
+	// SchedulerRuns 统计定时任务的执行结果，outcome 为 success、partial_failure 或 failure
+	SchedulerRuns = Default.NewCounterVec("dify2wxbot_scheduler_runs_total",
+		"Scheduled task runs by outcome.", "task", "outcome")
+)
+
+// RegisterConversationCount 注册对话存储规模的仪表盘，每次输出指标时调用 count 获取当前的对话数量
+// backend: 存储类型，例如 memory、bolt 或 redis，作为标签输出
+func RegisterConversationCount(backend string, count func() (int, error)) {
+	Default.NewGaugeFunc("dify2wxbot_conversations",
+		"Conversations currently held by the conversation store.", func() []Sample {
+			n, err := count()
+			if err != nil {
+				slog.Warn("[Metrics] 获取对话数量失败", "backend", backend, "error", err)
+				return nil
+			}
+			return []Sample{{LabelValues: []string{backend}, Value: float64(n)}}
+		}, "backend")
+}
+
+// Handler 返回输出 Default 中所有指标的 HTTP 处理器
+func Handler() http.Handler {
+	return Default.Handler()
+}
@@ -0,0 +1,299 @@
+// Package metrics 实现了一个精简的 Prometheus 指标注册表，并以文本格式 (text/plain; version=0.0.4) 对外暴露
+// 这里只实现服务实际用到的计数器、直方图和采集时计算的仪表盘，不依赖 Prometheus 客户端库。
+package metrics
+
+import (
+	"fmt"      // 导入 fmt 包，用于格式化指标样本
+	"io"       // 导入 io 包，用于写出指标文本
+	"math"     // 导入 math 包，用于格式化 +Inf 等特殊浮点数
+	"net/http" // 导入 net/http 包，用于提供 /metrics 处理器
+	"sort"     // 导入 sort 包，用于按标签排序输出样本，保证输出稳定
+	"strconv"  // 导入 strconv 包，用于格式化浮点数
+	"strings"  // 导入 strings 包，用于拼接标签和转义标签值
+	"sync"     // 导入 sync 包，用于保护指标数据的并发访问
+)
+
+// collector 是可以注册到 Registry 的指标
+type collector interface {
+	name() string      // name 返回指标名称
+	write(w io.Writer) // write 以文本格式写出 HELP、TYPE 和所有样本
+}
+
+// Registry 保存所有已注册的指标，并以 Prometheus 文本格式输出
+type Registry struct {
+	mu         sync.Mutex  // mu 保护 collectors
+	collectors []collector // collectors 按注册顺序保存指标
+}
+
+// NewRegistry 创建并返回一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register 注册指标，名称重复时 panic，因为这属于程序错误
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic(fmt.Sprintf("metrics: duplicate metric %q", c.name()))
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Write 将所有指标以文本格式写入 w
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler 返回输出 Registry 中所有指标的 HTTP 处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// CounterVec 是带标签的计数器，只增不减
+type CounterVec struct {
+	metricName string              // metricName 是指标名称
+	help       string              // help 是指标说明
+	labels     []string            // labels 是标签名列表
+	mu         sync.Mutex          // mu 保护 values
+	values     map[string]*counter // values 按标签值组合保存计数
+}
+
+// counter 是 CounterVec 中某一组标签值对应的计数
+type counter struct {
+	labelValues []string // labelValues 是标签值，与 CounterVec.labels 一一对应
+	value       float64  // value 是当前计数
+}
+
+// NewCounterVec 创建计数器并注册到 r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{metricName: name, help: help, labels: labels, values: make(map[string]*counter)}
+	r.register(c)
+	return c
+}
+
+// Inc 将指定标签值对应的计数加 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 将指定标签值对应的计数增加 v，v 为负数时忽略
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	checkLabels(c.metricName, c.labels, labelValues)
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.values[key]
+	if !ok {
+		entry = &counter{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = entry
+	}
+	entry.value += v
+}
+
+// name 实现 collector 接口
+func (c *CounterVec) name() string { return c.metricName }
+
+// write 实现 collector 接口
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		entry := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, entry.labelValues, "", ""), formatValue(entry.value))
+	}
+}
+
+// HistogramVec 是带标签的直方图，用于统计耗时等数值的分布
+type HistogramVec struct {
+	metricName string                // metricName 是指标名称
+	help       string                // help 是指标说明
+	labels     []string              // labels 是标签名列表
+	buckets    []float64             // buckets 是各个桶的上界，按升序排列，不包含 +Inf
+	mu         sync.Mutex            // mu 保护 values
+	values     map[string]*histogram // values 按标签值组合保存直方图数据
+}
+
+// histogram 是 HistogramVec 中某一组标签值对应的数据
+type histogram struct {
+	labelValues []string // labelValues 是标签值，与 HistogramVec.labels 一一对应
+	counts      []uint64 // counts 是落入每个桶 (非累计) 的观测次数，最后一个元素对应 +Inf
+	sum         float64  // sum 是所有观测值之和
+	count       uint64   // count 是观测次数
+}
+
+// NewHistogramVec 创建直方图并注册到 r
+// buckets: 各个桶的上界，会按升序排序
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{metricName: name, help: help, labels: labels, buckets: sorted, values: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	checkLabels(h.metricName, h.labels, labelValues)
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.values[key]
+	if !ok {
+		entry = &histogram{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = entry
+	}
+	i := sort.SearchFloat64s(h.buckets, v) // 第一个上界 >= v 的桶
+	entry.counts[i]++
+	entry.sum += v
+	entry.count++
+}
+
+// name 实现 collector 接口
+func (h *HistogramVec) name() string { return h.metricName }
+
+// write 实现 collector 接口
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.metricName, h.help, "histogram")
+	for _, key := range sortedKeys(h.values) {
+		entry := h.values[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += entry.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, entry.labelValues, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, entry.labelValues, "le", "+Inf"), entry.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, entry.labelValues, "", ""), formatValue(entry.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, entry.labelValues, "", ""), entry.count)
+	}
+}
+
+// Sample 是采集时计算出的一个仪表盘样本
+type Sample struct {
+	LabelValues []string // LabelValues 是标签值，与 GaugeFunc 的标签名一一对应
+	Value       float64  // Value 是样本值
+}
+
+// GaugeFunc 是在每次输出指标时调用函数计算当前值的仪表盘，适用于队列长度、存储大小等由其他组件维护的状态
+type GaugeFunc struct {
+	metricName string          // metricName 是指标名称
+	help       string          // help 是指标说明
+	labels     []string        // labels 是标签名列表
+	collect    func() []Sample // collect 返回当前的所有样本
+}
+
+// NewGaugeFunc 创建采集时计算的仪表盘并注册到 r
+// collect: 每次输出指标时调用，返回的样本标签数量必须与 labels 一致
+func (r *Registry) NewGaugeFunc(name, help string, collect func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, labels: labels, collect: collect}
+	r.register(g)
+	return g
+}
+
+// name 实现 collector 接口
+func (g *GaugeFunc) name() string { return g.metricName }
+
+// write 实现 collector 接口
+func (g *GaugeFunc) write(w io.Writer) {
+	samples := g.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return labelKey(samples[i].LabelValues) < labelKey(samples[j].LabelValues)
+	})
+	writeHeader(w, g.metricName, g.help, "gauge")
+	for _, s := range samples {
+		if len(s.LabelValues) != len(g.labels) {
+			continue // 标签数量不一致的样本无法输出，直接跳过
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, formatLabels(g.labels, s.LabelValues, "", ""), formatValue(s.Value))
+	}
+}
+
+// checkLabels 检查标签值数量是否与标签名一致，不一致属于程序错误，直接 panic
+func checkLabels(name string, labels, values []string) {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", name, len(labels), len(values)))
+	}
+}
+
+// labelKey 将标签值组合编码为 map 的键
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys 返回按字典序排列的 map 键，保证输出顺序稳定
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeHeader 写出指标的 HELP 和 TYPE 行
+func writeHeader(w io.Writer, name, help, typ string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels 将标签格式化为 {a="x",b="y"}，extraName 不为空时追加一个额外的标签 (例如直方图的 le)
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", n, escapeLabelValue(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelValueEscaper 按文本格式的要求转义标签值中的反斜杠、双引号和换行
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue 转义标签值，并替换其中的非法 UTF-8 字节
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(strings.ToValidUTF8(v, "\uFFFD"))
+}
+
+// formatValue 按 Prometheus 文本格式输出浮点数
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
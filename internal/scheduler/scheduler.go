@@ -0,0 +1,363 @@
+package scheduler
+
+import (
+	"bytes"         // 导入 bytes 包，用于构建 HTTP 请求体和渲染模板
+	"context"       // 导入 context 包，用于在停止调度器时取消进行中的定时任务
+	"encoding/json" // 导入 encoding/json 包，用于编码旧版 HTTP 调用的请求体
+	"fmt"           // 导入 fmt 包，用于格式化任务名称和错误信息
+	"io"            // 导入 io 包，用于读取 HTTP 响应体
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/http"      // 导入 net/http 包，用于旧版通过 HTTP 调用目标 URL 的定时任务
+	"text/template" // 导入 text/template 包，用于渲染消息、用户标识和输入变量模板
+	"time"          // 导入 time 包，用于设置 HTTP 超时和提供模板中的时间变量
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取定时任务配置
+	"dify2wxbot/internal/logging" // 导入 logging 包，用于为每次运行分配请求 ID
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录定时任务的执行结果
+	"dify2wxbot/internal/service" // 导入 internal/service 包，用于在进程内调用消息处理流程
+
+	"github.com/robfig/cron/v3" // 导入 cron 包，用于定时任务调度
+)
+
+const (
+	outcomeSuccess        = "success"         // 定时任务执行结果：成功
+	outcomePartialFailure = "partial_failure" // 定时任务执行结果：部分投递目标发送失败
+	outcomeFailure        = "failure"         // 定时任务执行结果：失败
+)
+
+// weekdays 是模板变量 Weekday 使用的中文星期名称
+var weekdays = [...]string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+
+// TemplateData 是定时任务模板中可以使用的变量
+// 例如 "今天是 {{.Date}} {{.Weekday}}"，或使用 {{.Now.Format "2006年01月"}} 自定义时间格式。
+type TemplateData struct {
+	Now     time.Time // Now 是本次触发的时间
+	Date    string    // Date 是本次触发的日期，格式为 "2006-01-02"
+	Time    string    // Time 是本次触发的时间，格式为 "15:04"
+	Weekday string    // Weekday 是本次触发是星期几，例如 "星期一"
+	Name    string    // Name 是定时任务名称
+	Index   int       // Index 是定时任务在配置中的序号，从 0 开始
+}
+
+// newTemplateData 根据触发时间创建模板变量
+func newTemplateData(now time.Time, name string, index int) TemplateData {
+	return TemplateData{
+		Now:     now,
+		Date:    now.Format("2006-01-02"),
+		Time:    now.Format("15:04"),
+		Weekday: weekdays[now.Weekday()],
+		Name:    name,
+		Index:   index,
+	}
+}
+
+// job 是一个已解析的定时任务
+type job struct {
+	cfg     config.SchedulerConfig // cfg 是定时任务的原始配置
+	index   int                    // index 是定时任务在配置中的序号
+	name    string                 // name 是定时任务名称，用于日志
+	message *template.Template     // message 是消息模板
+	user    *template.Template     // user 是用户标识模板
+	inputs  interface{}            // inputs 是输入变量，其中的字符串已解析为模板
+}
+
+// Scheduler 负责按配置定时触发消息处理
+// 定时任务在进程内直接调用 MessageConverter，不再经过本服务的 Webhook；
+// 仍配置了 target_url 的旧版任务会继续通过 HTTP 调用该 URL。
+type Scheduler struct {
+	cron       *cron.Cron                // cron 是 Cron 调度器实例
+	converter  *service.MessageConverter // converter 是消息转换器，用于在进程内处理定时消息
+	cfg        *config.AppConfig         // cfg 是应用程序配置，旧版 HTTP 调用时用于读取认证 Token
+	httpClient *http.Client              // httpClient 是旧版 HTTP 调用使用的客户端
+	ctx        context.Context           // ctx 是定时任务运行使用的上下文，停止调度器超时后被取消
+	cancel     context.CancelFunc        // cancel 取消 ctx，中止进行中的定时任务
+}
+
+// New 根据配置创建 Scheduler 并注册所有启用的定时任务
+// 模板或 Cron 表达式配置有误时返回错误；时间单位或间隔配置有误的任务会被跳过。
+func New(cfg *config.AppConfig, converter *service.MessageConverter) (*Scheduler, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		ctx:       ctx,
+		cancel:    cancel,
+		cron:      cron.New(),
+		converter: converter,
+		cfg:       cfg,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second, // 设置 HTTP 请求的超时时间为 10 秒，防止长时间阻塞
+		},
+	}
+	for i, schedulerCfg := range cfg.Schedulers {
+		name := schedulerCfg.Name
+		if name == "" {
+			name = fmt.Sprintf("定时器 %d", i)
+		}
+		if !schedulerCfg.Enable {
+			slog.Info("[Scheduler] 定时任务未启用，跳过配置和启动", "task", name)
+			continue
+		}
+		j, err := newJob(schedulerCfg, i, name)
+		if err != nil {
+			return nil, err
+		}
+		spec, ok := cronSpec(schedulerCfg, name)
+		if !ok {
+			continue
+		}
+		if _, err := s.cron.AddFunc(spec, func() { s.run(j, time.Now()) }); err != nil {
+			return nil, fmt.Errorf("%s：添加 Cron 表达式 '%s' 失败: %w", name, spec, err)
+		}
+		slog.Info("[Scheduler] 定时任务已启动", "task", name, "spec", spec)
+	}
+	return s, nil
+}
+
+// Start 启动调度器，在所有定时任务注册完毕后调用
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop 停止调度器，不再触发新的定时任务，并等待进行中的定时任务完成
+// ctx 到期时取消进行中的定时任务 (中止 Dify 调用和企业微信发送)，等待它们退出后返回 ctx.Err()。
+func (s *Scheduler) Stop(ctx context.Context) error {
+	done := s.cron.Stop().Done()
+	select {
+	case <-done:
+		s.cancel()
+		slog.Info("[Scheduler] 调度器已停止，所有定时任务已完成")
+		return nil
+	case <-ctx.Done():
+		slog.Warn("[Scheduler] 等待定时任务完成超时，正在取消进行中的定时任务")
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// cronSpec 返回定时任务的 Cron 表达式，优先使用 CronSpec，否则将间隔时间和单位转换为 "@every" 表达式
+// 时间单位或间隔配置有误时记录日志并返回 false。
+func cronSpec(cfg config.SchedulerConfig, name string) (string, bool) {
+	if cfg.CronSpec != "" {
+		return cfg.CronSpec, true
+	}
+	var spec string
+	switch cfg.Unit {
+	case "second": // 单位为秒
+		spec = fmt.Sprintf("@every %ds", cfg.Interval)
+	case "minute": // 单位为分钟
+		spec = fmt.Sprintf("@every %dm", cfg.Interval)
+	case "hour": // 单位为小时
+		spec = fmt.Sprintf("@every %dh", cfg.Interval)
+	default: // 未知的单位
+		slog.Error("[Scheduler] 检测到未知的定时任务单位，此定时任务将不会启动", "task", name, "unit", cfg.Unit)
+		return "", false
+	}
+	if cfg.Interval <= 0 {
+		slog.Error("[Scheduler] 定时任务间隔时间必须大于 0，此定时任务将不会启动", "task", name)
+		return "", false
+	}
+	return spec, true
+}
+
+// newJob 解析定时任务中的模板
+func newJob(cfg config.SchedulerConfig, index int, name string) (*job, error) {
+	j := &job{cfg: cfg, index: index, name: name}
+	var err error
+	if j.message, err = template.New("message").Parse(cfg.DefaultMessage); err != nil {
+		return nil, fmt.Errorf("%s：解析消息模板失败: %w", name, err)
+	}
+	user := cfg.User
+	if user == "" {
+		user = fmt.Sprintf("scheduler_bot_%d", index) // 定时任务的默认用户标识，带序号区分，便于追踪
+	}
+	if j.user, err = template.New("user").Parse(user); err != nil {
+		return nil, fmt.Errorf("%s：解析用户标识模板失败: %w", name, err)
+	}
+	if j.inputs, err = parseInputs(cfg.Inputs); err != nil {
+		return nil, fmt.Errorf("%s：解析 inputs 模板失败: %w", name, err)
+	}
+	return j, nil
+}
+
+// parseInputs 将输入变量中的字符串解析为模板，并把 YAML 解析出的 map[interface{}]interface{} 转换为可以编码为 JSON 的 map[string]interface{}
+func parseInputs(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return template.New("input").Parse(v)
+	case map[string]interface{}:
+		parsed := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			p, err := parseInputs(item)
+			if err != nil {
+				return nil, err
+			}
+			parsed[key] = p
+		}
+		return parsed, nil
+	case map[interface{}]interface{}:
+		parsed := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			p, err := parseInputs(item)
+			if err != nil {
+				return nil, err
+			}
+			parsed[fmt.Sprint(key)] = p
+		}
+		return parsed, nil
+	case []interface{}:
+		parsed := make([]interface{}, len(v))
+		for i, item := range v {
+			p, err := parseInputs(item)
+			if err != nil {
+				return nil, err
+			}
+			parsed[i] = p
+		}
+		return parsed, nil
+	default:
+		return v, nil
+	}
+}
+
+// renderInputs 使用模板变量渲染已解析的输入变量
+func renderInputs(value interface{}, data TemplateData) (interface{}, error) {
+	switch v := value.(type) {
+	case *template.Template:
+		return render(v, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := renderInputs(item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderInputs(item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return v, nil
+	}
+}
+
+// render 使用模板变量渲染模板
+func render(tmpl *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// run 执行一次定时任务，并将执行结果记录到指标中
+// 每次运行分配一个新的请求 ID，本次运行中 Dify 调用和企业微信发送的日志都会带上该 ID。
+func (s *Scheduler) run(j *job, now time.Time) {
+	ctx := logging.WithRequestID(s.ctx, logging.NewRequestID())
+	metrics.SchedulerRuns.Inc(j.name, s.execute(ctx, j, now))
+}
+
+// execute 执行一次定时任务并返回执行结果 (success、partial_failure 或 failure)
+func (s *Scheduler) execute(ctx context.Context, j *job, now time.Time) string {
+	data := newTemplateData(now, j.name, j.index)
+	message, err := render(j.message, data)
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] 渲染消息模板失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+	user, err := render(j.user, data)
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] 渲染用户标识模板失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+	rendered, err := renderInputs(j.inputs, data)
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] 渲染 inputs 模板失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+	inputs, _ := rendered.(map[string]interface{})
+
+	if j.cfg.TargetURL != "" {
+		return s.post(ctx, j, message, user)
+	}
+
+	slog.InfoContext(ctx, "[Scheduler] 定时任务触发", "task", j.name, "user", user, "app", j.cfg.App)
+	slog.DebugContext(ctx, "[Scheduler] 定时任务消息内容", "task", j.name, "message", message)
+	if !j.cfg.KeepConversation {
+		s.converter.ResetConversation(user) // 每次运行都开启新的对话，避免上下文在多次运行之间累积
+	}
+	result, err := s.converter.ConvertAndSendContext(ctx, service.ConvertRequest{
+		Message: message,
+		User:    user,
+		App:     j.cfg.App,
+		Targets: j.cfg.Targets,
+		Inputs:  inputs,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] 处理消息失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+	for _, delivery := range result.Deliveries {
+		if delivery.Err != nil {
+			slog.WarnContext(ctx, "[Scheduler] 投递到目标失败", "task", j.name, "target", delivery.Target, "error", delivery.Err)
+		}
+	}
+	if result.PartialFailure() {
+		slog.WarnContext(ctx, "[Scheduler] 处理完成，但部分投递目标发送失败", "task", j.name, "app", result.App)
+		return outcomePartialFailure
+	}
+	slog.InfoContext(ctx, "[Scheduler] 处理成功", "task", j.name, "app", result.App)
+	return outcomeSuccess
+}
+
+// post 以旧版方式通过 HTTP 调用定时任务的目标 URL，并返回执行结果
+// 请求通过 X-Request-ID 头携带本次运行的请求 ID，目标为本服务的 /webhook 时两端的日志可以关联。
+func (s *Scheduler) post(ctx context.Context, j *job, message, user string) string {
+	slog.InfoContext(ctx, "[Scheduler] 定时任务触发，正在调用目标 URL", "task", j.name, "url", j.cfg.TargetURL)
+	// 构建发送到目标 URL 的请求体，包含消息、用户标识、应用和投递目标
+	requestBody := map[string]interface{}{
+		"message": message,
+		"user":    user,
+		"app":     j.cfg.App,
+		"targets": j.cfg.Targets,
+	}
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] JSON 编码请求体失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.cfg.TargetURL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] 创建 HTTP 请求失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", logging.RequestID(ctx))
+	if s.cfg.EnableAuth { // 注意：这里的认证 Token 是全局的，所有定时任务共享
+		req.Header.Set("Authorization", "Bearer "+s.cfg.AuthToken)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		slog.ErrorContext(ctx, "[Scheduler] 发送 HTTP 请求失败", "task", j.name, "error", err)
+		return outcomeFailure
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		slog.ErrorContext(ctx, "[Scheduler] HTTP 请求返回非 200 状态码", "task", j.name, "status", resp.StatusCode, "body", string(bodyBytes))
+		return outcomeFailure
+	}
+	slog.InfoContext(ctx, "[Scheduler] HTTP 请求成功", "task", j.name)
+	return outcomeSuccess
+}
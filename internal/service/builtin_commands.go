@@ -0,0 +1,251 @@
+package service
+
+import (
+	"fmt"      // 导入 fmt 包，用于格式化命令回复
+	"log/slog" // 导入 log/slog 包，用于记录命令注册情况
+	"strings"  // 导入 strings 包，用于拼接命令回复
+	"time"     // 导入 time 包，用于格式化对话时间
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取自定义命令配置
+	"dify2wxbot/internal/store"  // 导入 internal/store 包，用于获取对话重置原因
+	"dify2wxbot/pkg/wecom"       // 导入 pkg/wecom 包，用于读取企业微信发送队列的运行状态
+)
+
+const (
+	defaultHistoryLimit = 5   // /history 默认显示的问答条数
+	maxHistoryLimit     = 20  // /history 最多显示的问答条数
+	historyAnswerRunes  = 200 // /history 中每条回答最多显示的字符数
+)
+
+// registerBuiltinCommands 注册内置命令: /reset、/help、/app、/history、/rename、/forget、/status、/good 和 /bad
+func (c *MessageConverter) registerBuiltinCommands() {
+	builtins := []*Command{
+		{
+			Name:        "reset",
+			Description: "丢弃当前对话，开启新的对话上下文",
+			Handler:     c.resetCommand,
+		},
+		{
+			Name:        "help",
+			Description: "显示可用命令，或查看指定命令的用法",
+			Args:        []Arg{{Name: "command", Type: ArgString}},
+			Handler:     c.helpCommand,
+		},
+		{
+			Name:        "app",
+			Description: "查看可用的 Dify 应用，或切换到指定应用",
+			Args:        []Arg{{Name: "name", Type: ArgString}},
+			Handler:     c.appCommand,
+		},
+		{
+			Name:        "history",
+			Description: fmt.Sprintf("查看当前对话最近的问答记录，默认 %d 条，最多 %d 条", defaultHistoryLimit, maxHistoryLimit),
+			Args:        []Arg{{Name: "count", Type: ArgInt}},
+			Handler:     c.historyCommand,
+		},
+		{
+			Name:        "rename",
+			Description: "重命名当前对话，不带名称时由 Dify 自动生成",
+			Args:        []Arg{{Name: "name", Type: ArgText}},
+			Handler:     c.renameCommand,
+		},
+		{
+			Name:        "forget",
+			Description: "从 Dify 中删除当前对话及其记录，并开启新的对话",
+			Handler:     c.forgetCommand,
+		},
+		{
+			Name:        "status",
+			Description: "查看当前对话和机器人的运行状态",
+			Handler:     c.statusCommand,
+		},
+		{
+			Name:        "good",
+			Description: "评价最近的一条回答有帮助",
+			Handler:     c.goodCommand,
+		},
+		{
+			Name:        "bad",
+			Description: "评价最近的一条回答没帮助，可以附上原因",
+			Args:        []Arg{{Name: "reason", Type: ArgText}},
+			Handler:     c.badCommand,
+		},
+	}
+	for _, cmd := range builtins {
+		if err := c.commands.Register(cmd); err != nil {
+			panic(err) // 内置命令定义错误属于编程错误
+		}
+	}
+}
+
+// registerConfigCommands 注册配置文件中定义的自定义命令
+// 自定义命令可以覆盖同名的内置命令，配置有误的命令会被跳过。
+func (c *MessageConverter) registerConfigCommands(commands []config.CommandConfig) {
+	for _, commandCfg := range commands {
+		commandCfg := commandCfg
+		if commandCfg.App != "" && !c.hasApp(commandCfg.App) {
+			slog.Warn("[Command] 自定义命令引用了不存在的 Dify 应用，已跳过", "command", commandCfg.Name, "app", commandCfg.App)
+			continue
+		}
+		cmd := &Command{
+			Name:         commandCfg.Name,
+			Description:  commandCfg.Description,
+			AllowedUsers: commandCfg.AllowedUsers,
+		}
+		if commandCfg.Reply != "" {
+			cmd.Handler = func(ctx *CommandContext) (string, error) {
+				return commandCfg.Reply, nil
+			}
+		} else {
+			cmd.Args = []Arg{{Name: "text", Type: ArgText, Required: commandCfg.Prompt == ""}}
+			cmd.Handler = func(ctx *CommandContext) (string, error) {
+				ctx.ForwardTo(commandCfg.App, strings.TrimSpace(commandCfg.Prompt+ctx.Args.String("text")))
+				return "", nil
+			}
+		}
+		if err := c.commands.Register(cmd); err != nil {
+			slog.Error("[Command] 注册自定义命令失败", "error", err)
+			continue
+		}
+		slog.Info("[Command] 已注册自定义命令", "usage", cmd.Usage())
+	}
+}
+
+// resetCommand 处理 /reset 命令
+func (c *MessageConverter) resetCommand(ctx *CommandContext) (string, error) {
+	c.ResetConversation(ctx.User)
+	ctx.Result.NewConversation = true
+	ctx.Result.ResetReason = store.ExpireReasonManual
+	return "对话已重置，下一条消息将开启新的对话。", nil
+}
+
+// helpCommand 处理 /help 命令，只列出当前用户有权限使用的命令
+func (c *MessageConverter) helpCommand(ctx *CommandContext) (string, error) {
+	if name := strings.TrimPrefix(ctx.Args.String("command"), "/"); name != "" {
+		cmd, ok := c.commands.Lookup(name)
+		if !ok || !cmd.Allowed(ctx.User) {
+			return fmt.Sprintf("未知命令: /%s，发送 /help 查看可用命令。", name), nil
+		}
+		return fmt.Sprintf("%s\n%s", cmd.Usage(), cmd.Description), nil
+	}
+
+	var b strings.Builder
+	b.WriteString("可用命令:")
+	for _, cmd := range c.commands.Commands() {
+		if !cmd.Allowed(ctx.User) {
+			continue
+		}
+		b.WriteString("\n" + cmd.Usage())
+		if cmd.Description != "" {
+			b.WriteString(" - " + cmd.Description)
+		}
+	}
+	return b.String(), nil
+}
+
+// appCommand 处理 /app 命令，不带参数时列出可用应用，带参数时切换到指定应用
+func (c *MessageConverter) appCommand(ctx *CommandContext) (string, error) {
+	name := ctx.Args.String("name")
+	if name == "" {
+		current := c.currentApp(ctx.User, ctx.Group).Name()
+		var b strings.Builder
+		b.WriteString("可用的 Dify 应用:")
+		for _, app := range c.appNames() {
+			marker := ""
+			if app == current {
+				marker = " (当前)"
+			}
+			b.WriteString("\n- " + app + marker)
+		}
+		return b.String(), nil
+	}
+	if !c.hasApp(name) {
+		return fmt.Sprintf("未找到 Dify 应用: %s，发送 /app 查看可用应用。", name), nil
+	}
+	c.selectApp(ctx.Context, ctx.User, name)
+	return fmt.Sprintf("已切换到 Dify 应用: %s", name), nil
+}
+
+// historyCommand 处理 /history 命令，从 Dify 获取当前对话最近的问答记录
+func (c *MessageConverter) historyCommand(ctx *CommandContext) (string, error) {
+	limit := ctx.Args.Int("count", defaultHistoryLimit)
+	if limit <= 0 {
+		return "参数 count 必须大于 0。", nil
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	svc := c.currentApp(ctx.User, ctx.Group)
+	conversation, ok := c.conversationStore.GetConversation(conversationKey(svc.Name(), ctx.User))
+	if !ok {
+		return fmt.Sprintf("在 Dify 应用 %s 中没有进行中的对话。", svc.Name()), nil
+	}
+	resp, err := svc.GetMessagesContext(ctx.Context, ctx.User, conversation.ID, "", limit)
+	if err != nil {
+		return "", fmt.Errorf("failed to get conversation history: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return "当前对话还没有问答记录。", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "最近 %d 条问答:", len(resp.Data))
+	// Dify 按时间倒序返回，这里按时间正序显示
+	for i := len(resp.Data) - 1; i >= 0; i-- {
+		message := resp.Data[i]
+		fmt.Fprintf(&b, "\n\n[%s]\n问: %s\n答: %s",
+			time.Unix(message.CreatedAt, 0).Format("01-02 15:04"), message.Query, truncateRunes(message.Answer, historyAnswerRunes))
+	}
+	return b.String(), nil
+}
+
+// statusCommand 处理 /status 命令
+func (c *MessageConverter) statusCommand(ctx *CommandContext) (string, error) {
+	svc := c.currentApp(ctx.User, ctx.Group)
+	var b strings.Builder
+	b.WriteString("运行状态:")
+	fmt.Fprintf(&b, "\nDify 应用: %s (类型: %s", svc.Name(), svc.app.BotType)
+	if svc.app.ResponseMode != "" {
+		fmt.Fprintf(&b, ", 响应模式: %s", svc.app.ResponseMode)
+	}
+	b.WriteString(")")
+
+	if conversation, ok := c.conversationStore.GetConversation(conversationKey(svc.Name(), ctx.User)); ok {
+		fmt.Fprintf(&b, "\n当前对话: %s，已进行 %d 轮", conversation.ID, conversation.Turns)
+		if !conversation.LastActive.IsZero() {
+			fmt.Fprintf(&b, "，最后活跃于 %s", conversation.LastActive.Format("2006-01-02 15:04:05"))
+		}
+	} else {
+		b.WriteString("\n当前对话: 无")
+	}
+	var limits []string
+	if c.policy.IdleTTL > 0 {
+		limits = append(limits, fmt.Sprintf("空闲超过 %s", c.policy.IdleTTL))
+	}
+	if c.policy.MaxTurns > 0 {
+		limits = append(limits, fmt.Sprintf("满 %d 轮", c.policy.MaxTurns))
+	}
+	if len(limits) > 0 {
+		fmt.Fprintf(&b, "\n对话策略: %s后开启新对话", strings.Join(limits, "或"))
+	}
+
+	for _, name := range c.robotOrder {
+		q, ok := c.robots[name].(interface{ QueueStats() wecom.QueueStats })
+		if !ok {
+			continue // 没有发送队列的投递目标，例如测试中使用的 sender.Recorder
+		}
+		stats := q.QueueStats()
+		fmt.Fprintf(&b, "\n发送队列 (%s): 排队 %d，已发送 %d，失败 %d，丢弃 %d，限流 %d 次",
+			name, stats.Depth, stats.Sent, stats.Failed, stats.Dropped, stats.RateLimited)
+	}
+	return b.String(), nil
+}
+
+// truncateRunes 将文本截断到最多 n 个字符，超出部分以省略号表示
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
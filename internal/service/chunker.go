@@ -0,0 +1,215 @@
+package service
+
+import (
+	"fmt"          // 导入 fmt 包，用于生成分段标记
+	"strings"      // 导入 strings 包，用于字符串切分和拼接
+	"unicode/utf8" // 导入 unicode/utf8 包，用于按字符边界安全切分多字节文本
+)
+
+const (
+	maxTextMessageBytes     = 2048               // 企业微信文本消息内容的最大字节数
+	maxMarkdownMessageBytes = 4096               // 企业微信 Markdown 消息内容的最大字节数
+	chunkMarkerReserve      = 16                 // 为分段标记 (例如 "\n(10/12)") 预留的字节数
+	truncatedMarker         = "\n\n……(回复过长，已截断)" // 只能发送一条消息的目标截断回复时追加的提示
+)
+
+// sentenceTerminators 是按句子切分时使用的句末标点
+var sentenceTerminators = []string{"。", "！", "？", "；", ". ", "! ", "? ", "; ", "\n"}
+
+// splitMessage 将长消息切分为多个不超过 limit 字节的分段
+// 切分优先级依次为：Markdown 块 (空行分隔，代码块整体视为一块)、句子、字符。
+// 代码块被切开时，每个分段都会补全开始和结束的 ``` 标记，保证代码块在各分段内闭合。
+// 分段数大于 1 时，每段末尾会追加 "(1/3)" 形式的分段标记。
+// content: 待切分的消息内容
+// limit: 单条消息的最大字节数，例如文本消息为 2048，Markdown 消息为 4096
+func splitMessage(content string, limit int) []string {
+	content = strings.TrimSpace(content)
+	if len(content) <= limit {
+		return []string{content}
+	}
+
+	budget := limit - chunkMarkerReserve // 每个分段正文可用的字节数
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	appendPiece := func(piece string, sep string) {
+		if current.Len() > 0 && current.Len()+len(sep)+len(piece) > budget {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString(sep)
+		}
+		current.WriteString(piece)
+	}
+
+	for _, block := range splitMarkdownBlocks(content) {
+		if len(block) <= budget {
+			appendPiece(block, "\n\n")
+			continue
+		}
+		// 单个块超出预算：代码块按行切分并补全标记，普通段落按句子切分
+		var pieces []string
+		if isCodeBlock(block) {
+			pieces = splitCodeBlock(block, budget)
+		} else {
+			pieces = splitSentences(block, budget)
+		}
+		flush()
+		for _, piece := range pieces {
+			appendPiece(piece, "")
+		}
+		flush()
+	}
+	flush()
+
+	if len(chunks) > 1 {
+		for i := range chunks {
+			chunks[i] = fmt.Sprintf("%s\n(%d/%d)", chunks[i], i+1, len(chunks))
+		}
+	}
+	return chunks
+}
+
+// truncateMessage 将消息截断为不超过 limit 字节的一条消息，用于只能发送一条消息的目标
+// 截断点落在 UTF-8 字符边界上，被截断的消息末尾追加 truncatedMarker。
+func truncateMessage(content string, limit int) string {
+	content = strings.TrimSpace(content)
+	if len(content) <= limit {
+		return content
+	}
+	return strings.TrimSpace(splitByBytes(content, limit-len(truncatedMarker))[0]) + truncatedMarker
+}
+
+// splitMarkdownBlocks 将 Markdown 文本按空行拆分为块
+// 代码块 (``` 包围的内容) 即使内部包含空行，也作为一个整体的块返回。
+func splitMarkdownBlocks(content string) []string {
+	var blocks []string
+	var current []string
+	inFence := false
+	flush := func() {
+		if len(current) > 0 {
+			block := strings.TrimSpace(strings.Join(current, "\n"))
+			if block != "" {
+				blocks = append(blocks, block)
+			}
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if !inFence {
+				flush() // 代码块开始前的内容自成一块
+			}
+			current = append(current, line)
+			if inFence {
+				flush() // 代码块结束，整体作为一块
+			}
+			inFence = !inFence
+			continue
+		}
+		if trimmed == "" && !inFence {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return blocks
+}
+
+// isCodeBlock 判断块是否为 ``` 包围的代码块
+func isCodeBlock(block string) bool {
+	return strings.HasPrefix(strings.TrimSpace(block), "```")
+}
+
+// splitCodeBlock 将超长代码块按行切分，并为每一段补全开始和结束标记
+// 开始标记保留原有的语言声明 (例如 ```go)。
+func splitCodeBlock(block string, budget int) []string {
+	lines := strings.Split(block, "\n")
+	opening := strings.TrimSpace(lines[0])
+	body := lines[1:]
+	if len(body) > 0 && strings.HasPrefix(strings.TrimSpace(body[len(body)-1]), "```") {
+		body = body[:len(body)-1]
+	}
+	const closing = "```"
+	inner := budget - len(opening) - len(closing) - 2 // 去掉开始、结束标记及两个换行后的可用字节数
+
+	var pieces []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			pieces = append(pieces, opening+"\n"+current.String()+"\n"+closing)
+			current.Reset()
+		}
+	}
+	for _, line := range body {
+		for _, part := range splitByBytes(line, inner) {
+			if current.Len() > 0 && current.Len()+1+len(part) > inner {
+				flush()
+			}
+			if current.Len() > 0 {
+				current.WriteString("\n")
+			}
+			current.WriteString(part)
+		}
+	}
+	flush()
+	return pieces
+}
+
+// splitSentences 将超长段落按句末标点切分，句子本身超出预算时再按字符切分
+// 返回的各片段直接拼接即可还原原文。
+func splitSentences(text string, budget int) []string {
+	var sentences []string
+	rest := text
+	for rest != "" {
+		end := -1
+		for _, term := range sentenceTerminators {
+			if idx := strings.Index(rest, term); idx >= 0 && (end < 0 || idx+len(term) < end) {
+				end = idx + len(term)
+			}
+		}
+		if end < 0 {
+			end = len(rest)
+		}
+		sentences = append(sentences, rest[:end])
+		rest = rest[end:]
+	}
+
+	var pieces []string
+	for _, sentence := range sentences {
+		pieces = append(pieces, splitByBytes(sentence, budget)...)
+	}
+	return pieces
+}
+
+// splitByBytes 将文本按字节数切分，切分点始终落在 UTF-8 字符边界上，不会截断多字节字符
+func splitByBytes(text string, limit int) []string {
+	if limit <= 0 || len(text) <= limit {
+		return []string{text}
+	}
+	var parts []string
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			_, size := utf8.DecodeRuneInString(text)
+			cut = size
+		}
+		parts = append(parts, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// stripMarkers 检查每个分段末尾的 "(i/n)" 标记并去掉，只有一个分段时不应有标记
+func stripMarkers(t *testing.T, chunks []string) []string {
+	t.Helper()
+	if len(chunks) == 1 {
+		return chunks
+	}
+	bodies := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		marker := fmt.Sprintf("\n(%d/%d)", i+1, len(chunks))
+		if !strings.HasSuffix(chunk, marker) {
+			t.Fatalf("chunk %d = %q, want it to end with %q", i, chunk, marker)
+		}
+		bodies[i] = strings.TrimSuffix(chunk, marker)
+	}
+	return bodies
+}
+
+func TestSplitMessage(t *testing.T) {
+	longSentences := strings.Repeat("年假需要提前三个工作日在系统中申请。", 20)
+	code := "```go\n" + strings.Repeat("fmt.Println(\"第一行代码\")\n", 60) + "```"
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		chunks  int                                 // chunks 是期望的分段数，0 表示只要求多于一段
+		check   func(t *testing.T, bodies []string) // check 检查去掉分段标记后的各段正文
+	}{
+		{
+			name:    "short message",
+			content: "  你好  ",
+			limit:   maxTextMessageBytes,
+			chunks:  1,
+			check: func(t *testing.T, bodies []string) {
+				if bodies[0] != "你好" {
+					t.Errorf("chunk = %q, want the trimmed message", bodies[0])
+				}
+			},
+		},
+		{
+			name:    "paragraphs",
+			content: strings.Repeat("第一段内容。\n\n", 30) + "最后一段。",
+			limit:   200,
+			check: func(t *testing.T, bodies []string) {
+				for _, body := range bodies {
+					if strings.HasPrefix(body, "\n") || strings.HasSuffix(body, "\n") {
+						t.Errorf("chunk %q should be split between paragraphs", body)
+					}
+				}
+				if got := strings.Join(bodies, "\n\n"); got != strings.TrimSpace(strings.Repeat("第一段内容。\n\n", 30)+"最后一段。") {
+					t.Errorf("joined chunks = %q, want the original paragraphs", got)
+				}
+			},
+		},
+		{
+			name:    "sentence fallback",
+			content: longSentences,
+			limit:   300,
+			check: func(t *testing.T, bodies []string) {
+				for _, body := range bodies {
+					if !strings.HasSuffix(body, "。") {
+						t.Errorf("chunk %q should end at a sentence boundary", body)
+					}
+				}
+				if got := strings.Join(bodies, ""); got != longSentences {
+					t.Errorf("joined chunks = %q, want the original paragraph", got)
+				}
+			},
+		},
+		{
+			name:    "single oversized line",
+			content: strings.Repeat("啊", 1000),
+			limit:   maxTextMessageBytes,
+			chunks:  2,
+			check: func(t *testing.T, bodies []string) {
+				if got := strings.Join(bodies, ""); got != strings.Repeat("啊", 1000) {
+					t.Errorf("joined chunks lost characters: %d bytes, want %d", len(got), 3000)
+				}
+			},
+		},
+		{
+			name:    "multi-byte boundary",
+			content: "a" + strings.Repeat("😀", 700),
+			limit:   maxTextMessageBytes,
+			check: func(t *testing.T, bodies []string) {
+				if got := strings.Join(bodies, ""); got != "a"+strings.Repeat("😀", 700) {
+					t.Errorf("joined chunks = %d bytes, want the original %d bytes", len(got), 1+4*700)
+				}
+			},
+		},
+		{
+			name:    "code fence",
+			content: "示例代码如下：\n\n" + code + "\n\n以上。",
+			limit:   400,
+			check: func(t *testing.T, bodies []string) {
+				var lines int
+				for _, body := range bodies {
+					if strings.Count(body, "```")%2 != 0 {
+						t.Errorf("chunk %q has an unclosed code fence", body)
+					}
+					if strings.Contains(body, "fmt.Println") && !strings.HasPrefix(body, "```go\n") && !strings.Contains(body, "\n```go\n") {
+						t.Errorf("chunk %q does not reopen the ```go fence", body)
+					}
+					lines += strings.Count(body, "fmt.Println")
+				}
+				if lines != 60 {
+					t.Errorf("chunks contain %d code lines, want 60", lines)
+				}
+				if !strings.HasPrefix(bodies[0], "示例代码如下：") || bodies[len(bodies)-1] != "以上。" && !strings.HasSuffix(bodies[len(bodies)-1], "\n\n以上。") {
+					t.Errorf("first chunk %q / last chunk %q, want the surrounding text kept in order", bodies[0], bodies[len(bodies)-1])
+				}
+			},
+		},
+		{
+			name:    "markdown limit",
+			content: strings.Repeat("## 标题\n\n"+strings.Repeat("内容", 100)+"\n\n", 30),
+			limit:   maxMarkdownMessageBytes,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := splitMessage(tt.content, tt.limit)
+			if tt.chunks > 0 && len(chunks) != tt.chunks {
+				t.Fatalf("got %d chunks, want %d", len(chunks), tt.chunks)
+			}
+			if tt.chunks == 0 && len(chunks) < 2 {
+				t.Fatalf("got %d chunks, want the message to be split", len(chunks))
+			}
+			for i, chunk := range chunks {
+				if len(chunk) > tt.limit {
+					t.Errorf("chunk %d is %d bytes including its marker, limit %d", i, len(chunk), tt.limit)
+				}
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %d splits a multi-byte character: %q", i, chunk)
+				}
+			}
+			bodies := stripMarkers(t, chunks)
+			if tt.check != nil {
+				tt.check(t, bodies)
+			}
+		})
+	}
+}
+
+func TestSplitMessageMarkerFitsManyChunks(t *testing.T) {
+	// 分段数达到三位数时，"(100/120)" 形式的标记也不能超出限制
+	chunks := splitMessage(strings.Repeat("很长的一句话没有任何标点", 800), 64)
+	if len(chunks) < 100 {
+		t.Fatalf("got %d chunks, want at least 100", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 64 || !utf8.ValidString(chunk) {
+			t.Fatalf("chunk %d = %q (%d bytes), want a valid chunk within 64 bytes", i, chunk, len(chunk))
+		}
+	}
+	stripMarkers(t, chunks)
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"fmt"      // 导入 fmt 包，用于格式化来源说明
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"strings"  // 导入 strings 包，用于拼接 Markdown 文本和整理片段
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取引用来源的展示配置
+	"dify2wxbot/pkg/sender"      // 导入 pkg/sender 包，用于向每个投递目标发送引用来源
+	"dify2wxbot/pkg/wecom"       // 导入 pkg/wecom 包，用于构建 news_notice 模板卡片
+)
+
+const (
+	defaultMaxCitations     = 3      // 未配置 max_sources 时最多展示的来源数
+	defaultCitationSnippet  = 60     // 未配置 snippet_length 时每条来源展示的片段长度 (字符数)
+	maxCardCitations        = 4      // news_notice 卡片的纵向内容列表最多 4 项
+	maxCardItemTitleRunes   = 26     // 卡片纵向内容标题的最大字符数
+	maxCardItemDescRunes    = 112    // 卡片纵向内容描述的最大字符数
+	citationsStyleCard      = "card" // 以模板卡片展示引用来源
+	citationsTitle          = "参考来源" // 引用来源的标题
+	citationsCardAspectRate = 2.25   // 卡片封面图片的宽高比
+)
+
+// selectCitations 按配置筛选要展示的检索结果：去掉相关度低于 min_score 的结果和重复的分段，并限制数量
+// 检索结果保持 Dify 返回的顺序 (按相关度从高到低)。
+func selectCitations(resources []DifyRetrieverResource, cfg config.CitationsConfig) []DifyRetrieverResource {
+	limit := cfg.MaxSources
+	if limit <= 0 {
+		limit = defaultMaxCitations
+	}
+	if cfg.Style == citationsStyleCard && limit > maxCardCitations {
+		limit = maxCardCitations
+	}
+	seen := make(map[string]bool)
+	var selected []DifyRetrieverResource
+	for _, r := range resources {
+		if len(selected) >= limit {
+			break
+		}
+		if r.Score < cfg.MinScore {
+			continue
+		}
+		if r.SegmentID != "" {
+			if seen[r.SegmentID] {
+				continue
+			}
+			seen[r.SegmentID] = true
+		}
+		selected = append(selected, r)
+	}
+	return selected
+}
+
+// citationSource 返回一条来源的说明，例如 "员工手册.pdf (人事知识库 · 第 3 段 · 相关度 0.87)"
+func citationSource(r DifyRetrieverResource) string {
+	name := r.DocumentName
+	if name == "" {
+		name = "未命名文档"
+	}
+	var details []string
+	if r.DatasetName != "" {
+		details = append(details, r.DatasetName)
+	}
+	if r.SegmentPosition > 0 {
+		details = append(details, fmt.Sprintf("第 %d 段", r.SegmentPosition))
+	}
+	if r.Score > 0 {
+		details = append(details, fmt.Sprintf("相关度 %.2f", r.Score))
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return name + " (" + strings.Join(details, " · ") + ")"
+}
+
+// citationSnippet 将分段内容整理为一行，超过 length 个字符时截断；length 为负数时返回空字符串
+func citationSnippet(content string, length int) string {
+	if length < 0 {
+		return ""
+	}
+	if length == 0 {
+		length = defaultCitationSnippet
+	}
+	return truncateRunes(strings.Join(strings.Fields(content), " "), length)
+}
+
+// citationsMarkdown 将检索结果渲染为带编号的 "参考来源" Markdown，片段以引用的形式显示在来源下方
+func citationsMarkdown(resources []DifyRetrieverResource, cfg config.CitationsConfig) string {
+	var b strings.Builder
+	b.WriteString("**" + citationsTitle + "**")
+	for i, r := range resources {
+		fmt.Fprintf(&b, "\n%d. %s", i+1, citationSource(r))
+		if snippet := citationSnippet(r.Content, cfg.SnippetLength); snippet != "" {
+			b.WriteString("\n> " + snippet)
+		}
+	}
+	return b.String()
+}
+
+// citationsCard 将检索结果渲染为 news_notice 模板卡片，每条来源对应纵向内容列表中的一项
+func citationsCard(resources []DifyRetrieverResource, cfg config.CitationsConfig) wecom.TemplateCard {
+	items := make([]interface{}, 0, len(resources))
+	for i, r := range resources {
+		desc := citationSource(r)
+		if snippet := citationSnippet(r.Content, cfg.SnippetLength); snippet != "" {
+			desc += "：" + snippet
+		}
+		items = append(items, map[string]string{
+			"title": truncateRunes(fmt.Sprintf("%d. %s", i+1, r.DocumentName), maxCardItemTitleRunes-len("...")),
+			"desc":  truncateRunes(desc, maxCardItemDescRunes-len("...")),
+		})
+	}
+	return wecom.TemplateCard{
+		CardType: "news_notice",
+		MainTitle: map[string]string{
+			"title": citationsTitle,
+			"desc":  fmt.Sprintf("本次回答引用了 %d 个知识库分段", len(resources)),
+		},
+		CardImage:       map[string]interface{}{"url": cfg.CardImageURL, "aspect_ratio": citationsCardAspectRate},
+		VerticalContent: items,
+		CardAction:      map[string]interface{}{"type": 1, "url": cfg.CardURL},
+	}
+}
+
+// sendCitations 在回答发送完成后发送引用来源，应用未开启 citations 或没有符合条件的检索结果时不发送
+// 引用来源只是回答的补充，发送失败时只记录日志，不计入投递结果，ConvertAndSend 不因此返回错误。
+func sendCitations(d *delivery, cfg config.CitationsConfig, resources []DifyRetrieverResource) {
+	if !cfg.Enable || len(resources) == 0 {
+		return
+	}
+	selected := selectCitations(resources, cfg)
+	if len(selected) == 0 {
+		slog.DebugContext(d.ctx, "[Converter] 检索结果的相关度均低于阈值，不发送引用来源", "resources", len(resources), "min_score", cfg.MinScore)
+		return
+	}
+	slog.InfoContext(d.ctx, "[Converter] 发送引用来源", "sources", len(selected), "style", cfg.Style)
+	markdown := citationsMarkdown(selected, cfg)
+	d.optional("citations", func(robot sender.Sender) error {
+		if cfg.Style == citationsStyleCard {
+			return d.sendTemplateCardTo(robot, citationsCard(selected, cfg), markdown)
+		}
+		return d.sendMarkdownTo(robot, markdown)
+	})
+}
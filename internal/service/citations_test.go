@@ -0,0 +1,42 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/pkg/sender"
+)
+
+func TestConverterAppendsCitations(t *testing.T) {
+	c, rec := newTestConverter(t, difyAnswer(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1", "metadata": {"retriever_resources": [
+		{"position": 1, "dataset_name": "人事知识库", "document_name": "员工手册.pdf", "segment_id": "s1", "segment_position": 3, "score": 0.91, "content": "员工每年享有\n10 天带薪年假。"},
+		{"position": 2, "dataset_name": "人事知识库", "document_name": "员工手册.pdf", "segment_id": "s1", "score": 0.91, "content": "重复的分段"},
+		{"position": 3, "dataset_name": "人事知识库", "document_name": "考勤制度.docx", "segment_id": "s2", "score": 0.35, "content": "相关度较低"}
+	]}}`), func(cfg *config.AppConfig) {
+		cfg.Dify.Citations = config.CitationsConfig{Enable: true, MinScore: 0.5}
+	})
+
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "年假有几天", User: "tester"}); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	got := rec.Records()
+	want := "**参考来源**\n1. 员工手册.pdf (人事知识库 · 第 3 段 · 相关度 0.91)\n> 员工每年享有 10 天带薪年假。"
+	if len(got) != 2 || got[0].Content != "年假为 10 天。" || got[1].Kind != sender.KindMarkdown || got[1].Content != want {
+		t.Fatalf("recorder got %+v, want the answer followed by one source", got)
+	}
+}
+
+func TestCitationsSendFailureDoesNotFailDelivery(t *testing.T) {
+	rec := sender.NewRecorder("default", weComCaps)
+	d := newTestDelivery(rec)
+	if err := d.sendText("年假为 10 天。"); err != nil {
+		t.Fatalf("sendText: %v", err)
+	}
+	rec.FailWith(errors.New("45009 api freq out of limit"))
+	sendCitations(d, config.CitationsConfig{Enable: true}, []DifyRetrieverResource{{DatasetName: "人事知识库", DocumentName: "员工手册.pdf", SegmentID: "s1", Score: 0.9}})
+
+	if got := d.results(); len(got) != 1 || got[0].Err != nil {
+		t.Fatalf("results = %+v, want the answer's target reported as delivered", got)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"  // 导入 context 包，用于向命令传递消息处理的上下文
+	"fmt"      // 导入 fmt 包，用于格式化错误信息和用法说明
+	"log/slog" // 导入 log/slog 包，用于日志输出
+	"sort"     // 导入 sort 包，用于按名称排列命令
+	"strconv"  // 导入 strconv 包，用于解析整数类型的参数
+	"strings"  // 导入 strings 包，用于拆分命令名称和参数
+	"sync"     // 导入 sync 包，用于保护命令注册表的并发访问
+	"unicode"  // 导入 unicode 包，用于识别参数之间的空白字符
+)
+
+// ArgType 定义命令参数的类型
+type ArgType int
+
+const (
+	ArgString ArgType = iota // ArgString 是单个单词
+	ArgInt                   // ArgInt 是整数
+	ArgText                  // ArgText 是剩余的全部文本 (可包含空格)，只能作为最后一个参数
+)
+
+// Arg 定义命令的一个参数
+type Arg struct {
+	Name     string  // Name 是参数名称，用于在处理函数中读取参数值，也显示在用法说明中
+	Type     ArgType // Type 是参数类型，决定参数的解析方式
+	Required bool    // Required 表示参数是否必填，必填参数必须位于可选参数之前
+}
+
+// Args 保存解析后的命令参数
+type Args map[string]interface{}
+
+// String 返回字符串或文本类型参数的值，未提供时返回空字符串
+func (a Args) String(name string) string {
+	value, _ := a[name].(string)
+	return value
+}
+
+// Int 返回整数类型参数的值，未提供时返回 def
+func (a Args) Int(name string, def int) int {
+	if value, ok := a[name].(int); ok {
+		return value
+	}
+	return def
+}
+
+// Has 判断参数是否已提供
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// CommandContext 是命令处理函数的执行上下文
+type CommandContext struct {
+	Context   context.Context   // Context 是本次消息处理的上下文，命令调用 Dify 或企业微信时应使用它以便随请求一起取消
+	User      string            // User 是发送命令的用户标识
+	Group     string            // Group 是命令来源的群组标识，可能为空
+	Args      Args              // Args 是解析后的命令参数
+	Converter *MessageConverter // Converter 是消息转换器，命令可以通过它访问 Dify 服务、对话存储和企业微信机器人
+	Result    *ConvertResult    // Result 是本次消息处理的结果，命令可以更新其中的对话信息
+	AnswerID  string            // AnswerID 是命令针对的 Dify 回答的消息 ID，来自模板卡片上的评价按钮，通常为空
+
+	forwarded bool   // forwarded 表示命令是否要求将消息转发给 Dify
+	query     string // query 是转发给 Dify 的消息内容
+	app       string // app 是转发的目标 Dify 应用名称，为空时由路由规则决定
+}
+
+// Forward 要求将 query 作为用户消息转发给 Dify 处理，而不是直接回复
+// 调用后处理函数返回的回复内容将被忽略。
+func (ctx *CommandContext) Forward(query string) {
+	ctx.ForwardTo("", query)
+}
+
+// ForwardTo 要求将 query 转发给指定的 Dify 应用处理，app 为空时由路由规则决定
+func (ctx *CommandContext) ForwardTo(app, query string) {
+	ctx.forwarded = true
+	ctx.query = query
+	ctx.app = app
+}
+
+// CommandHandler 是命令的处理函数，返回值是直接回复给用户的文本
+type CommandHandler func(ctx *CommandContext) (string, error)
+
+// Command 定义一个斜杠命令
+type Command struct {
+	Name         string         // Name 是命令名称，不含前导 "/"，例如 "reset"
+	Description  string         // Description 是命令说明，显示在 /help 中
+	Args         []Arg          // Args 是命令的参数定义，按顺序解析
+	AllowedUsers []string       // AllowedUsers 是允许使用该命令的用户列表，为空时所有用户都可以使用
+	Handler      CommandHandler // Handler 是命令的处理函数
+}
+
+// Usage 返回命令的用法说明，例如 "/app [name]"
+func (cmd *Command) Usage() string {
+	var b strings.Builder
+	b.WriteString("/" + cmd.Name)
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Type == ArgText {
+			name += "..."
+		}
+		if arg.Required {
+			b.WriteString(" <" + name + ">")
+		} else {
+			b.WriteString(" [" + name + "]")
+		}
+	}
+	return b.String()
+}
+
+// Allowed 判断用户是否有权限使用该命令
+func (cmd *Command) Allowed(user string) bool {
+	return len(cmd.AllowedUsers) == 0 || contains(cmd.AllowedUsers, user)
+}
+
+// parseArgs 按参数定义解析命令名称之后的文本
+func (cmd *Command) parseArgs(text string) (Args, error) {
+	args := make(Args)
+	rest := strings.TrimSpace(text)
+	for _, arg := range cmd.Args {
+		if rest == "" {
+			if arg.Required {
+				return nil, fmt.Errorf("缺少参数 %s", arg.Name)
+			}
+			continue
+		}
+		if arg.Type == ArgText {
+			args[arg.Name] = rest
+			rest = ""
+			continue
+		}
+		word := rest
+		if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+			word, rest = rest[:i], strings.TrimSpace(rest[i:])
+		} else {
+			rest = ""
+		}
+		switch arg.Type {
+		case ArgInt:
+			n, err := strconv.Atoi(word)
+			if err != nil {
+				return nil, fmt.Errorf("参数 %s 必须是整数: %s", arg.Name, word)
+			}
+			args[arg.Name] = n
+		default:
+			args[arg.Name] = word
+		}
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("多余的参数: %s", rest)
+	}
+	return args, nil
+}
+
+// CommandRegistry 保存所有已注册的斜杠命令
+type CommandRegistry struct {
+	mu       sync.RWMutex        // mu 保护 commands 的并发访问
+	commands map[string]*Command // commands 是命令名称 (小写) 到命令定义的映射
+}
+
+// NewCommandRegistry 创建并返回一个空的 CommandRegistry 实例
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{commands: make(map[string]*Command)}
+}
+
+// Register 注册一个命令，同名命令会被覆盖
+// 命令名称不区分大小写；参数定义中必填参数必须位于可选参数之前，文本参数只能是最后一个。
+func (r *CommandRegistry) Register(cmd *Command) error {
+	name := strings.ToLower(strings.TrimPrefix(cmd.Name, "/"))
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid command name: %q", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command /%s has no handler", name)
+	}
+	optional := false
+	for i, arg := range cmd.Args {
+		if arg.Type == ArgText && i != len(cmd.Args)-1 {
+			return fmt.Errorf("command /%s: text argument %s must be the last argument", name, arg.Name)
+		}
+		if arg.Required && optional {
+			return fmt.Errorf("command /%s: required argument %s follows an optional argument", name, arg.Name)
+		}
+		optional = optional || !arg.Required
+	}
+	cmd.Name = name
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[name]; exists {
+		slog.Warn("[Command] 命令已存在，将被覆盖", "command", name)
+	}
+	r.commands[name] = cmd
+	return nil
+}
+
+// Lookup 根据名称查找命令
+func (r *CommandRegistry) Lookup(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[strings.ToLower(name)]
+	return cmd, ok
+}
+
+// Commands 返回所有已注册的命令，按名称排序
+func (r *CommandRegistry) Commands() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	commands := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// parseCommandLine 将以 "/" 开头的消息拆分为命令名称和参数文本
+// 消息不是命令格式时 ok 为 false。
+func parseCommandLine(message string) (name, rest string, ok bool) {
+	message = strings.TrimSpace(message)
+	if len(message) < 2 || message[0] != '/' {
+		return "", "", false
+	}
+	name = message[1:]
+	if i := strings.IndexFunc(name, unicode.IsSpace); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+	return strings.ToLower(name), rest, true
+}
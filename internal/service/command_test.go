@@ -0,0 +1,141 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	cmd := &Command{Name: "remind", Args: []Arg{
+		{Name: "who", Type: ArgString, Required: true},
+		{Name: "minutes", Type: ArgInt},
+		{Name: "note", Type: ArgText},
+	}}
+	tests := []struct {
+		text    string
+		want    Args
+		wantErr string
+	}{
+		{text: "alice", want: Args{"who": "alice"}},
+		{text: "  alice\t15  ", want: Args{"who": "alice", "minutes": 15}},
+		{text: "alice -5 记得 提交  周报 ", want: Args{"who": "alice", "minutes": -5, "note": "记得 提交  周报"}},
+		{text: "", wantErr: "缺少参数 who"},
+		{text: "alice soon", wantErr: "参数 minutes 必须是整数: soon"},
+	}
+	for _, tt := range tests {
+		got, err := cmd.parseArgs(tt.text)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseArgs(%q) error = %v, want %q", tt.text, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseArgs(%q) = %v, %v; want %v", tt.text, got, err, tt.want)
+		}
+	}
+
+	// 没有文本参数时多余的单词是错误
+	short := &Command{Name: "app", Args: []Arg{{Name: "name", Type: ArgString}}}
+	if _, err := short.parseArgs("hr extra"); err == nil || err.Error() != "多余的参数: extra" {
+		t.Errorf("parseArgs with extra words error = %v, want the extra words reported", err)
+	}
+	if got, err := short.parseArgs(""); err != nil || len(got) != 0 || got.Has("name") || got.String("name") != "" || got.Int("name", 7) != 7 {
+		t.Errorf("parseArgs(\"\") = %v, %v; want no arguments", got, err)
+	}
+}
+
+func TestCommandRegistryRegister(t *testing.T) {
+	handler := func(ctx *CommandContext) (string, error) { return "", nil }
+	tests := []struct {
+		name    string
+		cmd     Command
+		wantErr string
+	}{
+		{name: "valid", cmd: Command{Name: "/Remind", Args: []Arg{{Name: "who", Required: true}, {Name: "note", Type: ArgText}}, Handler: handler}},
+		{name: "empty name", cmd: Command{Name: "/", Handler: handler}, wantErr: "invalid command name"},
+		{name: "space in name", cmd: Command{Name: "re mind", Handler: handler}, wantErr: "invalid command name"},
+		{name: "no handler", cmd: Command{Name: "remind"}, wantErr: "has no handler"},
+		{name: "text not last", cmd: Command{Name: "remind", Args: []Arg{{Name: "note", Type: ArgText}, {Name: "who"}}, Handler: handler},
+			wantErr: "text argument note must be the last argument"},
+		{name: "required after optional", cmd: Command{Name: "remind", Args: []Arg{{Name: "who"}, {Name: "minutes", Type: ArgInt, Required: true}}, Handler: handler},
+			wantErr: "required argument minutes follows an optional argument"},
+	}
+	for _, tt := range tests {
+		r := NewCommandRegistry()
+		cmd := tt.cmd
+		err := r.Register(&cmd)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Register error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Register: %v", tt.name, err)
+			continue
+		}
+		if got, ok := r.Lookup("REMIND"); !ok || got.Name != "remind" || got.Usage() != "/remind <who> [note...]" {
+			t.Errorf("%s: Lookup = %+v, %v; want the command registered as /remind", tt.name, got, ok)
+		}
+	}
+}
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		message   string
+		name      string
+		rest      string
+		isCommand bool
+	}{
+		{"/help", "help", "", true},
+		{"  /App  hr ", "app", "  hr", true},
+		{"/bad\n答非所问", "bad", "\n答非所问", true},
+		{"/", "", "", false},
+		{"help", "", "", false},
+		{"请问 /help 怎么用", "", "", false},
+	}
+	for _, tt := range tests {
+		name, rest, ok := parseCommandLine(tt.message)
+		if name != tt.name || rest != tt.rest || ok != tt.isCommand {
+			t.Errorf("parseCommandLine(%q) = %q, %q, %v; want %q, %q, %v", tt.message, name, rest, ok, tt.name, tt.rest, tt.isCommand)
+		}
+	}
+}
+
+func TestConverterChecksCommandArgsAndUsers(t *testing.T) {
+	c, _ := newTestConverter(t, nil) // 命令不调用 Dify
+	var got Args
+	err := c.Commands().Register(&Command{
+		Name:         "remind",
+		Args:         []Arg{{Name: "who", Required: true}, {Name: "minutes", Type: ArgInt}},
+		AllowedUsers: []string{"admin"},
+		Handler: func(ctx *CommandContext) (string, error) {
+			got = ctx.Args
+			return "好的", nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	tests := []struct {
+		user    string
+		message string
+		reply   string
+	}{
+		{"tester", "/remind alice 5", "您没有权限使用命令 /remind。"},
+		{"admin", "/remind", "缺少参数 who\n用法: /remind <who> [minutes]"},
+		{"admin", "/remind alice later", "参数 minutes 必须是整数: later\n用法: /remind <who> [minutes]"},
+		{"admin", "/REMIND alice 5", "好的"},
+	}
+	for _, tt := range tests {
+		result, err := c.ConvertAndSend(ConvertRequest{Message: tt.message, User: tt.user})
+		if err != nil || result.Answer != tt.reply {
+			t.Errorf("%s %q: reply = %q, %v; want %q", tt.user, tt.message, result.Answer, err, tt.reply)
+		}
+	}
+	if want := (Args{"who": "alice", "minutes": 5}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("handler args = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"  // 导入 context 包，用于向 Dify 传递请求的取消和截止时间
+	"errors"   // 导入 errors 包，用于识别 Dify 返回的 404
+	"fmt"      // 导入 fmt 包，用于格式化错误信息和命令回复
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"net/http" // 导入 net/http 包，用于识别 404 状态码
+
+	"dify2wxbot/internal/store" // 导入 internal/store 包，用于标记对话的重置原因
+)
+
+// ErrNotChatApp 表示对话管理操作指定的 Dify 应用不是聊天型应用，只有聊天型应用有对话
+var ErrNotChatApp = errors.New("dify app is not a chat app")
+
+// chatApp 返回对话管理操作使用的 Dify 应用：name 为空时使用用户在 group 中当前的应用，与按群组路由的消息一致
+// 应用不存在时返回 ErrUnknownApp，不是聊天型应用时返回 ErrNotChatApp。
+func (c *MessageConverter) chatApp(name, user, group string) (*DifyService, error) {
+	svc := c.currentApp(user, group)
+	if name != "" {
+		var ok bool
+		if svc, ok = c.apps[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownApp, name)
+		}
+	}
+	if svc.app.BotType != "chat" {
+		return nil, fmt.Errorf("%w: %s", ErrNotChatApp, svc.Name())
+	}
+	return svc, nil
+}
+
+// ListConversations 从 Dify 获取用户在应用中的会话列表
+// app: Dify 应用名称，为空时使用用户当前的应用
+// user: 用户标识，与消息处理时使用的用户标识相同 (群聊中为 "群聊 ID:userid")
+// lastID: 上一页最后一个会话的 ID，为空时返回第一页
+// limit: 最多返回的会话数，0 表示使用 Dify 的默认值
+func (c *MessageConverter) ListConversations(ctx context.Context, app, user, lastID string, limit int) (DifyConversationsResponse, error) {
+	svc, err := c.chatApp(app, user, "")
+	if err != nil {
+		return DifyConversationsResponse{}, err
+	}
+	return svc.ListConversationsContext(ctx, user, lastID, limit)
+}
+
+// ConversationMessages 从 Dify 获取会话中的消息，firstID 为上一页最早一条消息的 ID，为空时返回最近的消息
+func (c *MessageConverter) ConversationMessages(ctx context.Context, app, user, conversationID, firstID string, limit int) (DifyMessagesResponse, error) {
+	svc, err := c.chatApp(app, user, "")
+	if err != nil {
+		return DifyMessagesResponse{}, err
+	}
+	return svc.GetMessagesContext(ctx, user, conversationID, firstID, limit)
+}
+
+// RenameConversation 在 Dify 中重命名会话，name 为空时由 Dify 自动生成名称
+func (c *MessageConverter) RenameConversation(ctx context.Context, app, user, conversationID, name string) (DifyConversation, error) {
+	svc, err := c.chatApp(app, user, "")
+	if err != nil {
+		return DifyConversation{}, err
+	}
+	return svc.RenameConversationContext(ctx, conversationID, user, name)
+}
+
+// DeleteConversation 在 Dify 中删除会话，并在本地存储中的当前对话正是该会话时一并清除
+// Dify 中已不存在的会话视为删除成功，以便清理本地存储中过期的记录。
+func (c *MessageConverter) DeleteConversation(ctx context.Context, app, user, conversationID string) error {
+	svc, err := c.chatApp(app, user, "")
+	if err != nil {
+		return err
+	}
+	err = svc.DeleteConversationContext(ctx, conversationID, user)
+	var apiErr *DifyAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		slog.InfoContext(ctx, "[Converter] 会话在 Dify 中已不存在", "app", svc.Name(), "user", user, "conversation_id", conversationID)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	key := conversationKey(svc.Name(), user)
+	if current, ok := c.conversationStore.GetConversationID(key); ok && current == conversationID {
+		c.conversationStore.DeleteConversationID(key)
+		slog.InfoContext(ctx, "[Converter] 已清除本地存储中的对话", "app", svc.Name(), "user", user, "conversation_id", conversationID)
+	}
+	return nil
+}
+
+// currentConversation 返回用户在命令来源群组的当前应用中进行中的对话 ID，没有时返回的回复说明原因
+func (c *MessageConverter) currentConversation(ctx *CommandContext) (*DifyService, string, string) {
+	svc, err := c.chatApp("", ctx.User, ctx.Group)
+	if err != nil {
+		return nil, "", fmt.Sprintf("Dify 应用 %s 不是聊天型应用，没有对话。", c.currentApp(ctx.User, ctx.Group).Name())
+	}
+	conversationID, ok := c.conversationStore.GetConversationID(conversationKey(svc.Name(), ctx.User))
+	if !ok {
+		return nil, "", fmt.Sprintf("在 Dify 应用 %s 中没有进行中的对话。", svc.Name())
+	}
+	return svc, conversationID, ""
+}
+
+// renameCommand 处理 /rename 命令，不带名称时由 Dify 根据对话内容自动生成名称
+func (c *MessageConverter) renameCommand(ctx *CommandContext) (string, error) {
+	svc, conversationID, reply := c.currentConversation(ctx)
+	if svc == nil {
+		return reply, nil
+	}
+	conversation, err := c.RenameConversation(ctx.Context, svc.Name(), ctx.User, conversationID, ctx.Args.String("name"))
+	if err != nil {
+		return "", fmt.Errorf("failed to rename conversation: %w", err)
+	}
+	return fmt.Sprintf("对话已重命名为: %s", conversation.Name), nil
+}
+
+// forgetCommand 处理 /forget 命令，在 Dify 中删除当前对话及其消息记录，并开启新的对话
+func (c *MessageConverter) forgetCommand(ctx *CommandContext) (string, error) {
+	svc, conversationID, reply := c.currentConversation(ctx)
+	if svc == nil {
+		return reply, nil
+	}
+	if err := c.DeleteConversation(ctx.Context, svc.Name(), ctx.User, conversationID); err != nil {
+		return "", fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	ctx.Result.NewConversation = true
+	ctx.Result.ResetReason = store.ExpireReasonManual
+	return "当前对话及其记录已从 Dify 中删除，下一条消息将开启新的对话。", nil
+}
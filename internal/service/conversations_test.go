@@ -0,0 +1,140 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dify2wxbot/internal/config"
+)
+
+func TestConverterForgetsAndRenamesConversation(t *testing.T) {
+	var requests []string
+	c, rec := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/name"):
+			w.Write([]byte(`{"id": "c1", "name": "年假咨询"}`))
+		default:
+			w.Write([]byte(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1"}`))
+		}
+	})
+
+	for _, message := range []string{"年假有几天", "/rename 年假咨询", "/forget"} {
+		if _, err := c.ConvertAndSend(ConvertRequest{Message: message, User: "tester"}); err != nil {
+			t.Fatalf("%s: %v", message, err)
+		}
+	}
+	want := []string{
+		`POST /v1/conversations/c1/name {"auto_generate":false,"name":"年假咨询","user":"tester"}`,
+		`DELETE /v1/conversations/c1 {"user":"tester"}`,
+	}
+	if len(requests) != 3 || requests[1] != want[0] || requests[2] != want[1] {
+		t.Fatalf("dify requests = %q, want the chat followed by %q", requests, want)
+	}
+	if id, ok := c.conversationStore.GetConversationID(conversationKey(config.DefaultAppName, "tester")); ok {
+		t.Fatalf("local conversation %q kept after /forget", id)
+	}
+	if got := rec.Records(); len(got) != 3 || got[1].Content != "对话已重命名为: 年假咨询" {
+		t.Fatalf("recorder got %+v, want the answer and two command replies", got)
+	}
+}
+
+func TestConverterConversationCommandsFollowGroupRoute(t *testing.T) {
+	var requests []string
+	c, _ := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Authorization")+" "+r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/name"):
+			w.Write([]byte(`{"id": "c-hr", "name": "入职咨询"}`))
+		default:
+			w.Write([]byte(`{"answer": "请携带身份证。", "conversation_id": "c-hr", "message_id": "m1"}`))
+		}
+	}, func(cfg *config.AppConfig) {
+		cfg.Apps = []config.DifyConfig{
+			{Name: "default", APIKey: "app-default", BaseURL: cfg.Dify.BaseURL, BotType: "chat"},
+			{Name: "hr", APIKey: "app-hr", BaseURL: cfg.Dify.BaseURL, BotType: "chat"},
+		}
+		cfg.Routes = []config.RouteConfig{{App: "hr", Groups: []string{"hr-group"}}}
+	})
+
+	for _, message := range []string{"入职要准备什么", "/rename 入职咨询", "/forget"} {
+		if _, err := c.ConvertAndSend(ConvertRequest{Message: message, User: "tester", Group: "hr-group"}); err != nil {
+			t.Fatalf("%s: %v", message, err)
+		}
+	}
+	want := []string{
+		"Bearer app-hr POST /v1/chat-messages",
+		"Bearer app-hr POST /v1/conversations/c-hr/name",
+		"Bearer app-hr DELETE /v1/conversations/c-hr",
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("dify requests = %q, want all of them sent to the group's app %q", requests, want)
+	}
+	if id, ok := c.conversationStore.GetConversationID(conversationKey("hr", "tester")); ok {
+		t.Fatalf("local conversation %q kept after /forget", id)
+	}
+}
+
+func TestConverterConversationCommandErrors(t *testing.T) {
+	var renameStatus, deleteStatus int
+	c, rec := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			difyError(deleteStatus, "conversation_error")(w)
+		case strings.HasSuffix(r.URL.Path, "/name"):
+			difyError(renameStatus, "invalid_param")(w)
+		default:
+			w.Write([]byte(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1"}`))
+		}
+	})
+	send := func(message string) error {
+		_, err := c.ConvertAndSend(ConvertRequest{Message: message, User: "tester"})
+		return err
+	}
+	key := conversationKey(config.DefaultAppName, "tester")
+
+	// 没有进行中的对话时直接回复，不调用 Dify
+	if err := send("/rename 年假"); err != nil {
+		t.Fatalf("/rename without a conversation: %v", err)
+	}
+	if got := rec.Records(); len(got) != 1 || got[0].Content != "在 Dify 应用 default 中没有进行中的对话。" {
+		t.Fatalf("recorder got %+v, want the no-conversation reply", got)
+	}
+
+	if err := send("年假有几天"); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	renameStatus = http.StatusBadRequest
+	if err := send("/rename 年假"); err == nil || !strings.Contains(err.Error(), "failed to rename conversation") {
+		t.Fatalf("/rename error = %v, want the Dify error", err)
+	}
+
+	// Dify 中已不存在的会话视为删除成功，同时清除本地记录
+	deleteStatus = http.StatusNotFound
+	if err := send("/forget"); err != nil {
+		t.Fatalf("/forget of a conversation missing in Dify: %v", err)
+	}
+	if _, ok := c.conversationStore.GetConversationID(key); ok {
+		t.Fatal("local conversation kept after /forget")
+	}
+
+	// 其他删除错误返回给调用方，本地记录保留，用户可以重试
+	if err := send("年假有几天"); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	deleteStatus = http.StatusBadRequest
+	if err := send("/forget"); err == nil || !strings.Contains(err.Error(), "failed to delete conversation") {
+		t.Fatalf("/forget error = %v, want the Dify error", err)
+	}
+	if id, ok := c.conversationStore.GetConversationID(key); !ok || id != "c1" {
+		t.Fatalf("local conversation = %q, %v after a failed /forget, want c1 kept", id, ok)
+	}
+}
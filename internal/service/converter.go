@@ -1,62 +1,321 @@
 package service
 
 import (
+	"context"                    // 导入 context 包，用于取消消息处理并为 Dify 调用设置截止时间
 	"dify2wxbot/internal/config" // 导入 config 包，用于获取应用程序配置，例如 Dify API 的 BotType 和 DefaultPrompt
-	"dify2wxbot/pkg/wecom"       // 导入 pkg/wecom 包，用于与企业微信机器人交互，发送消息
+	"dify2wxbot/internal/store"  // 导入 internal/store 包，用于管理用户与 Dify 之间的对话 ID
+	"dify2wxbot/pkg/sender"      // 导入 pkg/sender 包，用于通过各个投递目标的发送方发送回复
 	"encoding/json"              // 导入 encoding/json 包，用于 JSON 数据的编解码，例如处理工作流响应
 	"fmt"                        // 导入 fmt 包，用于格式化字符串和错误信息
-	"log"                        // 导入 log 包，用于日志输出
+	"log/slog"                   // 导入 log/slog 包，用于结构化日志输出
 	"os"                         // 导入 os 包，用于文件操作，例如创建临时文件和删除文件
 	"path/filepath"              // 导入 path/filepath 包，用于处理文件路径，例如获取文件扩展名
 	"strings"                    // 导入 strings 包，用于字符串操作，例如将文件扩展名转换为小写
+	"sync"                       // 导入 sync 包，用于保护用户应用选择的并发访问
+	"time"                       // 导入 time 包，用于判断对话是否已超过空闲时间
+)
+
+const (
+	defaultChatTimeout       = 120 * time.Second // chat 类型应用的默认请求截止时间
+	defaultCompletionTimeout = 120 * time.Second // completion 类型应用的默认请求截止时间
+	defaultWorkflowTimeout   = 300 * time.Second // workflow 类型应用的默认请求截止时间
 )
 
 // MessageConverter 结构体定义了消息转换和发送的服务
 // 它负责将接收到的消息（可能包含文件）发送到 Dify AI 服务进行处理，
 // 然后将 Dify 的回复转换并发送到企业微信机器人。
 type MessageConverter struct {
-	robot       *wecom.Robot // robot 是一个企业微信机器人实例，用于发送消息到企业微信群
-	difyService *DifyService // difyService 是一个 DifyService 实例，用于与 Dify API 交互
+	robots            map[string]sender.Sender // robots 是投递目标名称到发送方 (群机器人或自建应用) 的映射，每个投递目标对应一个实例
+	robotOrder        []string                 // robotOrder 是按配置顺序排列的机器人名称
+	defaultTargets    []string                 // defaultTargets 是请求未指定投递目标时使用的机器人名称
+	apps              map[string]*DifyService  // apps 是应用名称到 DifyService 实例的映射，每个 Dify 应用对应一个实例
+	appOrder          []string                 // appOrder 是按配置顺序排列的应用名称
+	router            *Router                  // router 根据路由规则为每条消息选择 Dify 应用
+	conversationStore store.ConversationStore  // conversationStore 用于管理用户与 Dify 之间的对话 ID，以维持上下文
+	policy            store.Policy             // policy 是对话过期策略，决定何时为用户开启新的对话
+	commands          *CommandRegistry         // commands 是斜杠命令注册表，包含内置命令和配置中定义的命令
+	timeouts          config.TimeoutConfig     // timeouts 是按 Dify 应用类型区分的请求截止时间
+	answers           *answerTracker           // answers 记录最近发送的回答的消息 ID，用于 /good、/bad 命令和评价按钮
+
+	mu       sync.Mutex        // mu 保护 userApps 的并发访问
+	userApps map[string]string // userApps 记录用户通过 /app 命令选择的 Dify 应用
+}
+
+// ConvertRequest 描述一条待处理的消息
+type ConvertRequest struct {
+	Message        string                 // Message 是原始消息内容，可以是用户输入或定时任务的默认消息
+	User           string                 // User 是用户标识，用于 Dify API 请求和对话上下文管理
+	ConversationID string                 // ConversationID 是请求中明确指定的对话 ID，为空时使用存储中的对话 ID
+	FilePath       string                 // FilePath 是上传文件的本地路径 (如果存在)，用于文件上传到 Dify
+	FileName       string                 // FileName 是上传文件的原始文件名，上传到 Dify 时使用，为空时使用 FilePath 中的文件名
+	App            string                 // App 是请求中明确指定的 Dify 应用名称，为空时由路由规则决定
+	Group          string                 // Group 是消息来源的群组标识，用于按群组路由
+	Targets        []string               // Targets 是回复的投递目标 (机器人名称) 列表，为空时使用默认投递目标
+	Inputs         map[string]interface{} // Inputs 是传给 Dify 应用的变量，例如工作流的输入参数
+	Mentions       []string               // Mentions 是回复中需要 @ 的成员 ID，只在支持 @成员 的投递目标的第一条回复中生效
+	AnswerID       string                 // AnswerID 是评价按钮对应的 Dify 回答的消息 ID，/good 和 /bad 命令据此评价指定的回答，为空时评价用户最近的一条回答
+	ReplyTo        sender.Sender          // ReplyTo 是直接回复消息来源会话的发送方 (例如企业微信回调中的 response_url)，非空时替代 Targets 作为唯一的投递目标
+}
+
+// ConvertResult 描述一次消息处理的结果
+type ConvertResult struct {
+	App             string     // App 是处理本条消息的 Dify 应用名称，命令直接回复时为空
+	ConversationID  string     // ConversationID 是本次问答所在的 Dify 对话 ID，非 chat 类型应用为空
+	NewConversation bool       // NewConversation 表示本次问答是否开启了新的对话上下文
+	ResetReason     string     // ResetReason 是旧对话被丢弃的原因，例如 "idle"、"max_turns" 或 "manual"
+	Deliveries      []Delivery // Deliveries 是回复在每个投递目标上的发送结果，没有发送任何消息时为空
+	Answer          string     // Answer 是发送给用户的完整回复内容 (Dify 的回答或命令的回复)
 }
 
 // NewMessageConverter 创建并返回一个新的 MessageConverter 实例
-// cfg: 应用程序配置，用于初始化企业微信机器人
-// difyService: Dify 服务实例，用于与 Dify AI 交互
-func NewMessageConverter(cfg *config.AppConfig, difyService *DifyService) *MessageConverter {
-	return &MessageConverter{
-		robot:       wecom.NewRobot(cfg), // 使用配置创建并初始化企业微信机器人实例
-		difyService: difyService,         // 初始化 Dify 服务实例
+// cfg: 应用程序配置，用于初始化各个企业微信机器人、各个 Dify 应用、路由规则和对话过期策略
+// conversationStore: 对话存储实例，负责对话 ID 的管理
+// 创建时会注册内置命令和配置中定义的自定义命令。
+func NewMessageConverter(cfg *config.AppConfig, conversationStore store.ConversationStore) *MessageConverter {
+	c := &MessageConverter{
+		robots:            make(map[string]sender.Sender), // 初始化企业微信机器人映射
+		apps:              make(map[string]*DifyService),  // 初始化 Dify 应用映射
+		conversationStore: conversationStore,              // 初始化对话存储实例
+		policy:            store.NewPolicy(cfg.Store),     // 根据配置初始化对话过期策略
+		commands:          NewCommandRegistry(),           // 初始化斜杠命令注册表
+		userApps:          make(map[string]string),        // 初始化用户应用选择
+		timeouts:          cfg.Timeouts,                   // 初始化请求截止时间配置
+		answers:           newAnswerTracker(),             // 初始化回答记录
+	}
+	// 为每个 Dify 应用创建独立的 DifyService 实例
+	for _, app := range cfg.DifyApps() {
+		c.apps[app.Name] = NewDifyService(app, cfg.Retry)
+		c.appOrder = append(c.appOrder, app.Name)
+		slog.Info("[Converter] 已加载 Dify 应用", "app", app.Name, "bot_type", app.BotType)
+	}
+	c.router = NewRouter(c.appOrder, cfg.DefaultApp, cfg.Routes)
+	// 为每个投递目标创建独立的发送方实例 (企业微信、飞书、钉钉或 Slack)，各自拥有独立的发送队列和频率配额
+	for _, robotCfg := range cfg.WeComRobots() {
+		c.robots[robotCfg.Name] = newSender(robotCfg)
+		c.robotOrder = append(c.robotOrder, robotCfg.Name)
+		slog.Info("[Converter] 已加载企业微信机器人", "robot", robotCfg.Name, "type", robotCfg.Type)
+	}
+	c.defaultTargets = cfg.DefaultTargets
+	if len(c.defaultTargets) == 0 {
+		c.defaultTargets = c.robotOrder[:1]
+	}
+	c.registerBuiltinCommands()
+	c.registerConfigCommands(cfg.Commands)
+	return c
+}
+
+// RegisterSender 注册一个投递目标，已存在同名目标时替换原有的发送方
+// 可用于接入配置以外的渠道，或在测试中使用 sender.Recorder 代替真实的企业微信机器人；需要在开始处理消息之前调用。
+func (c *MessageConverter) RegisterSender(s sender.Sender) {
+	if _, ok := c.robots[s.Name()]; !ok {
+		c.robotOrder = append(c.robotOrder, s.Name())
+	}
+	c.robots[s.Name()] = s
+}
+
+// Commands 返回斜杠命令注册表，可用于在代码中注册新的命令
+func (c *MessageConverter) Commands() *CommandRegistry {
+	return c.commands
+}
+
+// appNames 返回所有可用的 Dify 应用名称，按配置顺序排列
+func (c *MessageConverter) appNames() []string {
+	return c.appOrder
+}
+
+// hasApp 判断指定名称的 Dify 应用是否存在
+func (c *MessageConverter) hasApp(name string) bool {
+	_, ok := c.apps[name]
+	return ok
+}
+
+// selectedApp 返回用户通过 /app 命令选择的 Dify 应用，未选择时返回空字符串
+func (c *MessageConverter) selectedApp(user string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userApps[user]
+}
+
+// currentApp 返回用户在不带特定消息内容时会使用的 Dify 应用，用于 /status 和 /history 等命令
+func (c *MessageConverter) currentApp(user, group string) *DifyService {
+	route, _ := c.router.Route("", user, group, "", c.selectedApp(user))
+	return c.apps[route.App]
+}
+
+// newDelivery 根据请求创建本次处理使用的 delivery
+// 请求指定了 ReplyTo 时只发送给它；否则按投递目标名称查找发送方，名称为空时使用默认投递目标。
+// 重复的名称只发送一次；存在未知的名称时返回 ErrUnknownTarget。
+func (c *MessageConverter) newDelivery(ctx context.Context, req ConvertRequest) (*delivery, error) {
+	if req.ReplyTo != nil {
+		return newDelivery(ctx, []string{req.ReplyTo.Name()}, []sender.Sender{req.ReplyTo}), nil
+	}
+	targets := req.Targets
+	if len(targets) == 0 {
+		targets = c.defaultTargets
+	}
+	var names []string
+	var robots []sender.Sender
+	for _, name := range targets {
+		robot, ok := c.robots[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTarget, name)
+		}
+		if contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+		robots = append(robots, robot)
 	}
+	return newDelivery(ctx, names, robots), nil
 }
 
-// preprocessMessage 对用户消息进行预处理，例如识别特定命令
-// message: 原始用户消息
-// 返回值：处理后的消息，是否已处理（如果为 true，则不再调用 Dify），错误
-func (c *MessageConverter) preprocessMessage(message string) (string, bool, error) {
-	// 示例：如果消息以 "/image" 开头，可以尝试生成图片或执行特定逻辑
-	if strings.HasPrefix(message, "/image ") {
-		// 这里可以添加调用图片生成 AI 的逻辑
-		// 为了演示，我们简单地返回一个提示
-		log.Printf("[Converter] 识别到图片生成命令: '%s'", message)
-		// 假设我们直接回复用户，不调用 Dify
-		return "抱歉，图片生成功能暂未实现。", true, nil
-	}
-	// 其他预处理逻辑...
-
-	return message, false, nil // 默认情况下，不处理消息，继续调用 Dify
+// Validate 在处理消息之前检查请求中指定的 Dify 应用和投递目标是否存在
+// 异步处理时用于在入队前发现请求参数错误，返回的错误可以用 errors.Is 识别为 ErrUnknownApp 或 ErrUnknownTarget。
+func (c *MessageConverter) Validate(req ConvertRequest) error {
+	if req.App != "" && !c.hasApp(req.App) {
+		return fmt.Errorf("%w: %s", ErrUnknownApp, req.App)
+	}
+	_, err := c.newDelivery(context.Background(), req)
+	return err
+}
+
+// CheckApps 并发检查每个 Dify 应用是否可以访问，返回应用名称到检查结果的映射，可以访问时结果为 nil
+// ctx: 检查的上下文，调用方应设置较短的截止时间
+func (c *MessageConverter) CheckApps(ctx context.Context) map[string]error {
+	results := make(map[string]error, len(c.appOrder))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range c.appOrder {
+		wg.Add(1)
+		go func(name string, svc *DifyService) {
+			defer wg.Done()
+			err := svc.Ping(ctx)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, c.apps[name])
+	}
+	wg.Wait()
+	return results
+}
+
+// difyTimeout 返回指定类型的 Dify 应用的请求截止时间，未配置时使用默认值
+func (c *MessageConverter) difyTimeout(botType string) time.Duration {
+	seconds, def := c.timeouts.ChatSeconds, defaultChatTimeout
+	switch botType {
+	case "completion":
+		seconds, def = c.timeouts.CompletionSeconds, defaultCompletionTimeout
+	case "workflow":
+		seconds, def = c.timeouts.WorkflowSeconds, defaultWorkflowTimeout
+	}
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// conversationKey 返回用户在指定 Dify 应用中的对话存储键，使不同应用的对话上下文互不干扰
+func conversationKey(app, user string) string {
+	return app + ":" + user
+}
+
+// selectApp 记录用户选择的 Dify 应用
+func (c *MessageConverter) selectApp(ctx context.Context, user, app string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.userApps[user] = app
+	slog.InfoContext(ctx, "[Converter] 用户切换 Dify 应用", "user", user, "app", app)
+}
+
+// ResetConversation 丢弃用户在所有 Dify 应用中的对话，下一条消息将开启新的对话上下文
+// user: 用户标识
+func (c *MessageConverter) ResetConversation(user string) {
+	for _, app := range c.appOrder {
+		c.conversationStore.DeleteConversationID(conversationKey(app, user))
+	}
+	slog.Info("[Converter] 用户的对话已重置", "user", user)
+}
+
+// resolveConversation 确定本次请求使用的对话 ID
+// 请求中明确提供的对话 ID 优先，并保存到存储中；否则使用存储中未过期的对话 ID。
+// 存储中的对话已过期时将其删除并返回过期原因，返回空对话 ID 表示由 Dify 创建新对话。
+func (c *MessageConverter) resolveConversation(ctx context.Context, app, user, conversationID string) (string, string) {
+	key := conversationKey(app, user)
+	if conversationID != "" {
+		c.conversationStore.SaveConversationID(key, conversationID)
+		slog.InfoContext(ctx, "[Converter] 请求中提供了对话ID，使用并更新存储", "conversation_id", conversationID)
+		return conversationID, store.ExpireReasonNone
+	}
+	conversation, ok := c.conversationStore.GetConversation(key)
+	if !ok {
+		slog.InfoContext(ctx, "[Converter] 未找到用户的对话ID，将由 Dify 创建新会话", "user", user, "app", app)
+		return "", store.ExpireReasonNone
+	}
+	if expired, reason := c.policy.Expired(conversation, time.Now()); expired {
+		c.conversationStore.DeleteConversationID(key)
+		slog.InfoContext(ctx, "[Converter] 用户的对话已过期，将开启新的对话", "user", user, "conversation_id", conversation.ID,
+			"reason", reason, "turns", conversation.Turns, "last_active", conversation.LastActive.Format(time.RFC3339))
+		return "", reason
+	}
+	slog.InfoContext(ctx, "[Converter] 从存储中获取到用户的对话ID", "user", user, "conversation_id", conversation.ID, "turns", conversation.Turns)
+	return conversation.ID, store.ExpireReasonNone
+}
+
+// preprocessMessage 对用户消息进行预处理，识别并执行斜杠命令
+// 未注册的 "/xxx" 消息按普通消息处理，交给 Dify。
+// req: 待处理的消息，命令要求转发到指定应用时会更新其中的 App
+// result: 本次消息处理的结果，命令可以更新其中的对话信息
+// 返回值：处理后的消息 (命令的回复或要转发给 Dify 的内容)，是否已处理（如果为 true，则不再调用 Dify），错误
+func (c *MessageConverter) preprocessMessage(parent context.Context, req *ConvertRequest, result *ConvertResult) (string, bool, error) {
+	message, user := req.Message, req.User
+	name, rest, ok := parseCommandLine(message)
+	if !ok {
+		return message, false, nil // 不是命令，继续调用 Dify
+	}
+	cmd, ok := c.commands.Lookup(name)
+	if !ok {
+		return message, false, nil // 未注册的命令按普通消息处理
+	}
+	slog.InfoContext(parent, "[Converter] 识别到命令", "command", cmd.Name, "user", user)
+
+	if !cmd.Allowed(user) {
+		slog.WarnContext(parent, "[Converter] 用户没有权限使用命令", "command", cmd.Name, "user", user)
+		return fmt.Sprintf("您没有权限使用命令 /%s。", cmd.Name), true, nil
+	}
+	args, err := cmd.parseArgs(rest)
+	if err != nil {
+		return fmt.Sprintf("%v\n用法: %s", err, cmd.Usage()), true, nil
+	}
+
+	ctx := &CommandContext{Context: parent, User: user, Group: req.Group, Args: args, Converter: c, Result: result, AnswerID: req.AnswerID}
+	reply, err := cmd.Handler(ctx)
+	if err != nil {
+		return "", true, fmt.Errorf("command /%s failed: %w", cmd.Name, err)
+	}
+	if ctx.forwarded {
+		slog.InfoContext(parent, "[Converter] 命令将消息转发给 Dify", "command", cmd.Name, "app", ctx.app)
+		slog.DebugContext(parent, "[Converter] 转发给 Dify 的消息内容", "query", ctx.query)
+		if ctx.app != "" {
+			req.App = ctx.app
+		}
+		return ctx.query, false, nil
+	}
+	return reply, true, nil
 }
 
 // postprocessDifyResponse 对 Dify 的响应进行后处理，根据内容发送不同类型的企业微信消息
+// d: 本次处理的投递目标
+// svc: 产生该响应的 Dify 应用，用于下载响应中的文件，下载使用 d 的上下文
 // difyResponse: Dify API 的原始响应字符串
-func (c *MessageConverter) postprocessDifyResponse(difyResponse string) error {
-	log.Printf("[Converter] 开始后处理 Dify 响应，长度: %d", len(difyResponse))
+func (c *MessageConverter) postprocessDifyResponse(d *delivery, svc *DifyService, difyResponse string) error {
+	slog.DebugContext(d.ctx, "[Converter] 开始后处理 Dify 响应", "length", len(difyResponse))
 
 	// 尝试将 Dify 响应解析为 JSON，以便检查是否有结构化数据（如图片URL、文件URL）
 	var jsonResponse map[string]interface{}
 	if err := json.Unmarshal([]byte(difyResponse), &jsonResponse); err == nil {
 		// 检查是否有图片 URL
 		if imageUrl, ok := jsonResponse["image_url"].(string); ok && imageUrl != "" {
-			log.Printf("[Converter] Dify 响应包含图片 URL: %s", imageUrl)
+			slog.InfoContext(d.ctx, "[Converter] Dify 响应包含图片", "url", imageUrl)
 			// 获取图片文件扩展名
 			imageExt := filepath.Ext(imageUrl)
 			if imageExt == "" {
@@ -65,29 +324,25 @@ func (c *MessageConverter) postprocessDifyResponse(difyResponse string) error {
 			// 下载图片到本地临时文件，并保留扩展名
 			tempFile, err := os.CreateTemp("", "dify_image_*"+imageExt)
 			if err != nil {
-				log.Printf("[Converter] 创建临时图片文件失败: %v", err)
-				return c.robot.SendTextMessage(fmt.Sprintf("Dify 返回了一张图片: %s，但下载失败。", imageUrl))
+				slog.ErrorContext(d.ctx, "[Converter] 创建临时图片文件失败", "error", err)
+				return d.sendText(fmt.Sprintf("Dify 返回了一张图片: %s，但下载失败。", imageUrl))
 			}
 			tempFilePath := tempFile.Name()
 			tempFile.Close() // 关闭文件句柄，以便 DifyService.DownloadFile 可以写入
 
-			if err := c.difyService.DownloadFile(imageUrl, tempFilePath); err != nil {
+			if err := svc.DownloadFileContext(d.ctx, imageUrl, tempFilePath); err != nil {
 				os.Remove(tempFilePath) // 下载失败，删除临时文件
-				log.Printf("[Converter] 下载 Dify 图片失败: %v", err)
-				return c.robot.SendTextMessage(fmt.Sprintf("Dify 返回了一张图片: %s，但下载失败。", imageUrl))
+				slog.ErrorContext(d.ctx, "[Converter] 下载 Dify 图片失败", "error", err)
+				return d.sendText(fmt.Sprintf("Dify 返回了一张图片: %s，但下载失败。", imageUrl))
 			}
 			defer os.Remove(tempFilePath) // 确保函数退出时删除临时文件
 
-			// 发送图片消息到企业微信
-			if err := c.robot.SendImageMessage(tempFilePath); err != nil {
-				log.Printf("[Converter] 发送图片消息到企业微信失败: %v", err)
-				return c.robot.SendTextMessage(fmt.Sprintf("Dify 返回了一张图片: %s，但发送失败。", imageUrl))
-			}
-			return nil // 图片消息已发送，不再发送文本
+			// 发送图片消息，某个目标发送失败或不支持图片时改为向该目标发送文本
+			return d.sendImage(tempFilePath, imageUrl)
 		}
 		// 检查是否有文件 URL
 		if fileUrl, ok := jsonResponse["file_url"].(string); ok && fileUrl != "" {
-			log.Printf("[Converter] Dify 响应包含文件 URL: %s", fileUrl)
+			slog.InfoContext(d.ctx, "[Converter] Dify 响应包含文件", "url", fileUrl)
 			// 获取文件扩展名
 			fileExt := filepath.Ext(fileUrl)
 			if fileExt == "" {
@@ -96,101 +351,128 @@ func (c *MessageConverter) postprocessDifyResponse(difyResponse string) error {
 			// 下载文件到本地临时文件，并保留扩展名
 			tempFile, err := os.CreateTemp("", "dify_file_*"+fileExt)
 			if err != nil {
-				log.Printf("[Converter] 创建临时文件失败: %v", err)
-				return c.robot.SendTextMessage(fmt.Sprintf("Dify 返回了一个文件: %s，但下载失败。", fileUrl))
+				slog.ErrorContext(d.ctx, "[Converter] 创建临时文件失败", "error", err)
+				return d.sendText(fmt.Sprintf("Dify 返回了一个文件: %s，但下载失败。", fileUrl))
 			}
 			tempFilePath := tempFile.Name()
 			tempFile.Close() // 关闭文件句柄，以便 DifyService.DownloadFile 可以写入
 
-			if err := c.difyService.DownloadFile(fileUrl, tempFilePath); err != nil {
+			if err := svc.DownloadFileContext(d.ctx, fileUrl, tempFilePath); err != nil {
 				os.Remove(tempFilePath) // 下载失败，删除临时文件
-				log.Printf("[Converter] 下载 Dify 文件失败: %v", err)
-				return c.robot.SendTextMessage(fmt.Sprintf("Dify 返回了一个文件: %s，但下载失败。", fileUrl))
+				slog.ErrorContext(d.ctx, "[Converter] 下载 Dify 文件失败", "error", err)
+				return d.sendText(fmt.Sprintf("Dify 返回了一个文件: %s，但下载失败。", fileUrl))
 			}
 			defer os.Remove(tempFilePath) // 确保函数退出时删除临时文件
 
-			// 发送文件消息到企业微信
-			if err := c.robot.SendFileMessage(tempFilePath); err != nil {
-				log.Printf("[Converter] 发送文件消息到企业微信失败: %v", err)
-				return c.robot.SendTextMessage(fmt.Sprintf("Dify 返回了一个文件: %s，但发送失败。", fileUrl))
-			}
-			return nil // 文件消息已发送，不再发送文本
+			// 发送文件消息，某个目标发送失败或不支持文件时改为向该目标发送文本
+			return d.sendFile(tempFilePath, fileUrl)
 		}
 		// 检查是否有 Markdown 内容
 		if markdownContent, ok := jsonResponse["markdown"].(string); ok && markdownContent != "" {
-			log.Printf("[Converter] Dify 响应包含 Markdown 内容，长度: %d", len(markdownContent))
-			return c.robot.SendMarkdownMessage(markdownContent)
-		}
-		// 如果是工作流响应，并且是 JSON 格式，可以考虑发送为 Markdown 或文本
-		if _, ok := jsonResponse["data"]; ok && c.difyService.cfg.Dify.BotType == "workflow" {
-			log.Printf("[Converter] Dify Workflow 响应为 JSON 格式，将作为文本发送。")
-			// 已经处理过截断，直接发送
-			return c.robot.SendTextMessage(difyResponse)
+			slog.InfoContext(d.ctx, "[Converter] Dify 响应包含 Markdown 内容", "length", len(markdownContent))
+			return d.sendMarkdown(markdownContent)
 		}
 	}
 
 	// 如果不是结构化响应，或者没有识别到特定类型，则作为普通文本消息发送
-	log.Printf("[Converter] Dify 响应为纯文本或无法解析，将作为文本发送。")
-
-	// 企业微信机器人文本消息最大长度为 2048 字节
-	const maxWeComMessageLength = 2048
-	if len(difyResponse) > maxWeComMessageLength {
-		log.Printf("[Converter] Dify 回复长度 (%d 字节) 超过企业微信消息限制 (%d 字节)，将进行截断。", len(difyResponse), maxWeComMessageLength)
-		// 截断消息并添加提示信息
-		truncatedResponse := []rune(difyResponse)[:maxWeComMessageLength-50] // 预留 50 字符用于提示信息
-		difyResponse = string(truncatedResponse) + "\n... (消息已截断，请查看 Dify 后台获取完整内容)"
-	}
-
-	return c.robot.SendTextMessage(difyResponse)
+	slog.DebugContext(d.ctx, "[Converter] Dify 响应为纯文本或无法解析，将作为文本发送")
+	return d.sendText(difyResponse)
 }
 
 // ConvertAndSend 方法用于转换消息并将其发送到企业微信机器人
 // 这是消息处理的核心逻辑，根据 Dify Bot 类型和是否包含文件进行不同的 API 调用。
-// message: 待发送的原始消息字符串，可以是用户输入或定时任务的默认消息
-// user: 用户标识，用于 Dify API 请求和对话上下文管理
-// conversationID: 对话 ID，用于维持用户与 Dify 之间的对话上下文
-// filePath: 上传文件的本地路径 (如果存在)，用于文件上传到 Dify
-func (c *MessageConverter) ConvertAndSend(message, user, conversationID, filePath string) error {
-	log.Printf("[Converter] 开始处理消息，用户: '%s', 对话ID: '%s', 消息: '%s', 文件路径: '%s'", user, conversationID, message, filePath)
+// 对于 chat 类型应用，会根据对话过期策略决定沿用已有对话还是开启新对话，并在问答完成后记录轮数。
+// 消息由路由规则选中的 Dify 应用处理，各应用的对话上下文相互独立；回复会扇出到请求指定的所有投递目标，
+// 每个目标的发送结果记录在返回结果的 Deliveries 中，只有所有目标都发送失败时才返回错误。
+// req: 待处理的消息，包含消息内容、用户标识、对话 ID、文件路径、用于路由的应用和群组以及投递目标
+func (c *MessageConverter) ConvertAndSend(req ConvertRequest) (*ConvertResult, error) {
+	return c.ConvertAndSendContext(context.Background(), req)
+}
+
+// ConvertAndSendContext 与 ConvertAndSend 相同，但使用 ctx 控制整个处理流程的取消
+// ctx 被取消时 (例如 Webhook 客户端断开连接或服务关闭)，进行中的 Dify 调用和企业微信发送会被中止，
+// 流式响应会通知 Dify 停止生成。调用 Dify 的阶段还受按应用类型配置的截止时间 (timeouts) 限制。
+func (c *MessageConverter) ConvertAndSendContext(ctx context.Context, req ConvertRequest) (*ConvertResult, error) {
+	user, conversationID, filePath := req.User, req.ConversationID, req.FilePath
+	slog.InfoContext(ctx, "[Converter] 开始处理消息", "user", user, "conversation_id", conversationID,
+		"message_length", len(req.Message), "file", filePath, "targets", req.Targets)
+	slog.DebugContext(ctx, "[Converter] 消息内容", "message", req.Message)
+	result := &ConvertResult{}
+
+	// 确定回复的投递目标，在调用 Dify 之前发现未知的目标
+	d, err := c.newDelivery(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	d.mentions = req.Mentions
+	defer func() { result.Deliveries = d.results() }()
 
 	// 1. 消息预处理
-	processedMessage, handled, err := c.preprocessMessage(message)
+	processedMessage, handled, err := c.preprocessMessage(ctx, &req, result)
 	if err != nil {
-		return fmt.Errorf("message preprocessing failed: %w", err)
+		return result, fmt.Errorf("message preprocessing failed: %w", err)
 	}
 	if handled {
-		log.Printf("[Converter] 消息已在预处理阶段处理，直接返回。")
+		slog.InfoContext(ctx, "[Converter] 消息已在预处理阶段处理，直接返回")
 		// 如果预处理函数已经发送了消息或处理了逻辑，则直接返回
 		// 这里的 processedMessage 可能是预处理后的回复，需要发送
 		if processedMessage != "" {
-			return c.robot.SendTextMessage(processedMessage)
+			result.Answer = processedMessage
+			return result, d.sendText(processedMessage)
 		}
-		return nil
+		return result, nil
+	}
+
+	// 根据路由规则选择处理本条消息的 Dify 应用
+	route, err := c.router.Route(processedMessage, user, req.Group, req.App, c.selectedApp(user))
+	if err != nil {
+		return result, err
 	}
-	message = processedMessage // 使用预处理后的消息
+	svc := c.apps[route.App]
+	result.App = route.App
+	message := route.Message // 使用预处理和路由后的消息
+	slog.InfoContext(ctx, "[Converter] 消息路由到 Dify 应用", "app", route.App, "reason", route.Reason)
 
 	// 如果消息为空且配置了默认提示词，则使用默认提示词
-	if message == "" && c.difyService.cfg.Dify.DefaultPrompt != "" {
-		message = c.difyService.cfg.Dify.DefaultPrompt
-		log.Printf("[Converter] 消息为空，使用默认提示词: '%s'", message)
+	if message == "" && svc.app.DefaultPrompt != "" {
+		message = svc.app.DefaultPrompt
+		slog.InfoContext(ctx, "[Converter] 消息为空，使用默认提示词", "app", route.App)
 	}
 
-	// 如果消息仍然为空（即没有传入消息也没有配置默认提示词）且没有文件路径，则返回错误
-	if message == "" && filePath == "" {
-		return fmt.Errorf("message content or file path cannot be empty")
+	// 如果消息仍然为空（即没有传入消息也没有配置默认提示词）且没有文件路径和输入变量，则返回错误
+	if message == "" && filePath == "" && len(req.Inputs) == 0 {
+		return result, fmt.Errorf("message content or file path cannot be empty")
 	}
 
 	// 根据配置的 BotType 调用不同的 Dify API
-	var difyResponse string // 用于存储 Dify API 的回复内容
-	var difyErr error       // 用于捕获 API 调用过程中可能发生的错误
+	var difyResponse string               // 用于存储 Dify API 的回复内容
+	var difyErr error                     // 用于捕获 API 调用过程中可能发生的错误
+	var streamed bool                     // 是否已通过流式模式推送过部分回答
+	var stream *paragraphFlusher          // 流式模式的分段推送器，推送过部分回答时由它发送剩余部分
+	var citations []DifyRetrieverResource // 回答引用的知识库分段，在回答发送后按应用的 citations 配置发送
+	var messageID string                  // 回答的消息 ID，用于在回答发送后获取推荐问题和接收评价
+	var workflow *workflowRun             // 工作流的运行结果，按应用的 workflow_output 配置渲染后发送
 
-	log.Printf("[Converter] 调用 Dify API，Bot 类型: %s", c.difyService.cfg.Dify.BotType)
-	switch c.difyService.cfg.Dify.BotType {
+	// 调用 Dify 的阶段 (包括文件上传和流式推送) 受按应用类型配置的截止时间限制
+	timeout := c.difyTimeout(svc.app.BotType)
+	difyCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	slog.InfoContext(ctx, "[Converter] 调用 Dify API", "bot_type", svc.app.BotType, "timeout", timeout.String())
+	switch svc.app.BotType {
 	case "chat": // 如果 Bot 类型是 "chat" (聊天型应用)
+		// 确定对话上下文，过期的对话会被丢弃，由 Dify 创建新对话
+		conversationID, result.ResetReason = c.resolveConversation(ctx, route.App, user, conversationID)
+		result.NewConversation = conversationID == ""
+
 		var files []map[string]interface{} // 用于存储上传到 Dify 的文件信息
 		if filePath != "" {                // 如果存在文件路径，则先上传文件
-			log.Printf("[Converter] 正在上传文件 '%s' 到 Dify...", filePath)
-			uploadResp, uploadErr := c.difyService.UploadFile(filePath, user) // 调用 DifyService 上传文件
+			slog.InfoContext(ctx, "[Converter] 正在上传文件到 Dify", "file", filePath)
+			fileName := req.FileName
+			if fileName == "" {
+				fileName = filepath.Base(filePath)
+			}
+			uploadResp, uploadErr := svc.uploadFile(difyCtx, filePath, fileName, user) // 调用 DifyService 上传文件
 			if uploadErr != nil {
 				difyErr = fmt.Errorf("failed to upload file to Dify: %w", uploadErr) // 文件上传失败则返回错误
 				break                                                                // 跳出 switch
@@ -203,88 +485,223 @@ func (c *MessageConverter) ConvertAndSend(message, user, conversationID, filePat
 					"transfer_method": "local_file", // 传输方法
 					"upload_file_id":  fileID,       // 上传后 Dify 返回的文件 ID
 				})
-				log.Printf("[Converter] 文件上传成功，文件ID: %s, 类型: %s", fileID, fileType)
+				slog.InfoContext(ctx, "[Converter] 文件上传成功", "file_id", fileID, "file_type", fileType)
 			} else {
-				log.Printf("[Converter] 文件上传成功但未获取到文件ID") // 如果没有获取到文件 ID，记录警告
+				slog.WarnContext(ctx, "[Converter] 文件上传成功但未获取到文件ID") // 如果没有获取到文件 ID，记录警告
 			}
 		}
 
 		// 构建 Dify 聊天请求体
 		req := DifyChatRequest{
 			DifyBaseRequest: DifyBaseRequest{
-				Inputs:       map[string]interface{}{}, // 根据 Dify 应用的配置填充 inputs
-				User:         user,                     // 用户标识
-				ResponseMode: responseModeBlocking,     // 响应模式为阻塞
-				Files:        files,                    // 包含上传的文件列表
+				Inputs:       copyInputs(req.Inputs), // 请求中提供的 Dify 应用变量
+				User:         user,                   // 用户标识
+				ResponseMode: responseModeBlocking,   // 响应模式为阻塞
+				Files:        files,                  // 包含上传的文件列表
 			},
 			Query:          message,        // 用户查询文本
 			ConversationID: conversationID, // 对话 ID
 		}
-		resp, e := c.difyService.CallDifyChatAPI(req) // 调用 Dify 聊天 API
+		if svc.app.ResponseMode == responseModeStreaming {
+			// 流式模式：边接收边按段落推送到企业微信，回答较短或为结构化数据时在最后统一后处理
+			// 有只能发送一条消息的目标时不分段推送，接收完整回答后按常规方式整体处理
+			flusher := newParagraphFlusher(d.sendText, d.sendMarkdown)
+			onAnswer := flusher.Write
+			if d.singleShot() {
+				onAnswer = nil
+			}
+			resp, e := svc.CallDifyChatStreamAPIContext(difyCtx, req, onAnswer)
+			if e != nil {
+				difyErr = fmt.Errorf("dify chat stream api call failed: %w", e) // 如果调用失败，设置错误
+				break
+			}
+			result.ConversationID = c.recordTurn(route.App, user, resp.ConversationID, conversationID)
+			result.Answer = resp.Answer
+			citations = resp.Metadata.RetrieverResources
+			messageID = resp.MessageID
+			if flusher.Flushed() {
+				streamed, stream = true, flusher // 只剩未推送的最后一部分需要发送
+				slog.InfoContext(ctx, "[Converter] Dify Chat API 流式响应成功", "answer_length", len(resp.Answer), "remainder_length", len(flusher.Remainder()))
+			} else {
+				difyResponse = resp.Answer // 回答较短或为结构化数据，按常规方式整体处理
+				slog.InfoContext(ctx, "[Converter] Dify Chat API 流式响应成功", "answer_length", len(difyResponse))
+			}
+			break
+		}
+		resp, e := svc.CallDifyChatAPIContext(difyCtx, req) // 调用 Dify 聊天 API
 		if e != nil {
 			difyErr = fmt.Errorf("dify chat api call failed: %w", e) // 如果调用失败，设置错误
 		} else {
+			result.ConversationID = c.recordTurn(route.App, user, resp.ConversationID, conversationID)
 			difyResponse = resp.Answer // 获取 Dify 的回答
-			log.Printf("[Converter] Dify Chat API 响应成功，回答长度: %d", len(difyResponse))
+			citations = resp.Metadata.RetrieverResources
+			messageID = resp.MessageID
+			slog.InfoContext(ctx, "[Converter] Dify Chat API 响应成功", "answer_length", len(difyResponse))
 		}
 	case "completion": // 如果 Bot 类型是 "completion" (补全型应用)
 		// 构建 Dify 补全请求体
 		req := DifyCompletionRequest{
 			DifyBaseRequest: DifyBaseRequest{
-				Inputs:       map[string]interface{}{}, // 根据 Dify 应用的配置填充 inputs
-				User:         user,                     // 用户标识
-				ResponseMode: responseModeBlocking,     // 响应模式为阻塞
+				Inputs:       copyInputs(req.Inputs), // 请求中提供的 Dify 应用变量
+				User:         user,                   // 用户标识
+				ResponseMode: responseModeBlocking,   // 响应模式为阻塞
 			},
 			Prompt: message, // 补全提示词
 		}
-		resp, e := c.difyService.CallDifyCompletionAPI(req) // 调用 Dify 补全 API
+		resp, e := svc.CallDifyCompletionAPIContext(difyCtx, req) // 调用 Dify 补全 API
 		if e != nil {
 			difyErr = fmt.Errorf("dify completion api call failed: %w", e) // 如果调用失败，设置错误
 		} else {
 			difyResponse = resp.Text // 获取 Dify 的补全文本
-			log.Printf("[Converter] Dify Completion API 响应成功，文本长度: %d", len(difyResponse))
+			citations = resp.Metadata.RetrieverResources
+			messageID = resp.MessageID
+			slog.InfoContext(ctx, "[Converter] Dify Completion API 响应成功", "text_length", len(difyResponse))
 		}
 	case "workflow": // 如果 Bot 类型是 "workflow" (工作流型应用)
 		// 构建 Dify 工作流请求体
+		// 工作流通常通过 inputs 字段传递数据，消息作为 query 变量，请求中提供的同名变量优先
+		inputs := copyInputs(req.Inputs)
+		if _, ok := inputs["query"]; !ok && message != "" {
+			inputs["query"] = message
+		}
 		req := DifyWorkflowRequest{
 			DifyBaseRequest: DifyBaseRequest{
-				Inputs:       map[string]interface{}{"query": message}, // 工作流通常通过 inputs 字段传递数据
-				User:         user,                                     // 用户标识
-				ResponseMode: responseModeBlocking,                     // 响应模式为阻塞
+				Inputs:       inputs,               // 工作流的输入变量
+				User:         user,                 // 用户标识
+				ResponseMode: responseModeBlocking, // 响应模式为阻塞
 			},
-			WorkflowID: c.difyService.cfg.Dify.WorkflowID, // 工作流 ID，从配置中获取
+			WorkflowID: svc.app.WorkflowID, // 工作流 ID，从配置中获取
 		}
-		resp, e := c.difyService.CallDifyWorkflowAPI(req) // 调用 Dify 工作流 API
+		resp, e := svc.CallDifyWorkflowAPIContext(difyCtx, req) // 调用 Dify 工作流 API
 		if e != nil {
 			difyErr = fmt.Errorf("dify workflow api call failed: %w", e) // 如果调用失败，设置错误
 		} else {
-			// 工作流的响应可能是一个复杂的数据结构，这里将其序列化为 JSON 字符串
-			jsonBytes, marshalErr := json.MarshalIndent(resp.Data, "", "  ") // 格式化输出 JSON
-			if marshalErr != nil {
-				log.Printf("[Converter] 序列化 Dify Workflow 响应失败: %v", marshalErr)
-				difyResponse = fmt.Sprintf("Error: failed to marshal workflow response: %v", marshalErr)
-			} else {
-				difyResponse = string(jsonBytes) // 将 JSON 字节转换为字符串
-			}
-			log.Printf("[Converter] Dify Workflow API 响应成功，数据长度: %d", len(difyResponse))
+			// 只展示 workflow_output 中选择的输出字段，而不是整个运行结果
+			run := newWorkflowRun(resp.Data, svc.app.WorkflowOutput.Fields)
+			workflow = &run
+			slog.InfoContext(ctx, "[Converter] Dify Workflow API 响应成功", "run_id", run.RunID, "status", run.Status,
+				"outputs", len(run.Fields), "elapsed_time", run.ElapsedTime)
 		}
 	default: // 如果 Bot 类型不支持
-		difyErr = fmt.Errorf("unsupported dify bot type: %s", c.difyService.cfg.Dify.BotType) // 返回不支持的 Bot 类型错误
+		difyErr = fmt.Errorf("unsupported dify bot type: %s", svc.app.BotType) // 返回不支持的 Bot 类型错误
 	}
 
 	// 如果 Dify API 调用过程中发生错误，则返回该错误
 	if difyErr != nil {
-		return fmt.Errorf("failed to call Dify API: %w", difyErr)
+		return result, fmt.Errorf("failed to call Dify API: %w", difyErr)
+	}
+	if workflow != nil {
+		return result, c.sendWorkflow(d, svc, *workflow, result)
+	}
+	if !streamed {
+		result.Answer = difyResponse
+	}
+
+	// 流式模式下以已推送部分的消息类型发送剩余部分，不再对回答做后处理
+	if streamed {
+		if err := stream.Close(); err != nil {
+			return result, fmt.Errorf("failed to send the rest of the streamed answer to wecom: %w", err)
+		}
+		slog.InfoContext(ctx, "[Converter] 流式回答已全部推送到企业微信")
+		c.recordAnswer(ctx, route.App, user, messageID)
+		sendFollowUps(d, svc, user, messageID, citations)
+		return result, nil
+	}
+
+	// 只能发送一条消息的目标：引用来源和推荐问题合并到回答末尾
+	if d.singleShot() {
+		d.footer = followUpsMarkdown(d, svc, user, messageID, citations)
 	}
 
 	// 2. Dify 响应后处理并发送到企业微信
-	err = c.postprocessDifyResponse(difyResponse)
+	err = c.postprocessDifyResponse(d, svc, difyResponse)
+	if err != nil {
+		return result, fmt.Errorf("failed to post-process Dify response and send to wecom: %w", err)
+	}
+	c.recordAnswer(ctx, route.App, user, messageID)
+	sendFollowUps(d, svc, user, messageID, citations)
+
+	slog.InfoContext(ctx, "[Converter] 消息成功发送到企业微信")
+	return result, nil // 消息成功发送
+}
+
+// sendFollowUps 在回答发送完成后依次发送引用来源、推荐问题和评价按钮，评价按钮尽量附在推荐问题卡片上
+// 这些补充内容发送失败时只记录日志，不影响投递结果。
+// 有只能发送一条消息的目标时不发送：引用来源和推荐问题已合并到回答末尾，评价可以通过 /good 和 /bad 命令提交。
+func sendFollowUps(d *delivery, svc *DifyService, user, messageID string, citations []DifyRetrieverResource) {
+	if d.singleShot() {
+		return
+	}
+	sendCitations(d, svc.app.Citations, citations)
+	if !sendSuggestions(d, svc, user, messageID) {
+		sendFeedbackCard(d, svc, messageID)
+	}
+}
+
+// followUpsMarkdown 返回合并到回答末尾的引用来源和推荐问题，都没有时返回空字符串
+func followUpsMarkdown(d *delivery, svc *DifyService, user, messageID string, citations []DifyRetrieverResource) string {
+	var parts []string
+	if cfg := svc.app.Citations; cfg.Enable {
+		if selected := selectCitations(citations, cfg); len(selected) > 0 {
+			parts = append(parts, citationsMarkdown(selected, cfg))
+		}
+	}
+	if questions := fetchSuggestions(d.ctx, svc, user, messageID); len(questions) > 0 {
+		parts = append(parts, suggestionsMarkdown(questions))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// sendWorkflow 将工作流的运行结果发送到投递目标
+// 运行失败时发送 Dify 返回的错误信息，并返回 ErrWorkflowFailed；成功时按应用的 workflow_output 配置渲染输出。
+func (c *MessageConverter) sendWorkflow(d *delivery, svc *DifyService, run workflowRun, result *ConvertResult) error {
+	if run.Status != workflowStatusSucceeded {
+		slog.WarnContext(d.ctx, "[Converter] Dify 工作流运行失败", "run_id", run.RunID, "status", run.Status, "error", run.Error)
+		result.Answer = workflowFailure(run)
+		if err := d.sendText(result.Answer); err != nil {
+			return fmt.Errorf("failed to send workflow failure to wecom: %w", err)
+		}
+		return fmt.Errorf("%w (status: %s): %s", ErrWorkflowFailed, run.Status, run.Error)
+	}
+	answer, err := svc.output.send(d, run)
+	result.Answer = answer
 	if err != nil {
-		return fmt.Errorf("failed to post-process Dify response and send to wecom: %w", err)
+		return fmt.Errorf("failed to send workflow output to wecom: %w", err)
 	}
+	slog.InfoContext(d.ctx, "[Converter] 工作流输出已发送到企业微信", "format", svc.app.WorkflowOutput.Format)
+	return nil
+}
 
-	log.Println("[Converter] 消息成功发送到企业微信。")
-	return nil // 消息成功发送，返回 nil
+// recordAnswer 记录已发送的回答，供 /good、/bad 命令和评价按钮查找；没有消息 ID 的回答 (例如工作流) 不记录
+func (c *MessageConverter) recordAnswer(ctx context.Context, app, user, messageID string) {
+	if messageID == "" {
+		return
+	}
+	c.answers.record(trackedAnswer{App: app, User: user, MessageID: messageID, SentAt: time.Now()})
+	slog.DebugContext(ctx, "[Converter] 已记录回答的消息 ID", "app", app, "user", user, "message_id", messageID)
+}
+
+// recordTurn 在 chat 问答完成后记录对话轮数，并返回本轮所在的对话 ID
+// Dify 新建对话时会在响应中返回新的对话 ID，未返回时沿用请求中的对话 ID。
+func (c *MessageConverter) recordTurn(app, user, respConversationID, reqConversationID string) string {
+	conversationID := respConversationID
+	if conversationID == "" {
+		conversationID = reqConversationID
+	}
+	if conversationID == "" {
+		return ""
+	}
+	c.conversationStore.RecordTurn(conversationKey(app, user), conversationID)
+	return conversationID
+}
+
+// copyInputs 复制请求中的 Dify 应用变量，避免修改调用方的数据；为 nil 时返回空映射
+func copyInputs(inputs map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(inputs))
+	for k, v := range inputs {
+		copied[k] = v
+	}
+	return copied
 }
 
 // getFileTypeFromPath 根据文件路径判断文件类型，返回 Dify API 期望的类型字符串
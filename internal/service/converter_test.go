@@ -0,0 +1,123 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/internal/store"
+	"dify2wxbot/pkg/sender"
+)
+
+// newTestConverter 创建使用 difyHandler 作为 Dify 服务端的聊天型 MessageConverter，回复记录在返回的 Recorder 中
+// difyHandler 为 nil 时任何 Dify 请求都会使测试失败；configure 可在创建前修改配置，例如开启引用来源或切换应用类型。
+func newTestConverter(t *testing.T, difyHandler http.HandlerFunc, configure ...func(cfg *config.AppConfig)) (*MessageConverter, *sender.Recorder) {
+	t.Helper()
+	if difyHandler == nil {
+		difyHandler = func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected dify request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	dify := httptest.NewServer(difyHandler)
+	t.Cleanup(dify.Close)
+
+	cfg := &config.AppConfig{
+		Dify:  config.DifyConfig{APIKey: "app-test", BaseURL: dify.URL, BotType: "chat"},
+		WeCom: config.WeComConfig{WebhookURL: "http://127.0.0.1:0/send?key=test"},
+	}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	c := NewMessageConverter(cfg, store.NewInMemoryConversationStore())
+	rec := sender.NewRecorder(config.DefaultRobotName, weComCaps)
+	c.RegisterSender(rec)
+	return c, rec
+}
+
+// difyAnswer 返回以固定的阻塞模式回答响应的 Dify 服务端
+func difyAnswer(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+}
+
+func TestConverterRepliesThroughRegisteredSender(t *testing.T) {
+	c, rec := newTestConverter(t, nil)
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "/help", User: "tester"})
+	if err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	got := rec.Records()
+	if len(got) == 0 || got[0].Content == "" {
+		t.Fatalf("recorder got %+v, want the /help reply", got)
+	}
+	if len(result.Deliveries) != 1 || result.Deliveries[0].Err != nil {
+		t.Fatalf("deliveries = %+v, want one successful delivery", result.Deliveries)
+	}
+}
+
+func TestConverterRepliesToReplyToInsteadOfTargets(t *testing.T) {
+	c, configured := newTestConverter(t, nil)
+	origin := sender.NewRecorder("origin", weComCaps)
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "/help", User: "tester", Targets: []string{config.DefaultRobotName}, ReplyTo: origin})
+	if err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	if len(origin.Records()) == 0 || len(configured.Records()) != 0 {
+		t.Fatalf("origin got %d messages, configured target got %d; want the reply only in the originating chat",
+			len(origin.Records()), len(configured.Records()))
+	}
+	if len(result.Deliveries) != 1 || result.Deliveries[0].Target != "origin" {
+		t.Fatalf("deliveries = %+v, want one delivery to origin", result.Deliveries)
+	}
+}
+
+func TestConverterMergesStreamedAnswerForSingleShotReplyTo(t *testing.T) {
+	paragraph := strings.Repeat("年假需要提前三个工作日在系统中申请。", 12) + "\n\n"
+	// message_end 事件携带引用来源
+	stream := strings.Replace(sseAnswer(paragraph, paragraph, "祝工作顺利。"), `{"event": "message_end",`,
+		`{"event": "message_end", "metadata": {"retriever_resources": [{"position": 1, "dataset_name": "人事知识库", "document_name": "员工手册.pdf", "segment_id": "s1", "score": 0.9, "content": "年假规定"}]},`, 1)
+	var suggested int
+	c, configured := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			suggested++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"result": "success", "data": ["病假怎么算？"]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	}, func(cfg *config.AppConfig) {
+		cfg.Dify.ResponseMode = "streaming"
+		cfg.Dify.Citations = config.CitationsConfig{Enable: true}
+		cfg.Dify.Suggestions = config.SuggestionsConfig{Enable: true}
+		cfg.Dify.Feedback = config.FeedbackConfig{Buttons: true}
+	})
+	origin := sender.NewRecorder("origin", sender.Capabilities{MaxTextBytes: 20480, MaxMarkdownBytes: 20480, Markdown: sender.MarkdownWeCom, SingleShot: true})
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "怎么请假", User: "tester", ReplyTo: origin})
+	if err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	got := origin.Records()
+	if len(got) != 1 {
+		t.Fatalf("single-shot target got %d messages, want the answer and follow-ups in one: %+v", len(got), got)
+	}
+	for _, part := range []string{strings.TrimSpace(paragraph), "祝工作顺利。", "**参考来源**\n1. 员工手册.pdf", "**你可能还想问**\n1. 病假怎么算？"} {
+		if !strings.Contains(got[0].Content, part) {
+			t.Errorf("reply is missing %q:\n%s", part, got[0].Content)
+		}
+	}
+	if suggested != 1 || len(configured.Records()) != 0 {
+		t.Errorf("suggestions fetched %d times, configured target got %d messages; want 1 and 0", suggested, len(configured.Records()))
+	}
+	if result.PartialFailure() || len(result.Deliveries) != 1 || result.Deliveries[0].Err != nil {
+		t.Fatalf("deliveries = %+v, want one successful delivery", result.Deliveries)
+	}
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"context"       // 导入 context 包，用于随消息处理一起取消发送
+	"encoding/json" // 导入 encoding/json 包，用于将发送结果编码为 JSON
+	"errors"        // 导入 errors 包，用于定义投递目标错误
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"strings"       // 导入 strings 包，用于在回复末尾附加 footer
+	"sync"          // 导入 sync 包，用于并发向多个目标发送消息
+
+	"dify2wxbot/pkg/sender" // 导入 pkg/sender 包，用于通过各个投递目标的发送方发送消息
+	"dify2wxbot/pkg/wecom"  // 导入 pkg/wecom 包，用于向企业微信目标发送模板卡片
+)
+
+// ErrUnknownTarget 表示请求中指定的投递目标 (企业微信机器人) 不存在
+var ErrUnknownTarget = errors.New("unknown wecom target")
+
+// Delivery 描述一条回复在某个投递目标上的发送结果
+type Delivery struct {
+	Target string // Target 是投递目标 (企业微信机器人) 的名称
+	Err    error  // Err 是发送失败的原因，发送成功时为 nil
+}
+
+// MarshalJSON 将发送结果编码为 {"target": ..., "status": "success" 或 "failed", "error": ...}
+func (d Delivery) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Target string `json:"target"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}{Target: d.Target, Status: "success"}
+	if d.Err != nil {
+		out.Status = "failed"
+		out.Error = d.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+// PartialFailure 判断是否有投递目标发送失败
+// 所有目标都失败时 ConvertAndSend 会返回错误，因此结果中出现失败的目标即表示部分成功。
+func (r *ConvertResult) PartialFailure() bool {
+	for _, delivery := range r.Deliveries {
+		if delivery.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// delivery 将一次消息处理产生的所有回复扇出到多个投递目标
+// 每个目标使用独立的发送方实例和发送队列，互不影响频率配额；某个目标发送失败后，
+// 本次处理中后续的消息不再发往该目标 (避免分段乱序)，其他目标继续发送。
+// 回复内容会按每个目标声明的能力 (sender.Capabilities) 调整：按各自的长度限制切分，
+// 不支持 Markdown 的目标收到转换后的纯文本，Slack 目标收到 mrkdwn，不支持图片或文件的目标收到链接；
+// 只能发送一条消息的目标 (sender.Capabilities.SingleShot) 收到截断后的一条消息，末尾附带 footer。
+type delivery struct {
+	ctx      context.Context // ctx 是本次消息处理的上下文，取消后尚未发送的消息不再发送
+	targets  []string        // targets 是投递目标名称，按配置顺序排列
+	robots   []sender.Sender // robots 是与 targets 一一对应的发送方实例
+	errs     []error         // errs 记录每个目标的首个发送错误
+	sent     bool            // sent 表示是否尝试发送过消息
+	mentions []string        // mentions 是需要在回复中 @ 的成员 ID，只附加在发往每个目标的第一条文本或 Markdown 消息上
+	footer   string          // footer 是合并到只能发送一条消息的目标的回复末尾的 Markdown 附加内容，例如引用来源和推荐问题
+
+	mu        sync.Mutex      // mu 保护 mentioned
+	mentioned map[string]bool // mentioned 记录已经 @ 过成员的目标
+}
+
+// newDelivery 创建一个新的 delivery 实例
+func newDelivery(ctx context.Context, targets []string, robots []sender.Sender) *delivery {
+	return &delivery{
+		ctx:     ctx,
+		targets: targets,
+		robots:  robots,
+		errs:    make([]error, len(targets)),
+	}
+}
+
+// each 并发地对每个尚未失败的目标执行 send，并记录失败的目标
+// 只要还有目标发送成功就返回 nil；所有目标都已失败时返回错误。
+func (d *delivery) each(send func(robot sender.Sender) error) error {
+	d.sent = true
+	var wg sync.WaitGroup
+	for i, robot := range d.robots {
+		if d.errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, robot sender.Sender) {
+			defer wg.Done()
+			if err := send(robot); err != nil {
+				slog.WarnContext(d.ctx, "[Delivery] 向目标发送消息失败，本次处理中不再向其发送", "target", d.targets[i], "error", err)
+				d.errs[i] = err
+			}
+		}(i, robot)
+	}
+	wg.Wait()
+	return d.err()
+}
+
+// optional 并发地对每个尚未失败的目标执行 send，用于发送引用来源、推荐问题等回答之外的补充内容
+// 补充内容发送失败时只记录日志，不计入该目标的发送结果，也不影响之后发往该目标的消息。
+// what: 补充内容的名称，用于日志
+func (d *delivery) optional(what string, send func(robot sender.Sender) error) {
+	var wg sync.WaitGroup
+	for i, robot := range d.robots {
+		if d.errs[i] != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, robot sender.Sender) {
+			defer wg.Done()
+			if err := send(robot); err != nil {
+				slog.WarnContext(d.ctx, "[Delivery] 向目标发送补充内容失败，已跳过", "target", d.targets[i], "content", what, "error", err)
+			}
+		}(i, robot)
+	}
+	wg.Wait()
+}
+
+// err 在所有目标都发送失败时返回错误，否则返回 nil
+func (d *delivery) err() error {
+	for _, err := range d.errs {
+		if err == nil {
+			return nil
+		}
+	}
+	if len(d.errs) == 1 {
+		return d.errs[0]
+	}
+	return fmt.Errorf("failed to deliver to all %d targets: %w", len(d.errs), errors.Join(d.errs...))
+}
+
+// singleShot 判断是否有投递目标每次处理只能发送一条消息
+// 此时回答不分段推送，引用来源和推荐问题合并到回答末尾 (footer)，不再单独发送。
+func (d *delivery) singleShot() bool {
+	for _, robot := range d.robots {
+		if robot.Capabilities().SingleShot {
+			return true
+		}
+	}
+	return false
+}
+
+// results 返回每个目标的发送结果，没有尝试发送过消息时返回 nil
+func (d *delivery) results() []Delivery {
+	if !d.sent {
+		return nil
+	}
+	results := make([]Delivery, len(d.targets))
+	for i, target := range d.targets {
+		results[i] = Delivery{Target: target, Err: d.errs[i]}
+	}
+	return results
+}
+
+// sendText 发送文本消息，超过目标的文本消息长度限制 (企业微信为 2048 字节) 时自动切分为多条消息依次发送
+// content: 文本消息内容
+func (d *delivery) sendText(content string) error {
+	return d.each(func(robot sender.Sender) error {
+		caps := robot.Capabilities()
+		text := content
+		if caps.SingleShot {
+			footer := d.footer
+			if caps.Markdown == sender.MarkdownNone {
+				footer = markdownToText(footer) // 不支持 Markdown 的目标收到转换后的附加内容
+			}
+			text = appendFooter(text, footer)
+		}
+		chunks := splitFor(caps, text, limitOrDefault(caps.MaxTextBytes, maxTextMessageBytes))
+		return d.sendChunks(robot, chunks, func(ctx context.Context, chunk string, first bool) error {
+			if first {
+				if mentions := d.takeMentions(robot); len(mentions) > 0 && caps.Mentions {
+					if m, ok := robot.(sender.MentionSender); ok {
+						return m.SendTextWithMentionMessageContext(ctx, chunk, mentions, nil)
+					}
+				}
+			}
+			return robot.SendTextMessageContext(ctx, chunk)
+		})
+	})
+}
+
+// sendMarkdown 发送 Markdown 消息，超过目标的 Markdown 消息长度限制 (群机器人为 4096 字节) 时自动切分为多条消息依次发送
+// 不支持 Markdown 的目标改为发送转换后的纯文本，Slack 目标发送转换后的 mrkdwn。
+// content: Markdown 格式的内容
+func (d *delivery) sendMarkdown(content string) error {
+	return d.each(func(robot sender.Sender) error {
+		return d.sendMarkdownTo(robot, content)
+	})
+}
+
+// sendMarkdownTo 按目标的能力向一个目标发送 Markdown 消息，供 sendMarkdown、sendTemplateCardTo 和补充内容使用
+func (d *delivery) sendMarkdownTo(robot sender.Sender, content string) error {
+	caps := robot.Capabilities()
+	if caps.SingleShot {
+		content = appendFooter(content, d.footer)
+	}
+	if caps.Markdown == sender.MarkdownNone {
+		chunks := splitFor(caps, markdownToText(content), limitOrDefault(caps.MaxTextBytes, maxTextMessageBytes))
+		return d.sendChunks(robot, chunks, func(ctx context.Context, chunk string, _ bool) error {
+			return robot.SendTextMessageContext(ctx, chunk)
+		})
+	}
+	if caps.Markdown == sender.MarkdownSlack {
+		content = markdownToSlack(content)
+	}
+	// 企业微信 Markdown 通过 <@userid> 语法 @成员，提醒放在第一个分段的开头，并从长度限制中扣除
+	var prefix string
+	if mentions := d.takeMentions(robot); len(mentions) > 0 && caps.Mentions && caps.Markdown == sender.MarkdownWeCom {
+		prefix = weComMarkdownMentions(mentions)
+	}
+	chunks := splitFor(caps, content, limitOrDefault(caps.MaxMarkdownBytes, maxMarkdownMessageBytes)-len(prefix))
+	chunks[0] = prefix + chunks[0]
+	return d.sendChunks(robot, chunks, func(ctx context.Context, chunk string, _ bool) error {
+		return robot.SendMarkdownMessageContext(ctx, chunk)
+	})
+}
+
+// templateCardSender 是支持企业微信模板卡片消息的发送方，群机器人和自建应用都实现了该接口
+type templateCardSender interface {
+	SendTemplateCardMessageContext(ctx context.Context, card wecom.TemplateCard) error
+}
+
+// sendTemplateCard 向支持模板卡片的目标发送卡片，其他目标以及卡片发送失败的目标改为发送 fallback Markdown
+// fallback 为空表示卡片是可有可无的补充：不支持模板卡片的目标不发送，卡片发送失败也不视为该目标发送失败。
+// card: 模板卡片内容
+// fallback: 与卡片内容相同的 Markdown 文本，可以为空
+func (d *delivery) sendTemplateCard(card wecom.TemplateCard, fallback string) error {
+	return d.each(func(robot sender.Sender) error {
+		return d.sendTemplateCardTo(robot, card, fallback)
+	})
+}
+
+// sendTemplateCardTo 按 sendTemplateCard 的规则向一个目标发送模板卡片，供 sendTemplateCard 和补充内容使用
+func (d *delivery) sendTemplateCardTo(robot sender.Sender, card wecom.TemplateCard, fallback string) error {
+	if cs, ok := robot.(templateCardSender); ok {
+		err := cs.SendTemplateCardMessageContext(d.ctx, card)
+		if err == nil {
+			return nil
+		}
+		if fallback == "" {
+			slog.WarnContext(d.ctx, "[Delivery] 发送模板卡片消息失败，已跳过", "target", robot.Name(), "card_type", card.CardType, "error", err)
+			return nil
+		}
+		slog.WarnContext(d.ctx, "[Delivery] 发送模板卡片消息失败，改为发送 Markdown", "target", robot.Name(), "error", err)
+	} else if fallback == "" {
+		return nil
+	}
+	return d.sendMarkdownTo(robot, fallback)
+}
+
+// newsSender 是支持图文消息的发送方，目前只有企业微信群机器人实现了该接口
+type newsSender interface {
+	SendNewsMessageContext(ctx context.Context, articles []wecom.Article) error
+}
+
+// sendNews 向支持图文消息的目标发送图文消息，其他目标以及图文消息发送失败的目标改为发送 fallback Markdown
+// articles: 图文消息中的文章列表
+// fallback: 与图文消息内容相同的 Markdown 文本
+func (d *delivery) sendNews(articles []wecom.Article, fallback string) error {
+	return d.each(func(robot sender.Sender) error {
+		if ns, ok := robot.(newsSender); ok {
+			err := ns.SendNewsMessageContext(d.ctx, articles)
+			if err == nil {
+				return nil
+			}
+			slog.WarnContext(d.ctx, "[Delivery] 发送图文消息失败，改为发送 Markdown", "target", robot.Name(), "error", err)
+		}
+		return d.sendMarkdownTo(robot, fallback)
+	})
+}
+
+// sendImage 向每个目标发送图片，不支持图片的目标改为发送图片链接，发送图片失败时改为向该目标发送文本提示
+// imagePath: 图片的本地路径
+// imageURL: 图片的原始地址，用于文本提示
+func (d *delivery) sendImage(imagePath, imageURL string) error {
+	return d.each(func(robot sender.Sender) error {
+		if !robot.Capabilities().Images {
+			return robot.SendTextMessageContext(d.ctx, fmt.Sprintf("Dify 返回了一张图片: %s", imageURL))
+		}
+		if err := robot.SendImageMessageContext(d.ctx, imagePath); err != nil {
+			slog.WarnContext(d.ctx, "[Delivery] 发送图片消息失败，改为发送文本提示", "target", robot.Name(), "error", err)
+			return robot.SendTextMessageContext(d.ctx, fmt.Sprintf("Dify 返回了一张图片: %s，但发送失败。", imageURL))
+		}
+		return nil // 图片消息已发送，不再发送文本
+	})
+}
+
+// sendFile 向每个目标发送文件，不支持文件的目标改为发送文件链接，发送文件失败时改为向该目标发送文本提示
+// filePath: 文件的本地路径
+// fileURL: 文件的原始地址，用于文本提示
+func (d *delivery) sendFile(filePath, fileURL string) error {
+	return d.each(func(robot sender.Sender) error {
+		if !robot.Capabilities().Files {
+			return robot.SendTextMessageContext(d.ctx, fmt.Sprintf("Dify 返回了一个文件: %s", fileURL))
+		}
+		if err := robot.SendFileMessageContext(d.ctx, filePath); err != nil {
+			slog.WarnContext(d.ctx, "[Delivery] 发送文件消息失败，改为发送文本提示", "target", robot.Name(), "error", err)
+			return robot.SendTextMessageContext(d.ctx, fmt.Sprintf("Dify 返回了一个文件: %s，但发送失败。", fileURL))
+		}
+		return nil // 文件消息已发送，不再发送文本
+	})
+}
+
+// sendChunks 向一个目标按顺序发送切分后的消息分段，first 表示是否为第一个分段
+// 发送速率由各个目标的发送队列控制，不会超过其频率限制。
+// 任意分段发送失败时立即停止向该目标发送，避免后续分段乱序到达。
+func (d *delivery) sendChunks(robot sender.Sender, chunks []string, send func(ctx context.Context, chunk string, first bool) error) error {
+	if len(chunks) > 1 {
+		slog.InfoContext(d.ctx, "[Delivery] 回复超出单条消息长度限制，将拆分发送", "target", robot.Name(), "chunks", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if err := send(d.ctx, chunk, i == 0); err != nil {
+			return fmt.Errorf("failed to send message chunk %d/%d: %w", i+1, len(chunks), err)
+		}
+	}
+	return nil
+}
+
+// takeMentions 返回发往该目标的消息需要 @ 的成员，每个目标只返回一次，之后的消息不再 @
+func (d *delivery) takeMentions(robot sender.Sender) []string {
+	if len(d.mentions) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mentioned == nil {
+		d.mentioned = make(map[string]bool)
+	}
+	if d.mentioned[robot.Name()] {
+		return nil
+	}
+	d.mentioned[robot.Name()] = true
+	return d.mentions
+}
+
+// splitFor 按目标的能力处理超长消息：只能发送一条消息的目标截断为一条，其他目标切分为多条
+func splitFor(caps sender.Capabilities, content string, limit int) []string {
+	if caps.SingleShot {
+		return []string{truncateMessage(content, limit)}
+	}
+	return splitMessage(content, limit)
+}
+
+// appendFooter 在回复末尾以空行分隔附加 footer，footer 为空时原样返回
+func appendFooter(content, footer string) string {
+	if footer == "" {
+		return content
+	}
+	return strings.TrimSpace(content) + "\n\n" + footer
+}
+
+// limitOrDefault 返回发送方声明的长度限制，未声明时返回默认值
+func limitOrDefault(limit, def int) int {
+	if limit > 0 {
+		return limit
+	}
+	return def
+}
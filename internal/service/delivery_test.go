@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"dify2wxbot/pkg/sender"
+)
+
+// weComCaps 与企业微信群机器人声明的能力一致
+var weComCaps = sender.Capabilities{
+	MaxTextBytes:     2048,
+	MaxMarkdownBytes: 4096,
+	Markdown:         sender.MarkdownWeCom,
+	Images:           true,
+	Files:            true,
+	Mentions:         true,
+}
+
+// newTestDelivery 创建一个向给定 Recorder 投递的 delivery
+func newTestDelivery(recorders ...*sender.Recorder) *delivery {
+	names := make([]string, len(recorders))
+	senders := make([]sender.Sender, len(recorders))
+	for i, r := range recorders {
+		names[i] = r.Name()
+		senders[i] = r
+	}
+	return newDelivery(context.Background(), names, senders)
+}
+
+func TestDeliveryMarkdownFallsBackToText(t *testing.T) {
+	rich := sender.NewRecorder("rich", weComCaps)
+	plain := sender.NewRecorder("plain", sender.Capabilities{})
+	d := newTestDelivery(rich, plain)
+
+	content := "# 标题\n\n**加粗** 和 [链接](https://example.com)\n\n```go\nfmt.Println(\"**\")\n```"
+	if err := d.sendMarkdown(content); err != nil {
+		t.Fatalf("sendMarkdown: %v", err)
+	}
+
+	got := rich.Records()
+	if len(got) != 1 || got[0].Kind != sender.KindMarkdown || got[0].Content != content {
+		t.Fatalf("rich target got %+v, want markdown unchanged", got)
+	}
+	got = plain.Records()
+	want := "标题\n\n加粗 和 链接 (https://example.com)\n\nfmt.Println(\"**\")"
+	if len(got) != 1 || got[0].Kind != sender.KindText || got[0].Content != want {
+		t.Fatalf("plain target got %+v, want text %q", got, want)
+	}
+}
+
+func TestDeliveryConvertsMarkdownForSlack(t *testing.T) {
+	slack := sender.NewRecorder("slack", sender.Capabilities{Markdown: sender.MarkdownSlack})
+	d := newTestDelivery(slack)
+
+	content := "## 结果\n\n- **加粗** 和 *斜体*\n- [链接](https://example.com/?a=1&b=2) <br>\n\n```\nx < y\n```"
+	if err := d.sendMarkdown(content); err != nil {
+		t.Fatalf("sendMarkdown: %v", err)
+	}
+	want := "*结果*\n\n• *加粗* 和 _斜体_\n• <https://example.com/?a=1&amp;b=2|链接> &lt;br&gt;\n\n```\nx &lt; y\n```"
+	if got := slack.Records(); len(got) != 1 || got[0].Kind != sender.KindMarkdown || got[0].Content != want {
+		t.Fatalf("slack target got %+v, want mrkdwn %q", got, want)
+	}
+}
+
+func TestDeliverySplitsByTargetLimit(t *testing.T) {
+	wide := sender.NewRecorder("wide", weComCaps)
+	narrow := sender.NewRecorder("narrow", sender.Capabilities{MaxTextBytes: 64})
+	d := newTestDelivery(wide, narrow)
+
+	content := strings.Repeat("这是一句话。", 20) // 360 字节
+	if err := d.sendText(content); err != nil {
+		t.Fatalf("sendText: %v", err)
+	}
+	if n := len(wide.Records()); n != 1 {
+		t.Fatalf("wide target got %d messages, want 1", n)
+	}
+	records := narrow.Records()
+	if len(records) < 2 {
+		t.Fatalf("narrow target got %d messages, want the reply split", len(records))
+	}
+	for _, r := range records {
+		if len(r.Content) > 64 {
+			t.Errorf("chunk of %d bytes exceeds limit: %q", len(r.Content), r.Content)
+		}
+	}
+}
+
+func TestDeliveryTruncatesForSingleShotTargets(t *testing.T) {
+	once := sender.NewRecorder("once", sender.Capabilities{MaxMarkdownBytes: 256, Markdown: sender.MarkdownWeCom, SingleShot: true})
+	d := newTestDelivery(once)
+	d.footer = "**参考来源**\n1. 员工手册.pdf"
+
+	if err := d.sendMarkdown(strings.Repeat("这是一句话。", 10)); err != nil {
+		t.Fatalf("sendMarkdown short: %v", err)
+	}
+	if got := once.Records(); len(got) != 1 || !strings.HasSuffix(got[0].Content, "。\n\n"+d.footer) {
+		t.Fatalf("single-shot target got %+v, want one message ending with the footer", got)
+	}
+
+	once.Reset()
+	if err := d.sendMarkdown(strings.Repeat("这是一句话。", 100)); err != nil {
+		t.Fatalf("sendMarkdown long: %v", err)
+	}
+	got := once.Records()
+	if len(got) != 1 || len(got[0].Content) > 256 || !strings.HasSuffix(got[0].Content, truncatedMarker) || !utf8.ValidString(got[0].Content) {
+		t.Fatalf("single-shot target got %+v, want one valid message truncated to 256 bytes", got)
+	}
+}
+
+func TestDeliveryImageFallsBackToLink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, []byte("png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withImages := sender.NewRecorder("images", weComCaps)
+	textOnly := sender.NewRecorder("text", sender.Capabilities{})
+	d := newTestDelivery(withImages, textOnly)
+
+	if err := d.sendImage(path, "https://example.com/image.png"); err != nil {
+		t.Fatalf("sendImage: %v", err)
+	}
+	if got := withImages.Records(); len(got) != 1 || got[0].Kind != sender.KindImage || string(got[0].Data) != "png" {
+		t.Fatalf("images target got %+v, want the image", got)
+	}
+	if got := textOnly.Records(); len(got) != 1 || got[0].Kind != sender.KindText || !strings.Contains(got[0].Content, "https://example.com/image.png") {
+		t.Fatalf("text target got %+v, want a link", got)
+	}
+}
+
+func TestDeliveryMentionsFirstMessageOnly(t *testing.T) {
+	mentions := sender.NewRecorder("mentions", weComCaps)
+	noMentions := sender.NewRecorder("plain", sender.Capabilities{})
+	d := newTestDelivery(mentions, noMentions)
+	d.mentions = []string{"zhangsan"}
+
+	for _, msg := range []string{"第一条", "第二条"} {
+		if err := d.sendText(msg); err != nil {
+			t.Fatalf("sendText: %v", err)
+		}
+	}
+	got := mentions.Records()
+	if len(got) != 2 || len(got[0].Mentions) != 1 || got[0].Mentions[0] != "zhangsan" || len(got[1].Mentions) != 0 {
+		t.Fatalf("mentions target got %+v, want only the first message to mention zhangsan", got)
+	}
+	for _, r := range noMentions.Records() {
+		if len(r.Mentions) != 0 {
+			t.Fatalf("target without mention support got mentions: %+v", r)
+		}
+	}
+}
+
+func TestDeliveryIsolatesFailedTargets(t *testing.T) {
+	ok := sender.NewRecorder("ok", weComCaps)
+	broken := sender.NewRecorder("broken", weComCaps)
+	broken.FailWith(errors.New("boom"))
+	d := newTestDelivery(ok, broken)
+
+	if err := d.sendText("你好"); err != nil {
+		t.Fatalf("sendText with one healthy target: %v", err)
+	}
+	results := d.results()
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("results = %+v, want only the broken target to fail", results)
+	}
+
+	ok.FailWith(errors.New("boom"))
+	if err := d.sendText("再见"); err == nil {
+		t.Fatal("sendText with all targets failed: want error")
+	}
+}
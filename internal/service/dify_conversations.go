@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"       // 导入 context 包，用于控制请求的取消和截止时间
+	"encoding/json" // 导入 encoding/json 包，用于编码请求体
+	"fmt"           // 导入 fmt 包，用于格式化路径和错误信息
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/url"       // 导入 net/url 包，用于构建查询参数和转义路径中的对话 ID
+	"strconv"       // 导入 strconv 包，用于格式化分页大小
+)
+
+const (
+	difyConversationsPath          = "/v1/conversations"         // Dify 会话列表 API 的相对路径
+	difyConversationPathFormat     = "/v1/conversations/%s"      // Dify 删除会话 API 的相对路径，%s 为对话 ID
+	difyConversationNamePathFormat = "/v1/conversations/%s/name" // Dify 会话重命名 API 的相对路径，%s 为对话 ID
+)
+
+// DifyConversation 定义 Dify 会话列表和会话重命名 API 返回的会话
+type DifyConversation struct {
+	ID           string                 `json:"id"`           // 对话 ID
+	Name         string                 `json:"name"`         // 会话名称，默认由 Dify 根据第一个问题生成
+	Inputs       map[string]interface{} `json:"inputs"`       // 会话的输入变量
+	Status       string                 `json:"status"`       // 会话状态，例如 "normal"
+	Introduction string                 `json:"introduction"` // 开场白
+	CreatedAt    int64                  `json:"created_at"`   // 创建时间 (Unix 秒)
+	UpdatedAt    int64                  `json:"updated_at"`   // 最后更新时间 (Unix 秒)
+}
+
+// DifyConversationsResponse 定义 Dify 会话列表 API 成功响应的结构
+type DifyConversationsResponse struct {
+	Data    []DifyConversation `json:"data"`     // 会话列表，按最后更新时间倒序排列
+	HasMore bool               `json:"has_more"` // 是否还有更多会话
+	Limit   int                `json:"limit"`    // 本次返回的最大条数
+}
+
+// ListConversations 获取用户在应用中的会话列表，按最后更新时间倒序分页
+// user: 用户标识
+// lastID: 上一页最后一个会话的 ID，为空时返回第一页
+// limit: 最多返回的会话数，0 表示使用 Dify 的默认值
+func (s *DifyService) ListConversations(user, lastID string, limit int) (DifyConversationsResponse, error) {
+	return s.ListConversationsContext(context.Background(), user, lastID, limit)
+}
+
+// ListConversationsContext 与 ListConversations 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) ListConversationsContext(ctx context.Context, user, lastID string, limit int) (DifyConversationsResponse, error) {
+	slog.InfoContext(ctx, "[DifyService] 获取会话列表", "app", s.app.Name, "user", user, "last_id", lastID, "limit", limit)
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return DifyConversationsResponse{}, fmt.Errorf("dify base url 或 api key 未配置")
+	}
+
+	query := url.Values{}
+	query.Set("user", user)
+	if lastID != "" {
+		query.Set("last_id", lastID)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var response DifyConversationsResponse
+	err := s.doDifyRequest(
+		ctx,   // 请求上下文
+		"GET", // HTTP 方法为 GET
+		difyConversationsPath+"?"+query.Encode(), // 会话列表 API 的相对路径和查询参数
+		nil,                 // GET 请求没有请求体
+		"application/json",  // Content-Type 为 application/json
+		"Conversations API", // 日志前缀
+		&response,           // 响应解析目标
+	)
+	if err != nil {
+		return DifyConversationsResponse{}, err
+	}
+	return response, nil
+}
+
+// RenameConversation 重命名会话，name 为空时由 Dify 根据对话内容自动生成名称
+// conversationID: 对话 ID
+// user: 用户标识，必须是会话的所有者
+// name: 新的会话名称
+func (s *DifyService) RenameConversation(conversationID, user, name string) (DifyConversation, error) {
+	return s.RenameConversationContext(context.Background(), conversationID, user, name)
+}
+
+// RenameConversationContext 与 RenameConversation 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) RenameConversationContext(ctx context.Context, conversationID, user, name string) (DifyConversation, error) {
+	slog.InfoContext(ctx, "[DifyService] 重命名会话", "app", s.app.Name, "user", user, "conversation_id", conversationID, "auto_generate", name == "")
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return DifyConversation{}, fmt.Errorf("dify base url 或 api key 未配置")
+	}
+	jsonData, err := json.Marshal(map[string]interface{}{"name": name, "auto_generate": name == "", "user": user})
+	if err != nil {
+		return DifyConversation{}, fmt.Errorf("failed to marshal rename request body: %w", err)
+	}
+
+	var response DifyConversation
+	err = s.doDifyRequest(
+		ctx,    // 请求上下文
+		"POST", // HTTP 方法为 POST
+		fmt.Sprintf(difyConversationNamePathFormat, url.PathEscape(conversationID)), // 会话重命名 API 的相对路径
+		jsonData,                // 请求体为 JSON 数据
+		"application/json",      // Content-Type 为 application/json
+		"Conversation Name API", // 日志前缀
+		&response,               // 响应解析目标
+	)
+	if err != nil {
+		return DifyConversation{}, err
+	}
+	return response, nil
+}
+
+// DeleteConversation 删除会话及其中的全部消息
+// conversationID: 对话 ID
+// user: 用户标识，必须是会话的所有者
+func (s *DifyService) DeleteConversation(conversationID, user string) error {
+	return s.DeleteConversationContext(context.Background(), conversationID, user)
+}
+
+// DeleteConversationContext 与 DeleteConversation 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) DeleteConversationContext(ctx context.Context, conversationID, user string) error {
+	slog.InfoContext(ctx, "[DifyService] 删除会话", "app", s.app.Name, "user", user, "conversation_id", conversationID)
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return fmt.Errorf("dify base url 或 api key 未配置")
+	}
+	jsonData, err := json.Marshal(map[string]string{"user": user})
+	if err != nil {
+		return fmt.Errorf("failed to marshal delete request body: %w", err)
+	}
+	return s.doDifyRequest(
+		ctx,      // 请求上下文
+		"DELETE", // HTTP 方法为 DELETE
+		fmt.Sprintf(difyConversationPathFormat, url.PathEscape(conversationID)), // 删除会话 API 的相对路径
+		jsonData,                  // 请求体为 JSON 数据
+		"application/json",        // Content-Type 为 application/json
+		"Delete Conversation API", // 日志前缀
+		nil,                       // 不需要解析响应体
+	)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context" // 导入 context 包，用于区分调用被取消和调用失败
+	"errors"  // 导入 errors 包，用于识别 Dify 错误和取消错误
+	"strconv" // 导入 strconv 包，用于将 HTTP 状态码转换为标签值
+	"strings" // 导入 strings 包，用于从日志前缀生成接口标签
+	"time"    // 导入 time 包，用于计算调用耗时
+
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录 Dify 调用的耗时、重试和 token 用量
+)
+
+const (
+	difyResultSuccess  = "success"  // Dify 调用结果标签：成功
+	difyResultError    = "error"    // Dify 调用结果标签：失败
+	difyResultCanceled = "canceled" // Dify 调用结果标签：调用方取消或超过截止时间
+)
+
+// endpointLabel 将日志前缀转换为指标中的接口标签，例如 "Chat Stream API" 转换为 "chat_stream"
+func endpointLabel(logPrefix string) string {
+	name := strings.TrimSuffix(strings.ToLower(logPrefix), " api")
+	return strings.ReplaceAll(strings.TrimSpace(name), " ", "_")
+}
+
+// observeRequest 记录一次 Dify 调用 (包含所有重试) 的耗时和结果
+// logPrefix: 日志前缀，用于生成接口标签
+// start: 调用开始时间
+// err: 调用返回的错误，nil 表示成功
+func (s *DifyService) observeRequest(logPrefix string, start time.Time, err error) {
+	endpoint := endpointLabel(logPrefix)
+	result := difyResultSuccess
+	if err != nil {
+		result = difyResultError
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			result = difyResultCanceled
+		}
+	}
+	metrics.DifyRequestDuration.Observe(time.Since(start).Seconds(), s.app.BotType, endpoint)
+	metrics.DifyRequests.Inc(s.app.BotType, endpoint, result)
+}
+
+// observeRetry 记录一次即将进行的重试，作为 retryPolicy 的 onRetry 回调
+// logPrefix: 日志前缀，用于生成接口标签
+// err: 导致重试的错误
+func (s *DifyService) observeRetry(logPrefix string, err error) {
+	metrics.DifyRetries.Inc(s.app.BotType, endpointLabel(logPrefix), retryReason(err))
+}
+
+// retryReason 返回导致重试的原因标签：Dify 错误码、HTTP 状态码或 "transport" (网络错误)
+func retryReason(err error) string {
+	var apiErr *DifyAPIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Code != "" {
+			return apiErr.Code
+		}
+		return strconv.Itoa(apiErr.StatusCode)
+	}
+	return "transport"
+}
+
+// recordUsage 记录 Dify 响应 metadata.usage 中报告的 token 用量，用量为 0 时不记录
+func (s *DifyService) recordUsage(usage DifyUsage) {
+	if usage.TotalTokens <= 0 && usage.PromptTokens <= 0 && usage.CompletionTokens <= 0 {
+		return
+	}
+	metrics.DifyTokens.Add(float64(usage.PromptTokens), s.app.Name, s.app.BotType, "prompt")
+	metrics.DifyTokens.Add(float64(usage.CompletionTokens), s.app.Name, s.app.BotType, "completion")
+	metrics.DifyTokens.Add(float64(usage.TotalTokens), s.app.Name, s.app.BotType, "total")
+}
+
+// workflowUsage 从工作流响应的 data 中读取 token 用量
+// 工作流的阻塞响应只在 data.total_tokens 中报告总量，没有区分提示词和补全。
+func workflowUsage(data map[string]interface{}) DifyUsage {
+	total, _ := data["total_tokens"].(float64)
+	return DifyUsage{TotalTokens: int(total)}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"       // 导入 context 包，用于在重试等待期间响应取消
+	"encoding/json" // 导入 encoding/json 包，用于解析 Dify 错误响应体
+	"errors"        // 导入 errors 包，用于识别 Dify 错误和超时错误
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"math/rand"     // 导入 math/rand 包，用于为退避时间加入随机抖动
+	"net/http"      // 导入 net/http 包，用于判断 HTTP 状态码和解析 Retry-After 头
+	"strconv"       // 导入 strconv 包，用于解析 Retry-After 头中的秒数
+	"strings"       // 导入 strings 包，用于去除 Retry-After 头中的空白
+	"time"          // 导入 time 包，用于计算退避时间和总时限
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取重试策略配置
+)
+
+const (
+	defaultRetryMaxAttempts    = 3                 // 默认的最大尝试次数 (包含首次请求)
+	defaultRetryInitialBackoff = time.Second       // 默认的首次退避时间
+	defaultRetryMaxBackoff     = 10 * time.Second  // 默认的单次退避时间上限
+	defaultRetryDeadline       = 120 * time.Second // 默认的单次调用总时限
+
+	difyErrorCodeRateLimit    = "rate_limit"    // Dify 错误码：请求过于频繁，可以重试
+	difyErrorCodeServerError  = "server_error"  // Dify 错误码：服务端内部错误，可以重试
+	difyErrorCodeInvalidParam = "invalid_param" // Dify 错误码：请求参数错误，重试不会成功
+)
+
+// DifyAPIError 表示 Dify API 返回的非 200 响应
+// Code 和 Message 来自响应体中的 DifyAPIErrorResponse，响应体无法解析时 Code 为空、Message 为原始响应体。
+type DifyAPIError struct {
+	API        string        // API 是出错的接口名称，例如 "Chat API"
+	StatusCode int           // StatusCode 是 HTTP 状态码
+	Code       string        // Code 是 Dify 返回的错误码，例如 "invalid_param"
+	Message    string        // Message 是 Dify 返回的错误消息
+	RetryAfter time.Duration // RetryAfter 是响应中 Retry-After 头要求的等待时间，未设置时为 0
+}
+
+// Error 实现 error 接口
+func (e *DifyAPIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s 错误: 错误码: %s, 消息: %s", e.API, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s 返回错误状态码 %d: %s", e.API, e.StatusCode, e.Message)
+}
+
+// Retryable 判断该错误是否值得重试
+// invalid_param 永远不重试；rate_limit、server_error 以及 HTTP 429 和 5xx 会重试；其他错误 (例如认证失败) 不重试。
+func (e *DifyAPIError) Retryable() bool {
+	switch e.Code {
+	case difyErrorCodeInvalidParam:
+		return false
+	case difyErrorCodeRateLimit, difyErrorCodeServerError:
+		return true
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// newDifyAPIError 根据非 200 响应创建 DifyAPIError
+// api: 接口名称，用于错误信息
+// resp: HTTP 响应，用于读取状态码和 Retry-After 头
+// body: 已读取的响应体
+func newDifyAPIError(api string, resp *http.Response, body []byte) *DifyAPIError {
+	apiErr := &DifyAPIError{
+		API:        api,
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	var errorResponse DifyAPIErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err == nil && errorResponse.Code != "" {
+		apiErr.Code = errorResponse.Code
+		apiErr.Message = errorResponse.Message
+	}
+	return apiErr
+}
+
+// parseRetryAfter 解析 Retry-After 头，支持秒数和 HTTP 日期两种格式
+// 头不存在、格式无效或时间已过时返回 0。
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// transportError 表示请求未能得到 HTTP 响应的网络错误，或读取响应体时的错误，这类错误总是可以重试
+type transportError struct {
+	err error // err 是原始错误
+}
+
+// Error 实现 error 接口
+func (e *transportError) Error() string { return e.err.Error() }
+
+// Unwrap 返回原始错误，便于调用方使用 errors.Is 判断超时等情况
+func (e *transportError) Unwrap() error { return e.err }
+
+// retryPolicy 定义调用 Dify API 失败时的重试策略
+type retryPolicy struct {
+	maxAttempts    int                               // maxAttempts 是最大尝试次数 (包含首次请求)
+	initialBackoff time.Duration                     // initialBackoff 是首次重试前的退避时间
+	maxBackoff     time.Duration                     // maxBackoff 是单次退避时间的上限
+	deadline       time.Duration                     // deadline 是一次调用中允许重试的总时限，与调用方 ctx 的截止时间以较早者为准，超过后不再重试，但不中断进行中的请求
+	onRetry        func(logPrefix string, err error) // onRetry 在每次决定重试时调用，用于记录指标，可以为 nil
+}
+
+// newRetryPolicy 根据配置创建重试策略，未配置的项使用默认值
+func newRetryPolicy(cfg config.RetryConfig) retryPolicy {
+	p := retryPolicy{
+		maxAttempts:    cfg.MaxAttempts,
+		initialBackoff: time.Duration(cfg.InitialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(cfg.MaxBackoffMs) * time.Millisecond,
+		deadline:       time.Duration(cfg.DeadlineSeconds) * time.Second,
+	}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = defaultRetryMaxAttempts
+	}
+	if p.initialBackoff <= 0 {
+		p.initialBackoff = defaultRetryInitialBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultRetryMaxBackoff
+	}
+	if p.maxBackoff < p.initialBackoff {
+		p.maxBackoff = p.initialBackoff
+	}
+	if p.deadline <= 0 {
+		p.deadline = defaultRetryDeadline
+	}
+	return p
+}
+
+// backoff 计算第 attempt 次重试 (从 1 开始) 前的等待时间
+// 退避时间按 initialBackoff * 2^(attempt-1) 指数增长并受 maxBackoff 限制，实际等待时间在其一半到全部之间随机取值，
+// 避免多个请求同时重试；retryAfter 大于 0 时直接使用服务端要求的等待时间。
+func (p retryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	d := p.initialBackoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// retryable 判断错误是否值得重试，并返回服务端要求的等待时间
+func retryable(err error) (bool, time.Duration) {
+	var apiErr *DifyAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable(), apiErr.RetryAfter
+	}
+	var netErr *transportError
+	return errors.As(err, &netErr), 0
+}
+
+// do 按重试策略执行 attempt，直到成功、遇到不可重试的错误、用完尝试次数、超过总时限或 ctx 被取消
+// ctx: 约束重试的上下文，调用方应以 context.WithTimeout(ctx, p.deadline) 创建，剩余时间不足以等待下一次重试时直接返回最后一次的错误
+// logPrefix: 日志前缀，用于区分不同的 API 调用
+// attempt: 执行一次请求，每次调用都必须重新构建请求体
+func (p retryPolicy) do(ctx context.Context, logPrefix string, attempt func() error) error {
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline {
+		deadline = time.Now().Add(p.deadline)
+	}
+	var err error
+	for i := 1; ; i++ {
+		err = attempt()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err // 调用方已取消或超过截止时间，不再重试
+		}
+		ok, retryAfter := retryable(err)
+		if !ok {
+			return err
+		}
+		if i >= p.maxAttempts {
+			return fmt.Errorf("%s 请求在 %d 次尝试后仍然失败: %w", logPrefix, i, err)
+		}
+		wait := p.backoff(i, retryAfter)
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("%s 请求在 %d 次尝试后剩余时间不足以继续重试: %w", logPrefix, i, err)
+		}
+		slog.WarnContext(ctx, "[DifyService] 请求失败，稍后重试", "api", logPrefix, "wait", wait.Round(time.Millisecond).String(),
+			"attempt", i+1, "max_attempts", p.maxAttempts, "error", err)
+		if p.onRetry != nil {
+			p.onRetry(logPrefix, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s 请求在等待重试时被取消: %w", logPrefix, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"dify2wxbot/internal/config"
+)
+
+// fastRetry 是测试使用的重试策略，退避时间只有几毫秒
+var fastRetry = config.RetryConfig{MaxAttempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 5}
+
+// retryServer 是按顺序返回预设响应的 Dify 服务端，记录每次请求的请求体
+type retryServer struct {
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter) // responses 是每次请求的响应，用完后重复最后一个
+	bodies    []string
+	times     []time.Time
+}
+
+func (s *retryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	i := len(s.bodies)
+	s.bodies = append(s.bodies, string(body))
+	s.times = append(s.times, time.Now())
+	s.mu.Unlock()
+	if i >= len(s.responses) {
+		i = len(s.responses) - 1
+	}
+	s.responses[i](w)
+}
+
+func (s *retryServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+// difyError 返回写入 Dify 错误响应的函数，header 是额外的响应头
+func difyError(status int, code string, header ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"code": %q, "message": "error", "status": %d}`, code, status)
+	}
+}
+
+func difyOK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"answer": "ok"}`))
+}
+
+// newRetryTestService 创建使用 srv 作为 Dify 服务端的 DifyService
+func newRetryTestService(t *testing.T, srv *retryServer, retry config.RetryConfig) *DifyService {
+	t.Helper()
+	dify := httptest.NewServer(srv)
+	t.Cleanup(dify.Close)
+	return NewDifyService(config.DifyConfig{Name: "test", APIKey: "app-test", BaseURL: dify.URL, BotType: "chat"}, retry)
+}
+
+func TestDoDifyRequestReplaysBodyOnRetry(t *testing.T) {
+	srv := &retryServer{responses: []func(http.ResponseWriter){difyError(http.StatusServiceUnavailable, ""), difyOK}}
+	s := newRetryTestService(t, srv, fastRetry)
+
+	var resp struct {
+		Answer string `json:"answer"`
+	}
+	body := `{"query": "你好", "user": "tester"}`
+	if err := s.doDifyRequest(context.Background(), http.MethodPost, "/chat-messages", []byte(body), "application/json", "Chat API", &resp); err != nil {
+		t.Fatalf("doDifyRequest: %v", err)
+	}
+	if resp.Answer != "ok" {
+		t.Fatalf("answer = %q, want ok", resp.Answer)
+	}
+	if len(srv.bodies) != 2 || srv.bodies[0] != body || srv.bodies[1] != body {
+		t.Fatalf("dify got bodies %q, want the same body on both attempts", srv.bodies)
+	}
+}
+
+func TestDoDifyRequestDoesNotRetryClientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		code   string
+	}{
+		{name: "invalid_param", status: http.StatusBadRequest, code: difyErrorCodeInvalidParam},
+		{name: "invalid_param with 5xx", status: http.StatusInternalServerError, code: difyErrorCodeInvalidParam},
+		{name: "unauthorized", status: http.StatusUnauthorized, code: "unauthorized"},
+		{name: "not found", status: http.StatusNotFound, code: "not_found"},
+		{name: "bad request without code", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		srv := &retryServer{responses: []func(http.ResponseWriter){difyError(tt.status, tt.code), difyOK}}
+		s := newRetryTestService(t, srv, fastRetry)
+		err := s.doDifyRequest(context.Background(), http.MethodPost, "/chat-messages", []byte(`{}`), "application/json", "Chat API", nil)
+		var apiErr *DifyAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+			t.Errorf("%s: error = %v, want a DifyAPIError with status %d", tt.name, err, tt.status)
+		}
+		if got := srv.attempts(); got != 1 {
+			t.Errorf("%s: attempts = %d, want 1", tt.name, got)
+		}
+	}
+}
+
+func TestDoDifyRequestRetriesRateLimitAndServerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		code   string
+	}{
+		{name: "429", status: http.StatusTooManyRequests},
+		{name: "500", status: http.StatusInternalServerError},
+		{name: "502", status: http.StatusBadGateway},
+		{name: "503", status: http.StatusServiceUnavailable},
+		{name: "rate_limit code", status: http.StatusBadRequest, code: difyErrorCodeRateLimit},
+	}
+	for _, tt := range tests {
+		fail := difyError(tt.status, tt.code)
+		srv := &retryServer{responses: []func(http.ResponseWriter){fail, fail, difyOK}}
+		s := newRetryTestService(t, srv, fastRetry)
+		if err := s.doDifyRequest(context.Background(), http.MethodGet, "/parameters", nil, "application/json", "Parameters API", nil); err != nil {
+			t.Errorf("%s: doDifyRequest: %v", tt.name, err)
+		}
+		if got := srv.attempts(); got != 3 {
+			t.Errorf("%s: attempts = %d, want 3", tt.name, got)
+		}
+	}
+}
+
+func TestDoDifyRequestGivesUpAfterMaxAttempts(t *testing.T) {
+	srv := &retryServer{responses: []func(http.ResponseWriter){difyError(http.StatusServiceUnavailable, "")}}
+	s := newRetryTestService(t, srv, fastRetry)
+	err := s.doDifyRequest(context.Background(), http.MethodGet, "/parameters", nil, "application/json", "Parameters API", nil)
+	var apiErr *DifyAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("error = %v, want the last DifyAPIError", err)
+	}
+	if got := srv.attempts(); got != fastRetry.MaxAttempts {
+		t.Fatalf("attempts = %d, want %d", got, fastRetry.MaxAttempts)
+	}
+}
+
+func TestDoDifyRequestHonorsRetryAfter(t *testing.T) {
+	srv := &retryServer{responses: []func(http.ResponseWriter){difyError(http.StatusTooManyRequests, "", "Retry-After", "1"), difyOK}}
+	s := newRetryTestService(t, srv, fastRetry)
+	if err := s.doDifyRequest(context.Background(), http.MethodGet, "/parameters", nil, "application/json", "Parameters API", nil); err != nil {
+		t.Fatalf("doDifyRequest: %v", err)
+	}
+	if len(srv.times) != 2 {
+		t.Fatalf("attempts = %d, want 2", len(srv.times))
+	}
+	if wait := srv.times[1].Sub(srv.times[0]); wait < time.Second {
+		t.Fatalf("retried after %v, want at least the 1s Retry-After", wait)
+	}
+}
+
+func TestDoDifyRequestStopsAtDeadline(t *testing.T) {
+	// 调用方的截止时间：退避时间超过剩余时间后不再重试
+	srv := &retryServer{responses: []func(http.ResponseWriter){difyError(http.StatusServiceUnavailable, "")}}
+	s := newRetryTestService(t, srv, config.RetryConfig{MaxAttempts: 10, InitialBackoffMs: 100, MaxBackoffMs: 1000})
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.doDifyRequest(ctx, http.MethodGet, "/parameters", nil, "application/json", "Parameters API", nil)
+	if err == nil {
+		t.Fatal("doDifyRequest succeeded, want an error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("doDifyRequest took %v, want it to stop at the 300ms deadline", elapsed)
+	}
+	if got := srv.attempts(); got < 1 || got >= 10 {
+		t.Fatalf("attempts = %d, want the deadline to stop retries before max attempts", got)
+	}
+
+	// 重试策略的总时限：Retry-After 超过总时限时直接返回
+	srv = &retryServer{responses: []func(http.ResponseWriter){difyError(http.StatusTooManyRequests, "", "Retry-After", "5"), difyOK}}
+	s = newRetryTestService(t, srv, config.RetryConfig{MaxAttempts: 3, DeadlineSeconds: 1})
+	start = time.Now()
+	err = s.doDifyRequest(context.Background(), http.MethodGet, "/parameters", nil, "application/json", "Parameters API", nil)
+	var apiErr *DifyAPIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != 5*time.Second {
+		t.Fatalf("error = %v, want the 429 DifyAPIError", err)
+	}
+	if got, elapsed := srv.attempts(), time.Since(start); got != 1 || elapsed > time.Second {
+		t.Fatalf("attempts = %d after %v, want a single attempt without waiting", got, elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 14, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: " 3 ", want: 3 * time.Second},
+		{value: "0", want: 0},
+		{value: "-1", want: 0},
+		{value: "soon", want: 0},
+		{value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second},
+		{value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDeadlineAppliesWhenCallerDeadlineIsLater(t *testing.T) {
+	// 消息处理中的调用总带有 timeouts 的截止时间，重试策略的总时限较早时应以总时限为准，阻塞和流式调用规则相同
+	calls := map[string]func(s *DifyService, ctx context.Context) error{
+		"blocking": func(s *DifyService, ctx context.Context) error {
+			return s.doDifyRequest(ctx, http.MethodGet, "/parameters", nil, "application/json", "Parameters API", nil)
+		},
+		"streaming": func(s *DifyService, ctx context.Context) error {
+			_, err := s.CallDifyChatStreamAPIContext(ctx, DifyChatRequest{Query: "hi", DifyBaseRequest: DifyBaseRequest{User: "tester"}}, nil)
+			return err
+		},
+	}
+	for name, call := range calls {
+		srv := &retryServer{responses: []func(http.ResponseWriter){difyError(http.StatusTooManyRequests, "", "Retry-After", "5"), difyOK}}
+		s := newRetryTestService(t, srv, config.RetryConfig{MaxAttempts: 3, DeadlineSeconds: 1})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		start := time.Now()
+		err := call(s, ctx)
+		cancel()
+		var apiErr *DifyAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("%s: error = %v, want the 429 DifyAPIError", name, err)
+		}
+		if got, elapsed := srv.attempts(), time.Since(start); got != 1 || elapsed > time.Second {
+			t.Errorf("%s: attempts = %d after %v, want a single attempt without waiting for Retry-After", name, got, elapsed)
+		}
+	}
+}
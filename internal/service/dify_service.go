@@ -2,35 +2,65 @@ package service
 
 import (
 	"bytes"                      // 导入 bytes 包，用于处理字节缓冲区，例如构建 HTTP 请求体
-	"dify2wxbot/internal/config" // 导入 config 包，用于加载应用程序配置，例如 Dify API Key 和 BaseURL
+	"context"                    // 导入 context 包，用于取消请求以及限制请求及其重试的总时限
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取 Dify 应用配置，例如 API Key 和 BaseURL
 	"encoding/json"              // 导入 encoding/json 包，用于 JSON 数据的编解码
 	"fmt"                        // 导入 fmt 包，用于格式化字符串和错误信息
 	"io"                         // 导入 io 包，用于 IO 操作，例如读取响应体和文件内容
-	"log"                        // 导入 log 包，用于日志输出
+	"log/slog"                   // 导入 log/slog 包，用于结构化日志输出
 	"mime/multipart"             // 导入 mime/multipart 包，用于处理 multipart/form-data 格式的请求，主要用于文件上传
 	"net/http"                   // 导入 net/http 包，用于构建和发送 HTTP 请求
+	"net/url"                    // 导入 net/url 包，用于构建查询参数
 	"os"                         // 导入 os 包，用于文件操作，例如打开文件
 	"path/filepath"              // 导入 path/filepath 包，用于处理文件路径，例如获取文件名
-	"time"                       // 导入 time 包，用于处理时间相关操作，例如设置 HTTP 客户端超时和重试间隔
+	"strconv"                    // 导入 strconv 包，用于将整数转换为查询参数
+	"time"                       // 导入 time 包，用于处理时间相关操作，例如设置下载文件的超时时间
 )
 
 // DifyService 结构体定义了与 Dify API 交互的服务
 // 它封装了 HTTP 客户端和 Dify 相关的配置，提供了调用 Dify 各类 API 的方法。
 type DifyService struct {
-	httpClient *http.Client      // httpClient 是一个 HTTP 客户端实例，用于发送请求并复用连接，提高效率
-	cfg        *config.AppConfig // cfg 是应用程序配置，用于获取 Dify API 相关的设置，如 API Key 和 Base URL
+	httpClient   *http.Client      // httpClient 是一个 HTTP 客户端实例，用于发送请求并复用连接，请求的截止时间由 context 控制
+	streamClient *http.Client      // streamClient 用于流式 (SSE) 请求，不设置整体超时，由空闲超时控制连接寿命
+	app          config.DifyConfig // app 是该服务对应的 Dify 应用配置，如 API Key、Base URL 和应用类型
+	retry        retryPolicy       // retry 是调用 Dify API 失败时的重试策略
+	output       *workflowOutput   // output 是解析后的工作流输出配置，仅 workflow 类型应用使用
 }
 
 // NewDifyService 创建并返回一个新的 DifyService 实例
-// cfg: 应用程序配置，用于初始化 DifyService
-// 它初始化一个带有默认超时时间的 HTTP 客户端，确保 API 请求不会无限期等待。
-func NewDifyService(cfg *config.AppConfig) *DifyService {
-	return &DifyService{
+// app: Dify 应用配置，每个 Dify 应用对应一个 DifyService
+// retry: 重试策略配置，未配置的项使用默认值
+// 请求不使用固定的客户端超时，调用方通过 context 设置截止时间；未设置时使用重试策略中的总时限，确保 API 请求不会无限期等待。
+func NewDifyService(app config.DifyConfig, retry config.RetryConfig) *DifyService {
+	s := &DifyService{
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second, // 设置 HTTP 请求的默认超时时间为 30 秒
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
 		},
-		cfg: cfg, // 初始化 DifyService 的 cfg 字段
+		streamClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				ResponseHeaderTimeout: 30 * time.Second, // 仅限制等待响应头的时间，响应体按事件持续读取
+			},
+		},
+		app:   app,                   // 初始化 DifyService 的应用配置
+		retry: newRetryPolicy(retry), // 根据配置初始化重试策略
+	}
+	s.retry.onRetry = s.observeRetry // 每次重试都记录到指标中
+	output, err := newWorkflowOutput(app.WorkflowOutput)
+	if err != nil {
+		// 启动时已通过 ValidateWorkflowOutput 检查过模板，这里只在直接创建 DifyService 时出现，不使用模板继续运行
+		slog.Error("[DifyService] 工作流输出模板无效，将按字段逐行列出输出", "app", app.Name, "error", err)
+		output = &workflowOutput{cfg: app.WorkflowOutput}
 	}
+	s.output = output
+	return s
+}
+
+// Name 返回该服务对应的 Dify 应用名称
+func (s *DifyService) Name() string {
+	return s.app.Name
 }
 
 // DifyAPIErrorResponse 定义 Dify API 错误响应的结构
@@ -75,106 +105,179 @@ type DifyWorkflowRequest struct {
 
 // DifyChatResponse 定义 Dify 聊天型应用成功响应的结构
 type DifyChatResponse struct {
-	Answer string `json:"answer"` // AI 回复的答案文本
-	// ... 其他聊天特有字段，根据 Dify 实际响应补充，例如 `conversation_id`, `message_id` 等
+	Answer         string               `json:"answer"`          // AI 回复的答案文本
+	ConversationID string               `json:"conversation_id"` // 对话 ID，Dify 新建会话时会返回新的 ID
+	MessageID      string               `json:"message_id"`      // 消息 ID，唯一标识本次回复
+	TaskID         string               `json:"task_id"`         // 任务 ID，可用于停止流式响应
+	Metadata       DifyResponseMetadata `json:"metadata"`        // 元数据，包含本次回复的 token 用量
+	// ... 其他聊天特有字段，根据 Dify 实际响应补充
 }
 
 // DifyCompletionResponse 定义 Dify 补全型应用成功响应的结构
 type DifyCompletionResponse struct {
-	Text string `json:"text"` // AI 回复的补全文本
+	Text      string               `json:"text"`       // AI 回复的补全文本
+	MessageID string               `json:"message_id"` // 消息 ID，唯一标识本次回复
+	Metadata  DifyResponseMetadata `json:"metadata"`   // 元数据，包含本次回复的 token 用量
 	// ... 其他补全特有字段，根据 Dify 实际响应补充
 }
 
+// DifyResponseMetadata 定义 Dify 响应中的 metadata 字段，解析 token 用量和知识库检索结果
+type DifyResponseMetadata struct {
+	Usage              DifyUsage               `json:"usage"`               // Usage 是本次调用的 token 用量
+	RetrieverResources []DifyRetrieverResource `json:"retriever_resources"` // RetrieverResources 是回答引用的知识库分段，应用未关联知识库时为空
+}
+
+// DifyRetrieverResource 定义 Dify 响应 metadata.retriever_resources 中的一条知识库检索结果
+type DifyRetrieverResource struct {
+	Position        int     `json:"position"`         // 在检索结果中的序号，从 1 开始
+	DatasetID       string  `json:"dataset_id"`       // 知识库 ID
+	DatasetName     string  `json:"dataset_name"`     // 知识库名称
+	DocumentID      string  `json:"document_id"`      // 文档 ID
+	DocumentName    string  `json:"document_name"`    // 文档名称
+	SegmentID       string  `json:"segment_id"`       // 分段 ID
+	SegmentPosition int     `json:"segment_position"` // 分段在文档中的位置，从 1 开始，较早的 Dify 版本不返回
+	Score           float64 `json:"score"`            // 与问题的相关度，通常在 0 到 1 之间
+	Content         string  `json:"content"`          // 分段内容
+}
+
+// DifyUsage 定义 Dify 响应 metadata.usage 中的 token 用量
+type DifyUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`     // 提示词消耗的 token 数
+	CompletionTokens int `json:"completion_tokens"` // 回复消耗的 token 数
+	TotalTokens      int `json:"total_tokens"`      // 总 token 数
+}
+
 // DifyWorkflowResponse 定义 Dify 工作流型应用成功响应的结构
+// 运行失败时 Dify 同样返回 200，data.status 为 "failed" 或 "stopped"，data.error 为错误信息。
 type DifyWorkflowResponse struct {
-	Data map[string]interface{} `json:"data"` // 工作流执行结果数据，通常是一个 JSON 对象
-	// ... 其他工作流特有字段，根据 Dify 实际响应补充
+	WorkflowRunID string                 `json:"workflow_run_id"` // 工作流运行 ID
+	TaskID        string                 `json:"task_id"`         // 任务 ID
+	Data          map[string]interface{} `json:"data"`            // 工作流执行结果数据，包含 status、outputs、error、elapsed_time 等字段
+}
+
+// DifyMessage 定义 Dify 对话历史中的一条消息
+type DifyMessage struct {
+	ID             string `json:"id"`              // 消息 ID
+	ConversationID string `json:"conversation_id"` // 消息所属的对话 ID
+	Query          string `json:"query"`           // 用户的提问
+	Answer         string `json:"answer"`          // AI 的回答
+	CreatedAt      int64  `json:"created_at"`      // 消息创建时间 (Unix 秒)
+}
+
+// DifyMessagesResponse 定义 Dify 对话历史 API 成功响应的结构
+type DifyMessagesResponse struct {
+	Data    []DifyMessage `json:"data"`     // 消息列表，按时间倒序排列
+	HasMore bool          `json:"has_more"` // 是否还有更早的消息
+	Limit   int           `json:"limit"`    // 本次返回的最大条数
+}
+
+// DifySuggestedResponse 定义 Dify 推荐问题 API 成功响应的结构
+type DifySuggestedResponse struct {
+	Result string   `json:"result"` // 固定为 "success"
+	Data   []string `json:"data"`   // 推荐的下一步问题
 }
 
 const (
-	difyChatMessagesPath       = "/v1/chat-messages"       // Dify 聊天消息 API 的相对路径
-	difyCompletionMessagesPath = "/v1/completion-messages" // Dify 补全消息 API 的相对路径
-	difyWorkflowRunPath        = "/v1/workflows/run"       // Dify 工作流运行 API 的相对路径
-	responseModeBlocking       = "blocking"                // Dify API 响应模式：阻塞模式，表示等待完整响应
-	defaultRole                = "员工"                      // Dify API 请求中 inputs 字段的默认角色，如果未指定
-	maxRetries                 = 3                         // API 请求失败时的最大重试次数
-	difyFileUploadPath         = "/files/upload"           // Dify 文件上传 API 的相对路径
+	difySuggestedPathFormat    = "/v1/messages/%s/suggested" // Dify 推荐问题 API 的相对路径，%s 为消息 ID
+	difyFeedbackPathFormat     = "/v1/messages/%s/feedbacks" // Dify 消息反馈 API 的相对路径，%s 为消息 ID
+	difyMessagesPath           = "/v1/messages"              // Dify 对话历史 API 的相对路径
+	difyChatMessagesPath       = "/v1/chat-messages"         // Dify 聊天消息 API 的相对路径
+	difyCompletionMessagesPath = "/v1/completion-messages"   // Dify 补全消息 API 的相对路径
+	difyWorkflowRunPath        = "/v1/workflows/run"         // Dify 工作流运行 API 的相对路径
+	responseModeBlocking       = "blocking"                  // Dify API 响应模式：阻塞模式，表示等待完整响应
+	responseModeStreaming      = "streaming"                 // Dify API 响应模式：流式模式，通过 SSE 逐块返回
+	defaultRole                = "员工"                        // Dify API 请求中 inputs 字段的默认角色，如果未指定
+	difyFileUploadPath         = "/files/upload"             // Dify 文件上传 API 的相对路径
+	difyChatStopPathFormat     = "/v1/chat-messages/%s/stop" // Dify 停止响应 API 的相对路径，%s 为任务 ID
+	difyParametersPath         = "/v1/parameters"            // Dify 应用参数 API 的相对路径，用于检查 Dify 是否可用
+	downloadTimeout            = 60 * time.Second            // 调用方未设置截止时间时，下载文件的超时时间
 )
 
 // doDifyRequest 是一个通用的辅助函数，用于发送 Dify API 请求并处理响应
 // 该函数封装了 HTTP 请求的创建、发送、认证、重试机制以及错误和成功响应的解析。
+// 每次尝试都会基于 body 重新构建请求，确保重试时发送完整的请求体；重试规则见 retryPolicy。
+// ctx 被取消或超过截止时间时立即中止请求和重试等待；重试还受重试策略的总时限约束 (与 ctx 的截止时间以较早者为准)，
+// 与流式请求的规则相同：超过总时限后不再重试，但不会中断进行中的请求。
+// ctx: 请求上下文
 // method: HTTP 方法 (e.g., "POST", "GET")
 // path: Dify API 的相对路径 (e.g., "/v1/chat-messages")
-// body: 请求体 (io.Reader 接口)，可以是 nil，用于 POST/PUT 请求的数据
+// body: 请求体字节，可以是 nil，用于 POST/PUT 请求的数据
 // contentType: Content-Type 头，例如 "application/json", "multipart/form-data"
 // responseStruct: 用于解析成功响应的结构体指针，如果不需要解析响应体，可以传入 nil
 // logPrefix: 日志前缀，用于区分不同的 API 调用，便于日志追踪 (e.g., "Chat API", "File Upload API")
-func (s *DifyService) doDifyRequest(method, path string, body io.Reader, contentType, logPrefix string, responseStruct interface{}) error {
-	fullURL := fmt.Sprintf("%s%s", s.cfg.Dify.BaseURL, path) // 拼接完整的 Dify API 请求 URL
-	log.Printf("[DifyService] %s 请求 URL: %s", logPrefix, fullURL)
-
-	req, err := http.NewRequest(method, fullURL, body) // 创建新的 HTTP 请求
-	if err != nil {
-		return fmt.Errorf("failed to create %s http request: %w", logPrefix, err) // 如果请求创建失败，返回错误
-	}
-
-	req.Header.Set("Content-Type", contentType)                  // 设置请求的 Content-Type 头
-	req.Header.Set("Authorization", "Bearer "+s.cfg.Dify.APIKey) // 设置 Authorization 头，携带 Dify API Key 进行认证
-
-	var resp *http.Response // 用于存储 HTTP 响应
-	// 循环重试机制，最多重试 maxRetries 次
-	for i := 0; i < maxRetries; i++ {
-		resp, err = s.httpClient.Do(req) // 使用 DifyService 的 HTTP 客户端发送请求
-		if err == nil {                  // 如果请求成功（没有网络错误），则跳出重试循环
-			break
+func (s *DifyService) doDifyRequest(ctx context.Context, method, path string, body []byte, contentType, logPrefix string, responseStruct interface{}) error {
+	fullURL := fmt.Sprintf("%s%s", s.app.BaseURL, path) // 拼接完整的 Dify API 请求 URL
+	slog.DebugContext(ctx, "[DifyService] 发送 Dify API 请求", "api", logPrefix, "method", method, "url", fullURL)
+	start := time.Now()
+
+	// 重试使用独立的截止时间，与流式请求相同；ctx 没有截止时间时，总时限同时作为单次请求的上限，避免 Dify 无响应时一直等待
+	retryCtx, cancel := context.WithTimeout(ctx, s.retry.deadline)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		ctx = retryCtx
+	}
+
+	var respBody []byte // 用于存储成功响应的响应体
+	err := s.retry.do(retryCtx, logPrefix, func() error {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body) // 每次尝试都重新构建请求体
 		}
-		log.Printf("[DifyService] %s 请求失败，正在重试 %d/%d 次: %v", logPrefix, i+1, maxRetries, err)
-		if i < maxRetries-1 { // 如果不是最后一次重试，则等待一段时间再重试
-			time.Sleep(time.Duration(i+1) * time.Second) // 每次重试等待时间递增
+		req, err := http.NewRequestWithContext(ctx, method, fullURL, reader) // 创建新的 HTTP 请求
+		if err != nil {
+			return fmt.Errorf("failed to create %s http request: %w", logPrefix, err) // 如果请求创建失败，返回错误
 		}
-	}
-	if err != nil {
-		return fmt.Errorf("%s 请求在 %d 次重试后仍然失败: %w", logPrefix, maxRetries, err) // 如果所有重试都失败，返回错误
-	}
-	defer resp.Body.Close() // 确保在函数返回前关闭响应体，释放资源
+		req.Header.Set("Content-Type", contentType)             // 设置请求的 Content-Type 头
+		req.Header.Set("Authorization", "Bearer "+s.app.APIKey) // 设置 Authorization 头，携带 Dify API Key 进行认证
 
-	respBody, err := io.ReadAll(resp.Body) // 读取完整的响应体内容
-	if err != nil {
-		return fmt.Errorf("failed to read %s 响应体: %w", logPrefix, err) // 如果读取响应体失败，返回错误
-	}
+		resp, err := s.httpClient.Do(req) // 使用 DifyService 的 HTTP 客户端发送请求
+		if err != nil {
+			return &transportError{err: err}
+		}
+		defer resp.Body.Close() // 确保在本次尝试结束前关闭响应体，释放资源
+
+		data, err := io.ReadAll(resp.Body) // 读取完整的响应体内容
+		if err != nil {
+			return &transportError{err: fmt.Errorf("failed to read %s 响应体: %w", logPrefix, err)}
+		}
 
-	log.Printf("[DifyService] %s 响应状态码: %d", logPrefix, resp.StatusCode) // 记录响应状态码
-	log.Printf("[DifyService] %s 响应体: %s", logPrefix, string(respBody))  // 记录完整的响应体内容
+		slog.DebugContext(ctx, "[DifyService] 收到 Dify API 响应", "api", logPrefix, "status", resp.StatusCode, "body", string(data)) // 响应体包含回答内容，只在 debug 级别记录
 
-	// 检查 HTTP 状态码是否为 200 OK
-	if resp.StatusCode != http.StatusOK {
-		var errorResponse DifyAPIErrorResponse
-		// 尝试将错误响应体解析为 DifyAPIErrorResponse 结构体
-		if err := json.Unmarshal(respBody, &errorResponse); err == nil {
-			// 如果解析成功，返回 Dify 提供的具体错误信息
-			return fmt.Errorf("%s 错误: 错误码: %s, 消息: %s", logPrefix, errorResponse.Code, errorResponse.Message)
+		// 检查 HTTP 状态码是否为 200 OK (删除对话等接口返回 204 No Content)，其他状态码返回 DifyAPIError，由重试策略决定是否重试
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+			return newDifyAPIError(logPrefix, resp, data)
 		}
-		// 如果解析失败，返回通用的错误状态码和原始响应体
-		return fmt.Errorf("%s 返回错误状态码 %d: %s", logPrefix, resp.StatusCode, string(respBody))
+		respBody = data
+		return nil
+	})
+	s.observeRequest(logPrefix, start, err)
+	if err != nil {
+		return err
 	}
 
-	// 如果提供了 responseStruct，则将成功响应体解析到该结构体
-	if responseStruct != nil {
+	// 如果提供了 responseStruct 且响应体不为空，则将成功响应体解析到该结构体
+	if responseStruct != nil && len(respBody) > 0 {
 		if err := json.Unmarshal(respBody, responseStruct); err != nil {
 			return fmt.Errorf("failed to parse %s 成功响应: %w", logPrefix, err) // 如果解析失败，返回错误
 		}
 	}
 
-	log.Printf("[DifyService] %s 调用成功。", logPrefix) // 记录 API 调用成功日志
+	slog.InfoContext(ctx, "[DifyService] Dify API 调用成功", "api", logPrefix, "app", s.app.Name, "duration", time.Since(start).String()) // 记录 API 调用成功日志
 	return nil
 }
 
 // CallDifyChatAPI 调用 Dify 聊天型应用 API 发送消息并获取回复
 // request: DifyChatRequest 结构体，包含查询文本、输入变量、用户标识和对话 ID
 func (s *DifyService) CallDifyChatAPI(request DifyChatRequest) (DifyChatResponse, error) {
-	log.Printf("[DifyService] 调用 Chat API，用户: '%s', 对话ID: '%s', 查询: '%s'", request.User, request.ConversationID, request.Query)
+	return s.CallDifyChatAPIContext(context.Background(), request)
+}
+
+// CallDifyChatAPIContext 与 CallDifyChatAPI 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) CallDifyChatAPIContext(ctx context.Context, request DifyChatRequest) (DifyChatResponse, error) {
+	slog.InfoContext(ctx, "[DifyService] 调用 Chat API", "app", s.app.Name, "user", request.User, "conversation_id", request.ConversationID)
+	slog.DebugContext(ctx, "[DifyService] Chat API 查询内容", "query", request.Query)
 	// 检查 Dify Base URL 和 API Key 是否已配置
-	if s.cfg.Dify.BaseURL == "" || s.cfg.Dify.APIKey == "" {
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
 		return DifyChatResponse{}, fmt.Errorf("dify base url 或 api key 未配置")
 	}
 
@@ -196,12 +299,13 @@ func (s *DifyService) CallDifyChatAPI(request DifyChatRequest) (DifyChatResponse
 
 	var response DifyChatResponse // 用于存储 Dify 聊天 API 的成功响应
 	err = s.doDifyRequest(
-		"POST",                    // HTTP 方法为 POST
-		difyChatMessagesPath,      // 聊天消息 API 的相对路径
-		bytes.NewBuffer(jsonData), // 请求体为 JSON 数据
-		"application/json",        // Content-Type 为 application/json
-		"Chat API",                // 日志前缀
-		&response,                 // 响应解析目标
+		ctx,                  // 请求上下文，用于取消请求和设置截止时间
+		"POST",               // HTTP 方法为 POST
+		difyChatMessagesPath, // 聊天消息 API 的相对路径
+		jsonData,             // 请求体为 JSON 数据
+		"application/json",   // Content-Type 为 application/json
+		"Chat API",           // 日志前缀
+		&response,            // 响应解析目标
 	)
 	if err != nil {
 		return DifyChatResponse{}, err // 如果 doDifyRequest 失败，返回错误
@@ -212,6 +316,7 @@ func (s *DifyService) CallDifyChatAPI(request DifyChatRequest) (DifyChatResponse
 		return DifyChatResponse{}, fmt.Errorf("dify chat api 响应未包含有效答案")
 	}
 
+	s.recordUsage(response.Metadata.Usage)
 	return response, nil // 返回成功响应
 }
 
@@ -219,9 +324,23 @@ func (s *DifyService) CallDifyChatAPI(request DifyChatRequest) (DifyChatResponse
 // fileURL: 文件的远程 URL
 // outputPath: 文件保存的本地路径
 func (s *DifyService) DownloadFile(fileURL, outputPath string) error {
-	log.Printf("[DifyService] 尝试从 URL '%s' 下载文件到 '%s'", fileURL, outputPath)
+	return s.DownloadFileContext(context.Background(), fileURL, outputPath)
+}
 
-	resp, err := s.httpClient.Get(fileURL) // 发送 GET 请求下载文件
+// DownloadFileContext 与 DownloadFile 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) DownloadFileContext(ctx context.Context, fileURL, outputPath string) error {
+	slog.DebugContext(ctx, "[DifyService] 尝试下载文件", "url", fileURL, "path", outputPath)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, downloadTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request for %s: %w", fileURL, err)
+	}
+	resp, err := s.httpClient.Do(req) // 发送 GET 请求下载文件
 	if err != nil {
 		return fmt.Errorf("failed to download file from %s: %w", fileURL, err) // 如果下载失败，返回错误
 	}
@@ -242,7 +361,7 @@ func (s *DifyService) DownloadFile(fileURL, outputPath string) error {
 		return fmt.Errorf("failed to write downloaded file to %s: %w", outputPath, err) // 如果写入文件失败，返回错误
 	}
 
-	log.Printf("[DifyService] 文件成功下载到 '%s'", outputPath) // 记录文件下载成功日志
+	slog.InfoContext(ctx, "[DifyService] 文件下载成功", "path", outputPath) // 记录文件下载成功日志
 	return nil
 }
 
@@ -250,9 +369,20 @@ func (s *DifyService) DownloadFile(fileURL, outputPath string) error {
 // filePath: 本地文件路径，待上传的文件在本地文件系统中的路径
 // user: 用户唯一标识，用于 Dify 关联文件上传和用户
 func (s *DifyService) UploadFile(filePath, user string) (map[string]interface{}, error) {
-	log.Printf("[DifyService] 尝试上传文件 '%s' 到 Dify，用户: '%s'", filePath, user)
+	return s.UploadFileContext(context.Background(), filePath, user)
+}
+
+// UploadFileContext 与 UploadFile 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) UploadFileContext(ctx context.Context, filePath, user string) (map[string]interface{}, error) {
+	return s.uploadFile(ctx, filePath, filepath.Base(filePath), user)
+}
+
+// uploadFile 将本地文件以 fileName 为文件名上传到 Dify
+// 本地文件通常是以随机名称保存的临时文件，fileName 是用户上传时的原始文件名。
+func (s *DifyService) uploadFile(ctx context.Context, filePath, fileName, user string) (map[string]interface{}, error) {
+	slog.InfoContext(ctx, "[DifyService] 尝试上传文件到 Dify", "app", s.app.Name, "file", filePath, "file_name", fileName, "user", user)
 	// 检查 Dify Base URL 和 API Key 是否已配置
-	if s.cfg.Dify.BaseURL == "" || s.cfg.Dify.APIKey == "" {
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
 		return nil, fmt.Errorf("dify base url 或 api key 未配置")
 	}
 
@@ -266,7 +396,7 @@ func (s *DifyService) UploadFile(filePath, user string) (map[string]interface{},
 	writer := multipart.NewWriter(body) // 创建 multipart 写入器
 
 	// 创建文件表单字段，将文件内容写入请求体
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath)) // "file" 是 Dify API 期望的文件字段名
+	part, err := writer.CreateFormFile("file", fileName) // "file" 是 Dify API 期望的文件字段名
 	if err != nil {
 		return nil, fmt.Errorf("failed to create form file: %w", err) // 如果创建表单文件失败，返回错误
 	}
@@ -283,9 +413,10 @@ func (s *DifyService) UploadFile(filePath, user string) (map[string]interface{},
 
 	var response map[string]interface{} // 用于存储 Dify 文件上传 API 的成功响应
 	err = s.doDifyRequest(
+		ctx,                          // 请求上下文，用于取消请求和设置截止时间
 		"POST",                       // HTTP 方法为 POST
 		difyFileUploadPath,           // 文件上传 API 的相对路径
-		body,                         // 请求体为 multipart 数据
+		body.Bytes(),                 // 请求体为 multipart 数据
 		writer.FormDataContentType(), // Content-Type 为 multipart/form-data
 		"File Upload API",            // 日志前缀
 		&response,                    // 响应解析目标
@@ -294,16 +425,22 @@ func (s *DifyService) UploadFile(filePath, user string) (map[string]interface{},
 		return nil, err // 如果 doDifyRequest 失败，返回错误
 	}
 
-	log.Println("[DifyService] 文件上传成功。") // 记录文件上传成功日志
-	return response, nil                 // 返回成功响应
+	slog.InfoContext(ctx, "[DifyService] 文件上传成功") // 记录文件上传成功日志
+	return response, nil                          // 返回成功响应
 }
 
 // CallDifyCompletionAPI 调用 Dify 补全型应用 API 发送消息并获取回复
 // request: DifyCompletionRequest 结构体，包含提示词、输入变量、用户标识
 func (s *DifyService) CallDifyCompletionAPI(request DifyCompletionRequest) (DifyCompletionResponse, error) {
-	log.Printf("[DifyService] 调用 Completion API，用户: '%s', 提示词: '%s'", request.User, request.Prompt)
+	return s.CallDifyCompletionAPIContext(context.Background(), request)
+}
+
+// CallDifyCompletionAPIContext 与 CallDifyCompletionAPI 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) CallDifyCompletionAPIContext(ctx context.Context, request DifyCompletionRequest) (DifyCompletionResponse, error) {
+	slog.InfoContext(ctx, "[DifyService] 调用 Completion API", "app", s.app.Name, "user", request.User)
+	slog.DebugContext(ctx, "[DifyService] Completion API 提示词内容", "prompt", request.Prompt)
 	// 检查 Dify Base URL 和 API Key 是否已配置
-	if s.cfg.Dify.BaseURL == "" || s.cfg.Dify.APIKey == "" {
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
 		return DifyCompletionResponse{}, fmt.Errorf("dify base url 或 api key 未配置")
 	}
 
@@ -325,9 +462,10 @@ func (s *DifyService) CallDifyCompletionAPI(request DifyCompletionRequest) (Dify
 
 	var response DifyCompletionResponse // 用于存储 Dify 补全 API 的成功响应
 	err = s.doDifyRequest(
+		ctx,                        // 请求上下文，用于取消请求和设置截止时间
 		"POST",                     // HTTP 方法为 POST
 		difyCompletionMessagesPath, // 补全消息 API 的相对路径
-		bytes.NewBuffer(jsonData),  // 请求体为 JSON 数据
+		jsonData,                   // 请求体为 JSON 数据
 		"application/json",         // Content-Type 为 application/json
 		"Completion API",           // 日志前缀
 		&response,                  // 响应解析目标
@@ -341,15 +479,21 @@ func (s *DifyService) CallDifyCompletionAPI(request DifyCompletionRequest) (Dify
 		return DifyCompletionResponse{}, fmt.Errorf("dify completion api 响应未包含有效文本")
 	}
 
+	s.recordUsage(response.Metadata.Usage)
 	return response, nil // 返回成功响应
 }
 
 // CallDifyWorkflowAPI 调用 Dify 工作流型应用 API 运行工作流并获取结果
 // request: DifyWorkflowRequest 结构体，包含输入变量、用户标识和工作流 ID
 func (s *DifyService) CallDifyWorkflowAPI(request DifyWorkflowRequest) (DifyWorkflowResponse, error) {
-	log.Printf("[DifyService] 调用 Workflow API，用户: '%s', 工作流ID: '%s'", request.User, request.WorkflowID)
+	return s.CallDifyWorkflowAPIContext(context.Background(), request)
+}
+
+// CallDifyWorkflowAPIContext 与 CallDifyWorkflowAPI 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) CallDifyWorkflowAPIContext(ctx context.Context, request DifyWorkflowRequest) (DifyWorkflowResponse, error) {
+	slog.InfoContext(ctx, "[DifyService] 调用 Workflow API", "app", s.app.Name, "user", request.User, "workflow_id", request.WorkflowID)
 	// 检查 Dify Base URL 和 API Key 是否已配置
-	if s.cfg.Dify.BaseURL == "" || s.cfg.Dify.APIKey == "" {
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
 		return DifyWorkflowResponse{}, fmt.Errorf("dify base url 或 api key 未配置")
 	}
 
@@ -368,12 +512,13 @@ func (s *DifyService) CallDifyWorkflowAPI(request DifyWorkflowRequest) (DifyWork
 
 	var response DifyWorkflowResponse // 用于存储 Dify 工作流 API 的成功响应
 	err = s.doDifyRequest(
-		"POST",                    // HTTP 方法为 POST
-		difyWorkflowRunPath,       // 工作流运行 API 的相对路径
-		bytes.NewBuffer(jsonData), // 请求体为 JSON 数据
-		"application/json",        // Content-Type 为 application/json
-		"Workflow API",            // 日志前缀
-		&response,                 // 响应解析目标
+		ctx,                 // 请求上下文，用于取消请求和设置截止时间
+		"POST",              // HTTP 方法为 POST
+		difyWorkflowRunPath, // 工作流运行 API 的相对路径
+		jsonData,            // 请求体为 JSON 数据
+		"application/json",  // Content-Type 为 application/json
+		"Workflow API",      // 日志前缀
+		&response,           // 响应解析目标
 	)
 	if err != nil {
 		return DifyWorkflowResponse{}, err // 如果 doDifyRequest 失败，返回错误
@@ -384,5 +529,161 @@ func (s *DifyService) CallDifyWorkflowAPI(request DifyWorkflowRequest) (DifyWork
 		return DifyWorkflowResponse{}, fmt.Errorf("dify workflow api 响应未包含有效数据")
 	}
 
+	s.recordUsage(workflowUsage(response.Data))
 	return response, nil // 返回成功响应
 }
+
+// GetMessages 获取用户在指定对话中的消息历史，按时间倒序分页
+// user: 用户标识
+// conversationID: 对话 ID
+// firstID: 上一页最早一条消息的 ID，为空时返回最近的消息；响应的 has_more 为 true 时可以用本页最早的消息 ID 继续向前翻页
+// limit: 最多返回的消息条数，不大于 0 时使用 Dify 的默认值 (20 条)
+func (s *DifyService) GetMessages(user, conversationID, firstID string, limit int) (DifyMessagesResponse, error) {
+	return s.GetMessagesContext(context.Background(), user, conversationID, firstID, limit)
+}
+
+// GetMessagesContext 与 GetMessages 相同，但使用 ctx 控制请求的取消和截止时间
+func (s *DifyService) GetMessagesContext(ctx context.Context, user, conversationID, firstID string, limit int) (DifyMessagesResponse, error) {
+	slog.InfoContext(ctx, "[DifyService] 获取对话历史", "app", s.app.Name, "user", user, "conversation_id", conversationID, "first_id", firstID, "limit", limit)
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return DifyMessagesResponse{}, fmt.Errorf("dify base url 或 api key 未配置")
+	}
+
+	query := url.Values{}
+	query.Set("user", user)
+	query.Set("conversation_id", conversationID)
+	if firstID != "" {
+		query.Set("first_id", firstID)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var response DifyMessagesResponse
+	err := s.doDifyRequest(
+		ctx,                                 // 请求上下文，用于取消请求和设置截止时间
+		"GET",                               // HTTP 方法为 GET
+		difyMessagesPath+"?"+query.Encode(), // 对话历史 API 的相对路径和查询参数
+		nil,                                 // GET 请求没有请求体
+		"application/json",                  // Content-Type 为 application/json
+		"Messages API",                      // 日志前缀
+		&response,                           // 响应解析目标
+	)
+	if err != nil {
+		return DifyMessagesResponse{}, err
+	}
+	return response, nil
+}
+
+// GetSuggestedQuestions 获取 Dify 针对某条回答生成的下一步问题建议
+// 需要在 Dify 应用中开启 "下一步问题建议"，未开启时 Dify 返回错误。
+// messageID: 回答的消息 ID
+// user: 用户标识，必须与发起提问时的用户一致
+func (s *DifyService) GetSuggestedQuestions(messageID, user string) ([]string, error) {
+	return s.GetSuggestedQuestionsContext(context.Background(), messageID, user)
+}
+
+// GetSuggestedQuestionsContext 与 GetSuggestedQuestions 相同，但会在 ctx 被取消或超过截止时间时中止请求和重试
+func (s *DifyService) GetSuggestedQuestionsContext(ctx context.Context, messageID, user string) ([]string, error) {
+	slog.DebugContext(ctx, "[DifyService] 获取推荐问题", "app", s.app.Name, "user", user, "message_id", messageID)
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return nil, fmt.Errorf("dify base url 或 api key 未配置")
+	}
+
+	query := url.Values{}
+	query.Set("user", user)
+
+	var response DifySuggestedResponse
+	err := s.doDifyRequest(
+		ctx,   // 请求上下文
+		"GET", // HTTP 方法为 GET
+		fmt.Sprintf(difySuggestedPathFormat, url.PathEscape(messageID))+"?"+query.Encode(), // 推荐问题 API 的相对路径和查询参数
+		nil,                // GET 请求没有请求体
+		"application/json", // Content-Type 为 application/json
+		"Suggested API",    // 日志前缀
+		&response,          // 响应解析目标
+	)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// SendMessageFeedback 对一条回答提交点赞或点踩，同一条回答再次提交时覆盖之前的评价
+// messageID: 回答的消息 ID
+// rating: 评价，FeedbackLike 或 FeedbackDislike
+// user: 用户标识，必须与发起提问时的用户一致
+// content: 评价的具体说明，可以为空
+func (s *DifyService) SendMessageFeedback(messageID, rating, user, content string) error {
+	return s.SendMessageFeedbackContext(context.Background(), messageID, rating, user, content)
+}
+
+// SendMessageFeedbackContext 与 SendMessageFeedback 相同，但会在 ctx 被取消或超过截止时间时中止请求和重试
+func (s *DifyService) SendMessageFeedbackContext(ctx context.Context, messageID, rating, user, content string) error {
+	slog.InfoContext(ctx, "[DifyService] 提交回答评价", "app", s.app.Name, "user", user, "message_id", messageID, "rating", rating)
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return fmt.Errorf("dify base url 或 api key 未配置")
+	}
+	jsonData, err := json.Marshal(map[string]string{"rating": rating, "user": user, "content": content})
+	if err != nil {
+		return fmt.Errorf("failed to marshal feedback request body: %w", err)
+	}
+	return s.doDifyRequest(
+		ctx,    // 请求上下文
+		"POST", // HTTP 方法为 POST
+		fmt.Sprintf(difyFeedbackPathFormat, url.PathEscape(messageID)), // 消息反馈 API 的相对路径
+		jsonData,           // 请求体为 JSON 数据
+		"application/json", // Content-Type 为 application/json
+		"Feedback API",     // 日志前缀
+		nil,                // 不需要解析响应体
+	)
+}
+
+// StopChatMessage 请求 Dify 停止一次流式响应的生成
+// 仅对流式模式的 chat 应用有效；任务已结束时 Dify 同样返回成功。
+// ctx: 请求上下文
+// taskID: 流式响应事件中携带的任务 ID
+// user: 用户标识，必须与发起请求时的用户一致
+func (s *DifyService) StopChatMessage(ctx context.Context, taskID, user string) error {
+	slog.InfoContext(ctx, "[DifyService] 请求停止生成", "app", s.app.Name, "user", user, "task_id", taskID)
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return fmt.Errorf("dify base url 或 api key 未配置")
+	}
+	jsonData, err := json.Marshal(map[string]string{"user": user})
+	if err != nil {
+		return fmt.Errorf("failed to marshal stop request body: %w", err)
+	}
+	return s.doDifyRequest(
+		ctx,    // 请求上下文
+		"POST", // HTTP 方法为 POST
+		fmt.Sprintf(difyChatStopPathFormat, url.PathEscape(taskID)), // 停止响应 API 的相对路径
+		jsonData,           // 请求体为 JSON 数据
+		"application/json", // Content-Type 为 application/json
+		"Stop API",         // 日志前缀
+		nil,                // 不需要解析响应体
+	)
+}
+
+// Ping 检查 Dify 是否可以访问且 API Key 有效
+// 通过请求应用参数 API 实现，不重试也不记录响应体，适合用于就绪检查。
+// ctx: 请求上下文，调用方应设置较短的截止时间
+func (s *DifyService) Ping(ctx context.Context) error {
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return fmt.Errorf("dify base url 或 api key 未配置")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.app.BaseURL+difyParametersPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create ping request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.app.APIKey)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("dify is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) // 读完响应体以便复用连接
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("dify returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"bufio"         // 导入 bufio 包，用于按行读取 SSE 事件流
+	"bytes"         // 导入 bytes 包，用于处理字节缓冲区，例如构建 HTTP 请求体和解析事件行
+	"context"       // 导入 context 包，用于取消流式请求
+	"encoding/json" // 导入 encoding/json 包，用于 JSON 数据的编解码
+	"fmt"           // 导入 fmt 包，用于格式化字符串和错误信息
+	"io"            // 导入 io 包，用于 IO 操作，例如读取响应体
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/http"      // 导入 net/http 包，用于构建和发送 HTTP 请求
+	"time"          // 导入 time 包，用于处理重试时限和空闲超时
+)
+
+const (
+	streamIdleTimeout  = 60 * time.Second // 流式响应的空闲超时时间，Dify 每 10 秒发送一次 ping，超过该时间无数据视为连接异常
+	maxSSELineBytes    = 1024 * 1024      // 单行 SSE 数据的最大字节数，防止异常数据撑爆内存
+	sseDataPrefix      = "data:"          // SSE 数据行前缀
+	sseEventPrefix     = "event:"         // SSE 事件名行前缀
+	streamEventMessage = "message"        // 文本块事件
+	streamEventAgent   = "agent_message"  // Agent 模式下的文本块事件
+	streamEventEnd     = "message_end"    // 消息结束事件
+	streamEventError   = "error"          // 错误事件
+	streamEventPing    = "ping"           // 心跳事件
+	stopRequestTimeout = 10 * time.Second // 流式响应中止后请求 Dify 停止生成的超时时间
+)
+
+// DifyStreamEvent 定义 Dify 流式响应中单个 SSE 事件的结构
+// 不同事件只会填充其中的部分字段，例如 message 事件携带 answer，error 事件携带 code 和 message。
+type DifyStreamEvent struct {
+	Event          string          `json:"event"`           // 事件类型，例如 "message", "agent_message", "message_end", "error", "ping"
+	TaskID         string          `json:"task_id"`         // 任务 ID，可用于停止流式响应
+	MessageID      string          `json:"message_id"`      // 消息 ID
+	ConversationID string          `json:"conversation_id"` // 对话 ID
+	Answer         string          `json:"answer"`          // 本次事件携带的文本块
+	Metadata       json.RawMessage `json:"metadata"`        // 元数据，仅 message_end 事件携带，例如 usage 和 retriever_resources
+	Status         int             `json:"status"`          // HTTP 状态码，仅 error 事件携带
+	Code           string          `json:"code"`            // 错误码，仅 error 事件携带
+	Message        string          `json:"message"`         // 错误消息，仅 error 事件携带
+}
+
+// readSSE 从 reader 中逐行读取 SSE 事件，并将每个事件的 data 内容交给 handle 处理
+// 多行 data 会按 SSE 规范使用换行拼接；空行表示一个事件结束。
+// onLine 会在每读到一行时被调用，用于刷新空闲超时计时器，可以为 nil。
+func readSSE(r io.Reader, onLine func(), handle func(eventName string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineBytes)
+
+	var eventName string
+	var data bytes.Buffer
+	dispatch := func() error {
+		if data.Len() == 0 {
+			eventName = ""
+			return nil
+		}
+		err := handle(eventName, data.Bytes())
+		eventName = ""
+		data.Reset()
+		return err
+	}
+
+	for scanner.Scan() {
+		if onLine != nil {
+			onLine()
+		}
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0: // 空行表示事件结束
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case line[0] == ':': // 注释行，忽略
+		case bytes.HasPrefix(line, []byte(sseEventPrefix)):
+			eventName = string(bytes.TrimSpace(line[len(sseEventPrefix):]))
+		case bytes.HasPrefix(line, []byte(sseDataPrefix)):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(bytes.TrimPrefix(line[len(sseDataPrefix):], []byte(" ")))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return dispatch() // 处理流末尾没有空行结束的最后一个事件
+}
+
+// CallDifyChatStreamAPI 以流式 (SSE) 模式调用 Dify 聊天型应用 API
+// 每收到一个文本块都会调用 onAnswer，调用方可以借此逐步推送回复；onAnswer 返回错误时会中止读取。
+// 函数返回时 DifyChatResponse.Answer 为拼接后的完整回答。
+// request: DifyChatRequest 结构体，包含查询文本、输入变量、用户标识和对话 ID
+// onAnswer: 文本块回调函数，可以为 nil
+func (s *DifyService) CallDifyChatStreamAPI(request DifyChatRequest, onAnswer func(delta string) error) (DifyChatResponse, error) {
+	return s.CallDifyChatStreamAPIContext(context.Background(), request, onAnswer)
+}
+
+// CallDifyChatStreamAPIContext 与 CallDifyChatStreamAPI 相同，但使用 ctx 控制请求的取消和截止时间
+// ctx 被取消、onAnswer 返回错误或连接空闲超时导致事件流在 message_end 之前中止时，
+// 会调用 Dify 的停止响应接口，避免 Dify 继续为已经无人接收的回答消耗资源。
+func (s *DifyService) CallDifyChatStreamAPIContext(ctx context.Context, request DifyChatRequest, onAnswer func(delta string) error) (DifyChatResponse, error) {
+	slog.InfoContext(ctx, "[DifyService] 调用 Chat API (流式)", "app", s.app.Name, "user", request.User, "conversation_id", request.ConversationID)
+	slog.DebugContext(ctx, "[DifyService] Chat API 查询内容", "query", request.Query)
+	// 检查 Dify Base URL 和 API Key 是否已配置
+	if s.app.BaseURL == "" || s.app.APIKey == "" {
+		return DifyChatResponse{}, fmt.Errorf("dify base url 或 api key 未配置")
+	}
+
+	// 确保 inputs 中包含 "role" 字段，如果不存在则使用默认角色
+	if request.Inputs == nil {
+		request.Inputs = make(map[string]interface{})
+	}
+	if _, ok := request.Inputs["role"]; !ok {
+		request.Inputs["role"] = defaultRole
+	}
+
+	// 设置响应模式为流式
+	request.ResponseMode = responseModeStreaming
+
+	jsonData, err := json.Marshal(request) // 将请求结构体编码为 JSON 字节
+	if err != nil {
+		return DifyChatResponse{}, fmt.Errorf("failed to marshal chat request body: %w", err)
+	}
+
+	resp, err := s.doDifyStreamRequest(ctx, difyChatMessagesPath, jsonData, "Chat Stream API")
+	if err != nil {
+		return DifyChatResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	// 空闲超时：超过 streamIdleTimeout 没有收到任何数据时关闭响应体，使读取立即返回
+	idleTimer := time.AfterFunc(streamIdleTimeout, func() {
+		slog.WarnContext(ctx, "[DifyService] Chat Stream API 长时间未收到数据，关闭连接", "idle_timeout", streamIdleTimeout.String())
+		resp.Body.Close()
+	})
+	defer idleTimer.Stop()
+
+	var response DifyChatResponse
+	var answer bytes.Buffer
+	ended := false
+
+	err = readSSE(resp.Body, func() { idleTimer.Reset(streamIdleTimeout) }, func(eventName string, data []byte) error {
+		if eventName == streamEventPing {
+			return nil
+		}
+		var event DifyStreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			slog.WarnContext(ctx, "[DifyService] Chat Stream API 无法解析事件数据，已忽略", "error", err)
+			return nil
+		}
+		// 记录会话相关的标识，任意事件中出现都以最新值为准
+		if event.ConversationID != "" {
+			response.ConversationID = event.ConversationID
+		}
+		if event.MessageID != "" {
+			response.MessageID = event.MessageID
+		}
+		if event.TaskID != "" {
+			response.TaskID = event.TaskID
+		}
+
+		switch event.Event {
+		case streamEventMessage, streamEventAgent:
+			if event.Answer == "" {
+				return nil
+			}
+			answer.WriteString(event.Answer)
+			if onAnswer != nil {
+				return onAnswer(event.Answer)
+			}
+		case streamEventEnd:
+			ended = true
+			if len(event.Metadata) > 0 {
+				if err := json.Unmarshal(event.Metadata, &response.Metadata); err != nil {
+					slog.WarnContext(ctx, "[DifyService] Chat Stream API 无法解析 message_end 元数据，已忽略", "error", err)
+				}
+			}
+		case streamEventError:
+			return fmt.Errorf("Chat Stream API 错误: 错误码: %s, 消息: %s", event.Code, event.Message)
+		case streamEventPing:
+		default:
+			// 其他事件 (例如 message_file、agent_thought、workflow_started) 暂不处理
+		}
+		return nil
+	})
+	if err != nil {
+		if !ended && response.TaskID != "" {
+			s.stopGeneration(ctx, response.TaskID, request.User)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr // 连接因 ctx 取消而关闭，返回取消原因而不是底层的读取错误
+		}
+		return DifyChatResponse{}, fmt.Errorf("读取 Chat Stream API 事件流失败: %w", err)
+	}
+	if !ended {
+		slog.WarnContext(ctx, "[DifyService] Chat Stream API 事件流在 message_end 之前结束")
+	}
+
+	response.Answer = answer.String()
+	// 检查 Dify 响应中是否包含有效的答案
+	if response.Answer == "" {
+		return DifyChatResponse{}, fmt.Errorf("dify chat api 响应未包含有效答案")
+	}
+
+	s.recordUsage(response.Metadata.Usage)
+	slog.InfoContext(ctx, "[DifyService] Chat Stream API 调用成功", "app", s.app.Name, "answer_length", len(response.Answer))
+	return response, nil
+}
+
+// stopGeneration 在流式响应中止后请求 Dify 停止生成，失败时只记录日志
+// 此时调用方的 ctx 可能已被取消，因此只沿用其中的请求 ID 等值，并使用独立的超时时间。
+func (s *DifyService) stopGeneration(parent context.Context, taskID, user string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), stopRequestTimeout)
+	defer cancel()
+	if err := s.StopChatMessage(ctx, taskID, user); err != nil {
+		slog.WarnContext(ctx, "[DifyService] 请求 Dify 停止生成失败", "task_id", taskID, "error", err)
+	}
+}
+
+// doDifyStreamRequest 发送流式 Dify API 请求并返回尚未读取的 HTTP 响应
+// 与 doDifyRequest 不同，它不会读取成功响应的响应体，调用方负责读取并关闭 resp.Body。
+// 只在收到响应头之前按重试策略重试 (网络错误、HTTP 429/5xx 等)，每次重试都会重新构建请求体；
+// 重试策略的总时限只约束重试，不会中断已经开始的事件流；ctx 同时作用于事件流的读取，ctx 被取消时连接会被关闭。
+// ctx: 请求上下文
+// path: Dify API 的相对路径
+// jsonData: JSON 格式的请求体
+// logPrefix: 日志前缀，用于区分不同的 API 调用
+func (s *DifyService) doDifyStreamRequest(ctx context.Context, path string, jsonData []byte, logPrefix string) (*http.Response, error) {
+	fullURL := fmt.Sprintf("%s%s", s.app.BaseURL, path) // 拼接完整的 Dify API 请求 URL
+	slog.DebugContext(ctx, "[DifyService] 发送 Dify 流式 API 请求", "api", logPrefix, "url", fullURL)
+	start := time.Now()
+
+	// 重试使用独立的截止时间，避免 ctx 上较长的截止时间或者没有截止时间时无限重试
+	retryCtx, cancel := context.WithTimeout(ctx, s.retry.deadline)
+	defer cancel()
+
+	var resp *http.Response
+	err := s.retry.do(retryCtx, logPrefix, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, bytes.NewReader(jsonData))
+		if err != nil {
+			return fmt.Errorf("failed to create %s http request: %w", logPrefix, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Authorization", "Bearer "+s.app.APIKey)
+
+		r, err := s.streamClient.Do(req)
+		if err != nil {
+			return &transportError{err: err}
+		}
+		slog.DebugContext(ctx, "[DifyService] 收到 Dify 流式 API 响应头", "api", logPrefix, "status", r.StatusCode)
+		if r.StatusCode != http.StatusOK {
+			defer r.Body.Close()
+			respBody, _ := io.ReadAll(r.Body)
+			return newDifyAPIError(logPrefix, r, respBody)
+		}
+		resp = r
+		return nil
+	})
+	s.observeRequest(logPrefix, start, err) // 流式请求的耗时只统计到收到响应头为止，事件流的读取时间取决于回答长度
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
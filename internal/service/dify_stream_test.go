@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"dify2wxbot/internal/config"
+)
+
+// sseEvent 是 readSSE 交给 handle 的一个事件
+type sseEvent struct {
+	name string
+	data string
+}
+
+func TestReadSSE(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []sseEvent
+	}{
+		{
+			name:   "single data line",
+			stream: "data: {\"event\": \"message\"}\n\n",
+			want:   []sseEvent{{data: `{"event": "message"}`}},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: first\ndata: second\ndata:third\n\n",
+			want:   []sseEvent{{data: "first\nsecond\nthird"}},
+		},
+		{
+			name:   "ping and comments",
+			stream: ": keep-alive\n\nevent: ping\n\ndata: {}\n\nevent: ping\ndata: \n\n",
+			want:   []sseEvent{{data: "{}"}},
+		},
+		{
+			name:   "named event",
+			stream: "event: message_end\ndata: {\"event\": \"message_end\"}\n\n",
+			want:   []sseEvent{{name: "message_end", data: `{"event": "message_end"}`}},
+		},
+		{
+			name:   "crlf line endings",
+			stream: "data: a\r\n\r\ndata: b\r\n\r\n",
+			want:   []sseEvent{{data: "a"}, {data: "b"}},
+		},
+		{
+			name:   "no trailing blank line",
+			stream: "data: a\n\ndata: b",
+			want:   []sseEvent{{data: "a"}, {data: "b"}},
+		},
+	}
+	for _, tt := range tests {
+		var got []sseEvent
+		lines := 0
+		err := readSSE(strings.NewReader(tt.stream), func() { lines++ }, func(name string, data []byte) error {
+			got = append(got, sseEvent{name: name, data: string(data)})
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: readSSE: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: events = %q, want %q", tt.name, got, tt.want)
+		}
+		if lines == 0 {
+			t.Errorf("%s: onLine was never called", tt.name)
+		}
+	}
+}
+
+// newStreamTestService 创建使用 stream 作为 Chat API 事件流的 DifyService，返回收到的停止生成请求的路径
+func newStreamTestService(t *testing.T, stream string) (*DifyService, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var stops []string
+	dify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stop") {
+			mu.Lock()
+			stops = append(stops, r.URL.Path)
+			mu.Unlock()
+			w.Write([]byte(`{"result": "success"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	}))
+	t.Cleanup(dify.Close)
+	s := NewDifyService(config.DifyConfig{Name: "test", APIKey: "app-test", BaseURL: dify.URL, BotType: "chat"}, fastRetry)
+	return s, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), stops...)
+	}
+}
+
+func TestCallDifyChatStreamAPIAssemblesAnswer(t *testing.T) {
+	stream := "event: ping\n\n" +
+		`data: {"event": "message", "task_id": "t1", "message_id": "m1", "conversation_id": "c1", "answer": "你好"}` + "\n\n" +
+		`data: {"event": "agent_message", "task_id": "t1", "answer": "，世界"}` + "\n\n" +
+		"event: ping\n\n" +
+		`data: {"event": "message_end", "task_id": "t1", "metadata": {"usage": {"total_tokens": 12}}}` // 没有结尾的空行
+	s, stops := newStreamTestService(t, stream)
+
+	var deltas []string
+	resp, err := s.CallDifyChatStreamAPIContext(context.Background(), DifyChatRequest{Query: "hi", DifyBaseRequest: DifyBaseRequest{User: "tester"}},
+		func(delta string) error {
+			deltas = append(deltas, delta)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("CallDifyChatStreamAPIContext: %v", err)
+	}
+	if resp.Answer != "你好，世界" || resp.ConversationID != "c1" || resp.MessageID != "m1" || resp.TaskID != "t1" {
+		t.Fatalf("response = %+v, want the assembled answer and ids", resp)
+	}
+	if !reflect.DeepEqual(deltas, []string{"你好", "，世界"}) {
+		t.Fatalf("deltas = %q, want each text chunk once", deltas)
+	}
+	if got := stops(); len(got) != 0 {
+		t.Fatalf("stop requests = %q, want none after message_end", got)
+	}
+}
+
+func TestCallDifyChatStreamAPIReturnsErrorEvent(t *testing.T) {
+	stream := `data: {"event": "message", "task_id": "t2", "answer": "部分"}` + "\n\n" +
+		`data: {"event": "error", "task_id": "t2", "status": 400, "code": "provider_quota_exceeded", "message": "quota exceeded"}` + "\n\n"
+	s, stops := newStreamTestService(t, stream)
+
+	_, err := s.CallDifyChatStreamAPIContext(context.Background(), DifyChatRequest{Query: "hi", DifyBaseRequest: DifyBaseRequest{User: "tester"}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "provider_quota_exceeded") {
+		t.Fatalf("error = %v, want the error event's code", err)
+	}
+	if got := stops(); !reflect.DeepEqual(got, []string{"/v1/chat-messages/t2/stop"}) {
+		t.Fatalf("stop requests = %q, want Dify asked to stop task t2", got)
+	}
+}
+
+func TestStopChatMessageEscapesTaskID(t *testing.T) {
+	var path string
+	dify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.Write([]byte(`{"result": "success"}`))
+	}))
+	defer dify.Close()
+	s := NewDifyService(config.DifyConfig{Name: "test", APIKey: "app-test", BaseURL: dify.URL, BotType: "chat"}, fastRetry)
+
+	if err := s.StopChatMessage(context.Background(), "../t 1?x", "tester"); err != nil {
+		t.Fatalf("StopChatMessage: %v", err)
+	}
+	if want := "/v1/chat-messages/..%2Ft%201%3Fx/stop"; path != want {
+		t.Fatalf("stop path = %q, want %q", path, want)
+	}
+}
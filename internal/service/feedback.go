@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"      // 导入 fmt 包，用于格式化错误信息
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"strings"  // 导入 strings 包，用于解析评价按钮的 key
+	"sync"     // 导入 sync 包，用于保护回答记录的并发访问
+	"time"     // 导入 time 包，用于回答记录的过期时间
+
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于统计评价结果
+	"dify2wxbot/pkg/sender"       // 导入 pkg/sender 包，用于向每个投递目标发送评价按钮卡片
+	"dify2wxbot/pkg/wecom"        // 导入 pkg/wecom 包，用于构建评价按钮卡片
+)
+
+const (
+	FeedbackLike    = "like"    // FeedbackLike 是 Dify 消息反馈 API 中的点赞
+	FeedbackDislike = "dislike" // FeedbackDislike 是 Dify 消息反馈 API 中的点踩
+
+	answerRetention        = 24 * time.Hour         // 回答在发送后多长时间内可以被评价
+	maxTrackedAnswers      = 10000                  // 最多记录的回答数，超出时丢弃最早的回答
+	feedbackKeyPrefix      = "dify2wxbot_feedback:" // 评价按钮 key 的前缀，后接 "good:" 或 "bad:" 和回答的消息 ID
+	feedbackTaskIDPrefix   = "feedback_"            // 评价卡片 task_id 的前缀，后接回答的消息 ID
+	feedbackResultNotFound = "not_found"            // 评价指标的结果标签：没有找到可评价的回答
+)
+
+// trackedAnswer 是一条已发送的 Dify 回答，用于将用户的评价提交到对应的应用和消息
+type trackedAnswer struct {
+	App       string    // App 是产生回答的 Dify 应用名称
+	User      string    // User 是提问的用户标识，提交评价时必须与提问时一致
+	MessageID string    // MessageID 是回答在 Dify 中的消息 ID
+	SentAt    time.Time // SentAt 是回答发送完成的时间
+}
+
+// answerTracker 记录最近发送的回答，既可以按消息 ID 查找 (评价按钮)，也可以查找用户最近的一条回答 (/good 和 /bad 命令)
+type answerTracker struct {
+	mu        sync.Mutex               // mu 保护 byMessage 和 latest
+	byMessage map[string]trackedAnswer // byMessage 是消息 ID 到回答的映射
+	latest    map[string]string        // latest 是用户标识到其最近一条回答的消息 ID 的映射
+}
+
+// newAnswerTracker 创建并返回一个空的 answerTracker 实例
+func newAnswerTracker() *answerTracker {
+	return &answerTracker{
+		byMessage: make(map[string]trackedAnswer),
+		latest:    make(map[string]string),
+	}
+}
+
+// record 记录一条已发送的回答，并清理超过保留时间的回答
+func (t *answerTracker) record(answer trackedAnswer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.byMessage) >= maxTrackedAnswers {
+		t.prune(answer.SentAt)
+	}
+	t.byMessage[answer.MessageID] = answer
+	t.latest[answer.User] = answer.MessageID
+}
+
+// prune 删除超过保留时间的回答；仍然超出上限时删除最早的回答，调用方必须持有 mu
+func (t *answerTracker) prune(now time.Time) {
+	var oldest trackedAnswer
+	for id, answer := range t.byMessage {
+		if now.Sub(answer.SentAt) > answerRetention {
+			t.forget(id)
+			continue
+		}
+		if oldest.MessageID == "" || answer.SentAt.Before(oldest.SentAt) {
+			oldest = answer
+		}
+	}
+	if len(t.byMessage) >= maxTrackedAnswers && oldest.MessageID != "" {
+		t.forget(oldest.MessageID)
+	}
+}
+
+// forget 删除一条回答，调用方必须持有 mu
+func (t *answerTracker) forget(messageID string) {
+	answer := t.byMessage[messageID]
+	delete(t.byMessage, messageID)
+	if t.latest[answer.User] == messageID {
+		delete(t.latest, answer.User)
+	}
+}
+
+// lookup 查找要评价的回答：messageID 不为空时按消息 ID 查找，否则查找 user 最近的一条回答
+// 超过保留时间的回答视为不存在。
+func (t *answerTracker) lookup(user, messageID string) (trackedAnswer, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if messageID == "" {
+		messageID = t.latest[user]
+	}
+	answer, ok := t.byMessage[messageID]
+	if !ok || time.Since(answer.SentAt) > answerRetention {
+		return trackedAnswer{}, false
+	}
+	return answer, true
+}
+
+// FeedbackFromKey 将评价按钮的 key 转换为对应的命令 ("/good" 或 "/bad") 和被评价回答的消息 ID
+// key 不是评价按钮时返回 false。
+func FeedbackFromKey(key string) (command, messageID string, ok bool) {
+	rest := strings.TrimPrefix(key, feedbackKeyPrefix)
+	if rest == key {
+		return "", "", false
+	}
+	name, messageID, found := strings.Cut(rest, ":")
+	if !found || messageID == "" || (name != "good" && name != "bad") {
+		return "", "", false
+	}
+	return "/" + name, messageID, true
+}
+
+// feedbackButtons 返回 "有帮助" 和 "没帮助" 两个评价按钮，按钮 key 携带被评价回答的消息 ID
+func feedbackButtons(messageID string) []interface{} {
+	return []interface{}{
+		map[string]interface{}{"text": "👍 有帮助", "style": 1, "key": feedbackKeyPrefix + "good:" + messageID},
+		map[string]interface{}{"text": "👎 没帮助", "style": 2, "key": feedbackKeyPrefix + "bad:" + messageID},
+	}
+}
+
+// feedbackCard 返回只有评价按钮的 button_interaction 模板卡片
+func feedbackCard(messageID string) wecom.TemplateCard {
+	return wecom.TemplateCard{
+		CardType:   "button_interaction",
+		MainTitle:  map[string]string{"title": "这条回答有帮助吗？", "desc": "也可以回复 /good 或 /bad [原因] 进行评价"},
+		ButtonList: feedbackButtons(messageID),
+		TaskID:     feedbackTaskIDPrefix + messageID,
+	}
+}
+
+// sendFeedbackCard 在应用开启评价按钮时，向支持模板卡片的投递目标单独发送评价按钮卡片
+// 评价按钮已附在推荐问题卡片上时不会调用；其他投递目标的用户仍可以通过 /good 和 /bad 命令评价，因此不发送任何替代内容。
+// 评价按钮只是回答的补充，发送失败时只记录日志，不计入投递结果。
+func sendFeedbackCard(d *delivery, svc *DifyService, messageID string) {
+	if !svc.app.Feedback.Buttons || messageID == "" {
+		return
+	}
+	card := feedbackCard(messageID)
+	d.optional("feedback", func(robot sender.Sender) error {
+		return d.sendTemplateCardTo(robot, card, "")
+	})
+}
+
+// goodCommand 处理 /good 命令
+func (c *MessageConverter) goodCommand(ctx *CommandContext) (string, error) {
+	return c.feedbackCommand(ctx, FeedbackLike, "")
+}
+
+// badCommand 处理 /bad 命令，reason 作为评价的具体说明一起提交
+func (c *MessageConverter) badCommand(ctx *CommandContext) (string, error) {
+	return c.feedbackCommand(ctx, FeedbackDislike, ctx.Args.String("reason"))
+}
+
+// feedbackCommand 将评价提交到 Dify
+// 来自评价按钮时评价按钮所在的回答，否则评价用户最近的一条回答；提交时使用提问者的用户标识，
+// 因此群聊中其他成员点击按钮也会记在这条回答上。
+func (c *MessageConverter) feedbackCommand(ctx *CommandContext, rating, reason string) (string, error) {
+	answer, ok := c.answers.lookup(ctx.User, ctx.AnswerID)
+	if !ok {
+		slog.InfoContext(ctx.Context, "[Converter] 没有找到可评价的回答", "user", ctx.User, "message_id", ctx.AnswerID, "rating", rating)
+		metrics.DifyFeedback.Inc("unknown", rating, feedbackResultNotFound)
+		return fmt.Sprintf("没有找到可以评价的回答，只能评价 %d 小时内的回答。", int(answerRetention.Hours())), nil
+	}
+	svc, ok := c.apps[answer.App]
+	if !ok {
+		metrics.DifyFeedback.Inc(answer.App, rating, feedbackResultNotFound)
+		return fmt.Sprintf("Dify 应用 %s 已不可用，无法评价这条回答。", answer.App), nil
+	}
+	if err := svc.SendMessageFeedbackContext(ctx.Context, answer.MessageID, rating, answer.User, reason); err != nil {
+		metrics.DifyFeedback.Inc(answer.App, rating, difyResultError)
+		return "", fmt.Errorf("failed to send feedback: %w", err)
+	}
+	metrics.DifyFeedback.Inc(answer.App, rating, difyResultSuccess)
+	slog.InfoContext(ctx.Context, "[Converter] 已提交回答评价", "app", answer.App, "message_id", answer.MessageID,
+		"rating", rating, "rated_by", ctx.User, "asked_by", answer.User, "reason", reason)
+	if rating == FeedbackLike {
+		return "感谢您的评价！", nil
+	}
+	return "感谢反馈，我们会根据您的意见改进回答。", nil
+}
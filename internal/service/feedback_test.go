@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/pkg/sender"
+	"dify2wxbot/pkg/wecom"
+)
+
+func TestConverterSubmitsFeedback(t *testing.T) {
+	var feedbackPath, feedbackBody string
+	c, _ := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/feedbacks") {
+			body, _ := io.ReadAll(r.Body)
+			feedbackPath, feedbackBody = r.URL.Path, string(body)
+			w.Write([]byte(`{"result": "success"}`))
+			return
+		}
+		w.Write([]byte(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1"}`))
+	})
+
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "/good", User: "tester"}); err != nil {
+		t.Fatalf("/good before any answer: %v", err)
+	}
+	if feedbackPath != "" {
+		t.Fatalf("feedback sent to %q before any answer", feedbackPath)
+	}
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "年假有几天", User: "tester"}); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "/bad 没有说明如何申请", User: "tester"}); err != nil {
+		t.Fatalf("/bad: %v", err)
+	}
+	want := `{"content":"没有说明如何申请","rating":"dislike","user":"tester"}`
+	if feedbackPath != "/v1/messages/m1/feedbacks" || feedbackBody != want {
+		t.Fatalf("feedback = %s %s, want %s for m1", feedbackPath, feedbackBody, want)
+	}
+
+	// 群聊中其他成员点击评价按钮时，评价记在按钮所在的回答上，并使用提问者的用户标识
+	key := feedbackCard("m1").ButtonList[0].(map[string]interface{})["key"].(string)
+	command, answerID, ok := FeedbackFromKey(key)
+	if !ok || command != "/good" || answerID != "m1" {
+		t.Fatalf("FeedbackFromKey(%q) = %q, %q, %v", key, command, answerID, ok)
+	}
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: command, User: "someone-else", AnswerID: answerID}); err != nil {
+		t.Fatalf("feedback button: %v", err)
+	}
+	if want := `{"content":"","rating":"like","user":"tester"}`; feedbackBody != want {
+		t.Fatalf("feedback body = %s, want %s", feedbackBody, want)
+	}
+}
+
+func TestBadWithoutTrackedAnswer(t *testing.T) {
+	c, rec := newTestConverter(t, nil) // 没有可评价的回答时不应调用 Dify
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "/bad 答非所问", User: "tester"})
+	if err != nil {
+		t.Fatalf("/bad: %v", err)
+	}
+	if !strings.HasPrefix(result.Answer, "没有找到可以评价的回答") {
+		t.Fatalf("reply = %q, want the not-found message", result.Answer)
+	}
+	if got := rec.Records(); len(got) != 1 || got[0].Content != result.Answer {
+		t.Fatalf("recorder got %+v, want the not-found message", got)
+	}
+}
+
+// cardRecorder 是支持模板卡片的 sender.Recorder，记录发送的卡片
+type cardRecorder struct {
+	*sender.Recorder
+	mu    sync.Mutex
+	cards []wecom.TemplateCard
+	err   error // err 不为 nil 时发送卡片返回该错误
+}
+
+func (r *cardRecorder) SendTemplateCardMessageContext(ctx context.Context, card wecom.TemplateCard) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.cards = append(r.cards, card)
+	return nil
+}
+
+// newFollowUpService 创建开启了推荐问题和评价按钮的 DifyService，Dify 为每条回答返回 questions
+func newFollowUpService(t *testing.T, style string, questions ...string) *DifyService {
+	t.Helper()
+	dify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := json.Marshal(map[string]interface{}{"result": "success", "data": questions})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(dify.Close)
+	return NewDifyService(config.DifyConfig{Name: "test", APIKey: "app-test", BaseURL: dify.URL, BotType: "chat",
+		Suggestions: config.SuggestionsConfig{Enable: true, Style: style, MaxQuestions: 6},
+		Feedback:    config.FeedbackConfig{Buttons: true},
+	}, fastRetry)
+}
+
+func TestFeedbackButtonsJoinSuggestionsCard(t *testing.T) {
+	questions := []string{"问题一", "问题二", "问题三", "问题四", "问题五", "问题六"}
+	rec := &cardRecorder{Recorder: sender.NewRecorder("default", weComCaps)}
+	d := newDelivery(context.Background(), []string{"default"}, []sender.Sender{rec})
+	sendFollowUps(d, newFollowUpService(t, "", questions...), "tester", "m1", nil)
+
+	if len(rec.cards) != 1 {
+		t.Fatalf("cards = %+v, want a single card with suggestions and feedback", rec.cards)
+	}
+	var keys []string
+	for _, button := range rec.cards[0].ButtonList {
+		keys = append(keys, button.(map[string]interface{})["key"].(string))
+	}
+	want := []string{suggestionKeyPrefix + "问题一", suggestionKeyPrefix + "问题二", suggestionKeyPrefix + "问题三", suggestionKeyPrefix + "问题四",
+		feedbackKeyPrefix + "good:m1", feedbackKeyPrefix + "bad:m1"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("button keys = %q, want four suggestions and the feedback buttons", keys)
+	}
+
+	// text_notice 卡片不能放按钮，评价按钮仍单独发送
+	rec = &cardRecorder{Recorder: sender.NewRecorder("default", weComCaps)}
+	d = newDelivery(context.Background(), []string{"default"}, []sender.Sender{rec})
+	sendFollowUps(d, newFollowUpService(t, suggestionsStyleText, questions...), "tester", "m1", nil)
+	if len(rec.cards) != 2 || rec.cards[0].CardType != "text_notice" || rec.cards[1].TaskID != feedbackTaskIDPrefix+"m1" {
+		t.Fatalf("cards = %+v, want the text_notice suggestions followed by the feedback card", rec.cards)
+	}
+}
+
+func TestFollowUpFailuresDoNotFailDelivery(t *testing.T) {
+	rec := &cardRecorder{Recorder: sender.NewRecorder("default", weComCaps)}
+	d := newDelivery(context.Background(), []string{"default"}, []sender.Sender{rec})
+	if err := d.sendText("年假为 10 天。"); err != nil {
+		t.Fatalf("sendText: %v", err)
+	}
+	rec.err = errors.New("45009 api freq out of limit")
+	rec.FailWith(rec.err) // 卡片和替代的 Markdown 都发送失败
+	sendFollowUps(d, newFollowUpService(t, suggestionsStyleText, "问题一"), "tester", "m1", nil)
+
+	if got := d.results(); len(got) != 1 || got[0].Err != nil {
+		t.Fatalf("results = %+v, want the answer's target reported as delivered", got)
+	}
+}
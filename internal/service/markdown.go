@@ -0,0 +1,138 @@
+package service
+
+import (
+	"regexp"  // 导入 regexp 包，用于识别 Markdown 行内语法
+	"strings" // 导入 strings 包，用于逐行处理 Markdown 文本
+)
+
+// markdownInlineRules 是将 Markdown 行内语法转换为纯文本的替换规则，按顺序应用
+var markdownInlineRules = []struct {
+	re   *regexp.Regexp // re 匹配 Markdown 语法
+	repl string         // repl 是替换后的文本
+}{
+	{regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`), "$2"},     // 图片只保留地址
+	{regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`), "$1 ($2)"}, // 链接保留文字和地址
+	{regexp.MustCompile(`\*\*([^*\n]+)\*\*`), "$1"},                   // 加粗
+	{regexp.MustCompile(`__([^_\n]+)__`), "$1"},                       // 加粗
+	{regexp.MustCompile(`~~([^~\n]+)~~`), "$1"},                       // 删除线
+	{regexp.MustCompile(`(^|[^\w*])\*([^*\s][^*\n]*)\*`), "$1$2"},     // 斜体
+	{regexp.MustCompile("`([^`\n]+)`"), "$1"},                         // 行内代码
+	{weComFontTag, ""}, // 企业微信 Markdown 的字体颜色标签
+}
+
+var (
+	markdownHeading = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`) // markdownHeading 匹配标题标记
+	markdownQuote   = regexp.MustCompile(`^\s{0,3}>\s?`)      // markdownQuote 匹配引用标记
+	markdownOrdered = regexp.MustCompile(`^\s*\d+\.\s+`)      // markdownOrdered 匹配有序列表标记
+	weComFontTag    = regexp.MustCompile(`</?font[^>]*>`)     // weComFontTag 匹配企业微信 Markdown 的字体颜色标签
+)
+
+// markdownToText 将 Markdown 转换为便于阅读的纯文本，供不支持 Markdown 的发送方使用
+// 去掉标题、引用、加粗等标记，链接转换为 "文字 (地址)"；代码块去掉 ``` 标记后原样保留。
+func markdownToText(content string) string {
+	lines := strings.Split(content, "\n")
+	out := make([]string, 0, len(lines))
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+		line = markdownHeading.ReplaceAllString(line, "")
+		line = markdownQuote.ReplaceAllString(line, "")
+		for _, rule := range markdownInlineRules {
+			line = rule.re.ReplaceAllString(line, rule.repl)
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// looksLikeMarkdown 判断文本中是否使用了 Markdown 语法 (标题、引用、列表、代码块或加粗、链接等行内语法)
+// 流式推送时据此决定以 Markdown 还是文本消息发送回答。
+func looksLikeMarkdown(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") || markdownHeading.MatchString(line) ||
+			markdownQuote.MatchString(line) || markdownBullet.MatchString(line) || markdownOrdered.MatchString(line) {
+			return true
+		}
+	}
+	for _, rule := range markdownInlineRules {
+		if rule.re.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+const slackBold = "\x00" // slackBold 是转换过程中加粗标记的占位符
+
+// slackInlineRules 是将 Markdown 行内语法转换为 Slack mrkdwn 的替换规则，按顺序应用
+// 加粗先替换为占位符 slackBold，避免随后被斜体规则当作 *斜体* 处理。
+var slackInlineRules = []struct {
+	re   *regexp.Regexp // re 匹配 Markdown 语法
+	repl string         // repl 是替换后的 mrkdwn 文本
+}{
+	{regexp.MustCompile(`!\[\]\(([^)\s]+)[^)]*\)`), "<$1>"},                 // 没有说明文字的图片转换为链接
+	{regexp.MustCompile(`!?\[([^\]]+)\]\(([^)\s]+)[^)]*\)`), "<$2|$1>"},     // 链接和图片转换为 <地址|文字>
+	{regexp.MustCompile(`\*\*([^*\n]+)\*\*`), slackBold + "$1" + slackBold}, // 加粗
+	{regexp.MustCompile(`__([^_\n]+)__`), slackBold + "$1" + slackBold},     // 加粗
+	{regexp.MustCompile(`(^|[^\w*])\*([^*\s][^*\n]*)\*`), "${1}_${2}_"},     // 斜体
+	{regexp.MustCompile(`~~([^~\n]+)~~`), "~$1~"},                           // 删除线
+}
+
+var (
+	markdownBullet = regexp.MustCompile(`^(\s*)[*+-]\s+`)                        // markdownBullet 匹配无序列表标记
+	slackEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;") // slackEscaper 转义 mrkdwn 中的控制字符
+)
+
+// markdownToSlack 将 Markdown 转换为 Slack 的 mrkdwn 格式
+// 标题转换为加粗，无序列表标记转换为 "•"，链接转换为 <地址|文字>；&、< 和 > 按 Slack 的要求转义，代码块原样保留。
+func markdownToSlack(content string) string {
+	lines := strings.Split(content, "\n")
+	out := make([]string, 0, len(lines))
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			out = append(out, line)
+			continue
+		}
+		if inFence {
+			out = append(out, slackEscaper.Replace(line))
+			continue
+		}
+		quote := markdownQuote.MatchString(line)
+		line = markdownQuote.ReplaceAllString(line, "")
+		heading := markdownHeading.MatchString(line)
+		line = markdownHeading.ReplaceAllString(line, "")
+		line = markdownBullet.ReplaceAllString(line, "$1• ")
+		line = slackEscaper.Replace(weComFontTag.ReplaceAllString(line, ""))
+		for _, rule := range slackInlineRules {
+			line = rule.re.ReplaceAllString(line, rule.repl)
+		}
+		if heading && line != "" {
+			line = "*" + strings.ReplaceAll(line, slackBold, "") + "*" // 标题整体加粗，mrkdwn 不支持嵌套加粗
+		} else {
+			line = strings.ReplaceAll(line, slackBold, "*")
+		}
+		if quote {
+			line = "> " + line
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// weComMarkdownMentions 返回企业微信 Markdown 中 @成员 的前缀，例如 "<@zhangsan> "
+func weComMarkdownMentions(userIDs []string) string {
+	var b strings.Builder
+	for _, id := range userIDs {
+		b.WriteString("<@" + id + "> ")
+	}
+	return b.String()
+}
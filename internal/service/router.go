@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"  // 导入 errors 包，用于定义路由错误
+	"fmt"     // 导入 fmt 包，用于格式化错误信息
+	"strings" // 导入 strings 包，用于匹配消息前缀和关键词
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取路由规则配置
+)
+
+// 路由原因，用于日志记录，说明消息为何交给某个 Dify 应用处理
+const (
+	routeReasonExplicit = "explicit" // 请求中明确指定了应用
+	routeReasonMessage  = "message"  // 消息内容命中了前缀或关键词规则
+	routeReasonSelected = "selected" // 用户通过 /app 命令选择了应用
+	routeReasonSender   = "sender"   // 发送方命中了用户或群组规则
+	routeReasonDefault  = "default"  // 没有规则命中，使用默认应用
+)
+
+// ErrUnknownApp 表示请求中指定的 Dify 应用不存在
+var ErrUnknownApp = errors.New("unknown dify app")
+
+// Route 描述一次路由的结果
+type Route struct {
+	App     string // App 是选中的 Dify 应用名称
+	Message string // Message 是发送给 Dify 的消息，命中前缀规则时已去掉前缀
+	Reason  string // Reason 是路由原因
+}
+
+// Router 根据路由规则为每条消息选择 Dify 应用
+// 优先级从高到低依次为：请求中明确指定的应用、按消息内容匹配的规则、用户通过 /app 选择的应用、按发送方匹配的规则、默认应用。
+// 同一优先级的规则按配置顺序匹配，第一条命中的规则生效。
+type Router struct {
+	apps          map[string]bool      // apps 是所有可用的应用名称
+	defaultApp    string               // defaultApp 是默认应用名称
+	messageRoutes []config.RouteConfig // messageRoutes 是设置了前缀或关键词的规则
+	senderRoutes  []config.RouteConfig // senderRoutes 是只设置了用户或群组的规则
+}
+
+// NewRouter 创建并返回一个新的 Router 实例
+// appNames: 所有可用的应用名称
+// defaultApp: 默认应用名称，为空时使用 appNames 中的第一个
+// routes: 路由规则列表
+func NewRouter(appNames []string, defaultApp string, routes []config.RouteConfig) *Router {
+	r := &Router{apps: make(map[string]bool), defaultApp: defaultApp}
+	for _, name := range appNames {
+		r.apps[name] = true
+	}
+	if r.defaultApp == "" && len(appNames) > 0 {
+		r.defaultApp = appNames[0]
+	}
+	for _, route := range routes {
+		if route.Prefix != "" || len(route.Keywords) > 0 {
+			r.messageRoutes = append(r.messageRoutes, route)
+		} else {
+			r.senderRoutes = append(r.senderRoutes, route)
+		}
+	}
+	return r
+}
+
+// Route 为一条消息选择 Dify 应用
+// explicit: 请求中明确指定的应用名称，为空表示未指定
+// selected: 用户通过 /app 命令选择的应用名称，为空表示未选择
+func (r *Router) Route(message, user, group, explicit, selected string) (Route, error) {
+	if explicit != "" {
+		if !r.apps[explicit] {
+			return Route{}, fmt.Errorf("%w: %s", ErrUnknownApp, explicit)
+		}
+		return Route{App: explicit, Message: message, Reason: routeReasonExplicit}, nil
+	}
+	for _, route := range r.messageRoutes {
+		if stripped, ok := matchRoute(route, message, user, group); ok {
+			return Route{App: route.App, Message: stripped, Reason: routeReasonMessage}, nil
+		}
+	}
+	if selected != "" && r.apps[selected] {
+		return Route{App: selected, Message: message, Reason: routeReasonSelected}, nil
+	}
+	for _, route := range r.senderRoutes {
+		if _, ok := matchRoute(route, message, user, group); ok {
+			return Route{App: route.App, Message: message, Reason: routeReasonSender}, nil
+		}
+	}
+	return Route{App: r.defaultApp, Message: message, Reason: routeReasonDefault}, nil
+}
+
+// matchRoute 判断消息是否满足规则中设置的所有条件，命中前缀时返回去掉前缀后的消息
+func matchRoute(route config.RouteConfig, message, user, group string) (string, bool) {
+	if route.Prefix != "" {
+		trimmed := strings.TrimSpace(message)
+		if !strings.HasPrefix(trimmed, route.Prefix) {
+			return message, false
+		}
+		message = strings.TrimSpace(strings.TrimPrefix(trimmed, route.Prefix))
+	}
+	if len(route.Keywords) > 0 && !containsAny(message, route.Keywords) {
+		return message, false
+	}
+	if len(route.Users) > 0 && !contains(route.Users, user) {
+		return message, false
+	}
+	if len(route.Groups) > 0 && !contains(route.Groups, group) {
+		return message, false
+	}
+	return message, true
+}
+
+// containsAny 判断文本是否包含任意一个关键词
+func containsAny(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if keyword != "" && strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains 判断列表中是否包含指定的值
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
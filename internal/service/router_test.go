@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"dify2wxbot/internal/config"
+)
+
+func TestRouterRoute(t *testing.T) {
+	r := NewRouter([]string{"general", "hr", "it", "sales"}, "", []config.RouteConfig{
+		{App: "hr", Prefix: "#hr"},
+		{App: "it", Keywords: []string{"VPN", "打印机"}},
+		{App: "hr", Prefix: "#ask", Keywords: []string{"请假"}, Groups: []string{"hr-group"}},
+		{App: "sales", Users: []string{"bob"}},
+		{App: "it", Groups: []string{"it-group"}},
+		{App: "sales", Groups: []string{"it-group"}}, // 同一优先级中排在后面，不会命中
+	})
+	tests := []struct {
+		name     string
+		message  string
+		user     string
+		group    string
+		explicit string
+		selected string
+		want     Route
+	}{
+		{name: "default", message: "你好", user: "alice",
+			want: Route{App: "general", Message: "你好", Reason: routeReasonDefault}},
+		{name: "explicit beats message rules", message: "#hr 年假", user: "bob", explicit: "it", selected: "sales",
+			want: Route{App: "it", Message: "#hr 年假", Reason: routeReasonExplicit}},
+		{name: "prefix stripped", message: "  #hr   年假有几天 ", user: "alice",
+			want: Route{App: "hr", Message: "年假有几天", Reason: routeReasonMessage}},
+		{name: "message rule beats selection", message: "VPN 连不上", user: "bob", selected: "sales",
+			want: Route{App: "it", Message: "VPN 连不上", Reason: routeReasonMessage}},
+		{name: "prefix and keyword and group all required", message: "#ask 怎么请假", user: "alice", group: "sales-group",
+			want: Route{App: "general", Message: "#ask 怎么请假", Reason: routeReasonDefault}},
+		{name: "keyword checked after prefix", message: "#ask 怎么请假", user: "alice", group: "hr-group",
+			want: Route{App: "hr", Message: "怎么请假", Reason: routeReasonMessage}},
+		{name: "selection beats sender rules", message: "你好", user: "bob", group: "it-group", selected: "hr",
+			want: Route{App: "hr", Message: "你好", Reason: routeReasonSelected}},
+		{name: "unknown selection ignored", message: "你好", user: "bob", selected: "removed",
+			want: Route{App: "sales", Message: "你好", Reason: routeReasonSender}},
+		{name: "first sender rule wins", message: "你好", user: "alice", group: "it-group",
+			want: Route{App: "it", Message: "你好", Reason: routeReasonSender}},
+		{name: "prefix must start the message", message: "关于 #hr 的问题", user: "alice",
+			want: Route{App: "general", Message: "关于 #hr 的问题", Reason: routeReasonDefault}},
+	}
+	for _, tt := range tests {
+		got, err := r.Route(tt.message, tt.user, tt.group, tt.explicit, tt.selected)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Route = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := r.Route("你好", "alice", "", "removed", ""); !errors.Is(err, ErrUnknownApp) {
+		t.Errorf("Route with an unknown explicit app error = %v, want ErrUnknownApp", err)
+	}
+	if got, _ := NewRouter([]string{"general", "hr"}, "hr", nil).Route("你好", "alice", "", "", ""); got.App != "hr" {
+		t.Errorf("Route = %+v, want the configured default app", got)
+	}
+}
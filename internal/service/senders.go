@@ -0,0 +1,25 @@
+package service
+
+import (
+	"dify2wxbot/internal/config" // 导入 config 包，用于根据配置中的 type 选择渠道
+	"dify2wxbot/pkg/dingtalk"    // 导入 pkg/dingtalk 包，用于发送钉钉群机器人消息
+	"dify2wxbot/pkg/feishu"      // 导入 pkg/feishu 包，用于发送飞书群机器人消息
+	"dify2wxbot/pkg/sender"      // 导入 pkg/sender 包，各渠道都实现其中的 Sender 接口
+	"dify2wxbot/pkg/slack"       // 导入 pkg/slack 包，用于发送 Slack 兼容的 Incoming Webhook 消息
+	"dify2wxbot/pkg/wecom"       // 导入 pkg/wecom 包，用于发送企业微信群机器人和自建应用消息
+)
+
+// newSender 根据投递目标配置中的 type 创建对应渠道的发送方
+// "feishu"、"dingtalk" 和 "slack" 分别创建对应渠道的群机器人，其余类型交给 wecom.NewSender 处理。
+func newSender(cfg config.WeComConfig) sender.Sender {
+	switch cfg.Type {
+	case "feishu":
+		return feishu.NewRobot(cfg)
+	case "dingtalk":
+		return dingtalk.NewRobot(cfg)
+	case "slack":
+		return slack.NewWebhook(cfg)
+	default:
+		return wecom.NewSender(cfg)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"strings" // 导入 strings 包，用于查找段落边界和代码块标记
+)
+
+// streamFlushMinBytes 是流式推送时单次发送的最小字节数
+// 段落较短时会合并后再发送，避免触发企业微信机器人每分钟 20 条的频率限制。
+const streamFlushMinBytes = 512
+
+// paragraphFlusher 将 Dify 流式回答按段落分批推送到企业微信
+// 它缓存收到的文本块，每当缓存中出现已完成的段落 (以空行结束且代码块已闭合) 并达到最小长度时，
+// 就把这些段落发送出去，剩余未完成的部分继续留在缓存中。
+// 首次推送时根据已完成的段落判断一次回答是否为 Markdown，之后的推送 (包括最后剩余的部分) 都沿用同一种消息类型。
+type paragraphFlusher struct {
+	sendText     func(content string) error // sendText 用于以文本消息发送段落
+	sendMarkdown func(content string) error // sendMarkdown 用于以 Markdown 消息发送段落
+	minBytes     int                        // minBytes 是单次发送的最小字节数
+	buf          string                     // buf 缓存尚未发送的文本
+	flushed      bool                       // flushed 表示是否已经推送过内容
+	markdown     bool                       // markdown 表示回答是否为 Markdown，在首次推送时确定
+	structured   bool                       // structured 表示回答是 JSON 结构化数据，需要等待完整回答后整体处理
+	decided      bool                       // decided 表示是否已根据首个非空字符判断过回答类型
+}
+
+// newParagraphFlusher 创建并返回一个新的 paragraphFlusher 实例
+// sendText: 文本消息的发送函数
+// sendMarkdown: Markdown 消息的发送函数，回答使用了 Markdown 语法时使用
+func newParagraphFlusher(sendText, sendMarkdown func(content string) error) *paragraphFlusher {
+	return &paragraphFlusher{
+		sendText:     sendText,
+		sendMarkdown: sendMarkdown,
+		minBytes:     streamFlushMinBytes,
+	}
+}
+
+// Write 追加一个文本块，并在有已完成段落时推送
+// 返回 send 产生的错误，调用方应据此中止流式读取。
+func (f *paragraphFlusher) Write(delta string) error {
+	f.buf += delta
+
+	// 根据首个非空字符判断回答是否为 JSON，JSON 回答可能包含 image_url 等结构化字段，不能拆分发送
+	if !f.decided {
+		trimmed := strings.TrimSpace(f.buf)
+		if trimmed == "" {
+			return nil
+		}
+		f.decided = true
+		f.structured = strings.HasPrefix(trimmed, "{")
+	}
+	if f.structured {
+		return nil
+	}
+
+	cut := lastParagraphBoundary(f.buf)
+	if cut < f.minBytes {
+		return nil
+	}
+	ready := strings.TrimSpace(f.buf[:cut])
+	f.buf = f.buf[cut:]
+	if ready == "" {
+		return nil
+	}
+	if !f.flushed {
+		f.markdown = looksLikeMarkdown(ready)
+	}
+	f.flushed = true
+	return f.send(ready)
+}
+
+// Close 以与已推送部分相同的消息类型发送缓存中剩余的文本
+// 尚未推送过内容时不发送任何消息，调用方应对完整回答执行常规的后处理。
+func (f *paragraphFlusher) Close() error {
+	rest := f.Remainder()
+	f.buf = ""
+	if !f.flushed || rest == "" {
+		return nil
+	}
+	return f.send(rest)
+}
+
+// send 按首次推送时确定的消息类型发送内容
+func (f *paragraphFlusher) send(content string) error {
+	if f.markdown {
+		return f.sendMarkdown(content)
+	}
+	return f.sendText(content)
+}
+
+// Flushed 返回是否已经推送过部分内容
+// 如果尚未推送，调用方应对完整回答执行常规的后处理。
+func (f *paragraphFlusher) Flushed() bool {
+	return f.flushed
+}
+
+// Remainder 返回缓存中尚未推送的文本
+func (f *paragraphFlusher) Remainder() string {
+	return strings.TrimSpace(f.buf)
+}
+
+// lastParagraphBoundary 返回文本中最后一个可安全切分的段落边界位置 (空行之后)
+// 只有当边界之前的代码块标记 (```) 成对出现时，该边界才是安全的。找不到时返回 0。
+func lastParagraphBoundary(text string) int {
+	end := len(text)
+	for end > 0 {
+		idx := strings.LastIndex(text[:end], "\n\n")
+		if idx < 0 {
+			return 0
+		}
+		cut := idx + 2
+		if countCodeFences(text[:cut])%2 == 0 {
+			return cut
+		}
+		end = idx
+	}
+	return 0
+}
+
+// countCodeFences 统计文本中以 ``` 开头的行数，用于判断代码块是否闭合
+func countCodeFences(text string) int {
+	count := 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/pkg/sender"
+)
+
+// sseAnswer 把 chunks 编码为 Dify Chat API 的流式响应，以 message_end 结束
+func sseAnswer(chunks ...string) string {
+	var b strings.Builder
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(map[string]string{"event": "message", "task_id": "t1", "message_id": "m1", "conversation_id": "c1", "answer": chunk})
+		b.WriteString("data: " + string(data) + "\n\n")
+	}
+	b.WriteString(`data: {"event": "message_end", "task_id": "t1", "message_id": "m1", "conversation_id": "c1"}` + "\n\n")
+	return b.String()
+}
+
+// newStreamingConverter 创建以流式模式调用 Dify 的 MessageConverter，Dify 对每次对话返回 stream
+func newStreamingConverter(t *testing.T, stream string) (*MessageConverter, *sender.Recorder) {
+	t.Helper()
+	return newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(stream))
+	}, func(cfg *config.AppConfig) {
+		cfg.Dify.ResponseMode = "streaming"
+	})
+}
+
+func TestStreamingMarkdownAnswerIsSentAsMarkdown(t *testing.T) {
+	section := "## 请假流程\n\n" + strings.Repeat("- 在系统中提交**请假申请**并等待审批。\n", 12) + "\n"
+	c, rec := newStreamingConverter(t, sseAnswer(section, section, "最后请**注意**假期余额。"))
+
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "怎么请假", User: "tester"}); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	got := rec.Records()
+	if len(got) < 2 {
+		t.Fatalf("recorder got %+v, want the answer pushed in several parts", got)
+	}
+	for i, r := range got {
+		if r.Kind != sender.KindMarkdown {
+			t.Errorf("part %d kind = %s, want markdown: %q", i, r.Kind, r.Content)
+		}
+	}
+	if last := got[len(got)-1].Content; last != "最后请**注意**假期余额。" {
+		t.Errorf("last part = %q, want the remainder sent as markdown", last)
+	}
+}
+
+func TestStreamingPlainAnswerIsSentAsText(t *testing.T) {
+	paragraph := strings.Repeat("年假需要提前三个工作日在系统中申请。", 12) + "\n\n"
+	c, rec := newStreamingConverter(t, sseAnswer(paragraph, paragraph, "祝工作顺利。"))
+
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "怎么请假", User: "tester"}); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	got := rec.Records()
+	if len(got) < 2 {
+		t.Fatalf("recorder got %+v, want the answer pushed in several parts", got)
+	}
+	for i, r := range got {
+		if r.Kind != sender.KindText {
+			t.Errorf("part %d kind = %s, want text: %q", i, r.Kind, r.Content)
+		}
+	}
+	if last := got[len(got)-1].Content; last != "祝工作顺利。" {
+		t.Errorf("last part = %q, want the remainder", last)
+	}
+}
+
+func TestStreamingAnswerWithoutParagraphBreakIsSentWhole(t *testing.T) {
+	// 超过最小推送长度但没有空行，直到 message_end 都没有推送过，应按完整回答处理
+	answer := strings.Repeat("年假需要提前三个工作日在系统中申请。", 12)
+	c, rec := newStreamingConverter(t, sseAnswer(answer[:300], answer[300:]))
+
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "怎么请假", User: "tester"}); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	got := rec.Records()
+	if len(got) != 1 || got[0].Kind != sender.KindText || got[0].Content != answer {
+		t.Fatalf("recorder got %+v, want the whole answer in one text message", got)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"  // 导入 context 包，用于限制获取推荐问题的时间
+	"fmt"      // 导入 fmt 包，用于格式化问题列表
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"strings"  // 导入 strings 包，用于整理问题文本和解析按钮 key
+	"time"     // 导入 time 包，用于设置获取推荐问题的超时时间
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取推荐问题的展示配置
+	"dify2wxbot/pkg/sender"      // 导入 pkg/sender 包，用于向每个投递目标发送推荐问题
+	"dify2wxbot/pkg/wecom"       // 导入 pkg/wecom 包，用于构建模板卡片
+)
+
+const (
+	defaultMaxSuggestions   = 3                     // 未配置 max_questions 时最多展示的问题数
+	maxButtonSuggestions    = 6                     // button_interaction 卡片最多 6 个按钮
+	maxTextSuggestions      = 3                     // text_notice 卡片的跳转指引列表最多 3 项
+	maxSuggestionKeyBytes   = 1024                  // 按钮 key 的最大字节数
+	maxButtonTextRunes      = 10                    // 按钮文字建议不超过 10 个字，更长的问题完整显示在副标题中
+	suggestionsStyleText    = "text"                // 以 text_notice 模板卡片展示推荐问题
+	suggestionsTitle        = "你可能还想问"              // 推荐问题的标题
+	suggestionKeyPrefix     = "dify2wxbot_suggest:" // 推荐问题按钮 key 的前缀，后接问题文本
+	suggestionTaskIDPrefix  = "suggest_"            // 推荐问题卡片 task_id 的前缀，后接回答的消息 ID
+	suggestionsFetchTimeout = 10 * time.Second      // 获取推荐问题的超时时间
+)
+
+// SuggestedQuestionFromKey 从模板卡片按钮的 key 中取出推荐问题
+// key 不是推荐问题按钮时返回 false，例如其他卡片的按钮。
+func SuggestedQuestionFromKey(key string) (string, bool) {
+	question := strings.TrimPrefix(key, suggestionKeyPrefix)
+	if question == key || question == "" {
+		return "", false
+	}
+	return question, true
+}
+
+// selectSuggestions 按配置筛选要展示的推荐问题：去掉空白和重复的问题，以及放不进按钮 key 的过长问题，并限制数量
+func selectSuggestions(questions []string, cfg config.SuggestionsConfig) []string {
+	limit := cfg.MaxQuestions
+	if limit <= 0 {
+		limit = defaultMaxSuggestions
+	}
+	maxLimit := maxButtonSuggestions
+	if cfg.Style == suggestionsStyleText {
+		maxLimit = maxTextSuggestions
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	seen := make(map[string]bool)
+	var selected []string
+	for _, q := range questions {
+		if len(selected) >= limit {
+			break
+		}
+		q = strings.Join(strings.Fields(q), " ")
+		if q == "" || seen[q] || len(suggestionKeyPrefix)+len(q) > maxSuggestionKeyBytes {
+			continue
+		}
+		seen[q] = true
+		selected = append(selected, q)
+	}
+	return selected
+}
+
+// suggestionsList 返回带编号的问题列表，每行一个问题
+func suggestionsList(questions []string) string {
+	lines := make([]string, len(questions))
+	for i, q := range questions {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, q)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// suggestionsMarkdown 将推荐问题渲染为带编号的 Markdown 列表，用于不支持模板卡片的投递目标
+func suggestionsMarkdown(questions []string) string {
+	return "**" + suggestionsTitle + "**\n" + suggestionsList(questions)
+}
+
+// suggestionsButtonCard 将推荐问题渲染为 button_interaction 模板卡片
+// 每个问题对应一个按钮，按钮 key 携带完整的问题，点击后经消息回调作为用户的下一条消息提交。
+// 按钮文字过长时会被截断，因此完整的问题同时列在副标题中。
+func suggestionsButtonCard(questions []string, messageID string) wecom.TemplateCard {
+	buttons := make([]interface{}, len(questions))
+	for i, q := range questions {
+		buttons[i] = map[string]interface{}{
+			"text":  truncateRunes(q, maxButtonTextRunes-len("...")),
+			"style": 1,
+			"key":   suggestionKeyPrefix + q,
+		}
+	}
+	return wecom.TemplateCard{
+		CardType:     "button_interaction",
+		MainTitle:    map[string]string{"title": suggestionsTitle},
+		SubTitleText: suggestionsList(questions),
+		ButtonList:   buttons,
+		TaskID:       suggestionTaskIDPrefix + messageID,
+	}
+}
+
+// suggestionsTextCard 将推荐问题渲染为 text_notice 模板卡片，每个问题对应跳转指引列表中的一项
+// 跳转类型 3 仅智能机器人支持，点击后以该问题直接向机器人提问。
+func suggestionsTextCard(questions []string) wecom.TemplateCard {
+	jumps := make([]interface{}, len(questions))
+	for i, q := range questions {
+		jumps[i] = map[string]interface{}{"type": 3, "title": q, "question": q}
+	}
+	return wecom.TemplateCard{
+		CardType:  "text_notice",
+		MainTitle: map[string]string{"title": suggestionsTitle},
+		JumpList:  jumps,
+	}
+}
+
+// fetchSuggestions 获取 Dify 的推荐问题并按配置筛选，应用未开启 suggested_questions、没有消息 ID 或获取失败时返回 nil
+// 推荐问题只是回答的补充，获取失败时只记录日志。
+func fetchSuggestions(ctx context.Context, svc *DifyService, user, messageID string) []string {
+	cfg := svc.app.Suggestions
+	if !cfg.Enable || messageID == "" || svc.app.BotType != "chat" {
+		return nil
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, suggestionsFetchTimeout)
+	defer cancel()
+	questions, err := svc.GetSuggestedQuestionsContext(fetchCtx, messageID, user)
+	if err != nil {
+		slog.WarnContext(ctx, "[Converter] 获取推荐问题失败", "message_id", messageID, "error", err)
+		return nil
+	}
+	selected := selectSuggestions(questions, cfg)
+	if len(selected) == 0 {
+		slog.DebugContext(ctx, "[Converter] Dify 没有返回推荐问题", "message_id", messageID)
+	}
+	return selected
+}
+
+// sendSuggestions 在回答发送完成后获取并发送 Dify 的推荐问题，没有可展示的推荐问题时不发送
+// 应用同时开启了评价按钮且以按钮卡片展示推荐问题时，评价按钮附在同一张卡片上 (推荐问题最多保留 4 个)，
+// 少发送一条消息，此时返回 true，调用方不再单独发送评价按钮卡片。
+// 推荐问题只是回答的补充，获取或发送失败时只记录日志，不计入投递结果，ConvertAndSend 不因此返回错误。
+func sendSuggestions(d *delivery, svc *DifyService, user, messageID string) (feedbackAttached bool) {
+	cfg := svc.app.Suggestions
+	selected := fetchSuggestions(d.ctx, svc, user, messageID)
+	if len(selected) == 0 {
+		return false
+	}
+	feedbackAttached = svc.app.Feedback.Buttons && cfg.Style != suggestionsStyleText
+	var card wecom.TemplateCard
+	if feedbackAttached {
+		buttons := feedbackButtons(messageID)
+		if limit := maxButtonSuggestions - len(buttons); len(selected) > limit {
+			selected = selected[:limit]
+		}
+		card = suggestionsButtonCard(selected, messageID)
+		card.ButtonList = append(card.ButtonList, buttons...)
+	} else if cfg.Style == suggestionsStyleText {
+		card = suggestionsTextCard(selected)
+	} else {
+		card = suggestionsButtonCard(selected, messageID)
+	}
+	slog.InfoContext(d.ctx, "[Converter] 发送推荐问题", "questions", len(selected), "style", cfg.Style, "feedback_buttons", feedbackAttached)
+	markdown := suggestionsMarkdown(selected)
+	d.optional("suggestions", func(robot sender.Sender) error {
+		return d.sendTemplateCardTo(robot, card, markdown)
+	})
+	return feedbackAttached
+}
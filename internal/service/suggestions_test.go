@@ -0,0 +1,94 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/pkg/sender"
+)
+
+func TestConverterSendsSuggestedQuestions(t *testing.T) {
+	var suggestedPath, suggestedUser string
+	c, rec := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			suggestedPath, suggestedUser = r.URL.Path, r.URL.Query().Get("user")
+			w.Write([]byte(`{"result": "success", "data": ["如何申请年假？", " 如何申请年假？ ", "", "病假怎么算？"]}`))
+			return
+		}
+		w.Write([]byte(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1"}`))
+	}, func(cfg *config.AppConfig) {
+		cfg.Dify.Suggestions = config.SuggestionsConfig{Enable: true}
+	})
+
+	if _, err := c.ConvertAndSend(ConvertRequest{Message: "年假有几天", User: "tester"}); err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	if suggestedPath != "/v1/messages/m1/suggested" || suggestedUser != "tester" {
+		t.Fatalf("suggested request = %q for user %q, want the answer's message", suggestedPath, suggestedUser)
+	}
+	got := rec.Records()
+	want := "**你可能还想问**\n1. 如何申请年假？\n2. 病假怎么算？"
+	if len(got) != 2 || got[1].Kind != sender.KindMarkdown || got[1].Content != want {
+		t.Fatalf("recorder got %+v, want the answer followed by the suggestions", got)
+	}
+
+	card := suggestionsButtonCard([]string{"如何申请年假？"}, "m1")
+	key := card.ButtonList[0].(map[string]interface{})["key"].(string)
+	if question, ok := SuggestedQuestionFromKey(key); !ok || question != "如何申请年假？" || card.TaskID == "" {
+		t.Fatalf("button key %q = %q, %v; want the question back", key, question, ok)
+	}
+}
+
+func TestConverterIgnoresSuggestionsFetchFailure(t *testing.T) {
+	c, rec := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code": "not_chat_app", "message": "suggested questions disabled", "status": 400}`))
+			return
+		}
+		w.Write([]byte(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1"}`))
+	}, func(cfg *config.AppConfig) {
+		cfg.Dify.Suggestions = config.SuggestionsConfig{Enable: true}
+	})
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "年假有几天", User: "tester"})
+	if err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	if got := rec.Records(); len(got) != 1 || got[0].Content != "年假为 10 天。" {
+		t.Fatalf("recorder got %+v, want only the answer", got)
+	}
+	if result.PartialFailure() {
+		t.Fatalf("deliveries = %+v, want the fetch failure ignored", result.Deliveries)
+	}
+}
+
+func TestConverterSuggestionsSendFailureDoesNotFailDelivery(t *testing.T) {
+	var rec *sender.Recorder
+	c, rec := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			rec.FailWith(errors.New("45009 api freq out of limit")) // 回答已发送，推荐问题发送失败
+			w.Write([]byte(`{"result": "success", "data": ["如何申请年假？"]}`))
+			return
+		}
+		w.Write([]byte(`{"answer": "年假为 10 天。", "conversation_id": "c1", "message_id": "m1"}`))
+	}, func(cfg *config.AppConfig) {
+		cfg.Dify.Suggestions = config.SuggestionsConfig{Enable: true}
+	})
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "年假有几天", User: "tester"})
+	if err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	if len(result.Deliveries) != 1 || result.Deliveries[0].Err != nil {
+		t.Fatalf("deliveries = %+v, want the answer's target reported as delivered", result.Deliveries)
+	}
+	if got := rec.Records(); len(got) != 1 || got[0].Content != "年假为 10 天。" {
+		t.Fatalf("recorder got %+v, want only the answer", got)
+	}
+}
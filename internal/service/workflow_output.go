@@ -0,0 +1,512 @@
+package service
+
+import (
+	"bytes"         // 导入 bytes 包，用于渲染模板
+	"encoding/json" // 导入 encoding/json 包，用于展示嵌套的输出值
+	"errors"        // 导入 errors 包，用于定义工作流运行失败的错误
+	"fmt"           // 导入 fmt 包，用于格式化输出值和错误信息
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"math"          // 导入 math 包，用于判断数值是否为整数
+	"reflect"       // 导入 reflect 包，用于在模板辅助函数中遍历任意类型的列表
+	"sort"          // 导入 sort 包，用于按字段名排序输出字段
+	"strconv"       // 导入 strconv 包，用于格式化和解析数字
+	"strings"       // 导入 strings 包，用于拼接消息内容
+	"text/template" // 导入 text/template 包，用于渲染工作流输出模板
+	"time"          // 导入 time 包，用于格式化日期
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取工作流输出配置
+	"dify2wxbot/pkg/wecom"       // 导入 pkg/wecom 包，用于构建图文消息和模板卡片
+)
+
+// ErrWorkflowFailed 表示工作流运行失败或被停止 (data.status 不为 "succeeded")
+// 失败信息已经发送给投递目标，ConvertAndSend 仍返回该错误，以便调用方和定时任务记录失败。
+var ErrWorkflowFailed = errors.New("dify workflow run failed")
+
+const (
+	workflowStatusSucceeded = "succeeded"        // 工作流运行成功的状态
+	workflowFormatText      = "text"             // 以文本消息发送工作流输出
+	workflowFormatNews      = "news"             // 以图文消息发送工作流输出
+	workflowFormatCard      = "template_card"    // 以 text_notice 模板卡片发送工作流输出
+	defaultWorkflowTitle    = "工作流运行结果"          // 未配置 title 时图文消息和模板卡片的标题
+	defaultDateLayout       = "2006-01-02 15:04" // date 辅助函数的默认格式
+	maxNewsDescRunes        = 120                // 图文消息描述的最大字符数
+	maxCardSubTitleRunes    = 112                // 模板卡片副标题的最大字符数
+	maxWorkflowErrorRunes   = 500                // 运行失败时展示的错误信息的最大字符数
+	noValue                 = "<no value>"       // text/template 渲染不存在的 map 键时输出的内容
+)
+
+// workflowField 是工作流的一个输出字段
+type workflowField struct {
+	Name  string      // Name 是输出字段名
+	Value interface{} // Value 是输出值，数字为 float64，列表为 []interface{}，对象为 map[string]interface{}
+}
+
+// workflowRun 是一次工作流运行的结果，也是输出模板的数据
+// 模板中可以通过 {{.Outputs.字段名}} 引用输出字段，通过 {{range .Fields}} 按展示顺序遍历输出字段。
+type workflowRun struct {
+	RunID       string                 // RunID 是工作流运行 ID
+	WorkflowID  string                 // WorkflowID 是工作流 ID
+	Status      string                 // Status 是运行状态，例如 "succeeded"、"failed" 或 "stopped"
+	Error       string                 // Error 是运行失败时的错误信息
+	Outputs     map[string]interface{} // Outputs 是按 fields 配置筛选后的输出
+	Fields      []workflowField        // Fields 是按展示顺序排列的输出字段
+	ElapsedTime float64                // ElapsedTime 是运行耗时 (秒)
+	TotalTokens int                    // TotalTokens 是运行消耗的 token 总数
+	TotalSteps  int                    // TotalSteps 是运行的节点数
+	CreatedAt   time.Time              // CreatedAt 是开始运行的时间
+	FinishedAt  time.Time              // FinishedAt 是运行结束的时间
+}
+
+// newWorkflowRun 从工作流响应的 data 中读取运行结果，并按 fields 选择输出字段
+// fields 为空时展示全部字段 (按字段名排序)；fields 中列出但输出中不存在的字段会被跳过。
+func newWorkflowRun(data map[string]interface{}, fields []string) workflowRun {
+	str := func(key string) string { s, _ := data[key].(string); return s }
+	num := func(key string) float64 { f, _ := data[key].(float64); return f }
+	run := workflowRun{
+		RunID:       str("id"),
+		WorkflowID:  str("workflow_id"),
+		Status:      str("status"),
+		Error:       str("error"),
+		Outputs:     make(map[string]interface{}),
+		ElapsedTime: num("elapsed_time"),
+		TotalTokens: int(num("total_tokens")),
+		TotalSteps:  int(num("total_steps")),
+	}
+	if ts := num("created_at"); ts > 0 {
+		run.CreatedAt = time.Unix(int64(ts), 0)
+	}
+	if ts := num("finished_at"); ts > 0 {
+		run.FinishedAt = time.Unix(int64(ts), 0)
+	}
+	outputs, _ := data["outputs"].(map[string]interface{})
+	if len(fields) == 0 {
+		for name := range outputs {
+			fields = append(fields, name)
+		}
+		sort.Strings(fields)
+	}
+	for _, name := range fields {
+		value, ok := outputs[name]
+		if !ok {
+			continue
+		}
+		run.Outputs[name] = value
+		run.Fields = append(run.Fields, workflowField{Name: name, Value: value})
+	}
+	return run
+}
+
+// workflowOutput 是解析后的工作流输出配置
+type workflowOutput struct {
+	cfg    config.WorkflowOutputConfig // cfg 是工作流输出配置
+	body   *template.Template          // body 是正文模板，未配置时为 nil
+	title  *template.Template          // title 是标题模板，未配置时为 nil
+	url    *template.Template          // url 是跳转地址模板，未配置时为 nil
+	picURL *template.Template          // picURL 是图片地址模板，未配置时为 nil
+}
+
+// ValidateWorkflowOutput 检查工作流输出配置中的模板能否解析，在创建消息转换器之前调用，使模板错误在启动时暴露
+func ValidateWorkflowOutput(cfg config.WorkflowOutputConfig) error {
+	_, err := newWorkflowOutput(cfg)
+	return err
+}
+
+// newWorkflowOutput 解析工作流输出配置中的模板
+func newWorkflowOutput(cfg config.WorkflowOutputConfig) (*workflowOutput, error) {
+	o := &workflowOutput{cfg: cfg}
+	for _, t := range []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{"template", cfg.Template, &o.body},
+		{"title", cfg.Title, &o.title},
+		{"url", cfg.URL, &o.url},
+		{"pic_url", cfg.PicURL, &o.picURL},
+	} {
+		if t.text == "" {
+			continue
+		}
+		tmpl, err := template.New(t.name).Funcs(workflowFuncs).Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("解析 workflow_output.%s 模板失败: %w", t.name, err)
+		}
+		*t.dst = tmpl
+	}
+	return o, nil
+}
+
+// execute 使用运行结果渲染模板，模板为 nil 时返回 def
+// 本次输出中不存在的字段渲染为空字符串，而不是 text/template 默认的 "<no value>"，
+// 这样由输出字段决定的跳转地址为空时可以正确地改为发送 Markdown。
+func (o *workflowOutput) execute(tmpl *template.Template, run workflowRun, def string) (string, error) {
+	if tmpl == nil {
+		return def, nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, run); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.ReplaceAll(buf.String(), noValue, "")), nil
+}
+
+// send 按配置的消息类型渲染并发送工作流输出，返回发送的正文
+// 模板渲染失败时记录日志并改为按字段逐行列出，避免一次输出中的异常数据导致没有任何回复。
+func (o *workflowOutput) send(d *delivery, run workflowRun) (string, error) {
+	markdown := o.cfg.Format != workflowFormatText
+	body, err := o.execute(o.body, run, "")
+	if err != nil || o.body == nil {
+		if err != nil {
+			slog.WarnContext(d.ctx, "[Converter] 渲染工作流输出模板失败，改为逐行列出输出字段", "run_id", run.RunID, "error", err)
+		}
+		body = defaultWorkflowBody(run.Fields, markdown)
+	}
+	if o.cfg.Format != workflowFormatNews && o.cfg.Format != workflowFormatCard {
+		if markdown {
+			return body, d.sendMarkdown(body)
+		}
+		return body, d.sendText(body)
+	}
+
+	title, titleErr := o.execute(o.title, run, defaultWorkflowTitle)
+	url, urlErr := o.execute(o.url, run, "")
+	picURL, picErr := o.execute(o.picURL, run, "")
+	if err := errors.Join(titleErr, urlErr, picErr); err != nil {
+		slog.WarnContext(d.ctx, "[Converter] 渲染工作流输出的标题或地址失败，改为发送 Markdown", "run_id", run.RunID, "error", err)
+		return body, d.sendMarkdown(body)
+	}
+	if title == "" {
+		title = defaultWorkflowTitle
+	}
+	fallback := "**" + title + "**\n" + body
+	if url == "" {
+		// 跳转地址由输出字段决定且本次为空时，图文消息和模板卡片都无法发送
+		slog.WarnContext(d.ctx, "[Converter] 工作流输出的跳转地址为空，改为发送 Markdown", "run_id", run.RunID)
+		return body, d.sendMarkdown(fallback)
+	}
+	fallback += "\n[查看详情](" + url + ")"
+	summary := strings.Join(strings.Fields(markdownToText(body)), " ")
+	if o.cfg.Format == workflowFormatNews {
+		article := wecom.Article{Title: title, Description: truncateRunes(summary, maxNewsDescRunes-len("...")), URL: url, PicURL: picURL}
+		return body, d.sendNews([]wecom.Article{article}, fallback)
+	}
+	card := wecom.TemplateCard{
+		CardType:     "text_notice",
+		MainTitle:    map[string]string{"title": title},
+		SubTitleText: truncateRunes(summary, maxCardSubTitleRunes-len("...")),
+		CardAction:   map[string]interface{}{"type": 1, "url": url},
+	}
+	return body, d.sendTemplateCard(card, fallback)
+}
+
+// workflowFailure 返回运行失败时发送给用户的提示，错误信息过长时截断
+func workflowFailure(run workflowRun) string {
+	status := "失败"
+	if run.Status == "stopped" {
+		status = "已停止"
+	}
+	message := truncateRunes(strings.TrimSpace(run.Error), maxWorkflowErrorRunes)
+	if message == "" {
+		return fmt.Sprintf("工作流运行%s (状态: %s)。", status, run.Status)
+	}
+	return fmt.Sprintf("工作流运行%s: %s", status, message)
+}
+
+// defaultWorkflowBody 在没有配置正文模板时按字段逐行列出输出
+// 只有一个文本字段时直接返回该字段，这是最常见的 "工作流输出一段文本" 的情况；
+// 对象列表渲染为表格，其他列表和对象渲染为列表。
+func defaultWorkflowBody(fields []workflowField, markdown bool) string {
+	if len(fields) == 0 {
+		return "工作流运行完成，没有输出。"
+	}
+	if s, ok := fields[0].Value.(string); ok && len(fields) == 1 {
+		return s
+	}
+	lines := make([]string, 0, len(fields))
+	for _, f := range fields {
+		name := f.Name
+		if markdown {
+			name = "**" + name + "**"
+		}
+		var block string
+		switch v := f.Value.(type) {
+		case []interface{}:
+			if _, isObject := firstElem(v).(map[string]interface{}); isObject && markdown {
+				block = tableFunc(v)
+			} else {
+				block = listFunc(v)
+			}
+		case map[string]interface{}:
+			block = listFunc(v)
+		default:
+			value := formatValue(v)
+			if !strings.Contains(value, "\n") {
+				lines = append(lines, name+": "+value)
+				continue
+			}
+			block = value
+		}
+		lines = append(lines, name+"\n"+block)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// workflowFuncs 是工作流输出模板可以使用的辅助函数
+//
+//	table ROWS [COLUMN...]    将对象列表渲染为 Markdown 表格，未指定列时使用第一行的全部字段
+//	list ITEMS                将列表或对象渲染为 "- " 开头的 Markdown 列表
+//	number VALUE [DECIMALS]   千分位格式化数字，DECIMALS 为保留的小数位数
+//	date VALUE [LAYOUT]       格式化 Unix 时间戳 (秒或毫秒) 或 RFC 3339 时间，LAYOUT 为 Go 时间格式
+//	json VALUE                将值编码为缩进的 JSON
+//	default DEFAULT VALUE     VALUE 为空时返回 DEFAULT
+//	join ITEMS SEP            用 SEP 连接列表中的值
+var workflowFuncs = template.FuncMap{
+	"table":   tableFunc,
+	"list":    listFunc,
+	"number":  numberFunc,
+	"date":    dateFunc,
+	"json":    jsonFunc,
+	"default": defaultFunc,
+	"join":    joinFunc,
+}
+
+// toList 将任意类型的切片或数组转换为 []interface{}，其他值返回 false
+func toList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// firstElem 返回列表中的第一个元素，列表为空时返回 nil
+func firstElem(list []interface{}) interface{} {
+	if len(list) == 0 {
+		return nil
+	}
+	return list[0]
+}
+
+// tableFunc 将对象列表渲染为 Markdown 表格，单元格中的 "|" 和换行会被转义
+func tableFunc(rows interface{}, columns ...string) string {
+	list, ok := toList(rows)
+	if !ok {
+		return formatValue(rows)
+	}
+	if len(columns) == 0 {
+		if first, ok := firstElem(list).(map[string]interface{}); ok {
+			for name := range first {
+				columns = append(columns, name)
+			}
+			sort.Strings(columns)
+		}
+	}
+	if len(columns) == 0 {
+		return listFunc(list)
+	}
+	cell := func(v interface{}) string {
+		return strings.NewReplacer("|", "\\|", "\r", "", "\n", " ").Replace(formatValue(v))
+	}
+	var b strings.Builder
+	b.WriteString("| " + strings.Join(columns, " | ") + " |\n|")
+	b.WriteString(strings.Repeat(" --- |", len(columns)))
+	for _, row := range list {
+		object, _ := row.(map[string]interface{})
+		cells := make([]string, len(columns))
+		for i, name := range columns {
+			cells[i] = cell(object[name])
+		}
+		b.WriteString("\n| " + strings.Join(cells, " | ") + " |")
+	}
+	return b.String()
+}
+
+// listFunc 将列表渲染为 "- " 开头的 Markdown 列表，对象按字段名排序渲染为 "- 字段: 值"
+func listFunc(items interface{}) string {
+	if object, ok := items.(map[string]interface{}); ok {
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := make([]string, len(names))
+		for i, name := range names {
+			lines[i] = "- " + name + ": " + formatValue(object[name])
+		}
+		return strings.Join(lines, "\n")
+	}
+	list, ok := toList(items)
+	if !ok {
+		return formatValue(items)
+	}
+	lines := make([]string, len(list))
+	for i, item := range list {
+		lines[i] = "- " + formatValue(item)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// toFloat 将数字或数字字符串转换为 float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// numberFunc 以千分位格式化数字，例如 1234567.891 保留 2 位小数为 "1,234,567.89"
+// 未指定小数位数时整数不带小数，其他数字最多保留 2 位小数；无法识别的值原样返回。
+func numberFunc(value interface{}, decimals ...int) string {
+	f, ok := toFloat(value)
+	if !ok {
+		return formatValue(value)
+	}
+	var s string
+	switch {
+	case len(decimals) > 0:
+		s = strconv.FormatFloat(f, 'f', decimals[0], 64)
+	case f == math.Trunc(f):
+		s = strconv.FormatFloat(f, 'f', 0, 64)
+	default:
+		s = strings.TrimRight(strconv.FormatFloat(f, 'f', 2, 64), "0")
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	for i, r := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if fraction != "" {
+		return sign + b.String() + "." + fraction
+	}
+	return sign + b.String()
+}
+
+// dateFunc 格式化时间，支持 Unix 时间戳 (大于 1e12 时视为毫秒)、RFC 3339 和 "2006-01-02 15:04:05" 格式的字符串以及 time.Time
+// layout 为 Go 时间格式，默认 "2006-01-02 15:04"；无法识别的值原样返回。
+func dateFunc(value interface{}, layout ...string) string {
+	format := defaultDateLayout
+	if len(layout) > 0 && layout[0] != "" {
+		format = layout[0]
+	}
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string:
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if parsed, err = time.ParseInLocation("2006-01-02 15:04:05", v, time.Local); err != nil {
+				if ts, ok := toFloat(v); ok {
+					return dateFunc(ts, format)
+				}
+				return v
+			}
+		}
+		t = parsed
+	default:
+		ts, ok := toFloat(v)
+		if !ok {
+			return formatValue(value)
+		}
+		if ts > 1e12 {
+			t = time.UnixMilli(int64(ts))
+		} else {
+			t = time.Unix(int64(ts), 0)
+		}
+	}
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(format)
+}
+
+// jsonFunc 将值编码为缩进的 JSON，编码失败时返回 fmt 的格式化结果
+func jsonFunc(value interface{}) string {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// defaultFunc 在 value 为 nil、空字符串、空列表或空对象时返回 def，参数顺序便于在管道中使用：{{.Outputs.x | default "无"}}
+func defaultFunc(def, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return def
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return def
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			return def
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+// joinFunc 用 sep 连接列表中的值，value 不是列表时原样返回
+func joinFunc(items interface{}, sep string) string {
+	list, ok := toList(items)
+	if !ok {
+		return formatValue(items)
+	}
+	values := make([]string, len(list))
+	for i, item := range list {
+		values[i] = formatValue(item)
+	}
+	return strings.Join(values, sep)
+}
+
+// formatValue 将输出值格式化为文本：整数不带小数，nil 为空字符串，列表和对象编码为 JSON
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool, int, int64, json.Number:
+		return fmt.Sprint(v)
+	case time.Time:
+		return dateFunc(v)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
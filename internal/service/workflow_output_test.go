@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"dify2wxbot/internal/config"
+	"dify2wxbot/pkg/sender"
+	"dify2wxbot/pkg/wecom"
+)
+
+func TestConverterRendersWorkflowOutput(t *testing.T) {
+	status := "succeeded"
+	c, rec := newTestConverter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"workflow_run_id": "run1", "task_id": "t1", "data": {"id": "run1", "status": "` + status + `",
+			"error": "LLM 节点调用超时", "elapsed_time": 1.5, "total_tokens": 42,
+			"outputs": {"title": "销售日报", "total": 1234567.891, "rows": [{"region": "华东", "amount": 1200}, {"region": "华南|西南", "amount": 800}], "debug": "不展示"}}}`))
+	}, func(cfg *config.AppConfig) {
+		cfg.Dify.BotType = "workflow"
+		cfg.Dify.WorkflowOutput = config.WorkflowOutputConfig{
+			Fields:   []string{"title", "total", "rows", "missing"},
+			Template: "**{{.Outputs.title}}**\n合计: {{number .Outputs.total 2}}\n{{table .Outputs.rows \"region\" \"amount\"}}\n{{.Outputs.missing | default \"无备注\"}}",
+		}
+	})
+
+	result, err := c.ConvertAndSend(ConvertRequest{Message: "生成日报", User: "tester"})
+	if err != nil {
+		t.Fatalf("ConvertAndSend: %v", err)
+	}
+	want := "**销售日报**\n合计: 1,234,567.89\n| region | amount |\n| --- | --- |\n| 华东 | 1200 |\n| 华南\\|西南 | 800 |\n无备注"
+	got := rec.Records()
+	if len(got) != 1 || got[0].Kind != sender.KindMarkdown || got[0].Content != want || result.Answer != want {
+		t.Fatalf("recorder got %+v, want the rendered template %q", got, want)
+	}
+
+	status = "failed"
+	rec.Reset()
+	_, err = c.ConvertAndSend(ConvertRequest{Message: "生成日报", User: "tester"})
+	if !errors.Is(err, ErrWorkflowFailed) {
+		t.Fatalf("ConvertAndSend error = %v, want ErrWorkflowFailed", err)
+	}
+	got = rec.Records()
+	if len(got) != 1 || got[0].Kind != sender.KindText || got[0].Content != "工作流运行失败: LLM 节点调用超时" {
+		t.Fatalf("recorder got %+v, want the workflow error", got)
+	}
+}
+
+func TestNumberFunc(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		decimals []int
+		want     string
+	}{
+		{1234567.891, nil, "1,234,567.89"},
+		{1234567.891, []int{0}, "1,234,568"},
+		{1234.0, []int{2}, "1,234.00"},
+		{1000.0, nil, "1,000"},
+		{999.0, nil, "999"},
+		{12.5, nil, "12.5"},
+		{100.001, nil, "100"},
+		{-1234.5, nil, "-1,234.5"},
+		{-999999.0, nil, "-999,999"},
+		{0.5, nil, "0.5"},
+		{42, nil, "42"},
+		{" 2500 ", nil, "2,500"},
+		{"abc", nil, "abc"},
+		{nil, nil, ""},
+	}
+	for _, tt := range tests {
+		if got := numberFunc(tt.value, tt.decimals...); got != tt.want {
+			t.Errorf("number %v %v = %q, want %q", tt.value, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestDateFunc(t *testing.T) {
+	sec := time.Unix(1700000000, 0)
+	rfc := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value  interface{}
+		layout []string
+		want   string
+	}{
+		{1700000000.0, nil, sec.Local().Format(defaultDateLayout)},
+		{1700000000000.0, nil, sec.Local().Format(defaultDateLayout)}, // 毫秒时间戳
+		{"1700000000", nil, sec.Local().Format(defaultDateLayout)},
+		{1700000000.0, []string{"2006/01/02"}, sec.Local().Format("2006/01/02")},
+		{1700000000.0, []string{""}, sec.Local().Format(defaultDateLayout)},
+		{"2024-03-01T08:30:00Z", nil, rfc.Local().Format(defaultDateLayout)},
+		{"2024-03-01 08:30:00", nil, "2024-03-01 08:30"},
+		{rfc, []string{time.RFC3339}, rfc.Local().Format(time.RFC3339)},
+		{time.Time{}, nil, ""},
+		{"下周一", nil, "下周一"},
+		{nil, nil, ""},
+		{true, nil, "true"},
+	}
+	for _, tt := range tests {
+		if got := dateFunc(tt.value, tt.layout...); got != tt.want {
+			t.Errorf("date %v %q = %q, want %q", tt.value, tt.layout, got, tt.want)
+		}
+	}
+}
+
+func TestListFunc(t *testing.T) {
+	tests := []struct {
+		items interface{}
+		want  string
+	}{
+		{[]interface{}{"年假", 3.0, nil}, "- 年假\n- 3\n- "},
+		{[]string{"华东", "华南"}, "- 华东\n- 华南"},
+		{[]interface{}{map[string]interface{}{"a": 1.0}}, "- {\"a\":1}"},
+		{map[string]interface{}{"b": "二", "a": 1.5}, "- a: 1.5\n- b: 二"},
+		{[]interface{}{}, ""},
+		{"不是列表", "不是列表"},
+	}
+	for _, tt := range tests {
+		if got := listFunc(tt.items); got != tt.want {
+			t.Errorf("list %v = %q, want %q", tt.items, got, tt.want)
+		}
+	}
+}
+
+// newsRecorder 是支持图文消息的 sender.Recorder，记录发送的文章
+type newsRecorder struct {
+	*sender.Recorder
+	articles []wecom.Article
+}
+
+func (r *newsRecorder) SendNewsMessageContext(ctx context.Context, articles []wecom.Article) error {
+	r.articles = append(r.articles, articles...)
+	return nil
+}
+
+func TestWorkflowOutputRichFormats(t *testing.T) {
+	run := newWorkflowRun(map[string]interface{}{"id": "run1", "status": "succeeded",
+		"outputs": map[string]interface{}{"title": "销售日报", "summary": "**合计** " + strings.Repeat("华东增长明显，", 40), "link": "https://example.com/r/1"}}, nil)
+	send := func(t *testing.T, cfg config.WorkflowOutputConfig, robot sender.Sender) string {
+		t.Helper()
+		o, err := newWorkflowOutput(cfg)
+		if err != nil {
+			t.Fatalf("newWorkflowOutput: %v", err)
+		}
+		d := newDelivery(context.Background(), []string{"default"}, []sender.Sender{robot})
+		body, err := o.send(d, run)
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		return body
+	}
+	base := config.WorkflowOutputConfig{Template: "{{.Outputs.summary}}", Title: "{{.Outputs.title}}", URL: "{{.Outputs.link}}"}
+
+	t.Run("news", func(t *testing.T) {
+		cfg := base
+		cfg.Format = workflowFormatNews
+		rec := &newsRecorder{Recorder: sender.NewRecorder("default", weComCaps)}
+		send(t, cfg, rec)
+		if len(rec.articles) != 1 || len(rec.Records()) != 0 {
+			t.Fatalf("articles = %+v, records = %+v; want a single news message", rec.articles, rec.Records())
+		}
+		a := rec.articles[0]
+		if a.Title != "销售日报" || a.URL != "https://example.com/r/1" || !strings.HasPrefix(a.Description, "合计 华东") ||
+			utf8.RuneCountInString(a.Description) > maxNewsDescRunes {
+			t.Fatalf("article = %+v, want the title, link and a plain-text summary within %d runes", a, maxNewsDescRunes)
+		}
+	})
+
+	t.Run("template_card", func(t *testing.T) {
+		cfg := base
+		cfg.Format = workflowFormatCard
+		cfg.Title = ""
+		rec := &cardRecorder{Recorder: sender.NewRecorder("default", weComCaps)}
+		send(t, cfg, rec)
+		if len(rec.cards) != 1 || len(rec.Records()) != 0 {
+			t.Fatalf("cards = %+v, records = %+v; want a single card", rec.cards, rec.Records())
+		}
+		card := rec.cards[0]
+		title, _ := card.MainTitle.(map[string]string)
+		action, _ := card.CardAction.(map[string]interface{})
+		if card.CardType != "text_notice" || title["title"] != defaultWorkflowTitle ||
+			action["url"] != "https://example.com/r/1" || utf8.RuneCountInString(card.SubTitleText) > maxCardSubTitleRunes {
+			t.Fatalf("card = %+v, want a text_notice card with the default title and the link", card)
+		}
+	})
+
+	t.Run("unsupported target", func(t *testing.T) {
+		cfg := base
+		cfg.Format = workflowFormatCard
+		rec := sender.NewRecorder("default", weComCaps)
+		body := send(t, cfg, rec)
+		want := "**销售日报**\n" + body + "\n[查看详情](https://example.com/r/1)"
+		if got := rec.Records(); len(got) != 1 || got[0].Kind != sender.KindMarkdown || got[0].Content != want {
+			t.Fatalf("recorder got %+v, want the Markdown fallback with the link", got)
+		}
+	})
+
+	t.Run("empty url", func(t *testing.T) {
+		cfg := base
+		cfg.Format = workflowFormatNews
+		cfg.URL = "{{.Outputs.missing}}"
+		rec := &newsRecorder{Recorder: sender.NewRecorder("default", weComCaps)}
+		body := send(t, cfg, rec)
+		if got := rec.Records(); len(rec.articles) != 0 || len(got) != 1 || got[0].Content != "**销售日报**\n"+body {
+			t.Fatalf("articles = %+v, records = %+v; want the Markdown fallback without a link", rec.articles, got)
+		}
+	})
+}
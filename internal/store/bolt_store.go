@@ -0,0 +1,192 @@
+package store
+
+import (
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"log/slog"      // 导入 log/slog 包，用于日志输出，记录对话存储操作
+	"os"            // 导入 os 包，用于创建数据库文件所在目录
+	"path/filepath" // 导入 path/filepath 包，用于获取数据库文件所在目录
+	"time"          // 导入 time 包，用于设置打开数据库文件的超时时间和记录活跃时间
+
+	"github.com/google/uuid" // 导入 uuid 包，用于生成唯一标识符 (UUID) 作为对话 ID
+	bolt "go.etcd.io/bbolt"  // 导入 bbolt 包，纯 Go 实现的嵌入式 KV 数据库，用于持久化对话 ID
+)
+
+// conversationsBucket 是 BoltDB 中存放对话 ID 的 bucket 名称
+var conversationsBucket = []byte("conversations")
+
+// BoltConversationStore 是 ConversationStore 接口基于 BoltDB 文件的实现
+// 对话 ID 保存在本地文件中，服务重启后用户的对话上下文不会丢失，适用于单实例部署。
+type BoltConversationStore struct {
+	db   *bolt.DB // db 是 BoltDB 数据库实例，本身是并发安全的
+	stop func()   // stop 用于停止后台清理任务，未启动时为 nil
+}
+
+// NewBoltConversationStore 打开 (不存在时创建) 指定路径的 BoltDB 文件，并返回 BoltConversationStore 实例
+// path: 数据库文件路径，例如 "data/conversations.db"
+func NewBoltConversationStore(path string) (*BoltConversationStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create store directory %s: %w", dir, err)
+		}
+	}
+	// 设置超时时间，避免另一个进程持有文件锁时无限期阻塞
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt store %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(conversationsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt bucket: %w", err)
+	}
+	slog.Info("[ConversationStore] 已打开 BoltDB 对话存储", "path", path)
+	return &BoltConversationStore{db: db}, nil
+}
+
+// GetConversationID 根据用户 ID 获取对话 ID，并指示是否存在
+func (s *BoltConversationStore) GetConversationID(userID string) (string, bool) {
+	conversation, ok := s.GetConversation(userID)
+	if !ok {
+		slog.Debug("[ConversationStore] 未找到用户的对话ID", "user", userID)
+		return "", false
+	}
+	slog.Debug("[ConversationStore] 获取对话ID成功", "user", userID, "conversation_id", conversation.ID)
+	return conversation.ID, true
+}
+
+// GetConversation 获取用户完整的对话记录
+func (s *BoltConversationStore) GetConversation(userID string) (Conversation, bool) {
+	var conversation Conversation
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(conversationsBucket).Get([]byte(userID)); value != nil {
+			conversation = decodeConversation(value) // value 只在事务内有效，解码时会复制
+			found = conversation.ID != ""
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("[ConversationStore] 读取用户的对话ID失败", "user", userID, "error", err)
+		return Conversation{}, false
+	}
+	return conversation, found
+}
+
+// update 在一个写事务中读取用户的对话记录并写回 fn 计算出的新记录
+func (s *BoltConversationStore) update(userID string, fn func(prev Conversation, exists bool) Conversation) (Conversation, error) {
+	var next Conversation
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		var prev Conversation
+		value := bucket.Get([]byte(userID))
+		if value != nil {
+			prev = decodeConversation(value)
+		}
+		next = fn(prev, value != nil)
+		data, err := encodeConversation(next)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(userID), data)
+	})
+	return next, err
+}
+
+// SaveConversationID 保存或更新用户 ID 对应的对话 ID
+func (s *BoltConversationStore) SaveConversationID(userID, conversationID string) {
+	_, err := s.update(userID, func(prev Conversation, exists bool) Conversation {
+		return nextConversation(prev, exists, conversationID, false, time.Now())
+	})
+	if err != nil {
+		slog.Error("[ConversationStore] 保存用户的对话ID失败", "user", userID, "error", err)
+		return
+	}
+	slog.Debug("[ConversationStore] 保存对话ID成功", "user", userID, "conversation_id", conversationID)
+}
+
+// RecordTurn 记录用户完成了一轮问答
+func (s *BoltConversationStore) RecordTurn(userID, conversationID string) Conversation {
+	next, err := s.update(userID, func(prev Conversation, exists bool) Conversation {
+		return nextConversation(prev, exists, conversationID, true, time.Now())
+	})
+	if err != nil {
+		slog.Error("[ConversationStore] 记录用户的问答轮次失败", "user", userID, "error", err)
+		return next
+	}
+	slog.Debug("[ConversationStore] 记录问答轮次", "user", userID, "conversation_id", conversationID, "turns", next.Turns)
+	return next
+}
+
+// NewConversationID 为指定用户生成并保存一个新的对话 ID
+func (s *BoltConversationStore) NewConversationID(userID string) string {
+	conversationID := uuid.New().String()
+	s.SaveConversationID(userID, conversationID)
+	return conversationID
+}
+
+// DeleteConversationID 删除用户 ID 对应的对话 ID
+func (s *BoltConversationStore) DeleteConversationID(userID string) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Delete([]byte(userID))
+	})
+	if err != nil {
+		slog.Error("[ConversationStore] 删除用户的对话ID失败", "user", userID, "error", err)
+		return
+	}
+	slog.Debug("[ConversationStore] 删除用户的对话ID成功", "user", userID)
+}
+
+// Count 返回数据库中保存的对话数量
+func (s *BoltConversationStore) Count() (int, error) {
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(conversationsBucket).Stats().KeyN
+		return nil
+	})
+	return count, err
+}
+
+// StartJanitor 启动后台清理任务，每隔 interval 删除空闲时间超过 idleTTL 的对话
+// idleTTL 或 interval 不大于 0 时不启动。
+func (s *BoltConversationStore) StartJanitor(idleTTL, interval time.Duration) {
+	if idleTTL <= 0 || interval <= 0 {
+		return
+	}
+	if s.stop != nil {
+		s.stop()
+	}
+	s.stop = startJanitor(interval, func() {
+		cutoff := time.Now().Add(-idleTTL)
+		evicted := 0
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(conversationsBucket).Cursor()
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				conversation := decodeConversation(value)
+				if conversation.LastActive.IsZero() || !conversation.LastActive.Before(cutoff) {
+					continue // 早期版本保存的记录没有活跃时间，保留到用户下次访问时再判断
+				}
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				evicted++
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("[ConversationStore] 清理空闲对话失败", "error", err)
+		} else if evicted > 0 {
+			slog.Info("[ConversationStore] 已清理空闲对话", "evicted", evicted, "idle_ttl", idleTTL.String())
+		}
+	})
+}
+
+// Close 停止后台清理任务并关闭数据库文件
+func (s *BoltConversationStore) Close() error {
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+	return s.db.Close()
+}
@@ -1,8 +1,10 @@
 package store
 
 import (
-	"log"  // 导入 log 包，用于日志输出，记录对话存储操作
-	"sync" // 导入 sync 包，用于处理并发安全，通过读写互斥锁保护 map 访问
+	"encoding/json" // 导入 encoding/json 包，用于持久化实现中对话记录的编解码
+	"log/slog"      // 导入 log/slog 包，用于日志输出，记录对话存储操作
+	"sync"          // 导入 sync 包，用于处理并发安全，通过读写互斥锁保护 map 访问
+	"time"          // 导入 time 包，用于记录对话的创建和最后活跃时间
 
 	"github.com/google/uuid" // 导入 uuid 包，用于生成唯一标识符 (UUID) 作为对话 ID
 )
@@ -15,6 +17,7 @@ type ConversationStore interface {
 	GetConversationID(userID string) (string, bool)
 	// SaveConversationID 保存或更新用户 ID 对应的对话 ID。
 	// 如果 userID 已存在，则更新其 conversationID；如果不存在，则添加新的映射。
+	// 对话 ID 发生变化时，轮数和创建时间会重新计算。
 	// userID: 用户的唯一标识符。
 	// conversationID: 要保存或更新的对话 ID。
 	SaveConversationID(userID, conversationID string)
@@ -25,36 +28,118 @@ type ConversationStore interface {
 	// DeleteConversationID 删除用户 ID 对应的对话 ID。
 	// userID: 用户的唯一标识符。
 	DeleteConversationID(userID string)
+	// GetConversation 获取用户完整的对话记录，包括轮数和最后活跃时间。
+	// userID: 用户的唯一标识符。
+	GetConversation(userID string) (Conversation, bool)
+	// RecordTurn 记录用户在指定对话中完成了一轮问答，更新轮数和最后活跃时间，并返回更新后的记录。
+	// 如果 conversationID 与已保存的不同，则视为开始了新的对话，轮数从 1 开始计算。
+	// userID: 用户的唯一标识符。
+	// conversationID: 本轮问答所在的对话 ID。
+	RecordTurn(userID, conversationID string) Conversation
+	// Count 返回当前保存的对话数量，用于监控存储规模。
+	Count() (int, error)
+	// Close 释放存储占用的资源，例如关闭数据库文件或网络连接。
+	Close() error
+}
+
+// Conversation 描述一个用户当前的对话状态
+type Conversation struct {
+	ID         string    `json:"id"`          // ID 是 Dify 对话 ID
+	Turns      int       `json:"turns"`       // Turns 是该对话已完成的问答轮数
+	CreatedAt  time.Time `json:"created_at"`  // CreatedAt 是对话开始的时间
+	LastActive time.Time `json:"last_active"` // LastActive 是最后一次问答的时间
+}
+
+// 对话过期原因，用于告知调用方为何开启了新的对话
+const (
+	ExpireReasonNone     = ""          // 未过期
+	ExpireReasonIdle     = "idle"      // 超过空闲时间未活跃
+	ExpireReasonMaxTurns = "max_turns" // 问答轮数达到上限
+	ExpireReasonManual   = "manual"    // 用户主动重置
+)
+
+// Policy 定义对话的过期策略
+type Policy struct {
+	IdleTTL  time.Duration // IdleTTL 是对话的最长空闲时间，超过后开启新对话，0 表示不限制
+	MaxTurns int           // MaxTurns 是单个对话的最大问答轮数，达到后开启新对话，0 表示不限制
+}
+
+// Expired 判断对话在 now 时刻是否已过期，并返回过期原因
+func (p Policy) Expired(c Conversation, now time.Time) (bool, string) {
+	if p.IdleTTL > 0 && !c.LastActive.IsZero() && now.Sub(c.LastActive) > p.IdleTTL {
+		return true, ExpireReasonIdle
+	}
+	if p.MaxTurns > 0 && c.Turns >= p.MaxTurns {
+		return true, ExpireReasonMaxTurns
+	}
+	return false, ExpireReasonNone
+}
+
+// nextConversation 根据已有记录计算保存或记录一轮问答后的新记录
+// turn 为 true 时轮数加一。
+func nextConversation(prev Conversation, exists bool, conversationID string, turn bool, now time.Time) Conversation {
+	next := prev
+	if !exists || prev.ID != conversationID {
+		next = Conversation{ID: conversationID, CreatedAt: now}
+	}
+	if turn {
+		next.Turns++
+	}
+	next.LastActive = now
+	return next
+}
+
+// encodeConversation 将对话记录编码为 JSON，供持久化实现使用
+func encodeConversation(c Conversation) ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// decodeConversation 解码持久化的对话记录
+// 兼容早期版本直接保存对话 ID 字符串的格式。
+func decodeConversation(data []byte) Conversation {
+	var c Conversation
+	if len(data) > 0 && data[0] == '{' && json.Unmarshal(data, &c) == nil {
+		return c
+	}
+	return Conversation{ID: string(data)}
 }
 
 // InMemoryConversationStore 是 ConversationStore 接口的内存实现
 // 它将对话 ID 存储在内存中的一个 map 中，适用于不需要持久化存储的场景。
 type InMemoryConversationStore struct {
-	store map[string]string // 存储用户 ID (string) 到对话 ID (string) 的映射
-	mu    sync.RWMutex      // 读写互斥锁，用于保证在并发访问 map 时的线程安全
+	store map[string]Conversation // 存储用户 ID (string) 到对话记录的映射
+	mu    sync.RWMutex            // 读写互斥锁，用于保证在并发访问 map 时的线程安全
+	stop  func()                  // stop 用于停止后台清理任务，未启动时为 nil
 }
 
 // NewInMemoryConversationStore 创建并返回一个新的 InMemoryConversationStore 实例
 // 这是 InMemoryConversationStore 的构造函数，负责初始化内部的 map。
 func NewInMemoryConversationStore() *InMemoryConversationStore {
 	return &InMemoryConversationStore{
-		store: make(map[string]string), // 初始化存储 map，准备接收数据
+		store: make(map[string]Conversation), // 初始化存储 map，准备接收数据
 	}
 }
 
 // GetConversationID 根据用户 ID 获取对话 ID，并指示是否存在
 // 该方法是并发安全的，通过获取读锁来保护对 map 的读取操作。
 func (s *InMemoryConversationStore) GetConversationID(userID string) (string, bool) {
-	s.mu.RLock()         // 获取读锁，允许多个读取者同时访问
-	defer s.mu.RUnlock() // 确保在函数返回时释放读锁
-
-	conversationID, ok := s.store[userID] // 从 map 中查找对话 ID
+	conversation, ok := s.GetConversation(userID)
 	if ok {
-		log.Printf("[ConversationStore] 获取对话ID成功，用户: '%s', 对话ID: '%s'", userID, conversationID)
+		slog.Debug("[ConversationStore] 获取对话ID成功", "user", userID, "conversation_id", conversation.ID)
 	} else {
-		log.Printf("[ConversationStore] 未找到用户 '%s' 的对话ID", userID)
+		slog.Debug("[ConversationStore] 未找到用户的对话ID", "user", userID)
 	}
-	return conversationID, ok // 返回对话 ID 和一个布尔值，指示是否找到
+	return conversation.ID, ok // 返回对话 ID 和一个布尔值，指示是否找到
+}
+
+// GetConversation 获取用户完整的对话记录
+// 该方法是并发安全的，通过获取读锁来保护对 map 的读取操作。
+func (s *InMemoryConversationStore) GetConversation(userID string) (Conversation, bool) {
+	s.mu.RLock()         // 获取读锁，允许多个读取者同时访问
+	defer s.mu.RUnlock() // 确保在函数返回时释放读锁
+
+	conversation, ok := s.store[userID] // 从 map 中查找对话记录
+	return conversation, ok
 }
 
 // SaveConversationID 保存或更新用户 ID 对应的对话 ID
@@ -63,8 +148,22 @@ func (s *InMemoryConversationStore) SaveConversationID(userID, conversationID st
 	s.mu.Lock()         // 获取写锁，独占访问，防止其他读写操作
 	defer s.mu.Unlock() // 确保在函数返回时释放写锁
 
-	s.store[userID] = conversationID // 设置或更新用户 ID 对应的对话 ID
-	log.Printf("[ConversationStore] 保存对话ID成功，用户: '%s', 对话ID: '%s'", userID, conversationID)
+	prev, ok := s.store[userID]
+	s.store[userID] = nextConversation(prev, ok, conversationID, false, time.Now()) // 设置或更新用户 ID 对应的对话记录
+	slog.Debug("[ConversationStore] 保存对话ID成功", "user", userID, "conversation_id", conversationID)
+}
+
+// RecordTurn 记录用户完成了一轮问答
+// 该方法是并发安全的，通过获取写锁来保护对 map 的写入操作。
+func (s *InMemoryConversationStore) RecordTurn(userID, conversationID string) Conversation {
+	s.mu.Lock()         // 获取写锁
+	defer s.mu.Unlock() // 确保在函数返回时释放写锁
+
+	prev, ok := s.store[userID]
+	next := nextConversation(prev, ok, conversationID, true, time.Now())
+	s.store[userID] = next
+	slog.Debug("[ConversationStore] 记录问答轮次", "user", userID, "conversation_id", conversationID, "turns", next.Turns)
+	return next
 }
 
 // NewConversationID 为指定用户生成并保存一个新的对话 ID
@@ -75,8 +174,8 @@ func (s *InMemoryConversationStore) NewConversationID(userID string) string {
 
 	// 使用 UUID 包生成一个全局唯一的对话 ID
 	conversationID := uuid.New().String()
-	s.store[userID] = conversationID // 将新生成的对话 ID 保存到 map 中
-	log.Printf("[ConversationStore] 为用户 '%s' 生成并保存新的对话ID: '%s'", userID, conversationID)
+	s.store[userID] = nextConversation(Conversation{}, false, conversationID, false, time.Now()) // 将新生成的对话 ID 保存到 map 中
+	slog.Debug("[ConversationStore] 为用户生成并保存新的对话ID", "user", userID, "conversation_id", conversationID)
 	return conversationID // 返回新生成的对话 ID
 }
 
@@ -87,5 +186,66 @@ func (s *InMemoryConversationStore) DeleteConversationID(userID string) {
 	defer s.mu.Unlock() // 确保在函数返回时释放写锁
 
 	delete(s.store, userID) // 从 map 中删除指定用户 ID 的对话 ID
-	log.Printf("[ConversationStore] 删除用户 '%s' 的对话ID成功", userID)
+	slog.Debug("[ConversationStore] 删除用户的对话ID成功", "user", userID)
+}
+
+// Count 返回内存中保存的对话数量
+func (s *InMemoryConversationStore) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.store), nil
+}
+
+// StartJanitor 启动后台清理任务，每隔 interval 删除空闲时间超过 idleTTL 的对话
+// idleTTL 或 interval 不大于 0 时不启动。重复调用会先停止之前的清理任务。
+func (s *InMemoryConversationStore) StartJanitor(idleTTL, interval time.Duration) {
+	if idleTTL <= 0 || interval <= 0 {
+		return
+	}
+	if s.stop != nil {
+		s.stop()
+	}
+	s.stop = startJanitor(interval, func() {
+		cutoff := time.Now().Add(-idleTTL)
+		s.mu.Lock()
+		evicted := 0
+		for userID, conversation := range s.store {
+			if conversation.LastActive.Before(cutoff) {
+				delete(s.store, userID)
+				evicted++
+			}
+		}
+		s.mu.Unlock()
+		if evicted > 0 {
+			slog.Info("[ConversationStore] 已清理空闲对话", "evicted", evicted, "idle_ttl", idleTTL.String())
+		}
+	})
+}
+
+// Close 停止后台清理任务，内存实现无需释放其他资源
+func (s *InMemoryConversationStore) Close() error {
+	if s.stop != nil {
+		s.stop()
+		s.stop = nil
+	}
+	return nil
+}
+
+// startJanitor 启动一个每隔 interval 执行一次 sweep 的后台 goroutine，并返回停止函数
+func startJanitor(interval time.Duration, sweep func()) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
 }
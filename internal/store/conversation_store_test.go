@@ -0,0 +1,240 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// backends 返回所有需要通过一致性测试的 ConversationStore 实现
+func backends(t *testing.T) map[string]func(t *testing.T) ConversationStore {
+	return map[string]func(t *testing.T) ConversationStore{
+		"memory": func(t *testing.T) ConversationStore {
+			return NewInMemoryConversationStore()
+		},
+		"bolt": func(t *testing.T) ConversationStore {
+			s, err := NewBoltConversationStore(filepath.Join(t.TempDir(), "conversations.db"))
+			if err != nil {
+				t.Fatalf("NewBoltConversationStore: %v", err)
+			}
+			return s
+		},
+		"redis": func(t *testing.T) ConversationStore {
+			mr := miniredis.RunT(t)
+			s, err := NewRedisConversationStore(RedisOptions{Addr: mr.Addr()})
+			if err != nil {
+				t.Fatalf("NewRedisConversationStore: %v", err)
+			}
+			return s
+		},
+	}
+}
+
+// TestConversationStoreConformance 对每个存储实现运行同一套行为测试
+func TestConversationStoreConformance(t *testing.T) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Run("GetMissing", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				if id, ok := s.GetConversationID("nobody"); ok || id != "" {
+					t.Fatalf("GetConversationID(missing) = %q, %v; want \"\", false", id, ok)
+				}
+			})
+
+			t.Run("SaveAndGet", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				s.SaveConversationID("alice", "conv-1")
+				if id, ok := s.GetConversationID("alice"); !ok || id != "conv-1" {
+					t.Fatalf("GetConversationID = %q, %v; want conv-1, true", id, ok)
+				}
+				s.SaveConversationID("alice", "conv-2")
+				if id, _ := s.GetConversationID("alice"); id != "conv-2" {
+					t.Fatalf("GetConversationID after overwrite = %q; want conv-2", id)
+				}
+			})
+
+			t.Run("UsersAreIsolated", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				s.SaveConversationID("alice", "conv-a")
+				s.SaveConversationID("bob", "conv-b")
+				if id, _ := s.GetConversationID("alice"); id != "conv-a" {
+					t.Fatalf("alice = %q; want conv-a", id)
+				}
+				if id, _ := s.GetConversationID("bob"); id != "conv-b" {
+					t.Fatalf("bob = %q; want conv-b", id)
+				}
+			})
+
+			t.Run("NewConversationID", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				first := s.NewConversationID("alice")
+				second := s.NewConversationID("alice")
+				if first == "" || first == second {
+					t.Fatalf("NewConversationID returned %q then %q; want distinct non-empty IDs", first, second)
+				}
+				if id, _ := s.GetConversationID("alice"); id != second {
+					t.Fatalf("GetConversationID = %q; want %q", id, second)
+				}
+			})
+
+			t.Run("Delete", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				s.SaveConversationID("alice", "conv-1")
+				s.DeleteConversationID("alice")
+				if _, ok := s.GetConversationID("alice"); ok {
+					t.Fatal("GetConversationID after delete reported ok")
+				}
+				s.DeleteConversationID("alice") // 删除不存在的键不应报错或 panic
+			})
+
+			t.Run("Count", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				if n, err := s.Count(); err != nil || n != 0 {
+					t.Fatalf("Count(empty) = %d, %v; want 0, nil", n, err)
+				}
+				s.SaveConversationID("alice", "conv-a")
+				s.SaveConversationID("bob", "conv-b")
+				s.SaveConversationID("alice", "conv-a2")
+				if n, err := s.Count(); err != nil || n != 2 {
+					t.Fatalf("Count = %d, %v; want 2, nil", n, err)
+				}
+				s.DeleteConversationID("bob")
+				if n, _ := s.Count(); n != 1 {
+					t.Fatalf("Count after delete = %d; want 1", n)
+				}
+			})
+
+			t.Run("Concurrent", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				var wg sync.WaitGroup
+				for i := 0; i < 20; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						user := string(rune('a' + i))
+						s.SaveConversationID(user, "conv-"+user)
+						s.GetConversationID(user)
+					}(i)
+				}
+				wg.Wait()
+				if id, _ := s.GetConversationID("c"); id != "conv-c" {
+					t.Fatalf("GetConversationID(c) = %q; want conv-c", id)
+				}
+			})
+
+			t.Run("RecordTurn", func(t *testing.T) {
+				s := newStore(t)
+				defer s.Close()
+				s.RecordTurn("alice", "conv-1")
+				c := s.RecordTurn("alice", "conv-1")
+				if c.ID != "conv-1" || c.Turns != 2 {
+					t.Fatalf("RecordTurn = %+v; want conv-1 with 2 turns", c)
+				}
+				got, ok := s.GetConversation("alice")
+				if !ok || got.Turns != 2 || got.LastActive.IsZero() || got.CreatedAt.IsZero() {
+					t.Fatalf("GetConversation = %+v, %v; want 2 turns with timestamps", got, ok)
+				}
+				// 对话 ID 变化时视为新对话，轮数重新计算
+				if c := s.RecordTurn("alice", "conv-2"); c.ID != "conv-2" || c.Turns != 1 {
+					t.Fatalf("RecordTurn(new conversation) = %+v; want conv-2 with 1 turn", c)
+				}
+			})
+		})
+	}
+}
+
+// TestPolicyExpired 验证空闲时间和轮数上限的判断
+func TestPolicyExpired(t *testing.T) {
+	now := time.Now()
+	policy := Policy{IdleTTL: time.Hour, MaxTurns: 3}
+	cases := []struct {
+		name   string
+		c      Conversation
+		reason string
+	}{
+		{"fresh", Conversation{ID: "c", Turns: 1, LastActive: now.Add(-time.Minute)}, ExpireReasonNone},
+		{"idle", Conversation{ID: "c", Turns: 1, LastActive: now.Add(-2 * time.Hour)}, ExpireReasonIdle},
+		{"max turns", Conversation{ID: "c", Turns: 3, LastActive: now}, ExpireReasonMaxTurns},
+		{"legacy record", Conversation{ID: "c"}, ExpireReasonNone},
+	}
+	for _, tc := range cases {
+		expired, reason := policy.Expired(tc.c, now)
+		if reason != tc.reason || expired != (tc.reason != ExpireReasonNone) {
+			t.Errorf("%s: Expired = %v, %q; want %q", tc.name, expired, reason, tc.reason)
+		}
+	}
+	if expired, _ := (Policy{}).Expired(Conversation{ID: "c", Turns: 100}, now); expired {
+		t.Error("zero Policy should never expire a conversation")
+	}
+}
+
+// TestInMemoryJanitorEvictsIdle 验证内存实现的后台清理任务会删除空闲的对话
+func TestInMemoryJanitorEvictsIdle(t *testing.T) {
+	s := NewInMemoryConversationStore()
+	defer s.Close()
+	s.SaveConversationID("alice", "conv-1")
+	s.StartJanitor(20*time.Millisecond, 5*time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := s.GetConversationID("alice"); !ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("janitor did not evict idle conversation")
+}
+
+// TestDecodeLegacyConversation 验证早期版本直接保存的对话 ID 字符串仍能被读取
+func TestDecodeLegacyConversation(t *testing.T) {
+	if c := decodeConversation([]byte("conv-legacy")); c.ID != "conv-legacy" || c.Turns != 0 {
+		t.Fatalf("decodeConversation(legacy) = %+v", c)
+	}
+}
+
+// TestBoltConversationStorePersists 验证 BoltDB 实现在重新打开文件后仍能读取到之前保存的数据
+func TestBoltConversationStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conversations.db")
+	s, err := NewBoltConversationStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltConversationStore: %v", err)
+	}
+	s.SaveConversationID("alice", "conv-1")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = NewBoltConversationStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	if id, ok := s.GetConversationID("alice"); !ok || id != "conv-1" {
+		t.Fatalf("GetConversationID after reopen = %q, %v; want conv-1, true", id, ok)
+	}
+}
+
+// TestRedisConversationStoreIdleTTL 验证 Redis 实现通过键过期清理空闲的对话
+func TestRedisConversationStoreIdleTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, err := NewRedisConversationStore(RedisOptions{Addr: mr.Addr(), IdleTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("NewRedisConversationStore: %v", err)
+	}
+	defer s.Close()
+	s.RecordTurn("alice", "conv-1")
+	mr.FastForward(2 * time.Minute)
+	if _, ok := s.GetConversationID("alice"); ok {
+		t.Fatal("conversation still present after idle TTL elapsed")
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"fmt"  // 导入 fmt 包，用于格式化错误信息
+	"time" // 导入 time 包，用于换算过期时间和清理间隔
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于读取对话存储配置
+)
+
+const (
+	defaultBoltPath        = "data/conversations.db" // BoltDB 数据库文件的默认路径
+	defaultJanitorInterval = 60 * time.Second        // 后台清理空闲对话的默认间隔
+)
+
+// NewConversationStore 根据配置创建对应类型的 ConversationStore
+// cfg.Type 为空或 "memory" 时返回内存实现；"bolt" 返回本地文件实现；"redis" 返回 Redis 实现。
+// 配置了空闲时间时，memory 和 bolt 会启动后台清理任务，redis 则通过键过期自动清理。
+func NewConversationStore(cfg config.StoreConfig) (ConversationStore, error) {
+	policy := NewPolicy(cfg)
+	interval := time.Duration(cfg.JanitorInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+
+	switch cfg.Type {
+	case "", "memory":
+		s := NewInMemoryConversationStore()
+		s.StartJanitor(policy.IdleTTL, interval)
+		return s, nil
+	case "bolt":
+		path := cfg.Path
+		if path == "" {
+			path = defaultBoltPath
+		}
+		s, err := NewBoltConversationStore(path)
+		if err != nil {
+			return nil, err
+		}
+		s.StartJanitor(policy.IdleTTL, interval)
+		return s, nil
+	case "redis":
+		return NewRedisConversationStore(RedisOptions{
+			Addr:      cfg.RedisAddr,
+			Password:  cfg.RedisPassword,
+			DB:        cfg.RedisDB,
+			KeyPrefix: cfg.KeyPrefix,
+			IdleTTL:   policy.IdleTTL,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported conversation store type: %s", cfg.Type)
+	}
+}
+
+// NewPolicy 根据配置创建对话过期策略
+func NewPolicy(cfg config.StoreConfig) Policy {
+	return Policy{
+		IdleTTL:  time.Duration(cfg.IdleTTLMinutes) * time.Minute,
+		MaxTurns: cfg.MaxTurns,
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"  // 导入 context 包，用于控制 Redis 命令的超时时间
+	"errors"   // 导入 errors 包，用于识别 redis.Nil (键不存在) 和事务冲突
+	"fmt"      // 导入 fmt 包，用于格式化错误信息
+	"log/slog" // 导入 log/slog 包，用于日志输出，记录对话存储操作
+	"time"     // 导入 time 包，用于设置命令超时时间和键的过期时间
+
+	"github.com/google/uuid"       // 导入 uuid 包，用于生成唯一标识符 (UUID) 作为对话 ID
+	"github.com/redis/go-redis/v9" // 导入 go-redis 包，用于访问 Redis
+)
+
+const (
+	redisCommandTimeout   = 3 * time.Second    // 单个 Redis 命令的超时时间
+	redisCountTimeout     = 10 * time.Second   // 统计对话数量时遍历键空间的超时时间
+	redisScanBatch        = 1000               // 统计对话数量时每次 SCAN 返回的建议键数
+	defaultRedisKeyPrefix = "dify2wxbot:conv:" // 默认的 Redis 键前缀
+)
+
+// RedisConversationStore 是 ConversationStore 接口基于 Redis 的实现
+// 多个服务副本共享同一个 Redis 时，用户的对话上下文在副本之间保持一致，适用于多副本部署。
+type RedisConversationStore struct {
+	client    *redis.Client // client 是 Redis 客户端实例，本身是并发安全的
+	keyPrefix string        // keyPrefix 是所有键的前缀，用于与同一 Redis 中的其他数据隔离
+	idleTTL   time.Duration // idleTTL 是键的过期时间，每次写入时刷新，0 表示永不过期
+}
+
+// RedisOptions 定义连接 Redis 所需的参数
+type RedisOptions struct {
+	Addr      string        // Addr 是 Redis 地址，例如 "localhost:6379"
+	Password  string        // Password 是 Redis 密码，可以为空
+	DB        int           // DB 是 Redis 数据库编号
+	KeyPrefix string        // KeyPrefix 是键前缀，为空时使用 "dify2wxbot:conv:"
+	IdleTTL   time.Duration // IdleTTL 是对话的最长空闲时间，由 Redis 键过期自动清理，0 表示不过期
+}
+
+// NewRedisConversationStore 连接 Redis 并返回 RedisConversationStore 实例
+// 创建时会执行一次 PING，确保 Redis 可用。
+func NewRedisConversationStore(opts RedisOptions) (*RedisConversationStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     opts.Addr,
+		Password: opts.Password,
+		DB:       opts.DB,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis %s: %w", opts.Addr, err)
+	}
+
+	prefix := opts.KeyPrefix
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	slog.Info("[ConversationStore] 已连接 Redis 对话存储", "addr", opts.Addr, "db", opts.DB, "prefix", prefix)
+	return &RedisConversationStore{client: client, keyPrefix: prefix, idleTTL: opts.IdleTTL}, nil
+}
+
+// key 返回用户 ID 对应的 Redis 键
+func (s *RedisConversationStore) key(userID string) string {
+	return s.keyPrefix + userID
+}
+
+// GetConversationID 根据用户 ID 获取对话 ID，并指示是否存在
+func (s *RedisConversationStore) GetConversationID(userID string) (string, bool) {
+	conversation, ok := s.GetConversation(userID)
+	if !ok {
+		slog.Debug("[ConversationStore] 未找到用户的对话ID", "user", userID)
+		return "", false
+	}
+	slog.Debug("[ConversationStore] 获取对话ID成功", "user", userID, "conversation_id", conversation.ID)
+	return conversation.ID, true
+}
+
+// GetConversation 获取用户完整的对话记录
+func (s *RedisConversationStore) GetConversation(userID string) (Conversation, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.key(userID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Conversation{}, false
+	}
+	if err != nil {
+		slog.Error("[ConversationStore] 读取用户的对话ID失败", "user", userID, "error", err)
+		return Conversation{}, false
+	}
+	conversation := decodeConversation(data)
+	return conversation, conversation.ID != ""
+}
+
+// update 使用 WATCH 乐观锁读取用户的对话记录并写回 fn 计算出的新记录
+// 多个副本同时修改同一用户时，失败的一方会重新读取后再次尝试。
+func (s *RedisConversationStore) update(userID string, fn func(prev Conversation, exists bool) Conversation) (Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+	defer cancel()
+
+	key := s.key(userID)
+	var next Conversation
+	txf := func(tx *redis.Tx) error {
+		var prev Conversation
+		data, err := tx.Get(ctx, key).Bytes()
+		exists := err == nil
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if exists {
+			prev = decodeConversation(data)
+		}
+		next = fn(prev, exists)
+		value, err := encodeConversation(next)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, value, s.idleTTL) // 每次写入都会刷新过期时间
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		err := s.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return next, err
+		}
+	}
+	return next, fmt.Errorf("conversation for user %s was modified concurrently", userID)
+}
+
+// SaveConversationID 保存或更新用户 ID 对应的对话 ID
+func (s *RedisConversationStore) SaveConversationID(userID, conversationID string) {
+	_, err := s.update(userID, func(prev Conversation, exists bool) Conversation {
+		return nextConversation(prev, exists, conversationID, false, time.Now())
+	})
+	if err != nil {
+		slog.Error("[ConversationStore] 保存用户的对话ID失败", "user", userID, "error", err)
+		return
+	}
+	slog.Debug("[ConversationStore] 保存对话ID成功", "user", userID, "conversation_id", conversationID)
+}
+
+// RecordTurn 记录用户完成了一轮问答
+func (s *RedisConversationStore) RecordTurn(userID, conversationID string) Conversation {
+	next, err := s.update(userID, func(prev Conversation, exists bool) Conversation {
+		return nextConversation(prev, exists, conversationID, true, time.Now())
+	})
+	if err != nil {
+		slog.Error("[ConversationStore] 记录用户的问答轮次失败", "user", userID, "error", err)
+		return next
+	}
+	slog.Debug("[ConversationStore] 记录问答轮次", "user", userID, "conversation_id", conversationID, "turns", next.Turns)
+	return next
+}
+
+// NewConversationID 为指定用户生成并保存一个新的对话 ID
+func (s *RedisConversationStore) NewConversationID(userID string) string {
+	conversationID := uuid.New().String()
+	s.SaveConversationID(userID, conversationID)
+	return conversationID
+}
+
+// DeleteConversationID 删除用户 ID 对应的对话 ID
+func (s *RedisConversationStore) DeleteConversationID(userID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCommandTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, s.key(userID)).Err(); err != nil {
+		slog.Error("[ConversationStore] 删除用户的对话ID失败", "user", userID, "error", err)
+		return
+	}
+	slog.Debug("[ConversationStore] 删除用户的对话ID成功", "user", userID)
+}
+
+// Count 返回带有键前缀的对话数量
+// 通过 SCAN 遍历键空间而不是 KEYS，避免在键很多时阻塞 Redis；已过期的键不会被计入。
+func (s *RedisConversationStore) Count() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCountTimeout)
+	defer cancel()
+
+	count := 0
+	iter := s.client.Scan(ctx, 0, s.keyPrefix+"*", redisScanBatch).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to count conversations in redis: %w", err)
+	}
+	return count, nil
+}
+
+// Close 关闭 Redis 连接
+func (s *RedisConversationStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,280 @@
+// Package dingtalk 实现钉钉群自定义机器人的消息发送
+// 自定义机器人通过 Webhook 发送文本和 Markdown 消息，开启 "加签" 安全设置时
+// 需要在请求地址中附加 timestamp 和 sign 参数；自定义机器人不能上传图片和文件。
+package dingtalk
+
+import (
+	"bytes"           // 导入 bytes 包，用于构建 HTTP 请求体
+	"context"         // 导入 context 包，用于取消等待配额和进行中的请求
+	"crypto/hmac"     // 导入 crypto/hmac 包，用于计算加签的 HMAC
+	"crypto/sha256"   // 导入 crypto/sha256 包，加签使用 HmacSHA256 算法
+	"encoding/base64" // 导入 encoding/base64 包，用于编码签名
+	"encoding/json"   // 导入 encoding/json 包，用于 JSON 数据的编解码
+	"errors"          // 导入 errors 包，用于识别网络错误
+	"fmt"             // 导入 fmt 包，用于格式化错误信息
+	"io"              // 导入 io 包，用于读取响应体
+	"log/slog"        // 导入 log/slog 包，用于结构化日志输出
+	"net/http"        // 导入 net/http 包，用于发送 HTTP 请求
+	"net/url"         // 导入 net/url 包，用于在请求地址中附加签名参数
+	"strconv"         // 导入 strconv 包，用于格式化时间戳和错误码
+	"strings"         // 导入 strings 包，用于生成 Markdown 消息标题和 @ 提醒
+	"time"            // 导入 time 包，用于生成签名时间戳和限流
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取机器人配置
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录消息发送结果
+	"dify2wxbot/pkg/sender"       // 导入 pkg/sender 包，Robot 实现其中的 Sender 接口
+)
+
+const (
+	channel                   = "dingtalk"       // channel 是指标中的渠道名称
+	defaultRateLimitPerMinute = 20               // 钉钉自定义机器人默认的发送频率上限：每分钟 20 条
+	rateLimitWindowLength     = time.Minute      // 频率限制的统计周期
+	maxRateLimitRetries       = 3                // 触发频率限制后的最大重试次数
+	rateLimitBackoff          = 10 * time.Second // 触发频率限制后的退避时间
+	errCodeRateLimited        = 130101           // 发送速度太快的错误码
+	maxMessageBytes           = 4096             // 单条消息内容的最大字节数，钉钉未公布精确上限，保守取值
+	maxTitleRunes             = 30               // Markdown 消息标题的最大字符数，标题只显示在会话列表和通知中
+	resultLabelHTTP           = "http"           // 请求未得到钉钉业务响应时使用的结果标签
+)
+
+var (
+	_ sender.Sender        = (*Robot)(nil) // Robot 实现了 Sender 接口
+	_ sender.MentionSender = (*Robot)(nil) // Robot 支持在文本消息中 @成员
+)
+
+// APIError 表示钉钉接口返回的业务错误 (errcode 不为 0)
+type APIError struct {
+	MsgType string // MsgType 是发送的消息类型
+	ErrCode int    // ErrCode 是钉钉返回的错误码
+	ErrMsg  string // ErrMsg 是钉钉返回的错误信息
+}
+
+// Error 实现 error 接口
+func (e *APIError) Error() string {
+	return fmt.Sprintf("dingtalk %s message failed: %s (errcode: %d)", e.MsgType, e.ErrMsg, e.ErrCode)
+}
+
+// At 描述消息中需要 @ 的成员
+type At struct {
+	AtUserIDs []string `json:"atUserIds,omitempty"` // AtUserIDs 是需要 @ 的成员 userId 列表
+	AtMobiles []string `json:"atMobiles,omitempty"` // AtMobiles 是需要 @ 的成员手机号列表
+	IsAtAll   bool     `json:"isAtAll,omitempty"`   // IsAtAll 表示是否 @所有人
+}
+
+// Robot 是钉钉群自定义机器人的客户端
+type Robot struct {
+	cfg        config.WeComConfig  // cfg 存储机器人配置，包含 Webhook URL 和加签密钥
+	httpClient *http.Client        // httpClient 用于发送请求并复用连接
+	limiter    *sender.RateLimiter // limiter 控制发送频率，不超过钉钉每分钟 20 条的限制
+}
+
+// NewRobot 创建并返回一个新的 Robot 实例
+// cfg: 机器人配置，type 为 "dingtalk"，包含 Webhook URL、加签密钥 (可选) 和发送频率限制
+func NewRobot(cfg config.WeComConfig) *Robot {
+	limit := cfg.RateLimitPerMinute
+	if limit <= 0 {
+		limit = defaultRateLimitPerMinute
+	}
+	return &Robot{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limiter:    sender.NewRateLimiter(limit, rateLimitWindowLength),
+	}
+}
+
+// Name 返回机器人名称
+func (r *Robot) Name() string {
+	return r.cfg.Name
+}
+
+// Capabilities 返回钉钉自定义机器人支持的消息能力：文本和钉钉 Markdown 各 4096 字节，支持 @成员；
+// 不支持发送本地图片和文件。
+func (r *Robot) Capabilities() sender.Capabilities {
+	return sender.Capabilities{
+		MaxTextBytes:     maxMessageBytes,
+		MaxMarkdownBytes: maxMessageBytes,
+		Markdown:         sender.MarkdownDingTalk,
+		Mentions:         true,
+	}
+}
+
+// sign 计算加签所需的签名：以密钥为 HmacSHA256 的密钥对 "timestamp\n密钥" 签名，再进行 base64 编码
+func sign(timestamp int64, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// webhookURL 返回本次请求的地址，配置了加签密钥时附加 timestamp (毫秒) 和 sign 参数
+func (r *Robot) webhookURL() (string, error) {
+	if r.cfg.Secret == "" {
+		return r.cfg.WebhookURL, nil
+	}
+	u, err := url.Parse(r.cfg.WebhookURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse dingtalk webhook url: %w", err)
+	}
+	timestamp := time.Now().UnixMilli()
+	q := u.Query()
+	q.Set("timestamp", strconv.FormatInt(timestamp, 10))
+	q.Set("sign", sign(timestamp, r.cfg.Secret))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// send 发送一条消息
+// 发送前等待频率配额；钉钉返回 130101 (发送速度太快) 时整体退避后重试，最多重试 maxRateLimitRetries 次。
+func (r *Robot) send(ctx context.Context, msgType string, msg map[string]interface{}) error {
+	msg["msgtype"] = msgType
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dingtalk %s message: %w", msgType, err)
+	}
+	for attempt := 0; ; attempt++ {
+		if err := r.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := r.post(ctx, msgType, jsonData)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.ErrCode == errCodeRateLimited && attempt < maxRateLimitRetries {
+			slog.WarnContext(ctx, "[DingTalk] 触发钉钉频率限制 (130101)，稍后重试", "robot", r.cfg.Name, "msgtype", msgType,
+				"backoff", rateLimitBackoff.String(), "attempt", attempt+1, "max_attempts", maxRateLimitRetries)
+			r.limiter.Pause(rateLimitBackoff)
+			continue
+		}
+		return err
+	}
+}
+
+// post 将已编码的消息 POST 到钉钉 Webhook 并解析返回结果，每次调用都按结果记录到指标中
+// 签名有效期为一小时，因此每次请求 (包括重试) 都重新生成签名。
+func (r *Robot) post(ctx context.Context, msgType string, jsonData []byte) (err error) {
+	start := time.Now()
+	result := resultLabelHTTP
+	defer func() {
+		metrics.ChannelMessages.Inc(channel, msgType, result)
+	}()
+
+	webhookURL, err := r.webhookURL()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create dingtalk %s message request: %w", msgType, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // webhook URL 中包含 access_token 和签名，不出现在错误信息中
+		}
+		return fmt.Errorf("failed to send dingtalk %s message: %w", msgType, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read dingtalk %s response body: %w", msgType, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to send dingtalk %s message (status code %d): %s", msgType, resp.StatusCode, string(body))
+	}
+	var res struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return fmt.Errorf("failed to parse dingtalk %s response: %w, body: %s", msgType, err, string(body))
+	}
+	result = strconv.Itoa(res.ErrCode)
+	if res.ErrCode != 0 {
+		return &APIError{MsgType: msgType, ErrCode: res.ErrCode, ErrMsg: res.ErrMsg}
+	}
+
+	slog.InfoContext(ctx, "[DingTalk] 消息成功发送到钉钉", "robot", r.cfg.Name, "msgtype", msgType, "duration", time.Since(start).String())
+	return nil
+}
+
+// SendTextMessage 向钉钉群发送文本消息
+// message: 文本消息内容
+func (r *Robot) SendTextMessage(message string) error {
+	return r.SendTextMessageContext(context.Background(), message)
+}
+
+// SendTextMessageContext 与 SendTextMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (r *Robot) SendTextMessageContext(ctx context.Context, message string) error {
+	return r.send(ctx, "text", map[string]interface{}{
+		"text": map[string]string{"content": message},
+	})
+}
+
+// SendTextWithMentionMessage 向钉钉群发送带 @ 提醒的文本消息
+// 钉钉要求消息内容中包含 "@userId" 或 "@手机号" 才会显示提醒，因此会在内容末尾追加这些文字。
+// content: 文本消息内容
+// mentionedList: 需要 @ 的成员 userId 列表
+// mentionedMobileList: 需要 @ 的成员手机号列表
+func (r *Robot) SendTextWithMentionMessage(content string, mentionedList []string, mentionedMobileList []string) error {
+	return r.SendTextWithMentionMessageContext(context.Background(), content, mentionedList, mentionedMobileList)
+}
+
+// SendTextWithMentionMessageContext 与 SendTextWithMentionMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (r *Robot) SendTextWithMentionMessageContext(ctx context.Context, content string, mentionedList []string, mentionedMobileList []string) error {
+	return r.send(ctx, "text", map[string]interface{}{
+		"text": map[string]string{"content": content + mentionSuffix(mentionedList, mentionedMobileList)},
+		"at":   At{AtUserIDs: mentionedList, AtMobiles: mentionedMobileList},
+	})
+}
+
+// SendMarkdownMessage 向钉钉群发送 Markdown 消息，标题取内容的第一行
+// content: Markdown 格式的内容
+func (r *Robot) SendMarkdownMessage(content string) error {
+	return r.SendMarkdownMessageContext(context.Background(), content)
+}
+
+// SendMarkdownMessageContext 与 SendMarkdownMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (r *Robot) SendMarkdownMessageContext(ctx context.Context, content string) error {
+	return r.send(ctx, "markdown", map[string]interface{}{
+		"markdown": map[string]string{"title": markdownTitle(content), "text": content},
+	})
+}
+
+// SendImageMessageContext 自定义机器人无法上传图片，始终返回 sender.ErrUnsupported
+func (r *Robot) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	return fmt.Errorf("dingtalk custom robot cannot upload images: %w", sender.ErrUnsupported)
+}
+
+// SendFileMessageContext 自定义机器人无法上传文件，始终返回 sender.ErrUnsupported
+func (r *Robot) SendFileMessageContext(ctx context.Context, filePath string) error {
+	return fmt.Errorf("dingtalk custom robot cannot upload files: %w", sender.ErrUnsupported)
+}
+
+// markdownTitle 取 Markdown 内容第一个非空行作为消息标题，去掉开头的 # 和 > 标记，超过 maxTitleRunes 个字符时截断
+func markdownTitle(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#> "))
+		if line == "" {
+			continue
+		}
+		if runes := []rune(line); len(runes) > maxTitleRunes {
+			line = string(runes[:maxTitleRunes]) + "…"
+		}
+		return line
+	}
+	return "新消息"
+}
+
+// mentionSuffix 返回追加在文本消息末尾的 @ 提醒文字，例如 "\n@zhangsan @13800000000"
+func mentionSuffix(userIDs, mobiles []string) string {
+	var b strings.Builder
+	for _, id := range append(append([]string(nil), userIDs...), mobiles...) {
+		if b.Len() == 0 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(" ")
+		}
+		b.WriteString("@" + id)
+	}
+	return b.String()
+}
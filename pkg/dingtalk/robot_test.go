@@ -0,0 +1,72 @@
+package dingtalk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"dify2wxbot/internal/config"
+)
+
+func TestSign(t *testing.T) {
+	// 加签算法：以密钥为密钥对 "timestamp\n密钥" 计算 HmacSHA256，再进行 base64 编码，timestamp 为毫秒
+	if got, want := sign(1577262236757, "SECdemo0123456789"), "xW+bcfmS4/UzY2P4aK6TlT1k6PcYJIdJMoGpgmdRZW8="; got != want {
+		t.Fatalf("sign = %q, want %q", got, want)
+	}
+}
+
+func TestRobotSignsRequestURL(t *testing.T) {
+	var queries []url.Values
+	var bodies []map[string]interface{}
+	errcode := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		queries = append(queries, r.URL.Query())
+		bodies = append(bodies, body)
+		w.Write([]byte(`{"errcode": ` + strconv.Itoa(errcode) + `, "errmsg": "sign not match"}`))
+	}))
+	defer srv.Close()
+	r := NewRobot(config.WeComConfig{Name: "dingtalk", Type: "dingtalk", WebhookURL: srv.URL + "/robot/send?access_token=abc", Secret: "SECdemo0123456789"})
+
+	if err := r.SendMarkdownMessageContext(context.Background(), "## 请假流程\n提交申请"); err != nil {
+		t.Fatalf("SendMarkdownMessageContext: %v", err)
+	}
+	if err := r.SendTextWithMentionMessageContext(context.Background(), "请审批", []string{"u1"}, []string{"13800000000"}); err != nil {
+		t.Fatalf("SendTextWithMentionMessageContext: %v", err)
+	}
+	for _, q := range queries {
+		ts, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+		if err != nil || time.Since(time.UnixMilli(ts)).Abs() > time.Minute {
+			t.Fatalf("timestamp = %q, want the current time in milliseconds", q.Get("timestamp"))
+		}
+		if q.Get("sign") != sign(ts, "SECdemo0123456789") || q.Get("access_token") != "abc" {
+			t.Fatalf("query = %v, want access_token kept and the signature of timestamp %d", q, ts)
+		}
+	}
+	markdown := bodies[0]["markdown"].(map[string]interface{})
+	if bodies[0]["msgtype"] != "markdown" || markdown["title"] != "请假流程" || markdown["text"] != "## 请假流程\n提交申请" {
+		t.Fatalf("markdown message = %v, want the first line as title", bodies[0])
+	}
+	text := bodies[1]["text"].(map[string]interface{})["content"]
+	at := bodies[1]["at"].(map[string]interface{})
+	if text != "请审批\n@u1 @13800000000" || !reflect.DeepEqual(at["atUserIds"], []interface{}{"u1"}) || !reflect.DeepEqual(at["atMobiles"], []interface{}{"13800000000"}) {
+		t.Fatalf("mention message = %v, want the mentions in content and at", bodies[1])
+	}
+
+	errcode = 310000
+	err := r.SendTextMessageContext(context.Background(), "你好")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrCode != 310000 {
+		t.Fatalf("error = %v, want the dingtalk APIError", err)
+	}
+}
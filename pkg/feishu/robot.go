@@ -0,0 +1,217 @@
+// Package feishu 实现飞书 (Lark) 群自定义机器人的消息发送
+// 自定义机器人只能通过 Webhook 发送文本、富文本 (post) 和消息卡片 (interactive)，本包发送文本消息和
+// 只包含一个 markdown 模块的消息卡片；图片和文件需要通过开放平台应用上传，因此不支持发送本地图片和文件。
+package feishu
+
+import (
+	"bytes"           // 导入 bytes 包，用于构建 HTTP 请求体
+	"context"         // 导入 context 包，用于取消等待配额和进行中的请求
+	"crypto/hmac"     // 导入 crypto/hmac 包，用于计算签名校验的 HMAC
+	"crypto/sha256"   // 导入 crypto/sha256 包，签名使用 HmacSHA256 算法
+	"encoding/base64" // 导入 encoding/base64 包，用于编码签名
+	"encoding/json"   // 导入 encoding/json 包，用于 JSON 数据的编解码
+	"errors"          // 导入 errors 包，用于识别网络错误
+	"fmt"             // 导入 fmt 包，用于格式化错误信息
+	"io"              // 导入 io 包，用于读取响应体
+	"log/slog"        // 导入 log/slog 包，用于结构化日志输出
+	"net/http"        // 导入 net/http 包，用于发送 HTTP 请求
+	"net/url"         // 导入 net/url 包，用于从网络错误中去掉包含凭据的 webhook URL
+	"strconv"         // 导入 strconv 包，用于格式化时间戳和错误码
+	"time"            // 导入 time 包，用于生成签名时间戳和限流
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取机器人配置
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录消息发送结果
+	"dify2wxbot/pkg/sender"       // 导入 pkg/sender 包，Robot 实现其中的 Sender 接口
+)
+
+const (
+	channel                   = "feishu"         // channel 是指标中的渠道名称
+	defaultRateLimitPerMinute = 100              // 飞书自定义机器人默认的发送频率上限：每分钟 100 条
+	rateLimitWindowLength     = time.Minute      // 频率限制的统计周期
+	maxRateLimitRetries       = 3                // 触发频率限制后的最大重试次数
+	rateLimitBackoff          = 10 * time.Second // 触发频率限制后的退避时间
+	codeRateLimited           = 9499             // 请求过于频繁的错误码
+	codeFrequencyLimited      = 11232            // 发送频率超过限制的错误码
+	maxMessageBytes           = 18 * 1024        // 单条消息内容的最大字节数，请求体上限为 20KB，为 JSON 结构和签名留出余量
+	resultLabelHTTP           = "http"           // 请求未得到飞书业务响应时使用的结果标签
+)
+
+var _ sender.Sender = (*Robot)(nil) // Robot 实现了 Sender 接口
+
+// APIError 表示飞书接口返回的业务错误 (code 不为 0)
+type APIError struct {
+	MsgType string // MsgType 是发送的消息类型
+	Code    int    // Code 是飞书返回的错误码
+	Msg     string // Msg 是飞书返回的错误信息
+}
+
+// Error 实现 error 接口
+func (e *APIError) Error() string {
+	return fmt.Sprintf("feishu %s message failed: %s (code: %d)", e.MsgType, e.Msg, e.Code)
+}
+
+// rateLimited 判断错误是否为频率超限
+func (e *APIError) rateLimited() bool {
+	return e.Code == codeRateLimited || e.Code == codeFrequencyLimited
+}
+
+// Robot 是飞书群自定义机器人的客户端
+type Robot struct {
+	cfg        config.WeComConfig  // cfg 存储机器人配置，包含 Webhook URL 和签名密钥
+	httpClient *http.Client        // httpClient 用于发送请求并复用连接
+	limiter    *sender.RateLimiter // limiter 控制发送频率，不超过飞书的频率限制
+}
+
+// NewRobot 创建并返回一个新的 Robot 实例
+// cfg: 机器人配置，type 为 "feishu"，包含 Webhook URL、签名密钥 (可选) 和发送频率限制
+func NewRobot(cfg config.WeComConfig) *Robot {
+	limit := cfg.RateLimitPerMinute
+	if limit <= 0 {
+		limit = defaultRateLimitPerMinute
+	}
+	return &Robot{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limiter:    sender.NewRateLimiter(limit, rateLimitWindowLength),
+	}
+}
+
+// Name 返回机器人名称
+func (r *Robot) Name() string {
+	return r.cfg.Name
+}
+
+// Capabilities 返回飞书自定义机器人支持的消息能力：文本和卡片 Markdown 各约 18KB；
+// 不支持发送本地图片和文件，也无法通过企业微信的成员 ID @成员。
+func (r *Robot) Capabilities() sender.Capabilities {
+	return sender.Capabilities{
+		MaxTextBytes:     maxMessageBytes,
+		MaxMarkdownBytes: maxMessageBytes,
+		Markdown:         sender.MarkdownCommonMark,
+	}
+}
+
+// sign 计算签名校验所需的签名：以 "timestamp\n密钥" 为 HmacSHA256 的密钥对空字符串签名，再进行 base64 编码
+func sign(timestamp int64, secret string) string {
+	h := hmac.New(sha256.New, []byte(strconv.FormatInt(timestamp, 10)+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// send 发送一条消息，配置了签名密钥时附加 timestamp 和 sign 字段
+// 发送前等待频率配额；飞书返回频率超限时整体退避后重试，最多重试 maxRateLimitRetries 次。
+func (r *Robot) send(ctx context.Context, msgType string, msg map[string]interface{}) error {
+	msg["msg_type"] = msgType
+	for attempt := 0; ; attempt++ {
+		if err := r.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		if r.cfg.Secret != "" {
+			timestamp := time.Now().Unix()
+			msg["timestamp"] = strconv.FormatInt(timestamp, 10)
+			msg["sign"] = sign(timestamp, r.cfg.Secret)
+		}
+		jsonData, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to marshal feishu %s message: %w", msgType, err)
+		}
+		err = r.post(ctx, msgType, jsonData)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.rateLimited() && attempt < maxRateLimitRetries {
+			slog.WarnContext(ctx, "[Feishu] 触发飞书频率限制，稍后重试", "robot", r.cfg.Name, "msgtype", msgType,
+				"code", apiErr.Code, "backoff", rateLimitBackoff.String(), "attempt", attempt+1, "max_attempts", maxRateLimitRetries)
+			r.limiter.Pause(rateLimitBackoff)
+			continue
+		}
+		return err
+	}
+}
+
+// post 将已编码的消息 POST 到飞书 Webhook 并解析返回结果，每次调用都按结果记录到指标中
+func (r *Robot) post(ctx context.Context, msgType string, jsonData []byte) (err error) {
+	start := time.Now()
+	result := resultLabelHTTP
+	defer func() {
+		metrics.ChannelMessages.Inc(channel, msgType, result)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create feishu %s message request: %w", msgType, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // webhook URL 中包含凭据，不出现在错误信息中
+		}
+		return fmt.Errorf("failed to send feishu %s message: %w", msgType, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read feishu %s response body: %w", msgType, err)
+	}
+	// 飞书在业务错误时也可能返回非 200 状态码，因此先尝试解析业务错误码
+	var res struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to send feishu %s message (status code %d): %s", msgType, resp.StatusCode, string(body))
+		}
+		return fmt.Errorf("failed to parse feishu %s response: %w, body: %s", msgType, err, string(body))
+	}
+	result = strconv.Itoa(res.Code)
+	if res.Code != 0 {
+		return &APIError{MsgType: msgType, Code: res.Code, Msg: res.Msg}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to send feishu %s message (status code %d): %s", msgType, resp.StatusCode, string(body))
+	}
+
+	slog.InfoContext(ctx, "[Feishu] 消息成功发送到飞书", "robot", r.cfg.Name, "msgtype", msgType, "duration", time.Since(start).String())
+	return nil
+}
+
+// SendTextMessage 向飞书群发送文本消息
+// message: 文本消息内容
+func (r *Robot) SendTextMessage(message string) error {
+	return r.SendTextMessageContext(context.Background(), message)
+}
+
+// SendTextMessageContext 与 SendTextMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (r *Robot) SendTextMessageContext(ctx context.Context, message string) error {
+	return r.send(ctx, "text", map[string]interface{}{
+		"content": map[string]string{"text": message},
+	})
+}
+
+// SendMarkdownMessage 向飞书群发送 Markdown 消息
+// 自定义机器人的文本和富文本消息不支持 Markdown，因此以只包含一个 markdown 元素的消息卡片发送。
+// content: Markdown 格式的内容
+func (r *Robot) SendMarkdownMessage(content string) error {
+	return r.SendMarkdownMessageContext(context.Background(), content)
+}
+
+// SendMarkdownMessageContext 与 SendMarkdownMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (r *Robot) SendMarkdownMessageContext(ctx context.Context, content string) error {
+	return r.send(ctx, "interactive", map[string]interface{}{
+		"card": map[string]interface{}{
+			"config":   map[string]bool{"wide_screen_mode": true},
+			"elements": []map[string]string{{"tag": "markdown", "content": content}},
+		},
+	})
+}
+
+// SendImageMessageContext 自定义机器人无法上传图片，始终返回 sender.ErrUnsupported
+func (r *Robot) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	return fmt.Errorf("feishu custom robot cannot upload images: %w", sender.ErrUnsupported)
+}
+
+// SendFileMessageContext 自定义机器人无法上传文件，始终返回 sender.ErrUnsupported
+func (r *Robot) SendFileMessageContext(ctx context.Context, filePath string) error {
+	return fmt.Errorf("feishu custom robot cannot upload files: %w", sender.ErrUnsupported)
+}
@@ -0,0 +1,69 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"dify2wxbot/internal/config"
+)
+
+func TestSign(t *testing.T) {
+	// 签名校验算法：以 "timestamp\n密钥" 为密钥对空字符串计算 HmacSHA256，再进行 base64 编码
+	if got, want := sign(1599360473, "demo-secret"), "3/MaVZ8JLIy4TUG+7KSFJqvUkTKd+HWY8g+56DZWq8s="; got != want {
+		t.Fatalf("sign = %q, want %q", got, want)
+	}
+}
+
+func TestRobotSendsSignedMessages(t *testing.T) {
+	var bodies []map[string]interface{}
+	code := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.Write([]byte(`{"code": ` + strconv.Itoa(code) + `, "msg": "sign match fail or timestamp is not within one hour from current time"}`))
+	}))
+	defer srv.Close()
+	r := NewRobot(config.WeComConfig{Name: "feishu", Type: "feishu", WebhookURL: srv.URL + "/open-apis/bot/v2/hook/abc", Secret: "demo-secret"})
+
+	if err := r.SendTextMessageContext(context.Background(), "你好"); err != nil {
+		t.Fatalf("SendTextMessageContext: %v", err)
+	}
+	if err := r.SendMarkdownMessageContext(context.Background(), "**加粗**"); err != nil {
+		t.Fatalf("SendMarkdownMessageContext: %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("webhook got %d requests, want 2", len(bodies))
+	}
+	for _, body := range bodies {
+		ts, err := strconv.ParseInt(body["timestamp"].(string), 10, 64)
+		if err != nil || time.Since(time.Unix(ts, 0)).Abs() > time.Minute {
+			t.Fatalf("timestamp = %v, want the current time in seconds", body["timestamp"])
+		}
+		if body["sign"] != sign(ts, "demo-secret") {
+			t.Fatalf("sign = %v, want the signature of timestamp %d", body["sign"], ts)
+		}
+	}
+	if bodies[0]["msg_type"] != "text" || bodies[0]["content"].(map[string]interface{})["text"] != "你好" {
+		t.Fatalf("text message = %v", bodies[0])
+	}
+	elements := bodies[1]["card"].(map[string]interface{})["elements"].([]interface{})
+	if bodies[1]["msg_type"] != "interactive" || len(elements) != 1 || elements[0].(map[string]interface{})["content"] != "**加粗**" {
+		t.Fatalf("markdown message = %v, want a card with a single markdown element", bodies[1])
+	}
+
+	code = 19021
+	err := r.SendTextMessageContext(context.Background(), "你好")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 19021 {
+		t.Fatalf("error = %v, want the feishu APIError", err)
+	}
+}
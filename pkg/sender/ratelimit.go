@@ -0,0 +1,79 @@
+package sender
+
+import (
+	"context" // 导入 context 包，用于在等待配额时响应取消
+	"sync"    // 导入 sync 包，用于保护发送记录的并发访问
+	"time"    // 导入 time 包，用于计算统计窗口和等待时间
+)
+
+// RateLimiter 是一个滑动窗口限流器，供各个渠道的发送方控制发送频率
+// 它记录最近一个周期内每次发送的时间，任意连续的一个周期内发送数都不超过 limit，
+// 与企业微信、钉钉等按滚动分钟统计的服务端限制一致；令牌桶在突发之后的第一个周期内可以发出接近两倍的消息。
+type RateLimiter struct {
+	mu          sync.Mutex       // mu 保护以下字段
+	limit       int              // limit 是每个周期允许发送的消息数
+	window      time.Duration    // window 是统计周期的长度
+	sent        []time.Time      // sent 是周期内已发送消息的时间，按时间先后排列
+	pausedUntil time.Time        // pausedUntil 是被限流后暂停发送的截止时间
+	now         func() time.Time // now 返回当前时间，测试中可以替换
+}
+
+// NewRateLimiter 创建一个任意 per 时间内最多允许 limit 次发送的限流器
+// limit 必须大于 0，由调用方按渠道的频率限制提供默认值。
+func NewRateLimiter(limit int, per time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		window: per,
+		sent:   make([]time.Time, 0, limit),
+		now:    time.Now,
+	}
+}
+
+// Take 尝试取得一次发送配额
+// 成功时返回 0 并记录本次发送；否则返回需要等待的时间 (直到周期内最早的一次发送移出窗口)，调用方应等待后重试。
+func (b *RateLimiter) Take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+	// 移除已经移出统计窗口的发送记录
+	expired := 0
+	for expired < len(b.sent) && !b.sent[expired].Add(b.window).After(now) {
+		expired++
+	}
+	b.sent = append(b.sent[:0], b.sent[expired:]...)
+	if len(b.sent) < b.limit {
+		b.sent = append(b.sent, now)
+		return 0
+	}
+	return b.sent[0].Add(b.window).Sub(now)
+}
+
+// Wait 阻塞直到取得一次发送配额，ctx 被取消时返回 ctx.Err()
+func (b *RateLimiter) Wait(ctx context.Context) error {
+	for wait := b.Take(); wait > 0; wait = b.Take() {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Pause 暂停发送 d 时间，暂停期间 Take 不发放配额
+// 在服务端返回频率超限 (例如企业微信的 45009) 时调用，说明服务端的计数与本地不一致，需要整体退避；
+// 已记录的发送仍留在窗口中，暂停结束后同样受周期内发送数的限制。
+func (b *RateLimiter) Pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until := b.now().Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
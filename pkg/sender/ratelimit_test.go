@@ -0,0 +1,88 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock 是可以手动推进的时钟
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(limit int, per time.Duration) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)}
+	b := NewRateLimiter(limit, per)
+	b.now = clock.now
+	return b, clock
+}
+
+func TestRateLimiterNeverExceedsLimitInAnyWindow(t *testing.T) {
+	const limit = 20
+	b, clock := newTestLimiter(limit, time.Minute)
+
+	// 每秒尝试一次，持续 5 分钟，记录所有成功发送的时间
+	var sent []time.Time
+	for i := 0; i < 300; i++ {
+		if b.Take() == 0 {
+			sent = append(sent, clock.now())
+		}
+		clock.advance(time.Second)
+	}
+	for i := range sent {
+		count := 0
+		for _, s := range sent[i:] {
+			if s.Sub(sent[i]) < time.Minute {
+				count++
+			}
+		}
+		if count > limit {
+			t.Fatalf("%d messages sent within one minute starting at %v, limit is %d", count, sent[i], limit)
+		}
+	}
+	if len(sent) != 5*limit {
+		t.Fatalf("sent %d messages in 5 minutes, want %d", len(sent), 5*limit)
+	}
+}
+
+func TestRateLimiterWaitsForOldestSendToLeaveWindow(t *testing.T) {
+	b, clock := newTestLimiter(2, time.Minute)
+	b.Take()
+	clock.advance(10 * time.Second)
+	b.Take()
+	if wait := b.Take(); wait != 50*time.Second {
+		t.Fatalf("wait = %v, want 50s until the first send leaves the window", wait)
+	}
+	clock.advance(50 * time.Second)
+	if wait := b.Take(); wait != 0 {
+		t.Fatalf("wait = %v after the first send left the window, want 0", wait)
+	}
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	b, clock := newTestLimiter(20, time.Minute)
+	b.Pause(10 * time.Second)
+	if wait := b.Take(); wait != 10*time.Second {
+		t.Fatalf("wait = %v during pause, want 10s", wait)
+	}
+	b.Pause(time.Second) // 较短的暂停不会缩短已有的暂停
+	clock.advance(10 * time.Second)
+	if wait := b.Take(); wait != 0 {
+		t.Fatalf("wait = %v after pause, want 0", wait)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	b := NewRateLimiter(1, time.Hour)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,119 @@
+package sender
+
+import (
+	"context" // 导入 context 包，用于实现 Sender 接口
+	"os"      // 导入 os 包，用于在发送时读取图片和文件内容
+	"sync"    // 导入 sync 包，用于保护已记录的消息
+)
+
+const (
+	KindText     = "text"     // KindText 是文本消息
+	KindMarkdown = "markdown" // KindMarkdown 是 Markdown 消息
+	KindImage    = "image"    // KindImage 是图片消息
+	KindFile     = "file"     // KindFile 是文件消息
+)
+
+// Record 是 Recorder 记录的一条消息
+type Record struct {
+	Kind     string   // Kind 是消息类型：text、markdown、image 或 file
+	Content  string   // Content 是文本或 Markdown 消息的内容
+	Path     string   // Path 是图片或文件消息的本地路径
+	Data     []byte   // Data 是发送时读取到的图片或文件内容，调用方通常会在发送后删除临时文件
+	Mentions []string // Mentions 是文本消息中 @ 的成员 ID
+}
+
+// Recorder 是把消息记录在内存中的发送方，不发起任何网络请求
+// 用于在测试中代替真实的发送方，检查 MessageConverter 发送了哪些消息以及如何按能力调整了内容。
+type Recorder struct {
+	name string       // name 是投递目标的名称
+	caps Capabilities // caps 是声明的消息能力
+
+	mu      sync.Mutex // mu 保护 records 和 err
+	records []Record   // records 是按发送顺序记录的消息
+	err     error      // err 不为 nil 时所有发送都返回该错误，且不记录消息
+}
+
+var (
+	_ Sender        = (*Recorder)(nil) // Recorder 实现了 Sender 接口
+	_ MentionSender = (*Recorder)(nil) // Recorder 实现了 MentionSender 接口
+)
+
+// NewRecorder 创建并返回一个新的 Recorder 实例
+// name: 投递目标的名称
+// caps: 声明的消息能力，用于测试内容调整逻辑
+func NewRecorder(name string, caps Capabilities) *Recorder {
+	return &Recorder{name: name, caps: caps}
+}
+
+// Name 返回发送方名称
+func (r *Recorder) Name() string {
+	return r.name
+}
+
+// Capabilities 返回创建时声明的消息能力
+func (r *Recorder) Capabilities() Capabilities {
+	return r.caps
+}
+
+// FailWith 使之后的发送都返回 err，用于模拟发送失败；传入 nil 恢复正常
+func (r *Recorder) FailWith(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// Records 返回已记录消息的副本
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+// Reset 清空已记录的消息
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = nil
+}
+
+// record 记录一条消息，设置了 FailWith 时返回该错误
+func (r *Recorder) record(ctx context.Context, rec Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.records = append(r.records, rec)
+	return nil
+}
+
+// SendTextMessageContext 记录一条文本消息
+func (r *Recorder) SendTextMessageContext(ctx context.Context, content string) error {
+	return r.record(ctx, Record{Kind: KindText, Content: content})
+}
+
+// SendTextWithMentionMessageContext 记录一条带 @ 提醒的文本消息，手机号与成员 ID 一起记录在 Mentions 中
+func (r *Recorder) SendTextWithMentionMessageContext(ctx context.Context, content string, mentionedList []string, mentionedMobileList []string) error {
+	mentions := append(append([]string(nil), mentionedList...), mentionedMobileList...)
+	return r.record(ctx, Record{Kind: KindText, Content: content, Mentions: mentions})
+}
+
+// SendMarkdownMessageContext 记录一条 Markdown 消息
+func (r *Recorder) SendMarkdownMessageContext(ctx context.Context, content string) error {
+	return r.record(ctx, Record{Kind: KindMarkdown, Content: content})
+}
+
+// SendImageMessageContext 记录一条图片消息，并读取图片内容
+func (r *Recorder) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	data, _ := os.ReadFile(imageFilePath)
+	return r.record(ctx, Record{Kind: KindImage, Path: imageFilePath, Data: data})
+}
+
+// SendFileMessageContext 记录一条文件消息，并读取文件内容
+func (r *Recorder) SendFileMessageContext(ctx context.Context, filePath string) error {
+	data, _ := os.ReadFile(filePath)
+	return r.record(ctx, Record{Kind: KindFile, Path: filePath, Data: data})
+}
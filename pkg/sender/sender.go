@@ -0,0 +1,59 @@
+// Package sender 定义回复消息的发送方抽象
+// MessageConverter 只依赖本包中的 Sender 接口，并根据每个发送方声明的能力 (Capabilities) 调整回复内容，
+// 因此可以接入企业微信以外的渠道，也可以在测试中使用 Recorder 代替真实的 HTTP 发送。
+package sender
+
+import (
+	"context" // 导入 context 包，用于取消排队等待和进行中的请求
+	"errors"  // 导入 errors 包，用于定义不支持的消息类型错误
+)
+
+// ErrUnsupported 表示发送方不支持该消息类型，调用方应根据 Capabilities 避免发送此类消息
+var ErrUnsupported = errors.New("message type not supported by this sender")
+
+// MarkdownDialect 表示发送方支持的 Markdown 方言
+type MarkdownDialect string
+
+const (
+	MarkdownNone       MarkdownDialect = ""           // MarkdownNone 表示不支持 Markdown，Markdown 回复会转换为纯文本发送
+	MarkdownWeCom      MarkdownDialect = "wecom"      // MarkdownWeCom 是企业微信的 Markdown 子集 (标题、加粗、链接、行内代码、引用和字体颜色)
+	MarkdownCommonMark MarkdownDialect = "commonmark" // MarkdownCommonMark 是标准 Markdown，支持列表、代码块和表格等完整语法
+	MarkdownDingTalk   MarkdownDialect = "dingtalk"   // MarkdownDingTalk 是钉钉的 Markdown 子集 (标题、引用、加粗、斜体、链接、图片和列表)，不支持的语法原样显示
+	MarkdownSlack      MarkdownDialect = "slack"      // MarkdownSlack 是 Slack 的 mrkdwn 格式，Markdown 回复会转换为 *加粗*、_斜体_ 和 <地址|文字> 等语法
+)
+
+// Capabilities 描述发送方支持的消息能力，零值表示只能发送纯文本且使用默认长度限制
+type Capabilities struct {
+	MaxTextBytes     int             // MaxTextBytes 是单条文本消息的最大字节数，超出时自动切分，0 表示使用默认值 2048
+	MaxMarkdownBytes int             // MaxMarkdownBytes 是单条 Markdown 消息的最大字节数，超出时自动切分，0 表示使用默认值 4096
+	Markdown         MarkdownDialect // Markdown 是支持的 Markdown 方言，为 MarkdownNone 时 Markdown 回复转换为纯文本
+	Images           bool            // Images 表示是否支持发送图片，不支持时改为发送图片链接
+	Files            bool            // Files 表示是否支持发送文件，不支持时改为发送文件链接
+	Mentions         bool            // Mentions 表示是否支持在文本消息中 @成员，支持时发送方需要实现 MentionSender
+	SingleShot       bool            // SingleShot 表示每次处理只能发送一条消息 (例如智能机器人的 response_url)，回复不会分段推送或切分，引用来源等附加内容合并到这条消息中
+}
+
+// Sender 是回复消息的发送方，每个投递目标对应一个实例
+// 实现需要支持并发调用，发送方法在 ctx 被取消时应停止排队等待或中止进行中的请求。
+type Sender interface {
+	// Name 返回发送方的名称，即投递目标的名称
+	Name() string
+	// Capabilities 返回发送方支持的消息能力
+	Capabilities() Capabilities
+	// SendTextMessageContext 发送文本消息，内容不超过 Capabilities().MaxTextBytes
+	SendTextMessageContext(ctx context.Context, content string) error
+	// SendMarkdownMessageContext 发送 Markdown 消息，仅在 Capabilities().Markdown 不为 MarkdownNone 时调用
+	SendMarkdownMessageContext(ctx context.Context, content string) error
+	// SendImageMessageContext 发送本地图片文件，仅在 Capabilities().Images 为 true 时调用
+	SendImageMessageContext(ctx context.Context, imageFilePath string) error
+	// SendFileMessageContext 发送本地文件，仅在 Capabilities().Files 为 true 时调用
+	SendFileMessageContext(ctx context.Context, filePath string) error
+}
+
+// MentionSender 是支持在文本消息中 @成员 的发送方
+type MentionSender interface {
+	// SendTextWithMentionMessageContext 发送带 @ 提醒的文本消息
+	// mentionedList: 需要 @ 的成员 ID 列表
+	// mentionedMobileList: 需要 @ 的成员手机号列表
+	SendTextWithMentionMessageContext(ctx context.Context, content string, mentionedList []string, mentionedMobileList []string) error
+}
@@ -0,0 +1,186 @@
+// Package slack 实现 Slack Incoming Webhook 的消息发送
+// 请求格式与 Slack 兼容的服务 (例如 Mattermost、Rocket.Chat 和 Discord 的 /slack 端点) 也可以使用。
+// Incoming Webhook 只能发送消息文本，不支持上传图片和文件，也无法通过企业微信的成员 ID @成员。
+package slack
+
+import (
+	"bytes"         // 导入 bytes 包，用于构建 HTTP 请求体
+	"context"       // 导入 context 包，用于取消等待配额和进行中的请求
+	"encoding/json" // 导入 encoding/json 包，用于编码请求体
+	"errors"        // 导入 errors 包，用于识别网络错误和频率限制
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"io"            // 导入 io 包，用于读取响应体
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/http"      // 导入 net/http 包，用于发送 HTTP 请求
+	"net/url"       // 导入 net/url 包，用于从网络错误中去掉包含凭据的 webhook URL
+	"strconv"       // 导入 strconv 包，用于解析 Retry-After 和格式化状态码
+	"strings"       // 导入 strings 包，用于转义消息文本
+	"time"          // 导入 time 包，用于限流和退避
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取 Webhook 配置
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录消息发送结果
+	"dify2wxbot/pkg/sender"       // 导入 pkg/sender 包，Webhook 实现其中的 Sender 接口
+)
+
+const (
+	channel                   = "slack"          // channel 是指标中的渠道名称
+	defaultRateLimitPerMinute = 60               // Slack Incoming Webhook 默认的发送频率上限：每秒 1 条
+	rateLimitWindowLength     = time.Minute      // 频率限制的统计周期
+	maxRateLimitRetries       = 3                // 收到 429 后的最大重试次数
+	defaultRetryAfter         = 30 * time.Second // 429 响应未携带 Retry-After 时的退避时间
+	maxMessageBytes           = 3000             // 单条消息文本的最大字节数，Slack 建议消息文本不超过 4000 个字符
+	resultLabelHTTP           = "http"           // 请求未得到响应时使用的结果标签
+)
+
+var _ sender.Sender = (*Webhook)(nil) // Webhook 实现了 Sender 接口
+
+// rateLimitError 表示 Slack 返回了 429，retryAfter 是服务端要求等待的时间
+type rateLimitError struct {
+	retryAfter time.Duration // retryAfter 是 Retry-After 头指定的等待时间
+}
+
+// Error 实现 error 接口
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("slack webhook rate limited, retry after %s", e.retryAfter)
+}
+
+// textEscaper 转义 Slack 消息文本中的控制字符 &、< 和 >
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Webhook 是 Slack Incoming Webhook 的客户端
+type Webhook struct {
+	cfg        config.WeComConfig  // cfg 存储 Webhook 配置，包含 Webhook URL 和发送频率限制
+	httpClient *http.Client        // httpClient 用于发送请求并复用连接
+	limiter    *sender.RateLimiter // limiter 控制发送频率，不超过 Slack 的频率限制
+}
+
+// NewWebhook 创建并返回一个新的 Webhook 实例
+// cfg: Webhook 配置，type 为 "slack"，包含 Webhook URL 和发送频率限制
+func NewWebhook(cfg config.WeComConfig) *Webhook {
+	limit := cfg.RateLimitPerMinute
+	if limit <= 0 {
+		limit = defaultRateLimitPerMinute
+	}
+	return &Webhook{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limiter:    sender.NewRateLimiter(limit, rateLimitWindowLength),
+	}
+}
+
+// Name 返回投递目标名称
+func (w *Webhook) Name() string {
+	return w.cfg.Name
+}
+
+// Capabilities 返回 Incoming Webhook 支持的消息能力：文本和 mrkdwn 各 3000 字节，不支持图片、文件和 @成员
+func (w *Webhook) Capabilities() sender.Capabilities {
+	return sender.Capabilities{
+		MaxTextBytes:     maxMessageBytes,
+		MaxMarkdownBytes: maxMessageBytes,
+		Markdown:         sender.MarkdownSlack,
+	}
+}
+
+// send 发送一条消息
+// 发送前等待频率配额；收到 429 时按 Retry-After 整体退避后重试，最多重试 maxRateLimitRetries 次。
+func (w *Webhook) send(ctx context.Context, msgType string, payload interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack %s message: %w", msgType, err)
+	}
+	for attempt := 0; ; attempt++ {
+		if err := w.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := w.post(ctx, msgType, jsonData)
+		var rateErr *rateLimitError
+		if errors.As(err, &rateErr) && attempt < maxRateLimitRetries {
+			slog.WarnContext(ctx, "[Slack] 触发 Slack 频率限制 (429)，稍后重试", "robot", w.cfg.Name, "msgtype", msgType,
+				"backoff", rateErr.retryAfter.String(), "attempt", attempt+1, "max_attempts", maxRateLimitRetries)
+			w.limiter.Pause(rateErr.retryAfter)
+			continue
+		}
+		return err
+	}
+}
+
+// post 将已编码的消息 POST 到 Webhook，每次调用都按 HTTP 状态码记录到指标中
+// Slack 成功时返回 "ok"，兼容服务可能返回 JSON，因此只根据状态码判断是否成功。
+func (w *Webhook) post(ctx context.Context, msgType string, jsonData []byte) (err error) {
+	start := time.Now()
+	result := resultLabelHTTP
+	defer func() {
+		metrics.ChannelMessages.Inc(channel, msgType, result)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.WebhookURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create slack %s message request: %w", msgType, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // webhook URL 的路径就是凭据，不出现在错误信息中
+		}
+		return fmt.Errorf("failed to send slack %s message: %w", msgType, err)
+	}
+	defer resp.Body.Close()
+	result = strconv.Itoa(resp.StatusCode)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := defaultRetryAfter
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return &rateLimitError{retryAfter: retryAfter}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to send slack %s message (status code %d): %s", msgType, resp.StatusCode, string(body))
+	}
+
+	slog.InfoContext(ctx, "[Slack] 消息成功发送到 Slack", "robot", w.cfg.Name, "msgtype", msgType, "duration", time.Since(start).String())
+	return nil
+}
+
+// SendTextMessage 发送纯文本消息，文本中的 &、< 和 > 会被转义，并关闭 mrkdwn 格式解析
+// message: 文本消息内容
+func (w *Webhook) SendTextMessage(message string) error {
+	return w.SendTextMessageContext(context.Background(), message)
+}
+
+// SendTextMessageContext 与 SendTextMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (w *Webhook) SendTextMessageContext(ctx context.Context, message string) error {
+	payload := struct {
+		Text   string `json:"text"`
+		Mrkdwn bool   `json:"mrkdwn"`
+	}{Text: textEscaper.Replace(message)}
+	return w.send(ctx, "text", payload)
+}
+
+// SendMarkdownMessage 发送 mrkdwn 格式的消息，内容需要已经是 Slack 的 mrkdwn 语法并完成转义
+// content: mrkdwn 格式的内容
+func (w *Webhook) SendMarkdownMessage(content string) error {
+	return w.SendMarkdownMessageContext(context.Background(), content)
+}
+
+// SendMarkdownMessageContext 与 SendMarkdownMessage 相同，但会在 ctx 被取消时停止等待配额或中止进行中的请求
+func (w *Webhook) SendMarkdownMessageContext(ctx context.Context, content string) error {
+	payload := struct {
+		Text string `json:"text"`
+	}{Text: content}
+	return w.send(ctx, "mrkdwn", payload)
+}
+
+// SendImageMessageContext Incoming Webhook 无法上传图片，始终返回 sender.ErrUnsupported
+func (w *Webhook) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	return fmt.Errorf("slack incoming webhook cannot upload images: %w", sender.ErrUnsupported)
+}
+
+// SendFileMessageContext Incoming Webhook 无法上传文件，始终返回 sender.ErrUnsupported
+func (w *Webhook) SendFileMessageContext(ctx context.Context, filePath string) error {
+	return fmt.Errorf("slack incoming webhook cannot upload files: %w", sender.ErrUnsupported)
+}
@@ -0,0 +1,76 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dify2wxbot/internal/config"
+)
+
+func TestWebhookSendsMessages(t *testing.T) {
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	w := NewWebhook(config.WeComConfig{Name: "slack", Type: "slack", WebhookURL: srv.URL + "/services/T0/B0/xyz"})
+
+	if err := w.SendTextMessageContext(context.Background(), "a < b & *c*"); err != nil {
+		t.Fatalf("SendTextMessageContext: %v", err)
+	}
+	if err := w.SendMarkdownMessageContext(context.Background(), "*加粗* <https://example.com|链接>"); err != nil {
+		t.Fatalf("SendMarkdownMessageContext: %v", err)
+	}
+	if len(bodies) != 2 || bodies[0]["text"] != "a &lt; b &amp; *c*" || bodies[0]["mrkdwn"] != false {
+		t.Fatalf("text message = %v, want escaped text with mrkdwn disabled", bodies)
+	}
+	if _, ok := bodies[1]["mrkdwn"]; ok || bodies[1]["text"] != "*加粗* <https://example.com|链接>" {
+		t.Fatalf("mrkdwn message = %v, want the content unchanged", bodies[1])
+	}
+}
+
+func TestWebhookRetriesAfterRateLimit(t *testing.T) {
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if len(times) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	w := NewWebhook(config.WeComConfig{Name: "slack", Type: "slack", WebhookURL: srv.URL})
+
+	if err := w.SendTextMessageContext(context.Background(), "你好"); err != nil {
+		t.Fatalf("SendTextMessageContext: %v", err)
+	}
+	if len(times) != 2 || times[1].Sub(times[0]) < time.Second {
+		t.Fatalf("requests at %v, want a retry after the 1s Retry-After", times)
+	}
+}
+
+func TestWebhookReportsHTTPErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no_service"))
+	}))
+	defer srv.Close()
+	w := NewWebhook(config.WeComConfig{Name: "slack", Type: "slack", WebhookURL: srv.URL + "/services/secret"})
+
+	err := w.SendTextMessageContext(context.Background(), "你好")
+	if err == nil || !strings.Contains(err.Error(), "no_service") || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("error = %v, want the status and body without the webhook URL", err)
+	}
+}
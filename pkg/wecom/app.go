@@ -0,0 +1,362 @@
+package wecom
+
+import (
+	"bytes"          // 导入 bytes 包，用于构建可重放的请求体
+	"context"        // 导入 context 包，用于取消排队等待和进行中的请求
+	"encoding/json"  // 导入 encoding/json 包，用于 JSON 数据的编解码
+	"errors"         // 导入 errors 包，用于去掉网络错误中带有 access_token 的 URL
+	"fmt"            // 导入 fmt 包，用于格式化字符串和错误信息
+	"io"             // 导入 io 包，用于读取上传的文件内容
+	"log/slog"       // 导入 log/slog 包，用于结构化日志输出
+	"mime/multipart" // 导入 mime/multipart 包，用于构建上传临时素材的请求
+	"net/http"       // 导入 net/http 包，用于构建和发送 HTTP 请求
+	"net/url"        // 导入 net/url 包，用于构建查询参数
+	"os"             // 导入 os 包，用于读取本地文件
+	"path/filepath"  // 导入 path/filepath 包，用于获取上传文件的文件名
+	"strconv"        // 导入 strconv 包，用于构建发送队列的键和指标标签
+	"strings"        // 导入 strings 包，用于拼接接收人列表
+	"time"           // 导入 time 包，用于处理时间相关操作
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取自建应用配置
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录消息发送结果和耗时
+)
+
+// AppSender 通过企业微信自建应用的应用消息接口发送消息
+// 与群机器人不同，应用消息可以发给指定的成员 (touser)、部门 (toparty) 或标签 (totag)，适用于单聊通知和不在群里的成员。
+// 接口调用需要的 access_token 由 corpid 和 corpsecret 获取并缓存，发送时发现剩余有效期不足 5 分钟才刷新。
+type AppSender struct {
+	cfg        config.WeComConfig // cfg 存储该应用的配置，包含 corpid、corpsecret、agentid 和接收人
+	httpClient *http.Client       // httpClient 是一个 HTTP 客户端实例，用于发送请求并复用连接
+	token      *accessToken       // token 是该应用的 access_token 缓存
+}
+
+// NewAppSender 创建并返回一个新的 AppSender 实例
+// cfg: 自建应用配置，type 为 "app"，包含 corp_id、corp_secret、agent_id 以及 to_user、to_party 或 to_tag
+func NewAppSender(cfg config.WeComConfig) *AppSender {
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second, // 设置 HTTP 请求的默认超时时间为 10 秒
+	}
+	return &AppSender{
+		cfg:        cfg,
+		httpClient: httpClient,
+		token:      getAccessToken(cfg.CorpID, cfg.CorpSecret, httpClient),
+	}
+}
+
+// Name 返回发送方名称
+func (a *AppSender) Name() string {
+	return a.cfg.Name
+}
+
+// QueueStats 返回该应用发送队列的运行状态，包括排队长度、等待时间和丢弃数量
+func (a *AppSender) QueueStats() QueueStats {
+	return a.queue().Stats()
+}
+
+// queue 返回该应用对应的发送队列
+// 同一个应用 (corpid + agentid) 的所有 AppSender 实例共享一个队列和发送配额。
+func (a *AppSender) queue() *sendQueue {
+	key := a.cfg.CorpID + ":" + strconv.Itoa(a.cfg.AgentID)
+	return getSendQueue(key, a.cfg.RateLimitPerMinute, a.cfg.QueueSize)
+}
+
+// apiResult 是企业微信服务端接口响应中的公共字段
+type apiResult struct {
+	ErrCode int    `json:"errcode"` // ErrCode 是错误码，0 表示成功
+	ErrMsg  string `json:"errmsg"`  // ErrMsg 是错误信息
+}
+
+// result 返回响应中的公共字段，嵌入 apiResult 的响应结构体都可以传给 call
+func (r *apiResult) result() *apiResult {
+	return r
+}
+
+// call 携带 access_token 调用企业微信服务端接口，并将响应解码到 out
+// newReq 根据 access_token 创建请求，重试时会重新调用以便重放请求体；
+// 企业微信返回 access_token 无效或过期 (40014、42001) 时丢弃缓存，获取新的 access_token 后重试一次。
+// 只有请求本身失败时返回错误，业务错误码由调用方从 out 中读取。
+func (a *AppSender) call(ctx context.Context, newReq func(token string) (*http.Request, error), out interface{ result() *apiResult }) error {
+	for attempt := 0; ; attempt++ {
+		token, err := a.token.get(ctx)
+		if err != nil {
+			return err
+		}
+		req, err := newReq(token)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := a.httpClient.Do(req)
+		if err != nil {
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err // 去掉错误信息中带有 access_token 的 URL
+			}
+			return fmt.Errorf("failed to call wecom %s: %w", req.URL.Path, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read wecom %s response body: %w", req.URL.Path, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to call wecom %s (status code %d): %s", req.URL.Path, resp.StatusCode, string(body))
+		}
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("failed to parse wecom %s response: %w, body: %s", req.URL.Path, err, string(body))
+		}
+		if code := out.result().ErrCode; (code == errCodeInvalidToken || code == errCodeTokenExpired) && attempt == 0 {
+			slog.WarnContext(ctx, "[WeCom App] access_token 已失效，刷新后重试", "app", a.cfg.Name, "errcode", code)
+			a.token.invalidate(token)
+			continue
+		}
+		return nil
+	}
+}
+
+// uploadMedia 上传临时素材到企业微信，并返回 media_id
+// mediaType: 媒体类型，例如 "image", "voice", "video", "file"
+// filename: 上传时使用的文件名，企业微信据此显示文件名称
+func (a *AppSender) uploadMedia(ctx context.Context, mediaType, filename string, data []byte) (string, error) {
+	slog.InfoContext(ctx, "[WeCom App] 尝试上传临时素材到企业微信", "app", a.cfg.Name, "file", filename, "media_type", mediaType)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("media", filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file for media: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to copy media file content: %w", err)
+	}
+	writer.Close()
+
+	var result struct {
+		apiResult
+		MediaID string `json:"media_id"`
+	}
+	err = a.call(ctx, func(token string) (*http.Request, error) {
+		query := url.Values{"access_token": {token}, "type": {mediaType}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiBaseURL+"/media/upload?"+query.Encode(), bytes.NewReader(body.Bytes()))
+		if err == nil {
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+		}
+		return req, err
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.ErrCode != 0 {
+		return "", fmt.Errorf("wecom media upload failed: %s (errcode: %d)", result.ErrMsg, result.ErrCode)
+	}
+
+	slog.InfoContext(ctx, "[WeCom App] 临时素材上传成功", "app", a.cfg.Name, "media_id", result.MediaID)
+	return result.MediaID, nil
+}
+
+// sendMessage 是一个通用的辅助函数，用于通过应用消息接口发送消息
+// 接收人、agentid 由配置决定；消息会先进入该应用的发送队列，在频率配额允许时按顺序发出。
+func (a *AppSender) sendMessage(ctx context.Context, msgType string, payload interface{}) error {
+	slog.DebugContext(ctx, "[WeCom App] 尝试发送应用消息到企业微信", "app", a.cfg.Name, "msgtype", msgType)
+
+	msg := map[string]interface{}{
+		"msgtype": msgType,
+		"agentid": a.cfg.AgentID,
+		msgType:   payload,
+	}
+	if len(a.cfg.ToUser) > 0 {
+		msg["touser"] = strings.Join(a.cfg.ToUser, "|")
+	}
+	if len(a.cfg.ToParty) > 0 {
+		msg["toparty"] = strings.Join(a.cfg.ToParty, "|")
+	}
+	if len(a.cfg.ToTag) > 0 {
+		msg["totag"] = strings.Join(a.cfg.ToTag, "|")
+	}
+
+	jsonData, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", msgType, err)
+	}
+
+	return a.queue().enqueue(ctx, msgType, func(ctx context.Context) error {
+		return a.postMessage(ctx, msgType, jsonData)
+	})
+}
+
+// postMessage 将已编码的消息 POST 到应用消息接口并解析返回结果
+// 企业微信返回业务错误时返回 *APIError，发送队列据此识别 45009 频率限制并退避重试；
+// 部分接收人无效时消息仍会发给其他接收人，只记录警告日志。
+func (a *AppSender) postMessage(ctx context.Context, msgType string, jsonData []byte) (err error) {
+	start := time.Now()
+	errCode := errCodeLabelHTTP
+	defer func() {
+		metrics.WeComSendDuration.Observe(time.Since(start).Seconds(), msgType)
+		metrics.WeComMessages.Inc(msgType, errCode)
+	}()
+
+	var result struct {
+		apiResult
+		InvalidUser  string `json:"invaliduser"`
+		InvalidParty string `json:"invalidparty"`
+		InvalidTag   string `json:"invalidtag"`
+	}
+	err = a.call(ctx, func(token string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiBaseURL+"/message/send?access_token="+url.QueryEscape(token), bytes.NewReader(jsonData))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("failed to send %s message: %w", msgType, err)
+	}
+	errCode = strconv.Itoa(result.ErrCode)
+
+	if result.ErrCode != 0 {
+		if result.ErrCode == errCodeRateLimited {
+			slog.WarnContext(ctx, "[WeCom App] 企业微信应用消息发送频率限制", "app", a.cfg.Name, "errcode", result.ErrCode, "errmsg", result.ErrMsg)
+		}
+		return &APIError{MsgType: msgType, ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
+	}
+	if result.InvalidUser != "" || result.InvalidParty != "" || result.InvalidTag != "" {
+		slog.WarnContext(ctx, "[WeCom App] 部分接收人无效，消息未送达这些接收人", "app", a.cfg.Name,
+			"invalid_user", result.InvalidUser, "invalid_party", result.InvalidParty, "invalid_tag", result.InvalidTag)
+	}
+
+	slog.InfoContext(ctx, "[WeCom App] 应用消息成功发送到企业微信", "app", a.cfg.Name, "msgtype", msgType, "duration", time.Since(start).String())
+	return nil
+}
+
+// SendTextMessage 发送文本应用消息
+// message: 文本消息内容
+func (a *AppSender) SendTextMessage(message string) error {
+	return a.SendTextMessageContext(context.Background(), message)
+}
+
+// SendTextMessageContext 与 SendTextMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendTextMessageContext(ctx context.Context, message string) error {
+	payload := struct {
+		Content string `json:"content"`
+	}{
+		Content: message,
+	}
+	return a.sendMessage(ctx, "text", payload)
+}
+
+// SendMarkdownMessage 发送 Markdown 应用消息
+// 应用消息的 Markdown 只支持企业微信客户端显示，在微信插件中会显示为不支持的消息。
+// content: Markdown 格式的内容
+func (a *AppSender) SendMarkdownMessage(content string) error {
+	return a.SendMarkdownMessageContext(context.Background(), content)
+}
+
+// SendMarkdownMessageContext 与 SendMarkdownMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendMarkdownMessageContext(ctx context.Context, content string) error {
+	payload := struct {
+		Content string `json:"content"`
+	}{
+		Content: content,
+	}
+	return a.sendMessage(ctx, "markdown", payload)
+}
+
+// SendImageMessage 发送图片应用消息
+// 图片先上传为临时素材再以 media_id 发送，非 JPG/PNG 格式或超过 2MB 的图片会自动转码并缩小后再上传。
+// imageFilePath: 图片文件的本地路径
+func (a *AppSender) SendImageMessage(imageFilePath string) error {
+	return a.SendImageMessageContext(context.Background(), imageFilePath)
+}
+
+// SendImageMessageContext 与 SendImageMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	data, err := os.ReadFile(imageFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read image file: %w", err)
+	}
+	return a.SendImageDataContext(ctx, data)
+}
+
+// SendImageData 发送内存中的图片数据
+// data: 图片的原始字节内容，支持 JPG、PNG、GIF、BMP、WebP、TIFF 格式
+func (a *AppSender) SendImageData(data []byte) error {
+	return a.SendImageDataContext(context.Background(), data)
+}
+
+// SendImageDataContext 与 SendImageData 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendImageDataContext(ctx context.Context, data []byte) error {
+	imageData, err := prepareImage(data)
+	if err != nil {
+		return fmt.Errorf("failed to prepare image for WeCom: %w", err)
+	}
+	filename := "image.jpg"
+	if http.DetectContentType(imageData) == "image/png" {
+		filename = "image.png"
+	}
+	mediaID, err := a.uploadMedia(ctx, "image", filename, imageData)
+	if err != nil {
+		return fmt.Errorf("failed to upload image for WeCom: %w", err)
+	}
+	payload := struct {
+		MediaID string `json:"media_id"`
+	}{
+		MediaID: mediaID,
+	}
+	return a.sendMessage(ctx, "image", payload)
+}
+
+// SendFileMessage 发送文件应用消息
+// filePath: 文件的本地路径
+func (a *AppSender) SendFileMessage(filePath string) error {
+	return a.SendFileMessageContext(context.Background(), filePath)
+}
+
+// SendFileMessageContext 与 SendFileMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendFileMessageContext(ctx context.Context, filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	mediaID, err := a.uploadMedia(ctx, "file", filepath.Base(filePath), data)
+	if err != nil {
+		return fmt.Errorf("failed to upload file for WeCom: %w", err)
+	}
+	payload := struct {
+		MediaID string `json:"media_id"`
+	}{
+		MediaID: mediaID,
+	}
+	return a.sendMessage(ctx, "file", payload)
+}
+
+// TextCard 定义文本卡片应用消息的结构
+type TextCard struct {
+	Title       string `json:"title"`            // 标题，不超过 128 个字节
+	Description string `json:"description"`      // 描述，不超过 512 个字节，支持 <div class="gray"> 等简单的 HTML 标签
+	URL         string `json:"url"`              // 点击后跳转的链接
+	BtnTxt      string `json:"btntxt,omitempty"` // 按钮文字，默认为 "详情"
+}
+
+// SendTextCardMessage 发送文本卡片应用消息
+// card: 文本卡片内容
+func (a *AppSender) SendTextCardMessage(card TextCard) error {
+	return a.SendTextCardMessageContext(context.Background(), card)
+}
+
+// SendTextCardMessageContext 与 SendTextCardMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendTextCardMessageContext(ctx context.Context, card TextCard) error {
+	if card.Title == "" || card.Description == "" || card.URL == "" {
+		return fmt.Errorf("textcard message requires title, description and url")
+	}
+	return a.sendMessage(ctx, "textcard", card)
+}
+
+// SendTemplateCardMessage 发送模板卡片应用消息
+// card: 模板卡片内容
+func (a *AppSender) SendTemplateCardMessage(card TemplateCard) error {
+	return a.SendTemplateCardMessageContext(context.Background(), card)
+}
+
+// SendTemplateCardMessageContext 与 SendTemplateCardMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (a *AppSender) SendTemplateCardMessageContext(ctx context.Context, card TemplateCard) error {
+	return a.sendMessage(ctx, "template_card", card)
+}
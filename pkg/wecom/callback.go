@@ -0,0 +1,267 @@
+package wecom
+
+import (
+	"bytes"         // 导入 bytes 包，用于判断回调内容是 XML 还是 JSON
+	"encoding/json" // 导入 encoding/json 包，用于解析智能机器人的 JSON 回调
+	"encoding/xml"  // 导入 encoding/xml 包，用于解析群机器人的 XML 回调
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"regexp"        // 导入 regexp 包，用于去掉消息开头的 @机器人
+	"strconv"       // 导入 strconv 包，用于在 JSON 被动回复中输出数字时间戳
+	"strings"       // 导入 strings 包，用于拼接图文混排消息中的文本
+)
+
+const (
+	CallbackMsgText  = "text"  // CallbackMsgText 是文本消息
+	CallbackMsgImage = "image" // CallbackMsgImage 是图片消息
+	CallbackMsgMixed = "mixed" // CallbackMsgMixed 是图文混排消息
+	CallbackMsgEvent = "event" // CallbackMsgEvent 是事件，例如进入会话或机器人被添加到群聊
+
+	EventEnterChat    = "enter_chat"          // EventEnterChat 是用户当天首次进入与智能机器人的单聊会话
+	EventTemplateCard = "template_card_event" // EventTemplateCard 是用户点击了模板卡片上的按钮
+
+	ChatTypeSingle = "single" // ChatTypeSingle 是单聊
+	ChatTypeGroup  = "group"  // ChatTypeGroup 是群聊
+)
+
+// leadingMentions 匹配消息开头的一个或多个 @提及，群聊中 @机器人 的消息以机器人名称开头
+var leadingMentions = regexp.MustCompile(`^(?:\s*@\S+)+\s*`)
+
+// CallbackEnvelope 是回调请求体中的加密外层，JSON 和 XML 格式共用
+type CallbackEnvelope struct {
+	Encrypt string `json:"encrypt" xml:"Encrypt"` // Encrypt 是 base64 编码的密文
+}
+
+// ParseEnvelope 从回调请求体中取出密文，请求体可以是 {"encrypt": "..."} 或 <xml><Encrypt>...</Encrypt></xml>
+// 返回值 isXML 表示请求体是否为 XML，被动回复需要使用相同的格式。
+func ParseEnvelope(body []byte) (encrypted string, isXML bool, err error) {
+	var envelope CallbackEnvelope
+	isXML = isXMLPayload(body)
+	if isXML {
+		err = xml.Unmarshal(body, &envelope)
+	} else {
+		err = json.Unmarshal(body, &envelope)
+	}
+	if err != nil {
+		return "", isXML, fmt.Errorf("failed to parse callback envelope: %w", err)
+	}
+	if envelope.Encrypt == "" {
+		return "", isXML, fmt.Errorf("callback envelope has no encrypted message")
+	}
+	return envelope.Encrypt, isXML, nil
+}
+
+// MarshalReply 构造加密的被动回复，格式与回调请求体一致 (XML 或 JSON)
+// encrypted 和 signature 由 MsgCrypt.EncryptReply 生成，timestamp 和 nonce 与签名时使用的值相同。
+func MarshalReply(isXML bool, encrypted, signature, timestamp, nonce string) ([]byte, error) {
+	if isXML {
+		return xml.Marshal(struct {
+			XMLName      xml.Name `xml:"xml"`          // XMLName 是根元素名称
+			Encrypt      cdata    `xml:"Encrypt"`      // Encrypt 是密文
+			MsgSignature cdata    `xml:"MsgSignature"` // MsgSignature 是签名
+			TimeStamp    string   `xml:"TimeStamp"`    // TimeStamp 是时间戳
+			Nonce        cdata    `xml:"Nonce"`        // Nonce 是随机数
+		}{Encrypt: cdata{encrypted}, MsgSignature: cdata{signature}, TimeStamp: timestamp, Nonce: cdata{nonce}})
+	}
+	reply := map[string]interface{}{
+		"encrypt":      encrypted,
+		"msgsignature": signature,
+		"timestamp":    timestamp,
+		"nonce":        nonce,
+	}
+	if ts, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+		reply["timestamp"] = ts // 智能机器人的回复中时间戳为数字
+	}
+	return json.Marshal(reply)
+}
+
+// TextReply 构造被动回复的文本消息明文，格式与回调请求体一致 (XML 或 JSON)，需要再经 MsgCrypt.EncryptReply 加密
+func TextReply(isXML bool, content string) ([]byte, error) {
+	if isXML {
+		return xml.Marshal(struct {
+			XMLName xml.Name `xml:"xml"`          // XMLName 是根元素名称
+			MsgType cdata    `xml:"MsgType"`      // MsgType 固定为 text
+			Content cdata    `xml:"Text>Content"` // Content 是文本内容
+		}{MsgType: cdata{CallbackMsgText}, Content: cdata{content}})
+	}
+	return json.Marshal(map[string]interface{}{
+		"msgtype": CallbackMsgText,
+		"text":    map[string]string{"content": content},
+	})
+}
+
+// cdata 在 XML 中以 <![CDATA[...]]> 形式输出
+type cdata struct {
+	Value string `xml:",cdata"` // Value 是 CDATA 中的内容
+}
+
+// CallbackMessage 是解密后的回调消息，智能机器人的 JSON 格式和群机器人的 XML 格式都被归一为该结构
+type CallbackMessage struct {
+	MsgID       string   // MsgID 是消息 ID，可用于去重
+	MsgType     string   // MsgType 是消息类型：text、image、mixed 或 event
+	ChatID      string   // ChatID 是会话 ID，单聊时可能为空
+	ChatType    string   // ChatType 是会话类型：single 或 group
+	UserID      string   // UserID 是发送者的 userid
+	Text        string   // Text 是消息中的文本，已去掉开头的 @机器人；图文混排消息中的多段文本以换行连接
+	ImageURLs   []string // ImageURLs 是消息中的图片地址，智能机器人的图片内容经过加密，需要用 MsgCrypt.DecryptFile 解密
+	Event       string   // Event 是事件类型，例如 enter_chat、add_to_chat，仅 MsgType 为 event 时有值
+	EventKey    string   // EventKey 是被点击按钮的 key，仅 Event 为 template_card_event 时有值
+	TaskID      string   // TaskID 是被点击卡片的 task_id，仅 Event 为 template_card_event 时有值
+	ResponseURL string   // ResponseURL 是智能机器人提供的主动回复地址
+	WebhookURL  string   // WebhookURL 是群机器人回调中携带的、可向该群发送消息的 Webhook 地址
+}
+
+// ConversationKey 返回该消息所属的对话标识
+// 群聊中同一个人在不同群里的对话互不干扰，因此使用 "群聊 ID:userid"；单聊直接使用 userid。
+func (m *CallbackMessage) ConversationKey() string {
+	if m.ChatType == ChatTypeGroup && m.ChatID != "" {
+		return m.ChatID + ":" + m.UserID
+	}
+	return m.UserID
+}
+
+// ParseCallbackMessage 解析解密后的回调消息，根据内容自动识别 XML (群机器人) 或 JSON (智能机器人) 格式
+func ParseCallbackMessage(data []byte) (*CallbackMessage, error) {
+	var msg *CallbackMessage
+	var err error
+	if isXMLPayload(data) {
+		msg, err = parseXMLCallback(data)
+	} else {
+		msg, err = parseJSONCallback(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.Text = strings.TrimSpace(leadingMentions.ReplaceAllString(msg.Text, ""))
+	return msg, nil
+}
+
+// jsonCallbackItem 是智能机器人 JSON 回调中的单条内容，图文混排消息由多条组成
+type jsonCallbackItem struct {
+	MsgType string `json:"msgtype"` // MsgType 是内容类型
+	Text    struct {
+		Content string `json:"content"` // Content 是文本内容
+	} `json:"text"` // Text 是文本内容
+	Image struct {
+		URL string `json:"url"` // URL 是加密图片的下载地址
+	} `json:"image"` // Image 是图片内容
+}
+
+// jsonCallback 是智能机器人的 JSON 回调消息
+type jsonCallback struct {
+	jsonCallbackItem        // jsonCallbackItem 是非图文混排消息的类型、文本和图片
+	MsgID            string `json:"msgid"`    // MsgID 是消息 ID
+	ChatID           string `json:"chatid"`   // ChatID 是会话 ID
+	ChatType         string `json:"chattype"` // ChatType 是会话类型
+	From             struct {
+		UserID string `json:"userid"` // UserID 是发送者的 userid
+	} `json:"from"` // From 是发送者
+	ResponseURL string `json:"response_url"` // ResponseURL 是主动回复地址
+	Mixed       struct {
+		Items []jsonCallbackItem `json:"msg_item"` // Items 是按顺序排列的文本和图片
+	} `json:"mixed"` // Mixed 是图文混排内容
+	Event struct {
+		EventType         string `json:"eventtype"` // EventType 是事件类型
+		TemplateCardEvent struct {
+			EventKey string `json:"event_key"` // EventKey 是被点击按钮的 key
+			TaskID   string `json:"task_id"`   // TaskID 是被点击卡片的 task_id
+		} `json:"template_card_event"` // TemplateCardEvent 是模板卡片按钮的点击事件
+	} `json:"event"` // Event 是事件内容
+}
+
+// parseJSONCallback 解析智能机器人的 JSON 回调消息
+func parseJSONCallback(data []byte) (*CallbackMessage, error) {
+	var raw jsonCallback
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse callback message: %w", err)
+	}
+	msg := &CallbackMessage{
+		MsgID:       raw.MsgID,
+		MsgType:     raw.MsgType,
+		ChatID:      raw.ChatID,
+		ChatType:    raw.ChatType,
+		UserID:      raw.From.UserID,
+		Event:       raw.Event.EventType,
+		EventKey:    raw.Event.TemplateCardEvent.EventKey,
+		TaskID:      raw.Event.TemplateCardEvent.TaskID,
+		ResponseURL: raw.ResponseURL,
+	}
+	items := []jsonCallbackItem{raw.jsonCallbackItem}
+	if raw.MsgType == CallbackMsgMixed {
+		items = raw.Mixed.Items
+	}
+	var texts []string
+	for _, item := range items {
+		switch item.MsgType {
+		case CallbackMsgText:
+			texts = append(texts, item.Text.Content)
+		case CallbackMsgImage:
+			if item.Image.URL != "" {
+				msg.ImageURLs = append(msg.ImageURLs, item.Image.URL)
+			}
+		}
+	}
+	msg.Text = strings.Join(texts, "\n")
+	return msg, nil
+}
+
+// xmlCallbackItem 是群机器人 XML 回调中的单条内容，图文混排消息由多条组成
+type xmlCallbackItem struct {
+	MsgType string `xml:"MsgType"`        // MsgType 是内容类型
+	Content string `xml:"Text>Content"`   // Content 是文本内容
+	Image   string `xml:"Image>ImageUrl"` // Image 是图片地址
+}
+
+// xmlCallback 是群机器人的 XML 回调消息
+type xmlCallback struct {
+	xmlCallbackItem                   // xmlCallbackItem 是非图文混排消息的类型、文本和图片
+	MsgID           string            `xml:"MsgId"`                            // MsgID 是消息 ID
+	ChatID          string            `xml:"ChatId"`                           // ChatID 是会话 ID
+	ChatType        string            `xml:"ChatType"`                         // ChatType 是会话类型
+	UserID          string            `xml:"From>UserId"`                      // UserID 是发送者的 userid
+	WebhookURL      string            `xml:"WebhookUrl"`                       // WebhookURL 是可向该群发送消息的 Webhook 地址
+	Items           []xmlCallbackItem `xml:"MixedMessage>MsgItem"`             // Items 是图文混排消息中按顺序排列的文本和图片
+	EventType       string            `xml:"Event>EventType"`                  // EventType 是事件类型
+	EventKey        string            `xml:"Event>TemplateCardEvent>EventKey"` // EventKey 是被点击按钮的 key
+	TaskID          string            `xml:"Event>TemplateCardEvent>TaskId"`   // TaskID 是被点击卡片的 task_id
+}
+
+// parseXMLCallback 解析群机器人的 XML 回调消息
+func parseXMLCallback(data []byte) (*CallbackMessage, error) {
+	var raw xmlCallback
+	if err := xml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse callback message: %w", err)
+	}
+	msg := &CallbackMessage{
+		MsgID:      raw.MsgID,
+		MsgType:    raw.MsgType,
+		ChatID:     raw.ChatID,
+		ChatType:   raw.ChatType,
+		UserID:     raw.UserID,
+		Event:      raw.EventType,
+		EventKey:   raw.EventKey,
+		TaskID:     raw.TaskID,
+		WebhookURL: raw.WebhookURL,
+	}
+	items := []xmlCallbackItem{raw.xmlCallbackItem}
+	if raw.MsgType == CallbackMsgMixed {
+		items = raw.Items
+	}
+	var texts []string
+	for _, item := range items {
+		switch item.MsgType {
+		case CallbackMsgText:
+			texts = append(texts, item.Content)
+		case CallbackMsgImage:
+			if item.Image != "" {
+				msg.ImageURLs = append(msg.ImageURLs, item.Image)
+			}
+		}
+	}
+	msg.Text = strings.Join(texts, "\n")
+	return msg, nil
+}
+
+// isXMLPayload 判断内容是否为 XML
+func isXMLPayload(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("<"))
+}
@@ -0,0 +1,145 @@
+package wecom
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		isXML   bool
+		wantErr bool
+	}{
+		{name: "json", body: `{"encrypt": "abc=="}`, want: "abc=="},
+		{name: "xml", body: "\n<xml><ToUserName><![CDATA[bot]]></ToUserName><Encrypt><![CDATA[abc==]]></Encrypt></xml>", want: "abc==", isXML: true},
+		{name: "json without encrypt", body: `{"msgtype": "text"}`, wantErr: true},
+		{name: "xml without encrypt", body: `<xml></xml>`, isXML: true, wantErr: true},
+		{name: "malformed", body: `{"encrypt":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, isXML, err := ParseEnvelope([]byte(tt.body))
+		if (err != nil) != tt.wantErr || got != tt.want || isXML != tt.isXML {
+			t.Errorf("%s: ParseEnvelope = %q, %v, %v; want %q, %v, error %v", tt.name, got, isXML, err, tt.want, tt.isXML, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCallbackMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want CallbackMessage
+	}{
+		{
+			name: "json text",
+			data: `{"msgid": "m1", "aibotid": "bot", "chatid": "g1", "chattype": "group", "from": {"userid": "zhangsan"},
+				"response_url": "https://qyapi.weixin.qq.com/cgi-bin/aibot/response?response_code=rc", "msgtype": "text", "text": {"content": "@小助手 年假有几天？ "}}`,
+			want: CallbackMessage{MsgID: "m1", MsgType: CallbackMsgText, ChatID: "g1", ChatType: ChatTypeGroup, UserID: "zhangsan",
+				Text: "年假有几天？", ResponseURL: "https://qyapi.weixin.qq.com/cgi-bin/aibot/response?response_code=rc"},
+		},
+		{
+			name: "json image",
+			data: `{"msgid": "m2", "chattype": "single", "from": {"userid": "lisi"}, "msgtype": "image", "image": {"url": "https://example.com/a.jpg"}}`,
+			want: CallbackMessage{MsgID: "m2", MsgType: CallbackMsgImage, ChatType: ChatTypeSingle, UserID: "lisi", ImageURLs: []string{"https://example.com/a.jpg"}},
+		},
+		{
+			name: "json mixed",
+			data: `{"msgid": "m3", "chattype": "single", "from": {"userid": "lisi"}, "msgtype": "mixed", "mixed": {"msg_item": [
+				{"msgtype": "text", "text": {"content": "@小助手 这张图"}},
+				{"msgtype": "image", "image": {"url": "https://example.com/b.jpg"}},
+				{"msgtype": "text", "text": {"content": "是什么？"}}]}}`,
+			want: CallbackMessage{MsgID: "m3", MsgType: CallbackMsgMixed, ChatType: ChatTypeSingle, UserID: "lisi",
+				Text: "这张图\n是什么？", ImageURLs: []string{"https://example.com/b.jpg"}},
+		},
+		{
+			name: "json event",
+			data: `{"msgid": "m4", "chattype": "single", "from": {"userid": "lisi"}, "msgtype": "event", "event": {"eventtype": "template_card_event",
+				"template_card_event": {"card_type": "button_interaction", "event_key": "suggest:abc", "task_id": "t1"}}}`,
+			want: CallbackMessage{MsgID: "m4", MsgType: CallbackMsgEvent, ChatType: ChatTypeSingle, UserID: "lisi",
+				Event: EventTemplateCard, EventKey: "suggest:abc", TaskID: "t1"},
+		},
+		{
+			name: "xml text",
+			data: `<xml><From><UserId>zhangsan</UserId><Name>张三</Name></From><WebhookUrl><![CDATA[https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=k1]]></WebhookUrl>
+				<ChatId>g1</ChatId><ChatType>group</ChatType><MsgId>x1</MsgId><MsgType>text</MsgType><Text><Content><![CDATA[@小助手 @李四 你好]]></Content></Text></xml>`,
+			want: CallbackMessage{MsgID: "x1", MsgType: CallbackMsgText, ChatID: "g1", ChatType: ChatTypeGroup, UserID: "zhangsan",
+				Text: "你好", WebhookURL: "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=k1"},
+		},
+		{
+			name: "xml image",
+			data: `<xml><From><UserId>zhangsan</UserId></From><ChatId>g1</ChatId><ChatType>group</ChatType><MsgId>x2</MsgId><MsgType>image</MsgType>
+				<Image><ImageUrl><![CDATA[https://example.com/c.png]]></ImageUrl></Image></xml>`,
+			want: CallbackMessage{MsgID: "x2", MsgType: CallbackMsgImage, ChatID: "g1", ChatType: ChatTypeGroup, UserID: "zhangsan",
+				ImageURLs: []string{"https://example.com/c.png"}},
+		},
+		{
+			name: "xml mixed",
+			data: `<xml><From><UserId>zhangsan</UserId></From><ChatId>g1</ChatId><ChatType>group</ChatType><MsgId>x3</MsgId><MsgType>mixed</MsgType>
+				<MixedMessage><MsgItem><MsgType>text</MsgType><Text><Content><![CDATA[@小助手 看图]]></Content></Text></MsgItem>
+				<MsgItem><MsgType>image</MsgType><Image><ImageUrl><![CDATA[https://example.com/d.png]]></ImageUrl></Image></MsgItem></MixedMessage></xml>`,
+			want: CallbackMessage{MsgID: "x3", MsgType: CallbackMsgMixed, ChatID: "g1", ChatType: ChatTypeGroup, UserID: "zhangsan",
+				Text: "看图", ImageURLs: []string{"https://example.com/d.png"}},
+		},
+		{
+			name: "xml event",
+			data: `<xml><From><UserId>zhangsan</UserId></From><ChatId>g1</ChatId><ChatType>group</ChatType><MsgId>x4</MsgId><MsgType>event</MsgType>
+				<Event><EventType>template_card_event</EventType><TemplateCardEvent><EventKey>feedback:like:m1</EventKey><TaskId>t2</TaskId></TemplateCardEvent></Event></xml>`,
+			want: CallbackMessage{MsgID: "x4", MsgType: CallbackMsgEvent, ChatID: "g1", ChatType: ChatTypeGroup, UserID: "zhangsan",
+				Event: EventTemplateCard, EventKey: "feedback:like:m1", TaskID: "t2"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseCallbackMessage([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: ParseCallbackMessage: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: ParseCallbackMessage =\n%+v\nwant\n%+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestCallbackMessageConversationKey(t *testing.T) {
+	group := CallbackMessage{ChatType: ChatTypeGroup, ChatID: "g1", UserID: "zhangsan"}
+	single := CallbackMessage{ChatType: ChatTypeSingle, ChatID: "s1", UserID: "zhangsan"}
+	if got := group.ConversationKey(); got != "g1:zhangsan" {
+		t.Errorf("group ConversationKey = %q, want g1:zhangsan", got)
+	}
+	if got := single.ConversationKey(); got != "zhangsan" {
+		t.Errorf("single ConversationKey = %q, want zhangsan", got)
+	}
+}
+
+func TestMarshalReply(t *testing.T) {
+	reply, err := MarshalReply(false, "enc==", "sig", "1700000000", "n1")
+	if err != nil {
+		t.Fatalf("MarshalReply json: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(reply, &got); err != nil {
+		t.Fatalf("unmarshal json reply: %v", err)
+	}
+	want := map[string]interface{}{"encrypt": "enc==", "msgsignature": "sig", "timestamp": float64(1700000000), "nonce": "n1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("json reply = %v, want %v (timestamp as a number)", got, want)
+	}
+
+	reply, err = MarshalReply(true, "enc==", "sig", "1700000000", "n1")
+	if err != nil {
+		t.Fatalf("MarshalReply xml: %v", err)
+	}
+	for _, part := range []string{"<Encrypt><![CDATA[enc==]]></Encrypt>", "<MsgSignature><![CDATA[sig]]></MsgSignature>", "<TimeStamp>1700000000</TimeStamp>", "<Nonce><![CDATA[n1]]></Nonce>"} {
+		if !strings.Contains(string(reply), part) {
+			t.Errorf("xml reply %s is missing %s", reply, part)
+		}
+	}
+	if encrypted, isXML, err := ParseEnvelope(reply); err != nil || !isXML || encrypted != "enc==" {
+		t.Errorf("ParseEnvelope(xml reply) = %q, %v, %v; want enc==", encrypted, isXML, err)
+	}
+}
@@ -0,0 +1,177 @@
+package wecom
+
+import (
+	"bytes"           // 导入 bytes 包，用于 PKCS#7 填充
+	"crypto/aes"      // 导入 crypto/aes 包，用于 AES-256 加解密
+	"crypto/cipher"   // 导入 crypto/cipher 包，用于 CBC 模式
+	"crypto/rand"     // 导入 crypto/rand 包，用于生成加密消息开头的随机字节
+	"crypto/sha1"     // 导入 crypto/sha1 包，用于计算消息签名
+	"crypto/subtle"   // 导入 crypto/subtle 包，用于以固定时间比较签名
+	"encoding/base64" // 导入 encoding/base64 包，用于解码 EncodingAESKey 和密文
+	"encoding/binary" // 导入 encoding/binary 包，用于读写消息长度
+	"encoding/hex"    // 导入 encoding/hex 包，用于格式化签名
+	"errors"          // 导入 errors 包，用于定义签名和解密错误
+	"fmt"             // 导入 fmt 包，用于格式化错误信息
+	"sort"            // 导入 sort 包，用于签名前对参数排序
+	"strings"         // 导入 strings 包，用于拼接签名参数
+)
+
+const (
+	encodingAESKeyLen = 43 // EncodingAESKey 的长度，base64 解码后为 32 字节的 AES 密钥
+	cryptBlockSize    = 32 // 企业微信加密消息使用的 PKCS#7 填充块大小
+	randomPrefixLen   = 16 // 加密消息明文开头的随机字节数
+)
+
+var (
+	// ErrInvalidSignature 表示回调请求的 msg_signature 与计算结果不一致
+	ErrInvalidSignature = errors.New("wecom callback signature mismatch")
+	// ErrReceiveIDMismatch 表示解密后的接收方 ID 与配置不一致，消息可能是发给其他企业或应用的
+	ErrReceiveIDMismatch = errors.New("wecom callback receive id mismatch")
+)
+
+// MsgCrypt 实现企业微信回调消息的签名校验和加解密 (与官方 WXBizMsgCrypt 兼容)
+// 明文格式为 16 字节随机数 + 4 字节网络字节序的消息长度 + 消息 + 接收方 ID，使用 AES-256-CBC 加密，
+// 密钥为 EncodingAESKey 补 "=" 后 base64 解码得到的 32 字节，IV 为密钥的前 16 字节。
+type MsgCrypt struct {
+	token     string // token 是回调配置中的 Token
+	key       []byte // key 是 32 字节的 AES 密钥
+	receiveID string // receiveID 是接收方 ID，为空时不校验
+}
+
+// NewMsgCrypt 创建 MsgCrypt 实例
+// token: 回调配置中的 Token
+// encodingAESKey: 回调配置中的 EncodingAESKey，43 个字符
+// receiveID: 接收方 ID，自建应用为 CorpID，智能机器人和群机器人为空字符串
+func NewMsgCrypt(token, encodingAESKey, receiveID string) (*MsgCrypt, error) {
+	if len(encodingAESKey) != encodingAESKeyLen {
+		return nil, fmt.Errorf("encoding aes key must be %d characters, got %d", encodingAESKeyLen, len(encodingAESKey))
+	}
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encoding aes key: %w", err)
+	}
+	return &MsgCrypt{token: token, key: key, receiveID: receiveID}, nil
+}
+
+// Signature 计算消息签名：将 Token、时间戳、随机数和密文按字典序排序后拼接，取 SHA1 的十六进制值
+func (c *MsgCrypt) Signature(timestamp, nonce, encrypted string) string {
+	params := []string{c.token, timestamp, nonce, encrypted}
+	sort.Strings(params)
+	sum := sha1.Sum([]byte(strings.Join(params, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifySignature 校验回调请求中的 msg_signature，不一致时返回 ErrInvalidSignature
+func (c *MsgCrypt) VerifySignature(signature, timestamp, nonce, encrypted string) error {
+	expected := c.Signature(timestamp, nonce, encrypted)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// VerifyURL 处理企业微信保存回调配置时发起的 URL 验证请求
+// 校验签名后解密 echostr，返回的明文需要原样写入响应体。
+func (c *MsgCrypt) VerifyURL(signature, timestamp, nonce, echostr string) ([]byte, error) {
+	if err := c.VerifySignature(signature, timestamp, nonce, echostr); err != nil {
+		return nil, err
+	}
+	return c.Decrypt(echostr)
+}
+
+// Decrypt 解密 base64 编码的密文，返回其中的消息，并校验接收方 ID
+func (c *MsgCrypt) Decrypt(encrypted string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted message: %w", err)
+	}
+	plain, err := c.decryptCBC(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < randomPrefixLen+4 {
+		return nil, fmt.Errorf("decrypted message too short: %d bytes", len(plain))
+	}
+	msgLen := int(binary.BigEndian.Uint32(plain[randomPrefixLen : randomPrefixLen+4]))
+	body := plain[randomPrefixLen+4:]
+	if msgLen > len(body) {
+		return nil, fmt.Errorf("decrypted message length %d exceeds payload size %d", msgLen, len(body))
+	}
+	if c.receiveID != "" && string(body[msgLen:]) != c.receiveID {
+		return nil, ErrReceiveIDMismatch
+	}
+	return body[:msgLen], nil
+}
+
+// Encrypt 按企业微信的格式加密消息，返回 base64 编码的密文
+func (c *MsgCrypt) Encrypt(msg []byte) (string, error) {
+	plain := make([]byte, randomPrefixLen+4, randomPrefixLen+4+len(msg)+len(c.receiveID)+cryptBlockSize)
+	if _, err := rand.Read(plain[:randomPrefixLen]); err != nil {
+		return "", fmt.Errorf("failed to generate random prefix: %w", err)
+	}
+	binary.BigEndian.PutUint32(plain[randomPrefixLen:], uint32(len(msg)))
+	plain = append(plain, msg...)
+	plain = append(plain, c.receiveID...)
+	plain = pkcs7Pad(plain, cryptBlockSize)
+
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create aes cipher: %w", err)
+	}
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, c.key[:aes.BlockSize]).CryptBlocks(ciphertext, plain)
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// EncryptReply 加密被动回复的消息，返回密文和对应的签名
+// timestamp 和 nonce 一般沿用回调请求中的值。
+func (c *MsgCrypt) EncryptReply(msg []byte, timestamp, nonce string) (encrypted, signature string, err error) {
+	encrypted, err = c.Encrypt(msg)
+	if err != nil {
+		return "", "", err
+	}
+	return encrypted, c.Signature(timestamp, nonce, encrypted), nil
+}
+
+// DecryptFile 解密智能机器人消息中图片和文件 URL 下载得到的内容
+// 文件内容使用与消息相同的密钥和 IV 以 AES-256-CBC 加密，没有随机数和长度前缀。
+func (c *MsgCrypt) DecryptFile(data []byte) ([]byte, error) {
+	return c.decryptCBC(data)
+}
+
+// decryptCBC 使用 AES-256-CBC 解密并去除 PKCS#7 填充
+func (c *MsgCrypt) decryptCBC(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext length %d is not a multiple of the block size", len(ciphertext))
+	}
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aes cipher: %w", err)
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, c.key[:aes.BlockSize]).CryptBlocks(plain, ciphertext)
+	return pkcs7Unpad(plain, cryptBlockSize)
+}
+
+// pkcs7Pad 将 data 按 PKCS#7 填充到 blockSize 的整数倍
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padding := blockSize - len(data)%blockSize
+	return append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+// pkcs7Unpad 去除 PKCS#7 填充，填充无效时返回错误 (通常意味着 EncodingAESKey 配置错误)
+func pkcs7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("invalid pkcs7 padding: empty data")
+	}
+	padding := int(data[len(data)-1])
+	if padding < 1 || padding > blockSize || padding > len(data) {
+		return nil, fmt.Errorf("invalid pkcs7 padding size %d", padding)
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New("invalid pkcs7 padding bytes")
+		}
+	}
+	return data[:len(data)-padding], nil
+}
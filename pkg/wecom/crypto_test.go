@@ -0,0 +1,147 @@
+package wecom
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// 企业微信官方加解密示例 (WXBizMsgCrypt) 中的参数和 URL 验证请求
+const (
+	refToken     = "QDG6eK"
+	refAESKey    = "jWmYm7qr5nMoAUwZRjGtBxmz3KA1tkAj3ykkR6q2B2C"
+	refReceiveID = "wx5823bf96d3bd56c7"
+	refSignature = "5c45ff5e21c57e6ad56bac8758b79b1d9ac89fd3"
+	refTimestamp = "1409659589"
+	refNonce     = "263014780"
+	refEchostr   = "P9nAzCzyDtyTWESHep1vC5X9xho/qYX3Zpb4yKa9SKld1DsH3Iyt3tP3zNdtp+4RPcs8TgAE7OaBO+FZXvnaqQ=="
+	refEchoPlain = "1616140317555161061"
+)
+
+func newRefCrypt(t *testing.T, receiveID string) *MsgCrypt {
+	t.Helper()
+	c, err := NewMsgCrypt(refToken, refAESKey, receiveID)
+	if err != nil {
+		t.Fatalf("NewMsgCrypt: %v", err)
+	}
+	return c
+}
+
+func TestMsgCryptMatchesReferenceVector(t *testing.T) {
+	c := newRefCrypt(t, refReceiveID)
+	if got := c.Signature(refTimestamp, refNonce, refEchostr); got != refSignature {
+		t.Fatalf("Signature = %s, want %s", got, refSignature)
+	}
+	plain, err := c.VerifyURL(refSignature, refTimestamp, refNonce, refEchostr)
+	if err != nil {
+		t.Fatalf("VerifyURL: %v", err)
+	}
+	if string(plain) != refEchoPlain {
+		t.Fatalf("VerifyURL = %q, want %q", plain, refEchoPlain)
+	}
+}
+
+func TestMsgCryptRoundTrip(t *testing.T) {
+	c := newRefCrypt(t, refReceiveID)
+	for _, msg := range []string{"", "hello", `{"msgtype":"text","text":{"content":"你好，世界"}}`, string(bytes.Repeat([]byte("x"), 1000))} {
+		encrypted, signature, err := c.EncryptReply([]byte(msg), "1700000000", "nonce")
+		if err != nil {
+			t.Fatalf("EncryptReply(%q): %v", msg, err)
+		}
+		if err := c.VerifySignature(signature, "1700000000", "nonce", encrypted); err != nil {
+			t.Fatalf("VerifySignature: %v", err)
+		}
+		plain, err := c.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", msg, err)
+		}
+		if string(plain) != msg {
+			t.Fatalf("Decrypt = %q, want %q", plain, msg)
+		}
+	}
+}
+
+func TestMsgCryptRejectsBadSignature(t *testing.T) {
+	c := newRefCrypt(t, refReceiveID)
+	tampered := []byte(refSignature)
+	tampered[0] = '6'
+	for name, verify := range map[string]func() error{
+		"signature": func() error { return c.VerifySignature(string(tampered), refTimestamp, refNonce, refEchostr) },
+		"timestamp": func() error { return c.VerifySignature(refSignature, "1409659590", refNonce, refEchostr) },
+		"token": func() error {
+			other, _ := NewMsgCrypt("other", refAESKey, refReceiveID)
+			_, err := other.VerifyURL(refSignature, refTimestamp, refNonce, refEchostr)
+			return err
+		},
+	} {
+		if err := verify(); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: error = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
+
+func TestMsgCryptChecksReceiveID(t *testing.T) {
+	encrypted, err := newRefCrypt(t, refReceiveID).Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := newRefCrypt(t, "wx0000000000000000").Decrypt(encrypted); !errors.Is(err, ErrReceiveIDMismatch) {
+		t.Fatalf("Decrypt with another receive id: error = %v, want ErrReceiveIDMismatch", err)
+	}
+	// 智能机器人和群机器人的接收方 ID 为空，不做校验
+	if plain, err := newRefCrypt(t, "").Decrypt(encrypted); err != nil || string(plain) != "hello" {
+		t.Fatalf("Decrypt without receive id = %q, %v; want hello", plain, err)
+	}
+}
+
+func TestMsgCryptRejectsBadPadding(t *testing.T) {
+	c := newRefCrypt(t, "")
+	// 最后一个字节声明了 5 字节填充，但前面的填充字节不一致
+	plain := bytes.Repeat([]byte{'a'}, 2*aes.BlockSize)
+	plain[len(plain)-1] = 5
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	ciphertext := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, c.key[:aes.BlockSize]).CryptBlocks(ciphertext, plain)
+	if _, err := c.Decrypt(base64.StdEncoding.EncodeToString(ciphertext)); err == nil {
+		t.Fatal("Decrypt accepted a message with invalid padding")
+	}
+	if _, err := c.Decrypt(base64.StdEncoding.EncodeToString(ciphertext[:aes.BlockSize+3])); err == nil {
+		t.Fatal("Decrypt accepted a message that is not a whole number of blocks")
+	}
+}
+
+func TestPKCS7Unpad(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{name: "one byte", data: []byte{'a', 'b', 1}, want: []byte("ab")},
+		{name: "several bytes", data: []byte{'a', 3, 3, 3}, want: []byte("a")},
+		{name: "full block", data: bytes.Repeat([]byte{32}, 32), want: []byte{}},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "zero", data: []byte{'a', 0}, wantErr: true},
+		{name: "larger than block", data: bytes.Repeat([]byte{33}, 33), wantErr: true},
+		{name: "larger than data", data: []byte{'a', 4, 4}, wantErr: true},
+		{name: "inconsistent bytes", data: []byte{'a', 2, 3, 3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := pkcs7Unpad(tt.data, cryptBlockSize)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: pkcs7Unpad = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: pkcs7Unpad = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
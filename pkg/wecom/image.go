@@ -0,0 +1,101 @@
+package wecom
+
+import (
+	"bytes"        // 导入 bytes 包，用于在内存中编码图片
+	"crypto/md5"   // 导入 crypto/md5 包，用于计算图片内容的 md5 值
+	"encoding/hex" // 导入 encoding/hex 包，用于将 md5 值转换为十六进制字符串
+	"fmt"          // 导入 fmt 包，用于格式化字符串和错误信息
+	"image"        // 导入 image 包，用于图片解码和尺寸计算
+	"image/color"  // 导入 image/color 包，用于填充透明背景
+	_ "image/gif"  // 注册 GIF 解码器，GIF 只取第一帧
+	"image/jpeg"   // 导入 image/jpeg 包，用于 JPEG 编解码
+	_ "image/png"  // 注册 PNG 解码器
+	"log/slog"     // 导入 log/slog 包，用于日志输出
+	"net/http"     // 导入 net/http 包，用于识别图片内容类型
+
+	_ "golang.org/x/image/bmp"  // 注册 BMP 解码器
+	"golang.org/x/image/draw"   // 导入 draw 包，用于图片缩放和背景合成
+	_ "golang.org/x/image/tiff" // 注册 TIFF 解码器
+	_ "golang.org/x/image/webp" // 注册 WebP 解码器
+)
+
+const (
+	maxImageBytes     = 2 * 1024 * 1024  // 企业微信图片消息的最大字节数 (base64 编码前)
+	minImageDimension = 64               // 缩放时允许的最小边长，小于该值时放弃继续缩放
+	maxImagePixels    = 40 * 1000 * 1000 // 允许转码的最大像素数 (约 4000 万像素，解码为 RGBA 后约 160MB)，防止很小的文件解码出巨大的图片
+	imageScaleStep    = 0.75             // 每轮缩放的比例
+)
+
+// jpegQualities 是重新编码为 JPEG 时依次尝试的质量参数
+var jpegQualities = []int{90, 80, 70, 60}
+
+// prepareImage 将任意图片数据转换为企业微信图片消息可接受的格式
+// 企业微信只接受 2MB 以内的 JPG 和 PNG 图片：符合要求的图片原样返回，
+// 其他格式 (WebP、GIF、BMP 等) 或超出大小的图片会重新编码为 JPEG，并在必要时逐步缩小尺寸直到满足大小限制。
+// 解码前先读取图片头中的尺寸，超过 maxImagePixels 的图片直接拒绝，不分配解码所需的内存。
+func prepareImage(data []byte) ([]byte, error) {
+	contentType := http.DetectContentType(data)
+	if len(data) <= maxImageBytes && (contentType == "image/jpeg" || contentType == "image/png") {
+		return data, nil
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image header (content type %s): %w", contentType, err)
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxImagePixels {
+		return nil, fmt.Errorf("%s image of %dx%d pixels exceeds the %d pixel limit for transcoding", format, cfg.Width, cfg.Height, maxImagePixels)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image (content type %s): %w", contentType, err)
+	}
+	slog.Info("[WeCom Robot] 图片需要转码为 JPEG", "format", format, "bytes", len(data))
+
+	img = flattenImage(img)
+	for {
+		for _, quality := range jpegQualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, fmt.Errorf("failed to encode image as jpeg: %w", err)
+			}
+			if buf.Len() <= maxImageBytes {
+				bounds := img.Bounds()
+				slog.Info("[WeCom Robot] 图片转码完成", "width", bounds.Dx(), "height", bounds.Dy(), "quality", quality, "bytes", buf.Len())
+				return buf.Bytes(), nil
+			}
+		}
+
+		bounds := img.Bounds()
+		width := int(float64(bounds.Dx()) * imageScaleStep)
+		height := int(float64(bounds.Dy()) * imageScaleStep)
+		if width < minImageDimension || height < minImageDimension {
+			return nil, fmt.Errorf("image cannot be reduced below %d bytes", maxImageBytes)
+		}
+		img = scaleImage(img, width, height)
+	}
+}
+
+// flattenImage 将图片绘制到白色背景上，去除透明通道
+// JPEG 不支持透明度，直接编码会让透明区域变成黑色。
+func flattenImage(src image.Image) image.Image {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+	return dst
+}
+
+// scaleImage 将图片缩放到指定尺寸
+func scaleImage(src image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// imageMD5 返回图片内容 (base64 编码前) 的 md5 十六进制字符串
+func imageMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
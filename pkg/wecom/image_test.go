@@ -0,0 +1,175 @@
+package wecom
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+
+	"dify2wxbot/internal/config"
+)
+
+// lossless1x1WebP 是 1x1 的无损 WebP 图片
+const lossless1x1WebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// noiseImage 返回一张随机噪点图片，噪点无法被 JPEG 有效压缩
+func noiseImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	return img
+}
+
+// decodeJPEG 检查 data 是 JPEG 图片并返回其尺寸
+func decodeJPEG(t *testing.T, data []byte) image.Rectangle {
+	t.Helper()
+	if ct := http.DetectContentType(data); ct != "image/jpeg" {
+		t.Fatalf("content type = %s, want image/jpeg", ct)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode jpeg: %v", err)
+	}
+	return img.Bounds()
+}
+
+func TestPrepareImageKeepsSmallJPEGAndPNG(t *testing.T) {
+	var pngData, jpegData bytes.Buffer
+	img := noiseImage(32, 32)
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	for name, data := range map[string][]byte{"png": pngData.Bytes(), "jpeg": jpegData.Bytes()} {
+		got, err := prepareImage(data)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: prepareImage changed a small %s image (err %v)", name, name, err)
+		}
+	}
+}
+
+func TestPrepareImageTranscodesToJPEG(t *testing.T) {
+	var gifData bytes.Buffer
+	palette := color.Palette{color.Transparent, color.Black, color.White}
+	frame := image.NewPaletted(image.Rect(0, 0, 40, 30), palette)
+	frame.SetColorIndex(5, 5, 1)
+	if err := gif.Encode(&gifData, frame, nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	webpData, err := base64.StdEncoding.DecodeString(lossless1x1WebP)
+	if err != nil {
+		t.Fatalf("decode webp fixture: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want image.Rectangle
+	}{
+		{name: "gif", data: gifData.Bytes(), want: image.Rect(0, 0, 40, 30)},
+		{name: "webp", data: webpData, want: image.Rect(0, 0, 1, 1)},
+	}
+	for _, tt := range tests {
+		got, err := prepareImage(tt.data)
+		if err != nil {
+			t.Fatalf("%s: prepareImage: %v", tt.name, err)
+		}
+		if bounds := decodeJPEG(t, got); bounds != tt.want {
+			t.Errorf("%s: transcoded image is %v, want %v", tt.name, bounds, tt.want)
+		}
+	}
+
+	// 透明区域铺白色背景，而不是变成黑色
+	got, _ := prepareImage(gifData.Bytes())
+	img, _ := jpeg.Decode(bytes.NewReader(got))
+	if r, g, b, _ := img.At(0, 0).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("transparent pixel became (%d, %d, %d), want white", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestPrepareImageShrinksLargeImages(t *testing.T) {
+	var bmpData bytes.Buffer
+	if err := bmp.Encode(&bmpData, noiseImage(2400, 2000)); err != nil {
+		t.Fatalf("encode bmp: %v", err)
+	}
+	got, err := prepareImage(bmpData.Bytes())
+	if err != nil {
+		t.Fatalf("prepareImage: %v", err)
+	}
+	if len(got) > maxImageBytes {
+		t.Fatalf("prepared image is %d bytes, want at most %d", len(got), maxImageBytes)
+	}
+	// 随机噪点即使以最低质量编码也超过 2MB，只能缩小尺寸
+	if bounds := decodeJPEG(t, got); bounds.Dx() >= 2400 || bounds.Dy() >= 2000 {
+		t.Fatalf("prepared image is %v, want it scaled below 2400x2000", bounds)
+	}
+}
+
+func TestPrepareImageRejectsDecompressionBombs(t *testing.T) {
+	// GIF 逻辑屏幕声明为 60000x60000，文件只有十几个字节
+	header := []byte("GIF89a")
+	header = binary.LittleEndian.AppendUint16(header, 60000)
+	header = binary.LittleEndian.AppendUint16(header, 60000)
+	header = append(header, 0, 0, 0)
+	if _, err := prepareImage(header); err == nil || !strings.Contains(err.Error(), "pixel limit") {
+		t.Fatalf("prepareImage error = %v, want the pixel limit to reject a 60000x60000 image", err)
+	}
+}
+
+func TestSendImageDataSendsBase64AndMD5(t *testing.T) {
+	var payload struct {
+		MsgType string `json:"msgtype"`
+		Image   struct {
+			Base64 string `json:"base64"`
+			MD5    string `json:"md5"`
+		} `json:"image"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	}))
+	defer srv.Close()
+
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.White}), nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	robot := NewRobot(config.WeComConfig{Name: "image-test", WebhookURL: srv.URL + "/cgi-bin/webhook/send?key=image-test"})
+	if err := robot.SendImageDataContext(context.Background(), gifData.Bytes()); err != nil {
+		t.Fatalf("SendImageDataContext: %v", err)
+	}
+
+	if payload.MsgType != "image" {
+		t.Fatalf("msgtype = %q, want image", payload.MsgType)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload.Image.Base64)
+	if err != nil {
+		t.Fatalf("decode base64: %v", err)
+	}
+	decodeJPEG(t, data) // GIF 被转码为 JPEG 后再编码
+	sum := md5.Sum(data)
+	if payload.Image.MD5 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("md5 = %s, want the md5 of the decoded image %x", payload.Image.MD5, sum)
+	}
+}
@@ -0,0 +1,355 @@
+package wecom
+
+import (
+	"context"  // 导入 context 包，用于在排队等待和发送过程中响应取消
+	"errors"   // 导入 errors 包，用于定义和识别队列相关的错误
+	"log/slog" // 导入 log/slog 包，用于结构化日志输出
+	"sync"     // 导入 sync 包，用于保护队列状态和全局队列注册表
+	"time"     // 导入 time 包，用于计算等待时间和退避时间
+
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于暴露发送队列的长度
+	"dify2wxbot/pkg/sender"       // 导入 pkg/sender 包，使用其中的滑动窗口限流器
+)
+
+const (
+	defaultQueueSize      = 100              // 每个机器人发送队列的默认最大长度
+	defaultQueueMaxWait   = 5 * time.Minute  // 消息在队列中的最长等待时间，超过后丢弃
+	maxRateLimitRetries   = 3                // 收到 45009 后的最大重试次数
+	rateLimitBaseBackoff  = 10 * time.Second // 收到 45009 后的初始退避时间
+	rateLimitMaxBackoff   = 60 * time.Second // 收到 45009 后的最大退避时间
+	errCodeRateLimited    = 45009            // 企业微信 API 调用频率超限的错误码
+	errCodeLabelHTTP      = "http"           // 请求未得到企业微信业务响应 (网络错误或非 200 状态码) 时使用的错误码标签
+	rateLimitWindowLength = time.Minute      // 企业微信机器人频率限制的统计周期
+
+	defaultRateLimitPerMinute = 20 // 企业微信机器人默认的发送频率上限：每分钟 20 条
+
+	drainPollInterval = 50 * time.Millisecond // 退出时检查发送队列是否已清空的间隔
+)
+
+var (
+	// ErrQueueFull 表示发送队列已满，消息被丢弃
+	ErrQueueFull = errors.New("wecom send queue is full")
+	// ErrQueueTimeout 表示消息在队列中等待过久，消息被丢弃
+	ErrQueueTimeout = errors.New("wecom message waited too long in send queue")
+)
+
+// QueueStats 描述某个机器人发送队列的运行状态
+type QueueStats struct {
+	Key         string        `json:"key"`          // Key 是脱敏后的 webhook key
+	Depth       int           `json:"depth"`        // Depth 是当前排队等待发送的消息数
+	Sent        uint64        `json:"sent"`         // Sent 是发送成功的消息数
+	Failed      uint64        `json:"failed"`       // Failed 是发送失败的消息数 (不含丢弃)
+	Dropped     uint64        `json:"dropped"`      // Dropped 是因队列已满或等待超时而丢弃的消息数
+	Canceled    uint64        `json:"canceled"`     // Canceled 是发送前被调用方取消的消息数
+	RateLimited uint64        `json:"rate_limited"` // RateLimited 是收到 45009 的次数
+	Retried     uint64        `json:"retried"`      // Retried 是因 45009 重试的次数
+	LastWait    time.Duration `json:"last_wait"`    // LastWait 是最近一条消息从入队到发送完成的等待时间
+	MaxWait     time.Duration `json:"max_wait"`     // MaxWait 是观察到的最长等待时间
+	TotalWait   time.Duration `json:"total_wait"`   // TotalWait 是所有已处理消息的累计等待时间
+}
+
+// outboundMessage 是发送队列中的一条待发送消息
+type outboundMessage struct {
+	ctx      context.Context                 // ctx 是调用方的上下文，取消后消息不再发送
+	msgType  string                          // msgType 是消息类型，用于日志
+	send     func(ctx context.Context) error // send 执行实际的 HTTP 发送
+	enqueued time.Time                       // enqueued 是入队时间
+	done     chan error                      // done 用于通知调用方发送结果
+}
+
+// sendQueue 是单个 webhook key 的先进先出发送队列
+// 每个队列有一个后台 goroutine 按顺序发送消息，发送前从限流器获取配额；
+// 配额用完时消息留在队列中等待，收到 45009 时整体退避后重试当前消息。
+type sendQueue struct {
+	key      string                          // key 是 webhook key
+	bucket   *sender.RateLimiter             // bucket 是该 key 的限流器，任意一分钟内的发送数不超过频率上限
+	maxDepth int                             // maxDepth 是队列最大长度
+	maxWait  time.Duration                   // maxWait 是消息在队列中的最长等待时间
+	backoff  func(attempt int) time.Duration // backoff 返回第 attempt 次收到 45009 后的退避时间
+	mu       sync.Mutex                      // mu 保护 items、busy 和 stats
+	items    []*outboundMessage
+	busy     bool          // busy 表示后台 goroutine 正在处理已取出的消息
+	notify   chan struct{} // notify 在有新消息入队时唤醒后台 goroutine
+	stats    QueueStats
+}
+
+var (
+	queuesMu sync.Mutex                    // queuesMu 保护 queues
+	queues   = make(map[string]*sendQueue) // queues 按 webhook key 保存发送队列，同一个 key 的多个 Robot 实例共享同一个队列和配额
+)
+
+// getSendQueue 返回 key 对应的发送队列，不存在时按给定参数创建并启动
+// limit: 每分钟允许发送的消息数
+// depth: 队列最大长度
+func getSendQueue(key string, limit, depth int) *sendQueue {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+
+	if q, ok := queues[key]; ok {
+		return q
+	}
+	q := newSendQueue(key, limit, depth)
+	queues[key] = q
+	go q.run()
+	return q
+}
+
+// newSendQueue 按给定参数创建发送队列，未配置的参数使用默认值；调用方负责启动后台 goroutine (run)
+func newSendQueue(key string, limit, depth int) *sendQueue {
+	if limit <= 0 {
+		limit = defaultRateLimitPerMinute
+	}
+	if depth <= 0 {
+		depth = defaultQueueSize
+	}
+	q := &sendQueue{
+		key:      key,
+		bucket:   sender.NewRateLimiter(limit, rateLimitWindowLength),
+		maxDepth: depth,
+		maxWait:  defaultQueueMaxWait,
+		notify:   make(chan struct{}, 1),
+		backoff:  rateLimitBackoff,
+	}
+	q.stats.Key = maskKey(key)
+	return q
+}
+
+// queueDepth 在每次输出指标时报告各个发送队列当前的长度，标签为脱敏后的 webhook key
+var queueDepth = metrics.Default.NewGaugeFunc("dify2wxbot_wecom_queue_depth",
+	"Messages waiting in each WeCom robot send queue.", func() []metrics.Sample {
+		stats := AllQueueStats()
+		samples := make([]metrics.Sample, 0, len(stats))
+		for _, s := range stats {
+			samples = append(samples, metrics.Sample{LabelValues: []string{s.Key}, Value: float64(s.Depth)})
+		}
+		return samples
+	}, "key")
+
+// AllQueueStats 返回所有机器人发送队列的运行状态
+func AllQueueStats() []QueueStats {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+
+	stats := make([]QueueStats, 0, len(queues))
+	for _, q := range queues {
+		stats = append(stats, q.Stats())
+	}
+	return stats
+}
+
+// Stats 返回队列当前的运行状态
+func (q *sendQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.items)
+	return stats
+}
+
+// enqueue 将消息放入队列并等待发送结果
+// 队列已满时立即返回 ErrQueueFull；ctx 被取消时立即返回 ctx.Err()，尚未发送的消息会被后台 goroutine 跳过。
+func (q *sendQueue) enqueue(ctx context.Context, msgType string, send func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	msg := &outboundMessage{
+		ctx:      ctx,
+		msgType:  msgType,
+		send:     send,
+		enqueued: time.Now(),
+		done:     make(chan error, 1),
+	}
+
+	q.mu.Lock()
+	if len(q.items) >= q.maxDepth {
+		q.stats.Dropped++
+		q.mu.Unlock()
+		slog.WarnContext(ctx, "[WeCom Robot] 发送队列已满，丢弃消息", "msgtype", msgType, "max_depth", q.maxDepth)
+		return ErrQueueFull
+	}
+	q.items = append(q.items, msg)
+	depth := len(q.items)
+	q.mu.Unlock()
+
+	if depth > 1 {
+		slog.DebugContext(ctx, "[WeCom Robot] 消息已进入发送队列", "msgtype", msgType, "depth", depth)
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	select {
+	case err := <-msg.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// next 阻塞直到队列中有消息，并取出队首消息
+func (q *sendQueue) next() *outboundMessage {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			msg := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.busy = true
+			q.mu.Unlock()
+			return msg
+		}
+		q.mu.Unlock()
+		<-q.notify
+	}
+}
+
+// run 是队列的后台 goroutine，按先进先出顺序逐条发送消息
+func (q *sendQueue) run() {
+	for {
+		q.process(q.next())
+		q.mu.Lock()
+		q.busy = false
+		q.mu.Unlock()
+	}
+}
+
+// DrainQueues 等待所有机器人发送队列中的消息处理完毕，用于优雅退出
+// ctx 结束时仍有消息未处理完则返回 ctx.Err()，剩余的消息随进程退出而丢弃。
+func DrainQueues(ctx context.Context) error {
+	queuesMu.Lock()
+	pending := make([]*sendQueue, 0, len(queues))
+	for _, q := range queues {
+		pending = append(pending, q)
+	}
+	queuesMu.Unlock()
+
+	for _, q := range pending {
+		if err := q.drain(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drain 阻塞直到队列为空且没有正在处理的消息，或 ctx 结束
+func (q *sendQueue) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for !q.idle() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// idle 判断队列是否为空且没有正在处理的消息
+func (q *sendQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items) == 0 && !q.busy
+}
+
+// process 发送单条消息：等待配额、发送，遇到 45009 时退避后重试
+// 调用方取消的消息在等待配额期间或发送前被跳过，不消耗发送配额。
+func (q *sendQueue) process(msg *outboundMessage) {
+	for attempt := 0; ; attempt++ {
+		if q.canceled(msg) {
+			return
+		}
+		if time.Since(msg.enqueued) > q.maxWait {
+			q.mu.Lock()
+			q.stats.Dropped++
+			q.mu.Unlock()
+			slog.WarnContext(msg.ctx, "[WeCom Robot] 消息在队列中等待超时，已丢弃", "msgtype", msg.msgType, "max_wait", q.maxWait.String())
+			msg.done <- ErrQueueTimeout
+			return
+		}
+		if !q.waitForToken(msg) {
+			return
+		}
+
+		err := msg.send(msg.ctx)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.ErrCode == errCodeRateLimited {
+			q.mu.Lock()
+			q.stats.RateLimited++
+			q.mu.Unlock()
+			if attempt < maxRateLimitRetries {
+				backoff := q.backoff(attempt)
+				slog.WarnContext(msg.ctx, "[WeCom Robot] 触发企业微信频率限制 (45009)，稍后重试", "msgtype", msg.msgType,
+					"backoff", backoff.String(), "attempt", attempt+1, "max_attempts", maxRateLimitRetries)
+				q.bucket.Pause(backoff)
+				q.mu.Lock()
+				q.stats.Retried++
+				q.mu.Unlock()
+				continue
+			}
+		}
+
+		wait := time.Since(msg.enqueued)
+		q.mu.Lock()
+		if err == nil {
+			q.stats.Sent++
+		} else {
+			q.stats.Failed++
+		}
+		q.stats.LastWait = wait
+		q.stats.TotalWait += wait
+		if wait > q.stats.MaxWait {
+			q.stats.MaxWait = wait
+		}
+		q.mu.Unlock()
+		msg.done <- err
+		return
+	}
+}
+
+// waitForToken 等待限流器的发送配额，等待期间消息被取消时返回 false
+func (q *sendQueue) waitForToken(msg *outboundMessage) bool {
+	for wait := q.bucket.Take(); wait > 0; wait = q.bucket.Take() {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-msg.ctx.Done():
+			timer.Stop()
+			q.canceled(msg)
+			return false
+		}
+	}
+	return true
+}
+
+// canceled 判断消息是否已被调用方取消，已取消时记录统计并通知调用方
+func (q *sendQueue) canceled(msg *outboundMessage) bool {
+	err := msg.ctx.Err()
+	if err == nil {
+		return false
+	}
+	q.mu.Lock()
+	q.stats.Canceled++
+	q.mu.Unlock()
+	slog.InfoContext(msg.ctx, "[WeCom Robot] 消息在发送前已被取消", "msgtype", msg.msgType, "error", err)
+	msg.done <- err
+	return true
+}
+
+// rateLimitBackoff 返回第 attempt 次收到 45009 后的退避时间，按指数增长并设置上限
+func rateLimitBackoff(attempt int) time.Duration {
+	backoff := rateLimitBaseBackoff << uint(attempt)
+	if backoff > rateLimitMaxBackoff {
+		backoff = rateLimitMaxBackoff
+	}
+	return backoff
+}
+
+// maskKey 对 webhook key 进行脱敏，只保留前 4 个字符
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
@@ -0,0 +1,132 @@
+package wecom
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestQueue 创建并启动一个退避时间很短的发送队列
+func newTestQueue(limit, depth int) *sendQueue {
+	q := newSendQueue("test-key", limit, depth)
+	q.backoff = func(int) time.Duration { return 10 * time.Millisecond }
+	go q.run()
+	return q
+}
+
+func TestSendQueueRetriesAfterRateLimit(t *testing.T) {
+	q := newTestQueue(20, 10)
+	var calls int32
+	err := q.enqueue(context.Background(), "text", func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			return &APIError{MsgType: "text", ErrCode: errCodeRateLimited, ErrMsg: "api freq out of limit"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	stats := q.Stats()
+	if calls != 3 || stats.RateLimited != 2 || stats.Retried != 2 || stats.Sent != 1 {
+		t.Fatalf("calls = %d, stats = %+v; want two 45009 retries then success", calls, stats)
+	}
+}
+
+func TestSendQueueGivesUpAfterMaxRateLimitRetries(t *testing.T) {
+	q := newTestQueue(20, 10)
+	var calls int32
+	err := q.enqueue(context.Background(), "text", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return &APIError{MsgType: "text", ErrCode: errCodeRateLimited}
+	})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrCode != errCodeRateLimited {
+		t.Fatalf("enqueue = %v, want the 45009 error", err)
+	}
+	if calls != maxRateLimitRetries+1 || q.Stats().Failed != 1 {
+		t.Fatalf("calls = %d, stats = %+v; want %d attempts and one failure", calls, q.Stats(), maxRateLimitRetries+1)
+	}
+}
+
+func TestSendQueueDropsWhenFull(t *testing.T) {
+	q := newTestQueue(20, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go q.enqueue(context.Background(), "text", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started // 第一条消息已出队并在发送中
+	go q.enqueue(context.Background(), "text", func(ctx context.Context) error { return nil })
+	deadline := time.Now().Add(time.Second)
+	for q.Stats().Depth < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := q.enqueue(context.Background(), "text", func(ctx context.Context) error { return nil })
+	close(release)
+	if !errors.Is(err, ErrQueueFull) || q.Stats().Dropped != 1 {
+		t.Fatalf("enqueue = %v, stats = %+v; want ErrQueueFull and one dropped message", err, q.Stats())
+	}
+}
+
+func TestSendQueueSkipsCanceledMessages(t *testing.T) {
+	q := newTestQueue(1, 10)
+	if err := q.enqueue(context.Background(), "text", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("first enqueue: %v", err)
+	}
+
+	// 配额已用完，第二条消息在等待配额期间被取消，不会被发送
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var sent int32
+	err := q.enqueue(ctx, "text", func(ctx context.Context) error {
+		atomic.AddInt32(&sent, 1)
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("enqueue = %v, want context.DeadlineExceeded", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for q.Stats().Canceled == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sent != 0 || q.Stats().Canceled != 1 {
+		t.Fatalf("sent = %d, stats = %+v; want the canceled message skipped", sent, q.Stats())
+	}
+
+	if err := q.enqueue(ctx, "text", func(ctx context.Context) error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("enqueue with canceled ctx = %v, want the ctx error immediately", err)
+	}
+}
+
+func TestSendQueueDrain(t *testing.T) {
+	q := newTestQueue(20, 10)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go q.enqueue(context.Background(), "text", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started // 消息已出队，队列为空但仍在发送中
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain while sending = %v, want context.DeadlineExceeded", err)
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.drain(ctx); err != nil {
+		t.Fatalf("drain after send = %v, want nil", err)
+	}
+	if q.Stats().Sent != 1 {
+		t.Fatalf("stats = %+v, want the message sent before drain returned", q.Stats())
+	}
+}
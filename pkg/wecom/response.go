@@ -0,0 +1,91 @@
+package wecom
+
+import (
+	"context"       // 导入 context 包，用于取消进行中的请求
+	"encoding/json" // 导入 encoding/json 包，用于编码回复消息
+	"errors"        // 导入 errors 包，用于定义 response_url 已被使用的错误
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"sync"          // 导入 sync 包，用于保证 response_url 只被调用一次
+
+	"dify2wxbot/internal/config" // 导入 config 包，用于构造发送请求的 Robot
+	"dify2wxbot/pkg/sender"      // 导入 pkg/sender 包，ResponseSender 实现其中的 Sender 接口
+)
+
+const responseMarkdownMaxBytes = 20480 // 智能机器人 Markdown 回复的最大字节数
+
+// ErrResponseURLUsed 表示 response_url 已经被调用过，企业微信只接受对同一个 response_url 的一次回复
+var ErrResponseURLUsed = errors.New("wecom response_url has already been used")
+
+var _ sender.Sender = (*ResponseSender)(nil) // ResponseSender 实现了 Sender 接口
+
+// ResponseSender 通过智能机器人回调消息中的 response_url 回复消息来源的会话
+// response_url 有效期 1 小时且只能调用一次，之后的消息返回 ErrResponseURLUsed，因此它声明 SingleShot 能力，
+// 由 MessageConverter 把回答和附加内容合并为一条不超过 20480 字节的消息。response_url 只接受 Markdown 消息，文本消息同样以 Markdown 发送。
+type ResponseSender struct {
+	robot *Robot     // robot 负责向 response_url 发送请求并解析企业微信的返回结果
+	mu    sync.Mutex // mu 保护 used
+	used  bool       // used 表示 response_url 是否已经被调用过
+}
+
+// NewResponseSender 创建一个通过 response_url 回复消息的发送方
+// name: 发送方名称，用于日志和投递结果
+// responseURL: 回调消息中的 response_url
+func NewResponseSender(name, responseURL string) *ResponseSender {
+	return &ResponseSender{robot: NewRobot(config.WeComConfig{Name: name, WebhookURL: responseURL})}
+}
+
+// Name 返回发送方名称
+func (s *ResponseSender) Name() string {
+	return s.robot.Name()
+}
+
+// Capabilities 返回 response_url 支持的消息能力：只能发送一条企业微信 Markdown 消息，最长 20480 字节，不支持图片、文件和 @成员
+func (s *ResponseSender) Capabilities() sender.Capabilities {
+	return sender.Capabilities{
+		MaxTextBytes:     responseMarkdownMaxBytes,
+		MaxMarkdownBytes: responseMarkdownMaxBytes,
+		Markdown:         sender.MarkdownWeCom,
+		SingleShot:       true,
+	}
+}
+
+// SendTextMessageContext 以 Markdown 消息发送文本
+func (s *ResponseSender) SendTextMessageContext(ctx context.Context, content string) error {
+	return s.send(ctx, "markdown", map[string]string{"content": content})
+}
+
+// SendMarkdownMessageContext 发送 Markdown 消息
+func (s *ResponseSender) SendMarkdownMessageContext(ctx context.Context, content string) error {
+	return s.send(ctx, "markdown", map[string]string{"content": content})
+}
+
+// SendImageMessageContext 返回 sender.ErrUnsupported，response_url 不支持图片消息
+func (s *ResponseSender) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	return sender.ErrUnsupported
+}
+
+// SendFileMessageContext 返回 sender.ErrUnsupported，response_url 不支持文件消息
+func (s *ResponseSender) SendFileMessageContext(ctx context.Context, filePath string) error {
+	return sender.ErrUnsupported
+}
+
+// send 将消息 POST 到 response_url，response_url 已经被使用时返回 ErrResponseURLUsed
+// response_url 只对应一次回复，不受群机器人每分钟 20 条的限制，因此不经过发送队列。
+func (s *ResponseSender) send(ctx context.Context, msgType string, payload interface{}) error {
+	s.mu.Lock()
+	if s.used {
+		s.mu.Unlock()
+		return ErrResponseURLUsed
+	}
+	s.used = true
+	s.mu.Unlock()
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"msgtype": msgType,
+		msgType:   payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", msgType, err)
+	}
+	return s.robot.postMessage(ctx, msgType, jsonData)
+}
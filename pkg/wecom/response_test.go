@@ -0,0 +1,40 @@
+package wecom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseSenderRepliesOnce(t *testing.T) {
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		bodies = append(bodies, body)
+		w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+	}))
+	defer srv.Close()
+
+	s := NewResponseSender("origin", srv.URL+"/cgi-bin/aibot/response?response_code=abc")
+	if !s.Capabilities().SingleShot {
+		t.Fatal("response sender does not declare SingleShot")
+	}
+	if err := s.SendTextMessageContext(context.Background(), "你好"); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	if err := s.SendMarkdownMessageContext(context.Background(), "**再见**"); !errors.Is(err, ErrResponseURLUsed) {
+		t.Fatalf("second send error = %v, want ErrResponseURLUsed", err)
+	}
+	if len(bodies) != 1 || bodies[0]["msgtype"] != "markdown" {
+		t.Fatalf("response_url got %v, want a single markdown message", bodies)
+	}
+	if content := bodies[0]["markdown"].(map[string]interface{})["content"]; content != "你好" {
+		t.Fatalf("content = %v, want 你好", content)
+	}
+}
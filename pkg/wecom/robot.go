@@ -1,30 +1,34 @@
 package wecom
 
 import (
-	"bytes"          // 导入 bytes 包，用于处理字节缓冲区，例如构建 HTTP 请求体
-	"encoding/json"  // 导入 encoding/json 包，用于 JSON 数据的编解码
-	"fmt"            // 导入 fmt 包，用于格式化字符串和错误信息
-	"io"             // 导入 io 包，用于 IO 操作，例如读取文件内容
-	"log"            // 导入 log 包，用于日志输出
-	"mime/multipart" // 导入 mime/multipart 包，用于处理 multipart/form-data 格式的请求
-	"net/http"       // 导入 net/http 包，用于构建和发送 HTTP 请求
-	"net/url"        // 导入 net/url 包，用于 URL 的解析和操作
-	"os"             // 导入 os 包，用于文件操作，例如打开文件
-	"path/filepath"  // 导入 path/filepath 包，用于处理文件路径
-	"time"           // 导入 time 包，用于处理时间相关操作
-
-	"dify2wxbot/internal/config" // 导入 config 包，用于加载应用程序配置
+	"bytes"           // 导入 bytes 包，用于处理字节缓冲区，例如构建 HTTP 请求体
+	"context"         // 导入 context 包，用于取消排队等待和进行中的请求
+	"encoding/base64" // 导入 encoding/base64 包，用于图片消息的 base64 编码
+	"encoding/json"   // 导入 encoding/json 包，用于 JSON 数据的编解码
+	"fmt"             // 导入 fmt 包，用于格式化字符串和错误信息
+	"io"              // 导入 io 包，用于 IO 操作，例如读取文件内容
+	"log/slog"        // 导入 log/slog 包，用于结构化日志输出
+	"mime/multipart"  // 导入 mime/multipart 包，用于处理 multipart/form-data 格式的请求
+	"net/http"        // 导入 net/http 包，用于构建和发送 HTTP 请求
+	"net/url"         // 导入 net/url 包，用于 URL 的解析和操作
+	"os"              // 导入 os 包，用于文件操作，例如打开文件
+	"path/filepath"   // 导入 path/filepath 包，用于处理文件路径
+	"strconv"         // 导入 strconv 包，用于将错误码转换为指标标签
+	"time"            // 导入 time 包，用于处理时间相关操作
+
+	"dify2wxbot/internal/config"  // 导入 config 包，用于读取企业微信机器人配置
+	"dify2wxbot/internal/metrics" // 导入 metrics 包，用于记录消息发送结果和耗时
 )
 
 // Robot 结构体定义了企业微信机器人的客户端
 type Robot struct {
-	cfg        *config.AppConfig // cfg 存储应用程序的配置，包含企业微信 Webhook URL
-	httpClient *http.Client      // httpClient 是一个 HTTP 客户端实例，用于发送请求并复用连接
+	cfg        config.WeComConfig // cfg 存储该机器人的配置，包含企业微信 Webhook URL 和发送频率限制
+	httpClient *http.Client       // httpClient 是一个 HTTP 客户端实例，用于发送请求并复用连接
 }
 
 // NewRobot 创建并返回一个新的 Robot 实例
-// cfg: 应用程序配置，包含企业微信 Webhook URL
-func NewRobot(cfg *config.AppConfig) *Robot {
+// cfg: 机器人配置，包含企业微信 Webhook URL 和发送频率限制
+func NewRobot(cfg config.WeComConfig) *Robot {
 	return &Robot{
 		cfg: cfg, // 初始化 Robot 的 cfg 字段
 		httpClient: &http.Client{
@@ -33,9 +37,14 @@ func NewRobot(cfg *config.AppConfig) *Robot {
 	}
 }
 
+// Name 返回机器人名称
+func (r *Robot) Name() string {
+	return r.cfg.Name
+}
+
 // getWebhookKey 从企业微信 Webhook URL 中提取 'key' 参数
 func (r *Robot) getWebhookKey() (string, error) {
-	parsedURL, err := url.Parse(r.cfg.WeCom.WebhookURL)
+	parsedURL, err := url.Parse(r.cfg.WebhookURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse wecom webhook url: %w", err)
 	}
@@ -48,9 +57,9 @@ func (r *Robot) getWebhookKey() (string, error) {
 
 // uploadMedia 上传媒体文件到企业微信，并返回 media_id
 // mediaFilePath: 媒体文件的本地路径
-// mediaType: 媒体类型，例如 "image", "voice", "video", "file"
-func (r *Robot) uploadMedia(mediaFilePath, mediaType string) (string, error) {
-	log.Printf("[WeCom Robot] 尝试上传媒体文件 '%s' (类型: %s) 到企业微信...", mediaFilePath, mediaType)
+// mediaType: 媒体类型，例如 "voice", "file"
+func (r *Robot) uploadMedia(ctx context.Context, mediaFilePath, mediaType string) (string, error) {
+	slog.InfoContext(ctx, "[WeCom Robot] 尝试上传媒体文件到企业微信", "robot", r.cfg.Name, "file", mediaFilePath, "media_type", mediaType)
 
 	key, err := r.getWebhookKey()
 	if err != nil {
@@ -78,7 +87,7 @@ func (r *Robot) uploadMedia(mediaFilePath, mediaType string) (string, error) {
 	}
 	writer.Close()
 
-	req, err := http.NewRequest(http.MethodPost, uploadURL, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, body)
 	if err != nil {
 		return "", fmt.Errorf("failed to create media upload request: %w", err)
 	}
@@ -110,13 +119,44 @@ func (r *Robot) uploadMedia(mediaFilePath, mediaType string) (string, error) {
 		return "", fmt.Errorf("wecom media upload failed: %s (errcode: %d)", result.ErrMsg, result.ErrCode)
 	}
 
-	log.Printf("[WeCom Robot] 媒体文件上传成功，MediaID: %s", result.MediaID)
+	slog.InfoContext(ctx, "[WeCom Robot] 媒体文件上传成功", "robot", r.cfg.Name, "media_id", result.MediaID)
 	return result.MediaID, nil
 }
 
+// APIError 表示企业微信接口返回的业务错误 (errcode 不为 0)
+type APIError struct {
+	MsgType string // MsgType 是发送的消息类型
+	ErrCode int    // ErrCode 是企业微信返回的错误码
+	ErrMsg  string // ErrMsg 是企业微信返回的错误信息
+}
+
+// Error 实现 error 接口
+func (e *APIError) Error() string {
+	if e.ErrCode == errCodeRateLimited {
+		return fmt.Sprintf("wecom %s message failed due to rate limit: %s (errcode: %d)", e.MsgType, e.ErrMsg, e.ErrCode)
+	}
+	return fmt.Sprintf("wecom %s message failed: %s (errcode: %d)", e.MsgType, e.ErrMsg, e.ErrCode)
+}
+
+// QueueStats 返回该机器人发送队列的运行状态，包括排队长度、等待时间和丢弃数量
+func (r *Robot) QueueStats() QueueStats {
+	return r.queue().Stats()
+}
+
+// queue 返回该机器人 webhook key 对应的发送队列
+// 同一个 webhook key 的所有 Robot 实例共享一个队列，从而共享每分钟 20 条的发送配额。
+func (r *Robot) queue() *sendQueue {
+	key, err := r.getWebhookKey()
+	if err != nil {
+		key = r.cfg.WebhookURL // 无法解析 key 时退化为按完整 URL 区分
+	}
+	return getSendQueue(key, r.cfg.RateLimitPerMinute, r.cfg.QueueSize)
+}
+
 // sendMessageToWeCom 是一个通用的辅助函数，用于向企业微信机器人发送消息
-func (r *Robot) sendMessageToWeCom(msgType string, payload interface{}) error {
-	log.Printf("[WeCom Robot] 尝试发送 %s 类型消息到企业微信...", msgType)
+// 消息会先进入该机器人的发送队列，在频率配额允许时按顺序发出；该函数会阻塞直到消息发送完成、被丢弃或 ctx 被取消。
+func (r *Robot) sendMessageToWeCom(ctx context.Context, msgType string, payload interface{}) error {
+	slog.DebugContext(ctx, "[WeCom Robot] 尝试发送消息到企业微信", "robot", r.cfg.Name, "msgtype", msgType)
 
 	msg := map[string]interface{}{
 		"msgtype": msgType,
@@ -128,7 +168,28 @@ func (r *Robot) sendMessageToWeCom(msgType string, payload interface{}) error {
 		return fmt.Errorf("failed to marshal %s message: %w", msgType, err)
 	}
 
-	resp, err := r.httpClient.Post(r.cfg.WeCom.WebhookURL, "application/json", bytes.NewBuffer(jsonData))
+	return r.queue().enqueue(ctx, msgType, func(ctx context.Context) error {
+		return r.postMessage(ctx, msgType, jsonData)
+	})
+}
+
+// postMessage 将已编码的消息 POST 到企业微信机器人 Webhook 并解析返回结果
+// 企业微信返回业务错误时返回 *APIError，发送队列据此识别 45009 频率限制并退避重试。
+// 每次调用都会按消息类型和错误码记录到指标中，未得到企业微信业务响应时错误码标签为 "http"。
+func (r *Robot) postMessage(ctx context.Context, msgType string, jsonData []byte) (err error) {
+	start := time.Now()
+	errCode := errCodeLabelHTTP
+	defer func() {
+		metrics.WeComSendDuration.Observe(time.Since(start).Seconds(), msgType)
+		metrics.WeComMessages.Inc(msgType, errCode)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create %s message request: %w", msgType, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send %s message: %w", msgType, err)
 	}
@@ -137,7 +198,7 @@ func (r *Robot) sendMessageToWeCom(msgType string, payload interface{}) error {
 	if resp.StatusCode != http.StatusOK {
 		body, readErr := io.ReadAll(resp.Body)
 		if readErr != nil {
-			log.Printf("[WeCom Robot] 读取企业微信响应体失败: %v", readErr)
+			slog.WarnContext(ctx, "[WeCom Robot] 读取企业微信响应体失败", "robot", r.cfg.Name, "status", resp.StatusCode, "error", readErr)
 			return fmt.Errorf("failed to send %s message (status code %d), could not read response body: %w", msgType, resp.StatusCode, readErr)
 		}
 		return fmt.Errorf("failed to send %s message (status code %d): %s", msgType, resp.StatusCode, string(body))
@@ -154,71 +215,115 @@ func (r *Robot) sendMessageToWeCom(msgType string, payload interface{}) error {
 	if err := json.Unmarshal(respBody, &result); err != nil {
 		return fmt.Errorf("failed to parse %s response: %w, body: %s", msgType, err, string(respBody))
 	}
+	errCode = strconv.Itoa(result.ErrCode)
 
 	if result.ErrCode != 0 {
-		if result.ErrCode == 45009 { // 45009 错误码通常表示 API 调用频率超过限制
-			log.Printf("[WeCom Robot] 警告: 企业微信消息发送频率限制，错误码: %d, 消息: %s", result.ErrCode, result.ErrMsg)
-			return fmt.Errorf("wecom %s message failed due to rate limit: %s (errcode: %d)", msgType, result.ErrMsg, result.ErrCode)
+		if result.ErrCode == errCodeRateLimited { // 45009 错误码表示 API 调用频率超过限制
+			slog.WarnContext(ctx, "[WeCom Robot] 企业微信消息发送频率限制", "robot", r.cfg.Name, "errcode", result.ErrCode, "errmsg", result.ErrMsg)
 		}
-		return fmt.Errorf("wecom %s message failed: %s (errcode: %d)", msgType, result.ErrMsg, result.ErrCode)
+		return &APIError{MsgType: msgType, ErrCode: result.ErrCode, ErrMsg: result.ErrMsg}
 	}
 
-	log.Printf("[WeCom Robot] %s 消息成功发送到企业微信。", msgType)
+	slog.InfoContext(ctx, "[WeCom Robot] 消息成功发送到企业微信", "robot", r.cfg.Name, "msgtype", msgType, "duration", time.Since(start).String())
 	return nil
 }
 
 // SendTextMessage 向企业微信机器人发送文本消息
 // message: 文本消息内容
 func (r *Robot) SendTextMessage(message string) error {
+	return r.SendTextMessageContext(context.Background(), message)
+}
+
+// SendTextMessageContext 与 SendTextMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendTextMessageContext(ctx context.Context, message string) error {
 	payload := struct {
 		Content string `json:"content"`
 	}{
 		Content: message,
 	}
-	return r.sendMessageToWeCom("text", payload)
+	return r.sendMessageToWeCom(ctx, "text", payload)
 }
 
 // SendMarkdownMessage 向企业微信机器人发送 Markdown 消息
 // content: Markdown 格式的内容
 func (r *Robot) SendMarkdownMessage(content string) error {
+	return r.SendMarkdownMessageContext(context.Background(), content)
+}
+
+// SendMarkdownMessageContext 与 SendMarkdownMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendMarkdownMessageContext(ctx context.Context, content string) error {
 	payload := struct {
 		Content string `json:"content"`
 	}{
 		Content: content,
 	}
-	return r.sendMessageToWeCom("markdown", payload)
+	return r.sendMessageToWeCom(ctx, "markdown", payload)
 }
 
 // SendMarkdownV2Message 向企业微信机器人发送 Markdown V2 消息
 // content: Markdown V2 格式的内容
 func (r *Robot) SendMarkdownV2Message(content string) error {
+	return r.SendMarkdownV2MessageContext(context.Background(), content)
+}
+
+// SendMarkdownV2MessageContext 与 SendMarkdownV2Message 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendMarkdownV2MessageContext(ctx context.Context, content string) error {
 	payload := struct {
 		Content string `json:"content"`
 	}{
 		Content: content,
 	}
-	return r.sendMessageToWeCom("markdown_v2", payload)
+	return r.sendMessageToWeCom(ctx, "markdown_v2", payload)
 }
 
 // SendImageMessage 向企业微信机器人发送图片消息
+// 企业微信图片消息使用 base64 + md5 传递图片内容，不支持 media_id。
+// 非 JPG/PNG 格式或超过 2MB 的图片会自动转码并缩小后再发送。
 // imageFilePath: 图片文件的本地路径
 func (r *Robot) SendImageMessage(imageFilePath string) error {
-	mediaID, err := r.uploadMedia(imageFilePath, "image")
+	return r.SendImageMessageContext(context.Background(), imageFilePath)
+}
+
+// SendImageMessageContext 与 SendImageMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendImageMessageContext(ctx context.Context, imageFilePath string) error {
+	data, err := os.ReadFile(imageFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read image file: %w", err)
+	}
+	return r.SendImageDataContext(ctx, data)
+}
+
+// SendImageData 向企业微信机器人发送内存中的图片数据
+// data: 图片的原始字节内容，支持 JPG、PNG、GIF、BMP、WebP、TIFF 格式
+func (r *Robot) SendImageData(data []byte) error {
+	return r.SendImageDataContext(context.Background(), data)
+}
+
+// SendImageDataContext 与 SendImageData 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendImageDataContext(ctx context.Context, data []byte) error {
+	imageData, err := prepareImage(data)
 	if err != nil {
-		return fmt.Errorf("failed to upload image for WeCom: %w", err)
+		return fmt.Errorf("failed to prepare image for WeCom: %w", err)
 	}
 	payload := struct {
-		MediaID string `json:"media_id"`
+		Base64 string `json:"base64"`
+		MD5    string `json:"md5"`
 	}{
-		MediaID: mediaID,
+		Base64: base64.StdEncoding.EncodeToString(imageData),
+		MD5:    imageMD5(imageData),
 	}
-	return r.sendMessageToWeCom("image", payload)
+	return r.sendMessageToWeCom(ctx, "image", payload)
 }
 
 // SendVoiceMessage 向企业微信机器人发送语音消息
 // voiceFilePath: 语音文件的本地路径
 func (r *Robot) SendVoiceMessage(voiceFilePath string) error {
-	mediaID, err := r.uploadMedia(voiceFilePath, "voice")
+	return r.SendVoiceMessageContext(context.Background(), voiceFilePath)
+}
+
+// SendVoiceMessageContext 与 SendVoiceMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendVoiceMessageContext(ctx context.Context, voiceFilePath string) error {
+	mediaID, err := r.uploadMedia(ctx, voiceFilePath, "voice")
 	if err != nil {
 		return fmt.Errorf("failed to upload voice for WeCom: %w", err)
 	}
@@ -227,13 +332,18 @@ func (r *Robot) SendVoiceMessage(voiceFilePath string) error {
 	}{
 		MediaID: mediaID,
 	}
-	return r.sendMessageToWeCom("voice", payload)
+	return r.sendMessageToWeCom(ctx, "voice", payload)
 }
 
 // SendVideoMessage 向企业微信机器人发送视频消息
 // videoFilePath: 视频文件的本地路径
 func (r *Robot) SendVideoMessage(videoFilePath string) error {
-	mediaID, err := r.uploadMedia(videoFilePath, "video")
+	return r.SendVideoMessageContext(context.Background(), videoFilePath)
+}
+
+// SendVideoMessageContext 与 SendVideoMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendVideoMessageContext(ctx context.Context, videoFilePath string) error {
+	mediaID, err := r.uploadMedia(ctx, videoFilePath, "video")
 	if err != nil {
 		return fmt.Errorf("failed to upload video for WeCom: %w", err)
 	}
@@ -242,13 +352,18 @@ func (r *Robot) SendVideoMessage(videoFilePath string) error {
 	}{
 		MediaID: mediaID,
 	}
-	return r.sendMessageToWeCom("video", payload)
+	return r.sendMessageToWeCom(ctx, "video", payload)
 }
 
 // SendFileMessage 向企业微信机器人发送文件消息
 // filePath: 文件的本地路径
 func (r *Robot) SendFileMessage(filePath string) error {
-	mediaID, err := r.uploadMedia(filePath, "file")
+	return r.SendFileMessageContext(context.Background(), filePath)
+}
+
+// SendFileMessageContext 与 SendFileMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendFileMessageContext(ctx context.Context, filePath string) error {
+	mediaID, err := r.uploadMedia(ctx, filePath, "file")
 	if err != nil {
 		return fmt.Errorf("failed to upload file for WeCom: %w", err)
 	}
@@ -257,7 +372,7 @@ func (r *Robot) SendFileMessage(filePath string) error {
 	}{
 		MediaID: mediaID,
 	}
-	return r.sendMessageToWeCom("file", payload)
+	return r.sendMessageToWeCom(ctx, "file", payload)
 }
 
 // SendTextWithMentionMessage 向企业微信机器人发送带 @ 提醒的文本消息
@@ -265,6 +380,11 @@ func (r *Robot) SendFileMessage(filePath string) error {
 // mentionedList: 需要 @ 的成员 ID 列表，例如 ["userid1", "userid2"]
 // mentionedMobileList: 需要 @ 的成员手机号列表，例如 ["13800000000", "@all"]
 func (r *Robot) SendTextWithMentionMessage(content string, mentionedList []string, mentionedMobileList []string) error {
+	return r.SendTextWithMentionMessageContext(context.Background(), content, mentionedList, mentionedMobileList)
+}
+
+// SendTextWithMentionMessageContext 与 SendTextWithMentionMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendTextWithMentionMessageContext(ctx context.Context, content string, mentionedList []string, mentionedMobileList []string) error {
 	payload := struct {
 		Content             string   `json:"content"`
 		MentionedList       []string `json:"mentioned_list,omitempty"`
@@ -274,7 +394,7 @@ func (r *Robot) SendTextWithMentionMessage(content string, mentionedList []strin
 		MentionedList:       mentionedList,
 		MentionedMobileList: mentionedMobileList,
 	}
-	return r.sendMessageToWeCom("text", payload)
+	return r.sendMessageToWeCom(ctx, "text", payload)
 }
 
 // Article 定义图文消息中的文章结构
@@ -288,6 +408,11 @@ type Article struct {
 // SendNewsMessage 向企业微信机器人发送图文消息
 // articles: 文章列表，最多支持 8 条
 func (r *Robot) SendNewsMessage(articles []Article) error {
+	return r.SendNewsMessageContext(context.Background(), articles)
+}
+
+// SendNewsMessageContext 与 SendNewsMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendNewsMessageContext(ctx context.Context, articles []Article) error {
 	if len(articles) == 0 || len(articles) > 8 {
 		return fmt.Errorf("news message must contain 1 to 8 articles")
 	}
@@ -296,28 +421,37 @@ func (r *Robot) SendNewsMessage(articles []Article) error {
 	}{
 		Articles: articles,
 	}
-	return r.sendMessageToWeCom("news", payload)
+	return r.sendMessageToWeCom(ctx, "news", payload)
 }
 
 // TemplateCard 定义模板卡片消息的结构
 type TemplateCard struct {
-	CardType          string        `json:"card_type"`                         // 卡片类型，固定为 "text_notice" 或 "news_notice"
+	CardType          string        `json:"card_type"`                         // 卡片类型，可以是 "text_notice"、"news_notice" 或 "button_interaction"
 	Source            interface{}   `json:"source,omitempty"`                  // 来源文案
 	MainTitle         interface{}   `json:"main_title,omitempty"`              // 主标题
 	QuoteArea         interface{}   `json:"quote_area,omitempty"`              // 引用区域
 	SubTitleText      string        `json:"sub_title_text,omitempty"`          // 副标题
 	HorizontalContent []interface{} `json:"horizontal_content_list,omitempty"` // 横向内容列表
 	VerticalContent   []interface{} `json:"vertical_content_list,omitempty"`   // 纵向内容列表
+	CardImage         interface{}   `json:"card_image,omitempty"`              // 图片样式，news_notice 卡片的封面图片
+	ImageTextArea     interface{}   `json:"image_text_area,omitempty"`         // 左图右文样式，仅 news_notice 卡片
+	JumpList          []interface{} `json:"jump_list,omitempty"`               // 跳转指引列表
 	CardAction        interface{}   `json:"card_action,omitempty"`             // 整体点击跳转
 	EmphasisContent   interface{}   `json:"emphasis_content,omitempty"`        // 关键数据区域
 	ButtonSelection   interface{}   `json:"button_selection,omitempty"`        // 按钮选择
 	ButtonList        []interface{} `json:"button_list,omitempty"`             // 按钮列表
+	TaskID            string        `json:"task_id,omitempty"`                 // 任务 ID，用于识别按钮点击事件，button_interaction 卡片必填
 }
 
 // SendTemplateCardMessage 向企业微信机器人发送模板卡片消息
 // card: 模板卡片内容
 func (r *Robot) SendTemplateCardMessage(card TemplateCard) error {
-	return r.sendMessageToWeCom("template_card", card)
+	return r.SendTemplateCardMessageContext(context.Background(), card)
+}
+
+// SendTemplateCardMessageContext 与 SendTemplateCardMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendTemplateCardMessageContext(ctx context.Context, card TemplateCard) error {
+	return r.sendMessageToWeCom(ctx, "template_card", card)
 }
 
 // InteractiveCard 定义互动卡片消息的结构
@@ -332,5 +466,10 @@ type InteractiveCard struct {
 // SendInteractiveCardMessage 向企业微信机器人发送互动卡片消息
 // card: 互动卡片内容
 func (r *Robot) SendInteractiveCardMessage(card InteractiveCard) error {
-	return r.sendMessageToWeCom("interactive_card", card)
+	return r.SendInteractiveCardMessageContext(context.Background(), card)
+}
+
+// SendInteractiveCardMessageContext 与 SendInteractiveCardMessage 相同，但会在 ctx 被取消时停止排队等待或中止进行中的请求
+func (r *Robot) SendInteractiveCardMessageContext(ctx context.Context, card InteractiveCard) error {
+	return r.sendMessageToWeCom(ctx, "interactive_card", card)
 }
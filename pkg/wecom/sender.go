@@ -0,0 +1,51 @@
+package wecom
+
+import (
+	"dify2wxbot/internal/config" // 导入 config 包，用于根据配置选择发送方类型
+	"dify2wxbot/pkg/sender"      // 导入 pkg/sender 包，群机器人和自建应用都实现其中的 Sender 接口
+)
+
+const (
+	SenderTypeRobot = "robot" // SenderTypeRobot 是群机器人 Webhook，未配置 type 时的默认值
+	SenderTypeApp   = "app"   // SenderTypeApp 是自建应用的应用消息，可以发给指定成员、部门或标签
+
+	appMarkdownMaxBytes = 2048 // 应用消息中 Markdown 内容的最大字节数，比群机器人的 4096 字节更短
+)
+
+var (
+	_ sender.Sender        = (*Robot)(nil)     // Robot 实现了 Sender 接口
+	_ sender.MentionSender = (*Robot)(nil)     // Robot 支持在文本消息中 @成员
+	_ sender.Sender        = (*AppSender)(nil) // AppSender 实现了 Sender 接口
+)
+
+// NewSender 根据配置中的 type 创建对应的发送方，type 为 "app" 时创建 AppSender，否则创建群机器人 Robot
+func NewSender(cfg config.WeComConfig) sender.Sender {
+	if cfg.Type == SenderTypeApp {
+		return NewAppSender(cfg)
+	}
+	return NewRobot(cfg)
+}
+
+// Capabilities 返回群机器人支持的消息能力：文本 2048 字节、企业微信 Markdown 4096 字节，支持图片、文件和 @成员
+func (r *Robot) Capabilities() sender.Capabilities {
+	return sender.Capabilities{
+		MaxTextBytes:     2048,
+		MaxMarkdownBytes: 4096,
+		Markdown:         sender.MarkdownWeCom,
+		Images:           true,
+		Files:            true,
+		Mentions:         true,
+	}
+}
+
+// Capabilities 返回自建应用支持的消息能力：文本和企业微信 Markdown 各 2048 字节，支持图片和文件；
+// 接收人由配置决定，不支持 @成员。
+func (a *AppSender) Capabilities() sender.Capabilities {
+	return sender.Capabilities{
+		MaxTextBytes:     2048,
+		MaxMarkdownBytes: appMarkdownMaxBytes,
+		Markdown:         sender.MarkdownWeCom,
+		Images:           true,
+		Files:            true,
+	}
+}
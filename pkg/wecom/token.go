@@ -0,0 +1,121 @@
+package wecom
+
+import (
+	"context"       // 导入 context 包，用于取消获取 access_token 的请求
+	"encoding/json" // 导入 encoding/json 包，用于解析 gettoken 接口的响应
+	"errors"        // 导入 errors 包，用于去掉网络错误中带有 corpsecret 的 URL
+	"fmt"           // 导入 fmt 包，用于格式化错误信息
+	"log/slog"      // 导入 log/slog 包，用于结构化日志输出
+	"net/http"      // 导入 net/http 包，用于调用 gettoken 接口
+	"net/url"       // 导入 net/url 包，用于构建查询参数
+	"sync"          // 导入 sync 包，用于保护缓存的 access_token
+	"time"          // 导入 time 包，用于计算 access_token 的过期时间
+)
+
+const (
+	apiBaseURL          = "https://qyapi.weixin.qq.com/cgi-bin" // 企业微信服务端接口的基础 URL
+	tokenRefreshMargin  = 5 * time.Minute                       // 获取 access_token 时剩余有效期少于该时间则重新获取
+	errCodeInvalidToken = 40014                                 // 企业微信返回的 access_token 不合法的错误码
+	errCodeTokenExpired = 42001                                 // 企业微信返回的 access_token 已过期的错误码
+)
+
+// accessToken 缓存某个应用 (corpid + corpsecret) 的 access_token
+// 刷新是惰性的，没有后台刷新：发送消息时调用 get，缓存为空或剩余有效期 (通常 7200 秒) 不足 tokenRefreshMargin 时才重新获取，
+// 刷新期间的并发调用等待同一次请求的结果；企业微信提前使 access_token 失效 (40014、42001) 时，调用方通过 invalidate 丢弃缓存后重新获取。
+type accessToken struct {
+	corpID     string       // corpID 是企业 ID
+	corpSecret string       // corpSecret 是应用的 Secret
+	httpClient *http.Client // httpClient 用于调用 gettoken 接口
+
+	mu        sync.Mutex // mu 保护 token 和 expiresAt，并保证同一时间只有一个刷新请求
+	token     string     // token 是缓存的 access_token
+	expiresAt time.Time  // expiresAt 是 access_token 的过期时间
+}
+
+var (
+	tokensMu sync.Mutex                      // tokensMu 保护 tokens
+	tokens   = make(map[string]*accessToken) // tokens 按 corpid 和 corpsecret 保存 access_token 缓存，同一个应用的多个 AppSender 共享
+)
+
+// getAccessToken 返回应用对应的 access_token 缓存，不存在时创建
+func getAccessToken(corpID, corpSecret string, httpClient *http.Client) *accessToken {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	key := corpID + "\x00" + corpSecret
+	if t, ok := tokens[key]; ok {
+		return t
+	}
+	t := &accessToken{corpID: corpID, corpSecret: corpSecret, httpClient: httpClient}
+	tokens[key] = t
+	return t
+}
+
+// get 返回有效的 access_token，缓存为空或即将过期时重新获取
+// 重新获取失败但缓存尚未过期时仍返回缓存的 access_token，下次调用再尝试刷新。
+func (t *accessToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Until(t.expiresAt) > tokenRefreshMargin {
+		return t.token, nil
+	}
+	token, expiresIn, err := t.fetch(ctx)
+	if err != nil {
+		if t.token != "" && time.Now().Before(t.expiresAt) {
+			// 提前刷新失败时继续使用尚未过期的 access_token，下次调用再尝试刷新
+			slog.WarnContext(ctx, "[WeCom App] 刷新 access_token 失败，继续使用缓存的 access_token", "error", err)
+			return t.token, nil
+		}
+		return "", err
+	}
+	t.token = token
+	t.expiresAt = time.Now().Add(expiresIn)
+	slog.InfoContext(ctx, "[WeCom App] 已获取新的 access_token", "expires_in", expiresIn.String())
+	return t.token, nil
+}
+
+// invalidate 丢弃缓存的 access_token，token 与缓存不一致时 (已被其他调用刷新) 不做处理
+func (t *accessToken) invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token = ""
+		t.expiresAt = time.Time{}
+	}
+}
+
+// fetch 调用 gettoken 接口获取新的 access_token 及其有效期
+func (t *accessToken) fetch(ctx context.Context) (string, time.Duration, error) {
+	query := url.Values{"corpid": {t.corpID}, "corpsecret": {t.corpSecret}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiBaseURL+"/gettoken?"+query.Encode(), nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create gettoken request: %w", err)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // 去掉错误信息中带有 corpsecret 的 URL
+		}
+		return "", 0, fmt.Errorf("failed to get wecom access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed to get wecom access token: status code %d", resp.StatusCode)
+	}
+
+	var result struct {
+		ErrCode     int    `json:"errcode"`
+		ErrMsg      string `json:"errmsg"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("failed to parse gettoken response: %w", err)
+	}
+	if result.ErrCode != 0 || result.AccessToken == "" {
+		return "", 0, fmt.Errorf("wecom gettoken failed: %s (errcode: %d)", result.ErrMsg, result.ErrCode)
+	}
+	return result.AccessToken, time.Duration(result.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,139 @@
+package wecom
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redirectTransport 把发往企业微信接口的请求转发到测试服务端
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// tokenServer 是模拟 gettoken 接口的测试服务端，每次成功获取返回 token-1、token-2……
+type tokenServer struct {
+	mu      sync.Mutex
+	fetches int
+	fail    bool // fail 为 true 时 gettoken 返回错误码
+}
+
+func (s *tokenServer) handle(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != "/cgi-bin/gettoken" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Query().Get("corpsecret") != "secret" {
+		w.Write([]byte(`{"errcode": 40001, "errmsg": "invalid credential"}`))
+		return true
+	}
+	if s.fail {
+		w.Write([]byte(`{"errcode": -1, "errmsg": "system busy"}`))
+		return true
+	}
+	s.fetches++
+	fmt.Fprintf(w, `{"errcode": 0, "errmsg": "ok", "access_token": "token-%d", "expires_in": 7200}`, s.fetches)
+	return true
+}
+
+// newTestClient 返回把请求转发到 handler 的 HTTP 客户端
+func newTestClient(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return &http.Client{Transport: redirectTransport{target: target}}
+}
+
+func TestAccessTokenCachesAndRefreshesLazily(t *testing.T) {
+	ts := &tokenServer{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) { ts.handle(w, r) })
+	tok := &accessToken{corpID: "corp", corpSecret: "secret", httpClient: client}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if got, err := tok.get(ctx); err != nil || got != "token-1" {
+			t.Fatalf("get #%d = %q, %v; want the cached token-1", i, got, err)
+		}
+	}
+	if ts.fetches != 1 {
+		t.Fatalf("gettoken called %d times, want the token cached", ts.fetches)
+	}
+
+	// 剩余有效期不足 tokenRefreshMargin 时，下一次 get 重新获取
+	tok.expiresAt = time.Now().Add(tokenRefreshMargin - time.Minute)
+	if got, err := tok.get(ctx); err != nil || got != "token-2" {
+		t.Fatalf("get near expiry = %q, %v; want token-2", got, err)
+	}
+
+	// 刷新失败时继续使用尚未过期的缓存，过期后返回错误
+	ts.fail = true
+	tok.expiresAt = time.Now().Add(time.Minute)
+	if got, err := tok.get(ctx); err != nil || got != "token-2" {
+		t.Fatalf("get with failed refresh = %q, %v; want the cached token-2", got, err)
+	}
+	tok.expiresAt = time.Now().Add(-time.Second)
+	if got, err := tok.get(ctx); err == nil {
+		t.Fatalf("get after expiry with failed refresh = %q, want an error", got)
+	}
+}
+
+func TestAccessTokenInvalidate(t *testing.T) {
+	ts := &tokenServer{}
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) { ts.handle(w, r) })
+	tok := &accessToken{corpID: "corp", corpSecret: "secret", httpClient: client}
+	ctx := context.Background()
+
+	first, _ := tok.get(ctx)
+	tok.invalidate("token-stale") // 已被其他调用刷新过的 token 不影响缓存
+	if got, _ := tok.get(ctx); got != first || ts.fetches != 1 {
+		t.Fatalf("get after invalidating another token = %q (%d fetches), want the cached %q", got, ts.fetches, first)
+	}
+	tok.invalidate(first)
+	if got, err := tok.get(ctx); err != nil || got != "token-2" {
+		t.Fatalf("get after invalidate = %q, %v; want a new token", got, err)
+	}
+}
+
+func TestAppSenderRetriesWithNewTokenAfterInvalidToken(t *testing.T) {
+	for _, code := range []int{errCodeInvalidToken, errCodeTokenExpired} {
+		ts := &tokenServer{}
+		var used []string
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if ts.handle(w, r) {
+				return
+			}
+			token := r.URL.Query().Get("access_token")
+			used = append(used, token)
+			if token == "token-1" {
+				fmt.Fprintf(w, `{"errcode": %d, "errmsg": "access_token expired"}`, code)
+				return
+			}
+			w.Write([]byte(`{"errcode": 0, "errmsg": "ok"}`))
+		})
+		a := &AppSender{httpClient: client, token: &accessToken{corpID: "corp", corpSecret: "secret", httpClient: client}}
+
+		var result apiResult
+		err := a.call(context.Background(), func(token string) (*http.Request, error) {
+			return http.NewRequest(http.MethodPost, apiBaseURL+"/message/send?access_token="+token, nil)
+		}, &result)
+		if err != nil || result.ErrCode != 0 {
+			t.Fatalf("errcode %d: call = %v, %+v; want success after refreshing", code, err, result)
+		}
+		if len(used) != 2 || used[0] != "token-1" || used[1] != "token-2" {
+			t.Fatalf("errcode %d: tokens used = %q, want a retry with a new token", code, used)
+		}
+	}
+}